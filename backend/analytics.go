@@ -0,0 +1,385 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+)
+
+// Analytics records the history of every task's container, completion,
+// estimate and sprint, and serves the flow charts computed from it.
+type Analytics struct {
+	db *sqlx.DB
+	tm *TaskManager
+}
+
+func NewAnalytics(db *sqlx.DB, tm *TaskManager) *Analytics {
+	return &Analytics{db: db, tm: tm}
+}
+
+func (a *Analytics) routes() []route {
+	rangeParams := []queryParam{
+		{name: "from", description: "first day, formatted as YYYY-MM-DD; defaults to 29 days before to"},
+		{name: "to", description: "last day, formatted as YYYY-MM-DD; defaults to today"},
+	}
+	return []route{
+		{name: "getBurndown", method: "GET", path: "/boards/{id}/analytics/burndown", handler: a.GetBurndownHandler, auth: true, tag: "analytics",
+			summary: "Chart the remaining tasks and estimates of a board or sprint per day", response: Burndown{},
+			query: append([]queryParam{{name: "sprint_id", description: "chart a sprint over its dates instead of the whole board", typ: "integer"}}, rangeParams...)},
+		{name: "getCumulativeFlow", method: "GET", path: "/boards/{id}/analytics/cfd", handler: a.GetCumulativeFlowHandler, auth: true, tag: "analytics",
+			summary: "Count the tasks in each container of a board per day", response: CumulativeFlow{},
+			query: rangeParams},
+		{name: "getFlowMetrics", method: "GET", path: "/boards/{id}/analytics/flow", handler: a.GetFlowMetricsHandler, auth: true, tag: "analytics",
+			summary: "Measure lead time, cycle time and weekly throughput of a board's completed tasks", response: FlowMetrics{},
+			query: []queryParam{
+				{name: "from", description: "first completion day, formatted as YYYY-MM-DD; defaults to 83 days before to"},
+				{name: "to", description: "last completion day, formatted as YYYY-MM-DD; defaults to today"},
+				{name: "start_container_id", description: "container whose first entry starts the cycle; defaults to leaving the initial container", typ: "integer"},
+				{name: "end_container_id", description: "container whose first entry ends the cycle; defaults to completion", typ: "integer"},
+				{name: "label", description: "only measure tasks with this label"},
+				{name: "assignee_id", description: "only measure tasks assigned to this user", typ: "integer"},
+			}},
+		{name: "getTaskHistory", method: "GET", path: "/tasks/{id}/history", handler: a.GetTaskHistoryHandler, auth: true, tag: "analytics",
+			summary: "List when a task entered each container and when it was completed", response: TaskFlow{}},
+	}
+}
+
+// maxAnalyticsDays bounds the date range of a chart.
+const maxAnalyticsDays = 366
+
+// recordHistory appends the current state of every task on board $1 that
+// differs from its last recorded state. Archived tasks and tasks in archived
+// containers are recorded without a container, as are tasks that have left
+// the board or were deleted.
+const recordHistory = `
+	INSERT INTO task_history (task_id, board_id, container_id, completed, estimate, sprint_id)
+	SELECT t.id, c.board_id, CASE WHEN t.archived_at IS NULL AND c.archived_at IS NULL THEN c.id END, t.completed, t.estimate, t.sprint_id
+	FROM tasks t JOIN containers c ON c.id = t.container_id
+	LEFT JOIN LATERAL (
+		SELECT * FROM task_history h WHERE h.task_id = t.id ORDER BY h.recorded_at DESC, h.id DESC LIMIT 1
+	) last ON true
+	WHERE c.board_id = $1 AND (last.id IS NULL OR
+		(last.board_id, last.container_id, last.completed, last.estimate, last.sprint_id) IS DISTINCT FROM
+		(c.board_id, CASE WHEN t.archived_at IS NULL AND c.archived_at IS NULL THEN c.id END, t.completed, t.estimate, t.sprint_id))
+	UNION ALL
+	SELECT last.task_id, last.board_id, NULL, last.completed, last.estimate, last.sprint_id
+	FROM (
+		SELECT DISTINCT ON (task_id) * FROM task_history
+		WHERE task_id IN (SELECT task_id FROM task_history WHERE board_id = $1)
+		ORDER BY task_id, recorded_at DESC, id DESC
+	) last
+	WHERE last.board_id = $1 AND last.container_id IS NOT NULL AND NOT EXISTS (
+		SELECT 1 FROM tasks t JOIN containers c ON c.id = t.container_id WHERE t.id = last.task_id AND c.board_id = $1
+	)`
+
+// historyStates lists the recorded state of board $1's tasks at the end of
+// each UTC day from $2 to $3, or at $4 if that is earlier. Tasks that were
+// not on the board, or archived, at that time are left out.
+const historyStates = `
+	WITH days AS (
+		SELECT g.day::date AS day FROM generate_series($2::date, $3::date, interval '1 day') AS g(day)
+	)
+	SELECT days.day, s.task_id, s.container_id, s.completed, s.estimate, s.sprint_id
+	FROM days CROSS JOIN LATERAL (
+		SELECT DISTINCT ON (h.task_id) h.task_id, h.board_id, h.container_id, h.completed, h.estimate, h.sprint_id
+		FROM task_history h
+		WHERE h.task_id IN (SELECT task_id FROM task_history WHERE board_id = $1)
+		AND h.recorded_at < LEAST((days.day + 1)::timestamp AT TIME ZONE 'UTC', $4::timestamptz)
+		ORDER BY h.task_id, h.recorded_at DESC, h.id DESC
+	) s
+	WHERE s.board_id = $1 AND s.container_id IS NOT NULL`
+
+// BurndownPoint is the state of the charted tasks at the end of a day. The
+// ideal values decline linearly from the first day's remaining work to zero
+// on the last day.
+type BurndownPoint struct {
+	Date              string  `json:"date"`
+	Remaining         int     `json:"remaining"`
+	RemainingEstimate float64 `json:"remaining_estimate"`
+	Completed         int     `json:"completed"`
+	CompletedEstimate float64 `json:"completed_estimate"`
+	Ideal             float64 `json:"ideal"`
+	IdealEstimate     float64 `json:"ideal_estimate"`
+}
+
+type Burndown struct {
+	BoardID  int             `json:"board_id"`
+	SprintID *int            `json:"sprint_id,omitempty"`
+	From     string          `json:"from"`
+	To       string          `json:"to"`
+	Points   []BurndownPoint `json:"points"`
+}
+
+// CumulativeFlowSeries counts a container's tasks on each of the chart's
+// dates.
+type CumulativeFlowSeries struct {
+	ContainerID int    `json:"container_id"`
+	Title       string `json:"title"`
+	Counts      []int  `json:"counts"`
+}
+
+type CumulativeFlow struct {
+	BoardID int                    `json:"board_id"`
+	Dates   []string               `json:"dates"`
+	Series  []CumulativeFlowSeries `json:"series"`
+}
+
+// HandleEvent records the state of the event's board after every change to
+// it, so that tasks changed by any means end up in the history.
+func (a *Analytics) HandleEvent(event Event) {
+	err := a.record(event.BoardID)
+	if err != nil {
+		log.Printf("analytics: recording board %d: %v", event.BoardID, err)
+	}
+}
+
+func (a *Analytics) record(boardID int) error {
+	_, err := a.db.Exec(recordHistory, boardID)
+	return err
+}
+
+// GetBurndownHandler charts the board's remaining work per day, or that of
+// one of its sprints. A sprint is charted over its dates unless from or to
+// are given, and as it was when completed once it is.
+func (a *Analytics) GetBurndownHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+	boardID := mux.Vars(r)["id"]
+	query := r.URL.Query()
+
+	err := a.tm.checkBoardOwnership(userID, boardID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	burndown := Burndown{Points: []BurndownPoint{}}
+	burndown.BoardID, _ = strconv.Atoi(boardID)
+
+	var from, to time.Time
+	var cutoff *time.Time
+	sprintID, ok := queryInt(query.Get("sprint_id"), 0, 1, 1<<31-1)
+	if !ok {
+		writeError(w, r, validationError([]FieldError{{Field: "sprint_id", Message: "must be a sprint ID"}}, "invalid query"))
+		return
+	}
+	if sprintID != 0 {
+		var sprint Sprint
+		err = a.db.Get(&sprint, "SELECT * FROM sprints WHERE id = $1 AND board_id = $2", sprintID, boardID)
+		if err == sql.ErrNoRows {
+			writeError(w, r, validationError([]FieldError{{Field: "sprint_id", Message: "must be a sprint of the board"}}, "invalid query"))
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		burndown.SprintID = &sprint.ID
+		from, to = sprintDates(sprint)
+		cutoff = sprint.CompletedAt
+	}
+
+	from, to, err = analyticsRange(query.Get("from"), query.Get("to"), from, to, 30)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var rows []struct {
+		Day               time.Time `db:"day"`
+		Remaining         int       `db:"remaining"`
+		RemainingEstimate float64   `db:"remaining_estimate"`
+		Completed         int       `db:"completed"`
+		CompletedEstimate float64   `db:"completed_estimate"`
+	}
+	err = a.db.Select(&rows, `
+		SELECT day,
+			COUNT(*) FILTER (WHERE NOT completed) AS remaining,
+			COALESCE(SUM(estimate) FILTER (WHERE NOT completed), 0) AS remaining_estimate,
+			COUNT(*) FILTER (WHERE completed) AS completed,
+			COALESCE(SUM(estimate) FILTER (WHERE completed), 0) AS completed_estimate
+		FROM (`+historyStates+`) states
+		WHERE $5::integer IS NULL OR sprint_id = $5::integer
+		GROUP BY day ORDER BY day
+	`, boardID, from.Format("2006-01-02"), to.Format("2006-01-02"), cutoff, burndown.SprintID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	byDay := map[string]BurndownPoint{}
+	for _, row := range rows {
+		date := row.Day.Format("2006-01-02")
+		byDay[date] = BurndownPoint{
+			Date:              date,
+			Remaining:         row.Remaining,
+			RemainingEstimate: row.RemainingEstimate,
+			Completed:         row.Completed,
+			CompletedEstimate: row.CompletedEstimate,
+		}
+	}
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		date := day.Format("2006-01-02")
+		point, ok := byDay[date]
+		if !ok {
+			point.Date = date
+		}
+		burndown.Points = append(burndown.Points, point)
+	}
+
+	first := burndown.Points[0]
+	for i := range burndown.Points {
+		left := 1.0
+		if n := len(burndown.Points); n > 1 {
+			left = float64(n-1-i) / float64(n-1)
+		}
+		burndown.Points[i].Ideal = float64(first.Remaining) * left
+		burndown.Points[i].IdealEstimate = first.RemainingEstimate * left
+	}
+	burndown.From = from.Format("2006-01-02")
+	burndown.To = to.Format("2006-01-02")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(burndown)
+}
+
+// GetCumulativeFlowHandler counts the tasks in each container of the board
+// at the end of every day. Containers archived since are charted too if they
+// held tasks in that period.
+func (a *Analytics) GetCumulativeFlowHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+	boardID := mux.Vars(r)["id"]
+	query := r.URL.Query()
+
+	err := a.tm.checkBoardOwnership(userID, boardID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	from, to, err := analyticsRange(query.Get("from"), query.Get("to"), time.Time{}, time.Time{}, 30)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var counts []struct {
+		Day         time.Time `db:"day"`
+		ContainerID int       `db:"container_id"`
+		Count       int       `db:"count"`
+	}
+	err = a.db.Select(&counts, `
+		SELECT day, container_id, COUNT(*) AS count
+		FROM (`+historyStates+`) states
+		GROUP BY day, container_id
+	`, boardID, from.Format("2006-01-02"), to.Format("2006-01-02"), nil)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var containers []Container
+	err = a.db.Select(&containers, "SELECT "+containerColumns+" FROM containers WHERE board_id = $1 ORDER BY id", boardID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	cfd := CumulativeFlow{Dates: []string{}, Series: []CumulativeFlowSeries{}}
+	cfd.BoardID, _ = strconv.Atoi(boardID)
+	dayIndex := map[string]int{}
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		dayIndex[day.Format("2006-01-02")] = len(cfd.Dates)
+		cfd.Dates = append(cfd.Dates, day.Format("2006-01-02"))
+	}
+
+	series := map[int][]int{}
+	for _, count := range counts {
+		if series[count.ContainerID] == nil {
+			series[count.ContainerID] = make([]int, len(cfd.Dates))
+		}
+		series[count.ContainerID][dayIndex[count.Day.Format("2006-01-02")]] = count.Count
+	}
+	for _, container := range containers {
+		if series[container.ID] == nil && container.ArchivedAt != nil {
+			continue
+		}
+		if series[container.ID] == nil {
+			series[container.ID] = make([]int, len(cfd.Dates))
+		}
+		cfd.Series = append(cfd.Series, CumulativeFlowSeries{ContainerID: container.ID, Title: container.Title, Counts: series[container.ID]})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cfd)
+}
+
+// sprintDates is the period a sprint covers: its planned dates, falling back
+// to when it was started and completed, or today.
+func sprintDates(sprint Sprint) (from, to time.Time) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	from, to = today, today
+	if sprint.StartedAt != nil {
+		from = sprint.StartedAt.UTC().Truncate(24 * time.Hour)
+	}
+	if sprint.StartsOn != "" {
+		from, _ = time.Parse("2006-01-02", sprint.StartsOn)
+	}
+	if sprint.CompletedAt != nil {
+		to = sprint.CompletedAt.UTC().Truncate(24 * time.Hour)
+	}
+	if sprint.EndsOn != "" {
+		to, _ = time.Parse("2006-01-02", sprint.EndsOn)
+	}
+	if to.Before(from) {
+		to = from
+	}
+	return from, to
+}
+
+// analyticsRange parses the from and to query parameters of a chart. Without
+// them the chart covers defFrom to defTo, or the last days days when those
+// are zero.
+func analyticsRange(fromParam, toParam string, defFrom, defTo time.Time, days int) (from, to time.Time, err error) {
+	if defTo.IsZero() {
+		defTo = time.Now().UTC().Truncate(24 * time.Hour)
+	}
+	if defFrom.IsZero() {
+		defFrom = defTo.AddDate(0, 0, 1-days)
+	}
+
+	var fieldErrs []FieldError
+	from, to = defFrom, defTo
+	if toParam != "" {
+		to, err = time.Parse("2006-01-02", toParam)
+		if err != nil {
+			fieldErrs = append(fieldErrs, FieldError{Field: "to", Message: "must be formatted as YYYY-MM-DD"})
+		}
+		if fromParam == "" && err == nil {
+			from = to.AddDate(0, 0, 1-days)
+		}
+	}
+	if fromParam != "" {
+		from, err = time.Parse("2006-01-02", fromParam)
+		if err != nil {
+			fieldErrs = append(fieldErrs, FieldError{Field: "from", Message: "must be formatted as YYYY-MM-DD"})
+		}
+	}
+	if len(fieldErrs) == 0 && to.Before(from) {
+		fieldErrs = append(fieldErrs, FieldError{Field: "to", Message: "must not be before from"})
+	}
+	if len(fieldErrs) == 0 && to.Sub(from) >= maxAnalyticsDays*24*time.Hour {
+		fieldErrs = append(fieldErrs, FieldError{Field: "from", Message: "must be at most 366 days before to"})
+	}
+	if len(fieldErrs) > 0 {
+		return from, to, validationError(fieldErrs, "invalid query")
+	}
+	return from, to, nil
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ArchivedItem is a board, container or task in the archive browser. For
+// containers and tasks BoardID and BoardTitle name the board they are on.
+type ArchivedItem struct {
+	Type        string    `json:"type" db:"type"`
+	ID          int       `json:"id" db:"id"`
+	Title       string    `json:"title" db:"title"`
+	BoardID     int       `json:"board_id" db:"board_id"`
+	BoardTitle  string    `json:"board_title" db:"board_title"`
+	ContainerID *int      `json:"container_id,omitempty" db:"container_id"`
+	ArchivedAt  time.Time `json:"archived_at" db:"archived_at"`
+}
+
+type ArchivePage struct {
+	Items  []ArchivedItem `json:"items"`
+	Total  int            `json:"total"`
+	Limit  int            `json:"limit"`
+	Offset int            `json:"offset"`
+}
+
+// archivedItems lists everything userID ($1) archived. Items inside an
+// archived board or container are only listed if archived themselves.
+const archivedItems = `
+	SELECT 'board' AS type, b.id, b.title, b.id AS board_id, b.title AS board_title, NULL::integer AS container_id, b.archived_at
+	FROM boards b WHERE b.user_id = $1 AND b.archived_at IS NOT NULL
+	UNION ALL
+	SELECT 'container', c.id, c.title, b.id, b.title, NULL, c.archived_at
+	FROM containers c JOIN boards b ON b.id = c.board_id
+	WHERE b.user_id = $1 AND c.archived_at IS NOT NULL
+	UNION ALL
+	SELECT 'task', t.id, t.title, b.id, b.title, c.id, t.archived_at
+	FROM tasks t JOIN containers c ON c.id = t.container_id JOIN boards b ON b.id = c.board_id
+	WHERE b.user_id = $1 AND t.archived_at IS NOT NULL`
+
+// archiveFilter narrows archivedItems by type ($2), board ($3) and a title
+// pattern ($4); empty and zero values match everything.
+const archiveFilter = `
+	WHERE ($2::text = '' OR type = $2::text)
+	AND ($3::integer = 0 OR board_id = $3::integer)
+	AND ($4::text = '' OR title ILIKE $4::text)`
+
+var includeArchivedParam = queryParam{name: "include_archived", description: "also list archived items", typ: "boolean"}
+
+// includeArchived reports whether a listing request asked for archived
+// items with ?include_archived=true.
+func includeArchived(r *http.Request) bool {
+	include, _ := strconv.ParseBool(r.URL.Query().Get("include_archived"))
+	return include
+}
+
+func (tm *TaskManager) ArchiveBoardHandler(w http.ResponseWriter, r *http.Request) {
+	tm.setBoardArchived(w, r, true)
+}
+
+func (tm *TaskManager) UnarchiveBoardHandler(w http.ResponseWriter, r *http.Request) {
+	tm.setBoardArchived(w, r, false)
+}
+
+func (tm *TaskManager) setBoardArchived(w http.ResponseWriter, r *http.Request, archived bool) {
+
+	userID := r.Context().Value("userID").(int)
+	boardID := mux.Vars(r)["id"]
+
+	err := tm.checkBoardOwnership(userID, boardID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var board Board
+	err = tm.db.Get(&board, `
+		UPDATE boards SET archived_at = CASE WHEN $1 THEN COALESCE(archived_at, now()) END
+		WHERE id = $2 RETURNING `+boardColumns, archived, boardID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	board.ContainerIDs, err = tm.getContainersForBoard(board.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	tm.emit(r, EventBoardUpdated, board.ID, board)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(board)
+}
+
+func (tm *TaskManager) ArchiveContainerHandler(w http.ResponseWriter, r *http.Request) {
+	tm.setContainerArchived(w, r, true)
+}
+
+func (tm *TaskManager) UnarchiveContainerHandler(w http.ResponseWriter, r *http.Request) {
+	tm.setContainerArchived(w, r, false)
+}
+
+func (tm *TaskManager) setContainerArchived(w http.ResponseWriter, r *http.Request, archived bool) {
+
+	userID := r.Context().Value("userID").(int)
+	containerID := mux.Vars(r)["id"]
+
+	var container Container
+	err := tm.db.Get(&container, "SELECT "+containerColumns+" FROM containers WHERE id = $1", containerID)
+	if err == sql.ErrNoRows {
+		writeError(w, r, notFoundError("container %s not found", containerID))
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	err = tm.checkBoardOwnership(userID, strconv.Itoa(container.BoardID))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tm.db.Get(&container, `
+		UPDATE containers SET archived_at = CASE WHEN $1 THEN COALESCE(archived_at, now()) END
+		WHERE id = $2 RETURNING `+containerColumns, archived, container.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	container.TaskIDs, err = tm.getTasksForContainer(container.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	tm.emit(r, EventContainerUpdated, container.BoardID, container)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(container)
+}
+
+func (tm *TaskManager) ArchiveTaskHandler(w http.ResponseWriter, r *http.Request) {
+	tm.setTaskArchived(w, r, true)
+}
+
+func (tm *TaskManager) UnarchiveTaskHandler(w http.ResponseWriter, r *http.Request) {
+	tm.setTaskArchived(w, r, false)
+}
+
+// setTaskArchived archives or restores a task. A restored task counts
+// against its container's WIP limit again.
+func (tm *TaskManager) setTaskArchived(w http.ResponseWriter, r *http.Request, archived bool) {
+
+	userID := r.Context().Value("userID").(int)
+
+	task, err := tm.checkTaskAccess(userID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	boardID, err := tm.boardIDForContainer(task.ContainerID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	tx, err := tm.db.Beginx()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	var violation *WIPViolation
+	if !archived && task.ArchivedAt != nil {
+		violation, err = tm.checkWIP(tx, task.ContainerID, 1)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+
+	err = tx.Get(&task, `
+		UPDATE tasks SET archived_at = CASE WHEN $1 THEN COALESCE(archived_at, now()) END
+		WHERE id = $2 RETURNING `+taskColumns, archived, task.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	tm.emit(r, EventTaskUpdated, boardID, task)
+
+	flagWIP(w, violation)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(task)
+}
+
+// GetArchiveHandler pages through the caller's archived boards, containers
+// and tasks, most recently archived first.
+func (tm *TaskManager) GetArchiveHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+	query := r.URL.Query()
+
+	var fieldErrs []FieldError
+	itemType := query.Get("type")
+	if itemType != "" && itemType != "board" && itemType != "container" && itemType != "task" {
+		fieldErrs = append(fieldErrs, FieldError{Field: "type", Message: "must be one of board, container, task"})
+	}
+	boardID, ok := queryInt(query.Get("board_id"), 0, 0, 1<<31-1)
+	if !ok {
+		fieldErrs = append(fieldErrs, FieldError{Field: "board_id", Message: "must be a board ID"})
+	}
+	limit, ok := queryInt(query.Get("limit"), 50, 1, 200)
+	if !ok {
+		fieldErrs = append(fieldErrs, FieldError{Field: "limit", Message: "must be between 1 and 200"})
+	}
+	offset, ok := queryInt(query.Get("offset"), 0, 0, 1<<31-1)
+	if !ok {
+		fieldErrs = append(fieldErrs, FieldError{Field: "offset", Message: "must not be negative"})
+	}
+	if len(fieldErrs) > 0 {
+		writeError(w, r, validationError(fieldErrs, "invalid query"))
+		return
+	}
+
+	pattern := ""
+	if q := strings.TrimSpace(query.Get("q")); q != "" {
+		pattern = "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(q) + "%"
+	}
+
+	page := ArchivePage{Items: []ArchivedItem{}, Limit: limit, Offset: offset}
+	err := tm.db.Get(&page.Total, "SELECT COUNT(*) FROM ("+archivedItems+") items"+archiveFilter,
+		userID, itemType, boardID, pattern)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	err = tm.db.Select(&page.Items, "SELECT * FROM ("+archivedItems+") items"+archiveFilter+`
+		ORDER BY archived_at DESC, type, id LIMIT $5 OFFSET $6
+	`, userID, itemType, boardID, pattern, limit, offset)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// queryInt parses an optional integer query parameter, returning def when
+// it is empty and false when it is malformed or outside [min, max].
+func queryInt(v string, def, min, max int) (int, bool) {
+	if v == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < min || n > max {
+		return 0, false
+	}
+	return n, true
+}
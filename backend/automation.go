@@ -0,0 +1,622 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	TriggerTaskCreated   = "task.created"
+	TriggerTaskMoved     = "task.moved"
+	TriggerTaskCompleted = "task.completed"
+	TriggerTaskDueSoon   = "task.due_soon"
+
+	RunSucceeded = "succeeded"
+	RunSkipped   = "skipped"
+	RunFailed    = "failed"
+
+	// maxAutomationDepth bounds chains of rules triggering each other.
+	maxAutomationDepth = 5
+)
+
+// RuleTrigger selects the events a rule reacts to. ContainerID narrows it
+// to tasks in that container, which for task.moved means moves into it;
+// WithinMinutes is the look-ahead of task.due_soon.
+type RuleTrigger struct {
+	Type          string `json:"type" validate:"required,oneof=task.created|task.moved|task.completed|task.due_soon"`
+	ContainerID   int    `json:"container_id,omitempty"`
+	WithinMinutes int    `json:"within_minutes,omitempty" validate:"max=43200"`
+}
+
+// RuleCondition must hold for the rule's actions to run. Value is a label
+// name, a user ID or "none" for assignee, or a regular expression for
+// title_matches.
+type RuleCondition struct {
+	Type   string `json:"type" validate:"required,oneof=label|assignee|title_matches"`
+	Value  string `json:"value" validate:"max=200"`
+	Negate bool   `json:"negate,omitempty"`
+}
+
+type RuleAction struct {
+	Type        string `json:"type" validate:"required,oneof=move|set_completed|add_label|assign|comment"`
+	ContainerID int    `json:"container_id,omitempty"`
+	Completed   bool   `json:"completed,omitempty"`
+	Label       string `json:"label,omitempty" validate:"max=50"`
+	AssigneeID  *int   `json:"assignee_id,omitempty"`
+	Comment     string `json:"comment,omitempty" validate:"max=5000"`
+}
+
+type RuleConditions []RuleCondition
+type RuleActions []RuleAction
+
+type AutomationRule struct {
+	ID         int            `json:"id" db:"id"`
+	BoardID    int            `json:"board_id" db:"board_id"`
+	Name       string         `json:"name" db:"name" validate:"required,max=100"`
+	Trigger    RuleTrigger    `json:"trigger" db:"trigger"`
+	Conditions RuleConditions `json:"conditions" db:"conditions" validate:"max=20"`
+	Actions    RuleActions    `json:"actions" db:"actions" validate:"required,min=1,max=20"`
+	Enabled    bool           `json:"enabled" db:"enabled"`
+	CreatedAt  time.Time      `json:"created_at" db:"created_at"`
+}
+
+type AutomationRun struct {
+	ID        int       `json:"id" db:"id"`
+	RuleID    int       `json:"rule_id" db:"rule_id"`
+	TaskID    *int      `json:"task_id" db:"task_id"`
+	Event     string    `json:"event" db:"event"`
+	Status    string    `json:"status" db:"status"`
+	Message   string    `json:"message" db:"message"`
+	Depth     int       `json:"depth" db:"depth"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// onlyUses reports whether every container the rule refers to is a key of
+// containers, so that copying the rule with them does not leave it pointing
+// at a container that was left behind.
+func (rule AutomationRule) onlyUses(containers map[int]int) bool {
+	if _, ok := containers[rule.Trigger.ContainerID]; rule.Trigger.ContainerID != 0 && !ok {
+		return false
+	}
+	for _, action := range rule.Actions {
+		if _, ok := containers[action.ContainerID]; action.ContainerID != 0 && !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (t RuleTrigger) Value() (driver.Value, error)    { return json.Marshal(t) }
+func (t *RuleTrigger) Scan(src interface{}) error     { return scanJSON(src, t) }
+func (c RuleConditions) Value() (driver.Value, error) { return json.Marshal(c) }
+func (c *RuleConditions) Scan(src interface{}) error  { return scanJSON(src, c) }
+func (a RuleActions) Value() (driver.Value, error)    { return json.Marshal(a) }
+func (a *RuleActions) Scan(src interface{}) error     { return scanJSON(src, a) }
+
+// scanJSON decodes a JSONB column into dst.
+func scanJSON(src interface{}, dst interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, dst)
+	case string:
+		return json.Unmarshal([]byte(v), dst)
+	case nil:
+		return nil
+	}
+	return fmt.Errorf("cannot scan %T into %T", src, dst)
+}
+
+// AutomationEngine runs board rules after every task event and checks
+// due_soon triggers periodically.
+type AutomationEngine struct {
+	db           *sqlx.DB
+	tm           *TaskManager
+	pollInterval time.Duration
+}
+
+func NewAutomationEngine(db *sqlx.DB, tm *TaskManager) *AutomationEngine {
+	return &AutomationEngine{db: db, tm: tm, pollInterval: time.Minute}
+}
+
+func (ae *AutomationEngine) routes() []route {
+	return []route{
+		{name: "listAutomationRules", method: "GET", path: "/boards/{id}/automation-rules", handler: ae.ListRulesHandler, auth: true, tag: "automation",
+			summary: "List a board's automation rules", response: []AutomationRule{}},
+		{name: "createAutomationRule", method: "POST", path: "/boards/{id}/automation-rules", handler: ae.CreateRuleHandler, auth: true, tag: "automation",
+			summary: "Add an automation rule to a board", request: AutomationRule{}, response: AutomationRule{}, status: http.StatusCreated},
+		{name: "updateAutomationRule", method: "PUT", path: "/automation-rules/{id}", handler: ae.UpdateRuleHandler, auth: true, tag: "automation",
+			summary: "Replace an automation rule", request: AutomationRule{}, response: AutomationRule{}},
+		{name: "deleteAutomationRule", method: "DELETE", path: "/automation-rules/{id}", handler: ae.DeleteRuleHandler, auth: true, tag: "automation",
+			summary: "Delete an automation rule and its run log"},
+		{name: "listAutomationRuns", method: "GET", path: "/automation-rules/{id}/runs", handler: ae.ListRunsHandler, auth: true, tag: "automation",
+			summary: "Show the most recent runs of a rule", response: []AutomationRun{}},
+	}
+}
+
+func (ae *AutomationEngine) ListRulesHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+	boardID := mux.Vars(r)["id"]
+
+	err := ae.tm.checkBoardOwnership(userID, boardID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	rules := []AutomationRule{}
+	err = ae.db.Select(&rules, "SELECT * FROM automation_rules WHERE board_id = $1 ORDER BY id", boardID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
+
+func (ae *AutomationEngine) CreateRuleHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+	boardID := mux.Vars(r)["id"]
+
+	rule := AutomationRule{Enabled: true}
+	err := decodeJSON(w, r, &rule)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = ae.tm.checkBoardOwnership(userID, boardID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	rule.BoardID, _ = strconv.Atoi(boardID)
+	err = ae.validateRule(rule)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if rule.Conditions == nil {
+		rule.Conditions = RuleConditions{}
+	}
+
+	err = ae.db.Get(&rule, `
+		INSERT INTO automation_rules (board_id, name, trigger, conditions, actions, enabled)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING *
+	`, rule.BoardID, rule.Name, rule.Trigger, rule.Conditions, rule.Actions, rule.Enabled)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(rule)
+}
+
+func (ae *AutomationEngine) UpdateRuleHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	rule := AutomationRule{Enabled: true}
+	err := decodeJSON(w, r, &rule)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	existing, err := ae.getRule(userID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	rule.BoardID = existing.BoardID
+	err = ae.validateRule(rule)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if rule.Conditions == nil {
+		rule.Conditions = RuleConditions{}
+	}
+
+	err = ae.db.Get(&rule, `
+		UPDATE automation_rules SET name = $1, trigger = $2, conditions = $3, actions = $4, enabled = $5
+		WHERE id = $6
+		RETURNING *
+	`, rule.Name, rule.Trigger, rule.Conditions, rule.Actions, rule.Enabled, existing.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rule)
+}
+
+func (ae *AutomationEngine) DeleteRuleHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	rule, err := ae.getRule(userID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	_, err = ae.db.Exec("DELETE FROM automation_rules WHERE id = $1", rule.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (ae *AutomationEngine) ListRunsHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	rule, err := ae.getRule(userID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	runs := []AutomationRun{}
+	err = ae.db.Select(&runs, "SELECT * FROM automation_runs WHERE rule_id = $1 ORDER BY id DESC LIMIT 100", rule.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(runs)
+}
+
+func (ae *AutomationEngine) getRule(userID int, ruleID string) (AutomationRule, error) {
+	var rule AutomationRule
+	err := ae.db.Get(&rule, "SELECT * FROM automation_rules WHERE id = $1", ruleID)
+	if err == sql.ErrNoRows {
+		return rule, notFoundError("automation rule %s not found", ruleID)
+	}
+	if err != nil {
+		return rule, err
+	}
+
+	err = ae.tm.checkBoardOwnership(userID, strconv.Itoa(rule.BoardID))
+	return rule, err
+}
+
+// validateRule checks the cross-field requirements that struct tags cannot
+// express, including that referenced containers belong to the rule's board.
+func (ae *AutomationEngine) validateRule(rule AutomationRule) error {
+	var fieldErrs []FieldError
+
+	if rule.Trigger.Type == TriggerTaskDueSoon && rule.Trigger.WithinMinutes <= 0 {
+		fieldErrs = append(fieldErrs, FieldError{Field: "trigger.within_minutes", Message: "is required for task.due_soon"})
+	}
+	if rule.Trigger.ContainerID != 0 && !ae.containerOnBoard(rule.Trigger.ContainerID, rule.BoardID) {
+		fieldErrs = append(fieldErrs, FieldError{Field: "trigger.container_id", Message: "must be a container of this board"})
+	}
+
+	for i, c := range rule.Conditions {
+		field := fmt.Sprintf("conditions[%d].value", i)
+		switch c.Type {
+		case "title_matches":
+			if _, err := regexp.Compile(c.Value); err != nil {
+				fieldErrs = append(fieldErrs, FieldError{Field: field, Message: "must be a valid regular expression"})
+			}
+		case "assignee":
+			if _, err := strconv.Atoi(c.Value); err != nil && c.Value != "none" {
+				fieldErrs = append(fieldErrs, FieldError{Field: field, Message: `must be a user ID or "none"`})
+			}
+		case "label":
+			if strings.TrimSpace(c.Value) == "" {
+				fieldErrs = append(fieldErrs, FieldError{Field: field, Message: "is required"})
+			}
+		}
+	}
+
+	for i, a := range rule.Actions {
+		prefix := fmt.Sprintf("actions[%d].", i)
+		switch a.Type {
+		case "move":
+			if !ae.containerOnBoard(a.ContainerID, rule.BoardID) {
+				fieldErrs = append(fieldErrs, FieldError{Field: prefix + "container_id", Message: "must be a container of this board"})
+			}
+		case "add_label":
+			if strings.TrimSpace(a.Label) == "" {
+				fieldErrs = append(fieldErrs, FieldError{Field: prefix + "label", Message: "is required"})
+			}
+		case "comment":
+			if strings.TrimSpace(a.Comment) == "" {
+				fieldErrs = append(fieldErrs, FieldError{Field: prefix + "comment", Message: "is required"})
+			}
+		}
+	}
+
+	if len(fieldErrs) > 0 {
+		return validationError(fieldErrs, "invalid automation rule")
+	}
+	return nil
+}
+
+func (ae *AutomationEngine) containerOnBoard(containerID, boardID int) bool {
+	actual, err := ae.tm.boardIDForContainer(containerID)
+	return err == nil && actual == boardID
+}
+
+// HandleEvent evaluates the board's rules whose trigger matches event.
+// Rules never react to their own changes, and chains stop at
+// maxAutomationDepth.
+func (ae *AutomationEngine) HandleEvent(event Event) {
+	var task Task
+	switch data := event.Data.(type) {
+	case Task:
+		task = data
+	case TaskMove:
+		task = data.Task
+	default:
+		return
+	}
+
+	switch event.Type {
+	case TriggerTaskCreated, TriggerTaskMoved, TriggerTaskCompleted:
+	default:
+		return
+	}
+
+	rules := []AutomationRule{}
+	err := ae.db.Select(&rules, `
+		SELECT * FROM automation_rules
+		WHERE board_id = $1 AND enabled AND trigger->>'type' = $2
+		ORDER BY id
+	`, event.BoardID, event.Type)
+	if err != nil {
+		log.Printf("automation: loading rules for board %d: %v", event.BoardID, err)
+		return
+	}
+
+	for _, rule := range rules {
+		if rule.Trigger.ContainerID != 0 && rule.Trigger.ContainerID != task.ContainerID {
+			continue
+		}
+		if rule.ID == event.RuleID {
+			continue
+		}
+		if event.Depth >= maxAutomationDepth {
+			ae.recordRun(rule, task.ID, event, RunSkipped, "loop protection: too many chained rules")
+			continue
+		}
+		ae.runRule(rule, task.ID, event)
+	}
+}
+
+// runRule re-reads the task, checks the rule's conditions and applies its
+// actions, publishing an event for every change so that webhooks and other
+// rules see them.
+func (ae *AutomationEngine) runRule(rule AutomationRule, taskID int, cause Event) {
+	task, err := ae.tm.getTask(strconv.Itoa(taskID))
+	if err != nil {
+		ae.recordRun(rule, taskID, cause, RunFailed, err.Error())
+		return
+	}
+
+	if ok, reason := conditionsHold(rule.Conditions, task); !ok {
+		ae.recordRun(rule, taskID, cause, RunSkipped, reason)
+		return
+	}
+
+	var applied []string
+	var caused []Event
+	for _, action := range rule.Actions {
+		events, err := ae.apply(rule, action, &task)
+		caused = append(caused, events...)
+		if err != nil {
+			ae.recordRun(rule, taskID, cause, RunFailed, fmt.Sprintf("%s: %v", action.Type, err))
+			break
+		}
+		applied = append(applied, action.Type)
+	}
+	if len(applied) == len(rule.Actions) {
+		ae.recordRun(rule, taskID, cause, RunSucceeded, strings.Join(applied, ", "))
+	}
+
+	for _, e := range caused {
+		e.BoardID = rule.BoardID
+		e.UserID = cause.UserID
+		e.RuleID = rule.ID
+		e.Depth = cause.Depth + 1
+		e.OccurredAt = time.Now().UTC()
+		ae.tm.publish(e)
+	}
+}
+
+func conditionsHold(conditions RuleConditions, task Task) (bool, string) {
+	for _, c := range conditions {
+		var holds bool
+		switch c.Type {
+		case "label":
+			for _, label := range task.Labels {
+				if label == c.Value {
+					holds = true
+				}
+			}
+		case "assignee":
+			if c.Value == "none" {
+				holds = task.AssigneeID == nil
+			} else {
+				holds = task.AssigneeID != nil && strconv.Itoa(*task.AssigneeID) == c.Value
+			}
+		case "title_matches":
+			re, err := regexp.Compile(c.Value)
+			holds = err == nil && re.MatchString(task.Title)
+		}
+		if holds == c.Negate {
+			return false, fmt.Sprintf("condition %s %q not met", c.Type, c.Value)
+		}
+	}
+	return true, ""
+}
+
+// apply performs one action on task and returns the events it caused.
+func (ae *AutomationEngine) apply(rule AutomationRule, action RuleAction, task *Task) ([]Event, error) {
+	previous := *task
+
+	switch action.Type {
+	case "move":
+		if task.ContainerID == action.ContainerID {
+			return nil, nil
+		}
+		tx, err := ae.db.Beginx()
+		if err != nil {
+			return nil, err
+		}
+		defer tx.Rollback()
+		if _, err := ae.tm.checkWIP(tx, action.ContainerID, 1); err != nil {
+			return nil, err
+		}
+		err = tx.Get(task, moveTaskQuery, action.ContainerID, task.ID)
+		if err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		return []Event{{Type: EventTaskMoved, Data: TaskMove{Task: *task, FromContainerID: previous.ContainerID}}}, nil
+
+	case "set_completed":
+		if task.Completed == action.Completed {
+			return nil, nil
+		}
+		if action.Completed {
+			if err := ae.tm.checkCompletable(*task); err != nil {
+				return nil, err
+			}
+		}
+		err := ae.db.Get(task, "UPDATE tasks SET completed = $1 WHERE id = $2 RETURNING "+taskColumns, action.Completed, task.ID)
+		if err != nil {
+			return nil, err
+		}
+		events := []Event{{Type: EventTaskUpdated, Data: *task}}
+		if task.Completed {
+			events = append(events, Event{Type: EventTaskCompleted, Data: *task})
+		}
+		return events, nil
+
+	case "add_label":
+		err := ae.db.Get(task, `
+			UPDATE tasks SET labels = array_append(labels, $1)
+			WHERE id = $2 AND NOT ($1 = ANY(labels))
+			RETURNING `+taskColumns, strings.TrimSpace(action.Label), task.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return []Event{{Type: EventTaskUpdated, Data: *task}}, nil
+
+	case "assign":
+		previous := task.AssigneeID
+		err := ae.db.Get(task, "UPDATE tasks SET assignee_id = $1 WHERE id = $2 RETURNING "+taskColumns, action.AssigneeID, task.ID)
+		if err != nil {
+			return nil, err
+		}
+		events := []Event{{Type: EventTaskUpdated, Data: *task}}
+		if task.AssigneeID != nil && !sameID(task.AssigneeID, previous) {
+			events = append(events, Event{Type: EventTaskAssigned, Data: *task})
+		}
+		return events, nil
+
+	case "comment":
+		var comment TaskComment
+		err := ae.db.Get(&comment, "INSERT INTO task_comments (task_id, rule_id, body) VALUES ($1, $2, $3) RETURNING *", task.ID, rule.ID, action.Comment)
+		if err != nil {
+			return nil, err
+		}
+		return []Event{{Type: EventCommentCreated, Data: comment}}, nil
+	}
+
+	return nil, fmt.Errorf("unknown action %q", action.Type)
+}
+
+func (ae *AutomationEngine) recordRun(rule AutomationRule, taskID int, cause Event, status, message string) {
+	_, err := ae.db.Exec(`
+		INSERT INTO automation_runs (rule_id, task_id, event, status, message, depth)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, rule.ID, taskID, cause.Type, status, message, cause.Depth)
+	if err != nil {
+		log.Printf("automation: recording run of rule %d: %v", rule.ID, err)
+	}
+}
+
+// Run fires task.due_soon rules until ctx is cancelled. Each rule fires at
+// most once per task, tracked through the run log.
+func (ae *AutomationEngine) Run(ctx context.Context) {
+	ticker := time.NewTicker(ae.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := ae.checkDueSoon(ctx); err != nil {
+				log.Printf("automation: %v", err)
+			}
+		}
+	}
+}
+
+func (ae *AutomationEngine) checkDueSoon(ctx context.Context) error {
+	rules := []AutomationRule{}
+	err := ae.db.SelectContext(ctx, &rules, "SELECT * FROM automation_rules WHERE enabled AND trigger->>'type' = $1", TriggerTaskDueSoon)
+	if err != nil {
+		return err
+	}
+
+	for _, rule := range rules {
+		var taskIDs []int
+		err := ae.db.SelectContext(ctx, &taskIDs, `
+			SELECT t.id FROM tasks t
+			JOIN containers c ON c.id = t.container_id
+			WHERE c.board_id = $1
+				AND NOT t.completed
+				AND t.due_at IS NOT NULL
+				AND t.due_at <= now() + make_interval(mins => $2)
+				AND ($5 = 0 OR t.container_id = $5)
+				AND NOT EXISTS (
+					SELECT 1 FROM automation_runs ar
+					WHERE ar.rule_id = $3 AND ar.task_id = t.id AND ar.event = $4
+				)
+		`, rule.BoardID, rule.Trigger.WithinMinutes, rule.ID, TriggerTaskDueSoon, rule.Trigger.ContainerID)
+		if err != nil {
+			return err
+		}
+
+		for _, taskID := range taskIDs {
+			ae.runRule(rule, taskID, Event{Type: TriggerTaskDueSoon, BoardID: rule.BoardID})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestAutomationCommentIsAnnounced(t *testing.T) {
+	ts := newTestServer(t)
+	owner := ts.signup(t, "owner")
+
+	var board Board
+	ts.call(t, "POST", "/boards", owner.Token, Board{Title: "Inbox"}, &board)
+	var container Container
+	ts.call(t, "POST", fmt.Sprintf("/boards/%d/containers", board.ID), owner.Token, Container{Title: "New"}, &container)
+	var rule AutomationRule
+	ts.call(t, "POST", fmt.Sprintf("/boards/%d/automation-rules", board.ID), owner.Token, AutomationRule{
+		Name:    "Ask for triage",
+		Trigger: RuleTrigger{Type: TriggerTaskCreated},
+		Actions: RuleActions{{Type: "comment", Comment: "Please triage"}},
+		Enabled: true,
+	}, &rule)
+	// Queued deliveries show which events were published.
+	_, err := ts.db.Exec(`
+		INSERT INTO webhooks (board_id, url, events, secret) VALUES ($1, 'https://93.184.216.34/hooks', $2, 's3cret')
+	`, board.ID, pq.StringArray{EventCommentCreated})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var task Task
+	ts.call(t, "POST", fmt.Sprintf("/containers/%d/tasks", container.ID), owner.Token, Task{Title: "Fix the printer"}, &task)
+
+	var comments []TaskComment
+	if err := ts.db.Select(&comments, "SELECT * FROM task_comments WHERE task_id = $1", task.ID); err != nil {
+		t.Fatal(err)
+	}
+	if len(comments) != 1 || comments[0].RuleID == nil || *comments[0].RuleID != rule.ID {
+		t.Fatalf("comments = %+v, want one by rule %d", comments, rule.ID)
+	}
+
+	var payloads []json.RawMessage
+	if err := ts.db.Select(&payloads, "SELECT payload FROM webhook_deliveries WHERE event = $1", EventCommentCreated); err != nil {
+		t.Fatal(err)
+	}
+	if len(payloads) != 1 {
+		t.Fatalf("queued %d comment.created deliveries, want 1", len(payloads))
+	}
+	var event struct {
+		BoardID int         `json:"board_id"`
+		Data    TaskComment `json:"data"`
+	}
+	if err := json.Unmarshal(payloads[0], &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.BoardID != board.ID || event.Data.ID != comments[0].ID || event.Data.Body != "Please triage" {
+		t.Errorf("comment.created = %+v, want comment %d on board %d", event, comments[0].ID, board.ID)
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	BulkMove         = "move"
+	BulkSetCompleted = "set_completed"
+	BulkAddLabel     = "add_label"
+	BulkRemoveLabel  = "remove_label"
+	BulkAssign       = "assign"
+	BulkDelete       = "delete"
+	BulkArchive      = "archive"
+)
+
+// BulkRequest applies one operation to many tasks. The operation's
+// argument is ContainerID for move, Completed for set_completed, Label for
+// the label operations and AssigneeID for assign, where null unassigns.
+type BulkRequest struct {
+	TaskIDs     []int  `json:"task_ids" validate:"required,min=1,max=500"`
+	Operation   string `json:"operation" validate:"required,oneof=move|set_completed|add_label|remove_label|assign|delete|archive"`
+	ContainerID int    `json:"container_id,omitempty"`
+	Completed   bool   `json:"completed,omitempty"`
+	Label       string `json:"label,omitempty" validate:"max=50"`
+	AssigneeID  *int   `json:"assignee_id,omitempty"`
+}
+
+// BulkItemResult reports what happened to one task. Status is "applied",
+// "failed", or "skipped" when another task's failure stopped the batch.
+type BulkItemResult struct {
+	TaskID  int    `json:"task_id"`
+	Status  string `json:"status"`
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+	Task    *Task  `json:"task,omitempty"`
+}
+
+// BulkResult is the response of a bulk operation, and the details of the
+// error returned when it was not applied.
+type BulkResult struct {
+	Operation string           `json:"operation"`
+	Applied   bool             `json:"applied"`
+	Results   []BulkItemResult `json:"results"`
+}
+
+func (res *BulkItemResult) fail(err error) {
+	_, body, _ := describeError(err)
+	res.Status = "failed"
+	res.Code = body.Code
+	res.Message = body.Message
+}
+
+// BulkTasksHandler applies an operation to every listed task or to none.
+// Access to each task is checked before anything is written; the writes then
+// run in one transaction that stops at the first failing task.
+func (tm *TaskManager) BulkTasksHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	var req BulkRequest
+	err := decodeJSON(w, r, &req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	targetBoardID, err := tm.validateBulk(userID, &req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	result := BulkResult{Operation: req.Operation, Results: make([]BulkItemResult, len(req.TaskIDs))}
+	tasks := make([]Task, len(req.TaskIDs))
+	boardIDs := make([]int, len(req.TaskIDs))
+	var cause error
+
+	for i, id := range req.TaskIDs {
+		result.Results[i].TaskID = id
+		tasks[i], err = tm.checkTaskAccess(userID, strconv.Itoa(id))
+		if err == nil {
+			boardIDs[i], err = tm.boardIDForContainer(tasks[i].ContainerID)
+		}
+		if err != nil {
+			result.Results[i].fail(err)
+			if cause == nil {
+				cause = err
+			}
+		}
+	}
+
+	var events []Event
+	var violation *WIPViolation
+	if cause == nil {
+		events, violation, cause = tm.applyBulk(req, tasks, boardIDs, targetBoardID, &result)
+	}
+
+	if cause != nil {
+		for i := range result.Results {
+			if result.Results[i].Status != "failed" {
+				result.Results[i] = BulkItemResult{TaskID: result.Results[i].TaskID, Status: "skipped"}
+			}
+		}
+		writeError(w, r, bulkError(result, cause))
+		return
+	}
+
+	result.Applied = true
+	for _, event := range events {
+		tm.emit(r, event.Type, event.BoardID, event.Data)
+	}
+
+	flagWIP(w, violation)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// validateBulk checks the operation's argument and returns the board of the
+// destination container for moves.
+func (tm *TaskManager) validateBulk(userID int, req *BulkRequest) (int, error) {
+	var fieldErrs []FieldError
+
+	seen := map[int]bool{}
+	for _, id := range req.TaskIDs {
+		if seen[id] {
+			fieldErrs = append(fieldErrs, FieldError{Field: "task_ids", Message: fmt.Sprintf("lists task %d more than once", id)})
+			break
+		}
+		seen[id] = true
+	}
+
+	targetBoardID := 0
+	switch req.Operation {
+	case BulkMove:
+		boardID, err := tm.boardIDForContainer(req.ContainerID)
+		if err == nil {
+			err = tm.checkBoardOwnership(userID, strconv.Itoa(boardID))
+		}
+		if errors.Is(err, ErrNotFound) || errors.Is(err, ErrForbidden) {
+			fieldErrs = append(fieldErrs, FieldError{Field: "container_id", Message: "unknown container"})
+		} else if err != nil {
+			return 0, err
+		}
+		targetBoardID = boardID
+
+	case BulkAddLabel, BulkRemoveLabel:
+		req.Label = strings.TrimSpace(req.Label)
+		if req.Label == "" {
+			fieldErrs = append(fieldErrs, FieldError{Field: "label", Message: "is required"})
+		}
+
+	case BulkAssign:
+		if req.AssigneeID != nil {
+			var exists bool
+			err := tm.db.Get(&exists, "SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)", *req.AssigneeID)
+			if err != nil {
+				return 0, err
+			}
+			if !exists {
+				fieldErrs = append(fieldErrs, FieldError{Field: "assignee_id", Message: "unknown user"})
+			}
+		}
+	}
+
+	if len(fieldErrs) > 0 {
+		return 0, validationError(fieldErrs, "invalid bulk operation")
+	}
+	return targetBoardID, nil
+}
+
+// applyBulk writes the operation in a transaction and returns the events to
+// emit once it has committed, along with any warn-mode WIP violation of the
+// destination of a move.
+func (tm *TaskManager) applyBulk(req BulkRequest, tasks []Task, boardIDs []int, targetBoardID int, result *BulkResult) ([]Event, *WIPViolation, error) {
+	tx, err := tm.db.Beginx()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	var violation *WIPViolation
+	if req.Operation == BulkMove {
+		adding := 0
+		for _, task := range tasks {
+			if task.ContainerID != req.ContainerID {
+				adding++
+			}
+		}
+		violation, err = tm.checkWIP(tx, req.ContainerID, adding)
+		if err != nil {
+			for i, task := range tasks {
+				if task.ContainerID != req.ContainerID {
+					result.Results[i].fail(err)
+				}
+			}
+			return nil, nil, err
+		}
+	}
+
+	var events []Event
+	for i, task := range tasks {
+		itemEvents, err := tm.applyBulkItem(tx, req, task, boardIDs[i], targetBoardID, &result.Results[i])
+		if err != nil {
+			result.Results[i].fail(err)
+			return nil, nil, err
+		}
+		result.Results[i].Status = "applied"
+		events = append(events, itemEvents...)
+	}
+
+	return events, violation, tx.Commit()
+}
+
+func (tm *TaskManager) applyBulkItem(tx *sqlx.Tx, req BulkRequest, task Task, boardID, targetBoardID int, res *BulkItemResult) ([]Event, error) {
+	var updated Task
+	var err error
+
+	switch req.Operation {
+	case BulkMove:
+		if task.ContainerID == req.ContainerID {
+			res.Task = &task
+			return nil, nil
+		}
+		err = tx.Get(&updated, moveTaskQuery, req.ContainerID, task.ID)
+		if err != nil {
+			return nil, err
+		}
+		res.Task = &updated
+		return []Event{{Type: EventTaskMoved, BoardID: targetBoardID, Data: TaskMove{Task: updated, FromContainerID: task.ContainerID}}}, nil
+
+	case BulkSetCompleted:
+		// Re-read the task so blockers completed earlier in the batch count.
+		err = tx.Get(&task, "SELECT "+taskColumns+" FROM tasks WHERE id = $1 FOR UPDATE", task.ID)
+		if err != nil {
+			return nil, err
+		}
+		if req.Completed {
+			if err = tm.checkCompletable(task); err != nil {
+				return nil, err
+			}
+		}
+		err = tx.Get(&updated, "UPDATE tasks SET completed = $1 WHERE id = $2 RETURNING "+taskColumns, req.Completed, task.ID)
+		if err != nil {
+			return nil, err
+		}
+		res.Task = &updated
+		events := []Event{{Type: EventTaskUpdated, BoardID: boardID, Data: updated}}
+		if updated.Completed && !task.Completed {
+			events = append(events, Event{Type: EventTaskCompleted, BoardID: boardID, Data: updated})
+		}
+		return events, nil
+
+	case BulkAddLabel:
+		err = tx.Get(&updated, `
+			UPDATE tasks SET labels = CASE WHEN $1 = ANY(labels) THEN labels ELSE array_append(labels, $1) END
+			WHERE id = $2 RETURNING `+taskColumns, req.Label, task.ID)
+
+	case BulkRemoveLabel:
+		err = tx.Get(&updated, "UPDATE tasks SET labels = array_remove(labels, $1) WHERE id = $2 RETURNING "+taskColumns, req.Label, task.ID)
+
+	case BulkAssign:
+		err = tx.Get(&updated, "UPDATE tasks SET assignee_id = $1 WHERE id = $2 RETURNING "+taskColumns, req.AssigneeID, task.ID)
+		if err != nil {
+			return nil, err
+		}
+		res.Task = &updated
+		events := []Event{{Type: EventTaskUpdated, BoardID: boardID, Data: updated}}
+		if updated.AssigneeID != nil && !sameID(updated.AssigneeID, task.AssigneeID) {
+			events = append(events, Event{Type: EventTaskAssigned, BoardID: boardID, Data: updated})
+		}
+		return events, nil
+
+	case BulkArchive:
+		err = tx.Get(&updated, "UPDATE tasks SET archived_at = COALESCE(archived_at, now()) WHERE id = $1 RETURNING "+taskColumns, task.ID)
+
+	case BulkDelete:
+		_, err = tx.Exec("DELETE FROM tasks WHERE id = $1", task.ID)
+		if err != nil {
+			return nil, err
+		}
+		return []Event{{Type: EventTaskDeleted, BoardID: boardID, Data: task}}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	res.Task = &updated
+	return []Event{{Type: EventTaskUpdated, BoardID: boardID, Data: updated}}, nil
+}
+
+// bulkError reports a batch that was not applied, with the status of the
+// first failure and the per-task report as details. Internal errors are
+// returned unchanged so they are logged and hidden like any other.
+func bulkError(result BulkResult, cause error) error {
+	_, body, matched := describeError(cause)
+	if !matched {
+		return cause
+	}
+	return &DomainError{
+		Kind:    cause,
+		Message: fmt.Sprintf("bulk %s was not applied: %s", result.Operation, body.Message),
+		Details: result,
+	}
+}
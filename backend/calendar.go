@@ -0,0 +1,302 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+)
+
+// CalendarFeeds serves the tasks with due dates as iCalendar (RFC 5545)
+// feeds. Calendar apps cannot log in, so each user has a secret token that
+// authenticates their feed URLs instead.
+type CalendarFeeds struct {
+	db *sqlx.DB
+	tm *TaskManager
+}
+
+func NewCalendarFeeds(db *sqlx.DB, tm *TaskManager) *CalendarFeeds {
+	return &CalendarFeeds{db: db, tm: tm}
+}
+
+func (cf *CalendarFeeds) routes() []route {
+	feedParams := []queryParam{
+		{name: "token", description: "the caller's calendar feed token"},
+		{name: "format", description: "event (default) for VEVENT entries or todo for VTODO entries"},
+		{name: "include_completed", description: "also list completed tasks", typ: "boolean"},
+	}
+	return []route{
+		{name: "getCalendarFeed", method: "GET", path: "/calendar/feed", handler: cf.GetFeedHandler, auth: true, tag: "calendar",
+			summary: "Get the caller's calendar feed URLs, creating the token on first use", response: CalendarFeed{}},
+		{name: "regenerateCalendarFeed", method: "POST", path: "/calendar/feed/regenerate", handler: cf.RegenerateFeedHandler, auth: true, tag: "calendar",
+			summary: "Replace the caller's calendar feed token, disabling the old URLs", response: CalendarFeed{}},
+		{name: "calendarFeed", method: "GET", path: "/calendar.ics", handler: cf.FeedHandler, tag: "calendar",
+			summary: "iCalendar feed of the due tasks on all of a user's boards", query: feedParams},
+		{name: "boardCalendarFeed", method: "GET", path: "/boards/{id}/calendar.ics", handler: cf.FeedHandler, tag: "calendar",
+			summary: "iCalendar feed of the due tasks on one board", query: feedParams},
+	}
+}
+
+// CalendarFeed describes a user's feed URLs. BoardURL contains {id} in place
+// of the board ID.
+type CalendarFeed struct {
+	Token     string    `json:"token" db:"token"`
+	URL       string    `json:"url"`
+	BoardURL  string    `json:"board_url"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// icalPriorities maps task priorities to the iCalendar PRIORITY scale, where
+// 1 is the highest and 9 the lowest.
+var icalPriorities = map[string]int{
+	PriorityUrgent: 1,
+	PriorityHigh:   3,
+	PriorityMedium: 5,
+	PriorityLow:    7,
+}
+
+func (cf *CalendarFeeds) GetFeedHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	token, err := newFeedToken()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	_, err = cf.db.Exec("INSERT INTO calendar_tokens (user_id, token) VALUES ($1, $2) ON CONFLICT (user_id) DO NOTHING", userID, token)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var feed CalendarFeed
+	err = cf.db.Get(&feed, "SELECT token, created_at FROM calendar_tokens WHERE user_id = $1", userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	feed.setURLs(r)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(feed)
+}
+
+func (cf *CalendarFeeds) RegenerateFeedHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	token, err := newFeedToken()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var feed CalendarFeed
+	err = cf.db.Get(&feed, `
+		INSERT INTO calendar_tokens (user_id, token) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET token = EXCLUDED.token, created_at = now()
+		RETURNING token, created_at
+	`, userID, token)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	feed.setURLs(r)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(feed)
+}
+
+// FeedHandler renders the token owner's due tasks, on all of their boards or
+// on the board in the path. Tasks on archived boards or containers and
+// archived tasks are left out.
+func (cf *CalendarFeeds) FeedHandler(w http.ResponseWriter, r *http.Request) {
+
+	query := r.URL.Query()
+
+	format := query.Get("format")
+	if format == "" {
+		format = "event"
+	}
+	if format != "event" && format != "todo" {
+		writeError(w, r, validationError([]FieldError{{Field: "format", Message: "must be one of event, todo"}}, "invalid query"))
+		return
+	}
+
+	var userID int
+	err := cf.db.Get(&userID, "SELECT user_id FROM calendar_tokens WHERE token = $1", query.Get("token"))
+	if err == sql.ErrNoRows || query.Get("token") == "" {
+		writeError(w, r, notFoundError("calendar feed not found"))
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	name := "Tasks due"
+	boardID := 0
+	if id, ok := mux.Vars(r)["id"]; ok {
+		var board Board
+		err = cf.db.Get(&board, "SELECT "+boardColumns+" FROM boards WHERE id = $1 AND user_id = $2", id, userID)
+		if err == sql.ErrNoRows {
+			writeError(w, r, notFoundError("calendar feed not found"))
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		name, boardID = board.Title, board.ID
+	}
+
+	var boardTitles []struct {
+		ContainerID int    `db:"container_id"`
+		Title       string `db:"title"`
+	}
+	err = cf.db.Select(&boardTitles, `
+		SELECT c.id AS container_id, b.title FROM containers c JOIN boards b ON b.id = c.board_id
+		WHERE b.user_id = $1 AND b.archived_at IS NULL AND c.archived_at IS NULL
+		AND ($2::integer = 0 OR b.id = $2::integer)
+	`, userID, boardID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	boards := map[int]string{}
+	for _, bt := range boardTitles {
+		boards[bt.ContainerID] = bt.Title
+	}
+
+	includeCompleted, _ := strconv.ParseBool(query.Get("include_completed"))
+	tasks := []Task{}
+	err = cf.db.Select(&tasks, `
+		SELECT `+taskColumns+` FROM tasks
+		WHERE container_id IN (
+			SELECT c.id FROM containers c JOIN boards b ON b.id = c.board_id
+			WHERE b.user_id = $1 AND b.archived_at IS NULL AND c.archived_at IS NULL
+			AND ($2::integer = 0 OR b.id = $2::integer)
+		)
+		AND due_at IS NOT NULL AND archived_at IS NULL AND ($3 OR NOT completed)
+		ORDER BY due_at, id
+	`, userID, boardID, includeCompleted)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	fmt.Fprint(w, renderCalendar(name, tasks, boards, format, time.Now().UTC()))
+}
+
+// renderCalendar writes tasks as an iCalendar object of VEVENT or VTODO
+// components. boards maps container IDs to board titles, which become the
+// entries' first category.
+func renderCalendar(name string, tasks []Task, boards map[int]string, format string, now time.Time) string {
+	var c icalWriter
+	c.line("BEGIN", "VCALENDAR")
+	c.line("VERSION", "2.0")
+	c.line("PRODID", "-//taskapp//Due tasks//EN")
+	c.line("CALSCALE", "GREGORIAN")
+	c.line("METHOD", "PUBLISH")
+	c.line("X-WR-CALNAME", icalText(name))
+
+	component := "VEVENT"
+	if format == "todo" {
+		component = "VTODO"
+	}
+	for _, task := range tasks {
+		c.line("BEGIN", component)
+		c.line("UID", fmt.Sprintf("task-%d@taskapp", task.ID))
+		c.line("DTSTAMP", now.Format("20060102T150405Z"))
+
+		due := task.DueAt.UTC()
+		dueProp := "DUE"
+		if component == "VEVENT" {
+			dueProp = "DTSTART"
+		}
+		// Due dates without a time of day are stored at midnight UTC and
+		// become all-day entries.
+		if due.Equal(due.Truncate(24 * time.Hour)) {
+			c.line(dueProp+";VALUE=DATE", due.Format("20060102"))
+		} else {
+			c.line(dueProp, due.Format("20060102T150405Z"))
+		}
+
+		c.line("SUMMARY", icalText(task.Title))
+		if task.Description != "" {
+			c.line("DESCRIPTION", icalText(task.Description))
+		}
+		categories := []string{icalText(boards[task.ContainerID])}
+		for _, label := range task.Labels {
+			categories = append(categories, icalText(label))
+		}
+		c.line("CATEGORIES", strings.Join(categories, ","))
+
+		if component == "VTODO" {
+			if priority, ok := icalPriorities[task.Priority]; ok {
+				c.line("PRIORITY", strconv.Itoa(priority))
+			}
+			if task.Completed {
+				c.line("STATUS", "COMPLETED")
+			} else {
+				c.line("STATUS", "NEEDS-ACTION")
+			}
+		}
+		c.line("END", component)
+	}
+	c.line("END", "VCALENDAR")
+	return c.b.String()
+}
+
+// icalWriter builds iCalendar content lines, folding them at 75 octets
+// without splitting UTF-8 sequences.
+type icalWriter struct {
+	b strings.Builder
+}
+
+func (c *icalWriter) line(name, value string) {
+	line := name + ":" + value
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		c.b.WriteString(line[:cut])
+		c.b.WriteString("\r\n ")
+		line = line[cut:]
+		limit = 74
+	}
+	c.b.WriteString(line)
+	c.b.WriteString("\r\n")
+}
+
+// icalText escapes a TEXT property value.
+func icalText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`).Replace(s)
+}
+
+func (f *CalendarFeed) setURLs(r *http.Request) {
+	base := "https://" + r.Host
+	f.URL = base + "/calendar.ics?token=" + f.Token
+	f.BoardURL = base + "/boards/{id}/calendar.ics?token=" + f.Token
+}
+
+func newFeedToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
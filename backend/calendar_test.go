@@ -0,0 +1,257 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+type icalProperty struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+type icalComponent struct {
+	name       string
+	properties []icalProperty
+	children   []*icalComponent
+}
+
+func (c *icalComponent) get(name string) (icalProperty, bool) {
+	for _, p := range c.properties {
+		if p.name == name {
+			return p, true
+		}
+	}
+	return icalProperty{}, false
+}
+
+// parseICal parses an iCalendar object, failing the test on anything RFC
+// 5545 does not allow in the subset renderCalendar writes: lines not ended
+// by CRLF, lines over 75 octets, and folds inside UTF-8 sequences.
+func parseICal(t *testing.T, s string) *icalComponent {
+	t.Helper()
+
+	if !strings.HasSuffix(s, "\r\n") {
+		t.Fatalf("output does not end with CRLF")
+	}
+	physical := strings.Split(strings.TrimSuffix(s, "\r\n"), "\r\n")
+
+	var lines []string
+	for i, line := range physical {
+		if strings.ContainsAny(line, "\r\n") {
+			t.Fatalf("line %d contains a bare CR or LF: %q", i+1, line)
+		}
+		if len(line) > 75 {
+			t.Errorf("line %d is %d octets long: %q", i+1, len(line), line)
+		}
+		if !utf8.ValidString(line) {
+			t.Errorf("line %d splits a UTF-8 sequence: %q", i+1, line)
+		}
+		if strings.HasPrefix(line, " ") {
+			if len(lines) == 0 {
+				t.Fatalf("line %d continues nothing", i+1)
+			}
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	root := &icalComponent{}
+	stack := []*icalComponent{root}
+	for _, line := range lines {
+		colon := strings.Index(line, ":")
+		if colon < 0 {
+			t.Fatalf("content line without a value: %q", line)
+		}
+		nameParams := strings.Split(line[:colon], ";")
+		prop := icalProperty{name: nameParams[0], params: map[string]string{}, value: line[colon+1:]}
+		for _, param := range nameParams[1:] {
+			kv := strings.SplitN(param, "=", 2)
+			if len(kv) != 2 {
+				t.Fatalf("malformed parameter in %q", line)
+			}
+			prop.params[kv[0]] = kv[1]
+		}
+
+		current := stack[len(stack)-1]
+		switch prop.name {
+		case "BEGIN":
+			child := &icalComponent{name: prop.value}
+			current.children = append(current.children, child)
+			stack = append(stack, child)
+		case "END":
+			if current.name != prop.value {
+				t.Fatalf("END:%s closes %s", prop.value, current.name)
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			current.properties = append(current.properties, prop)
+		}
+	}
+	if len(stack) != 1 || len(root.children) != 1 {
+		t.Fatalf("expected exactly one closed top-level component")
+	}
+	return root.children[0]
+}
+
+// icalUnescape splits a TEXT value on unescaped commas and undoes the
+// escaping of each part.
+func icalUnescape(t *testing.T, value string) []string {
+	t.Helper()
+
+	var parts []string
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c == '\\' && i+1 < len(value):
+			i++
+			switch value[i] {
+			case 'n', 'N':
+				b.WriteByte('\n')
+			case '\\', ';', ',':
+				b.WriteByte(value[i])
+			default:
+				t.Errorf("invalid escape \\%c in %q", value[i], value)
+			}
+		case c == ',':
+			parts = append(parts, b.String())
+			b.Reset()
+		case c == ';':
+			t.Errorf("unescaped semicolon in %q", value)
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return append(parts, b.String())
+}
+
+func TestRenderCalendar(t *testing.T) {
+	now := time.Date(2026, 3, 1, 8, 30, 0, 0, time.UTC)
+	timed := time.Date(2026, 3, 4, 14, 15, 0, 0, time.UTC)
+	allDay := time.Date(2026, 3, 5, 0, 0, 0, 0, time.UTC)
+
+	longTitle := strings.Repeat("Überprüfung der Größenänderung 🚀 ", 4)
+	tasks := []Task{
+		{
+			ID: 1, ContainerID: 10, Title: longTitle, Priority: PriorityHigh, DueAt: &timed,
+			Description: "Steps: build, test; ship\\deploy\nthen celebrate\r\nreally",
+			Labels:      []string{"release", "a,b;c"},
+		},
+		{ID: 2, ContainerID: 10, Title: "Holiday", DueAt: &allDay, Completed: true},
+	}
+	boards := map[int]string{10: "Team; Ops, EU"}
+
+	for _, format := range []string{"event", "todo"} {
+		t.Run(format, func(t *testing.T) {
+			calendar := parseICal(t, renderCalendar("Due, soon", tasks, boards, format, now))
+			if calendar.name != "VCALENDAR" {
+				t.Fatalf("top-level component is %s", calendar.name)
+			}
+			if name, _ := calendar.get("X-WR-CALNAME"); strings.Join(icalUnescape(t, name.value), ",") != "Due, soon" {
+				t.Errorf("X-WR-CALNAME = %q", name.value)
+			}
+
+			component, dueProp := "VEVENT", "DTSTART"
+			if format == "todo" {
+				component, dueProp = "VTODO", "DUE"
+			}
+			if len(calendar.children) != len(tasks) {
+				t.Fatalf("got %d components, want %d", len(calendar.children), len(tasks))
+			}
+			for _, c := range calendar.children {
+				if c.name != component {
+					t.Errorf("component is %s, want %s", c.name, component)
+				}
+				if stamp, _ := c.get("DTSTAMP"); stamp.value != "20260301T083000Z" {
+					t.Errorf("DTSTAMP = %q", stamp.value)
+				}
+			}
+
+			first, second := calendar.children[0], calendar.children[1]
+			if uid, _ := first.get("UID"); uid.value != "task-1@taskapp" {
+				t.Errorf("UID = %q", uid.value)
+			}
+
+			due, ok := first.get(dueProp)
+			if !ok || due.value != "20260304T141500Z" || len(due.params) != 0 {
+				t.Errorf("timed %s = %+v", dueProp, due)
+			}
+			due, ok = second.get(dueProp)
+			if !ok || due.value != "20260305" || due.params["VALUE"] != "DATE" {
+				t.Errorf("all-day %s = %+v", dueProp, due)
+			}
+
+			summary, _ := first.get("SUMMARY")
+			if got := icalUnescape(t, summary.value); len(got) != 1 || got[0] != longTitle {
+				t.Errorf("SUMMARY = %q, want %q", got, longTitle)
+			}
+			description, _ := first.get("DESCRIPTION")
+			want := "Steps: build, test; ship\\deploy\nthen celebrate\nreally"
+			if got := icalUnescape(t, description.value); len(got) != 1 || got[0] != want {
+				t.Errorf("DESCRIPTION = %q, want %q", got, want)
+			}
+			if _, ok := second.get("DESCRIPTION"); ok {
+				t.Errorf("task without a description has a DESCRIPTION")
+			}
+
+			categories, _ := first.get("CATEGORIES")
+			got := icalUnescape(t, categories.value)
+			if strings.Join(got, "|") != "Team; Ops, EU|release|a,b;c" {
+				t.Errorf("CATEGORIES = %q", got)
+			}
+
+			priority, hasPriority := first.get("PRIORITY")
+			status, _ := second.get("STATUS")
+			if format == "todo" {
+				if priority.value != "3" {
+					t.Errorf("PRIORITY = %q, want 3", priority.value)
+				}
+				if status.value != "COMPLETED" {
+					t.Errorf("STATUS = %q, want COMPLETED", status.value)
+				}
+				if _, ok := second.get("PRIORITY"); ok {
+					t.Errorf("task without a priority has a PRIORITY")
+				}
+			} else if hasPriority {
+				t.Errorf("VEVENT has a PRIORITY")
+			}
+		})
+	}
+}
+
+func TestICalWriterFoldsAtOctets(t *testing.T) {
+	// Every rune is two octets, so an odd limit lands inside one.
+	value := strings.Repeat("é", 100)
+
+	var c icalWriter
+	c.line("SUMMARY", value)
+	out := c.b.String()
+
+	lines := strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n")
+	if len(lines) < 3 {
+		t.Fatalf("expected the line to be folded, got %q", out)
+	}
+	unfolded := lines[0]
+	for i, line := range lines {
+		if len(line) > 75 {
+			t.Errorf("line %d is %d octets long", i+1, len(line))
+		}
+		if !utf8.ValidString(line) {
+			t.Errorf("line %d splits a UTF-8 sequence: %q", i+1, line)
+		}
+		if i > 0 {
+			if !strings.HasPrefix(line, " ") {
+				t.Errorf("continuation line %d does not start with a space", i+1)
+			}
+			unfolded += line[1:]
+		}
+	}
+	if unfolded != "SUMMARY:"+value {
+		t.Errorf("unfolded line = %q", unfolded)
+	}
+}
@@ -0,0 +1,427 @@
+// Package client is a typed Go client for the taskapp HTTP API.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client talks to a taskapp server. It is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	maxRetries int
+	backoff    time.Duration
+
+	mu          sync.Mutex
+	token       string
+	credentials *credentials
+}
+
+type credentials struct {
+	username string
+	password string
+}
+
+type Option func(*Client)
+
+// WithHTTPClient replaces the default http.Client, e.g. to trust the
+// server's self-signed certificate.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// WithToken starts the client with an existing bearer token.
+func WithToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// WithRetries sets how often idempotent requests are retried after network
+// errors, 429 or 5xx responses, and the base delay of the exponential backoff.
+func WithRetries(maxRetries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.backoff = backoff
+	}
+}
+
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		maxRetries: 3,
+		backoff:    200 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Token returns the current bearer token.
+func (c *Client) Token() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.token
+}
+
+func (c *Client) setToken(token string) {
+	c.mu.Lock()
+	c.token = token
+	c.mu.Unlock()
+}
+
+// refreshWindow is how close to expiry a token may get before the client
+// renews it ahead of the next request.
+const refreshWindow = 5 * time.Minute
+
+// do sends one API request. Authenticated requests renew the token when it
+// is about to expire, and re-login once on 401 when Login credentials are
+// known.
+func (c *Client) do(ctx context.Context, method, path string, auth bool, body, out interface{}) error {
+	if auth {
+		if err := c.refreshIfExpiring(ctx); err != nil {
+			return err
+		}
+	}
+
+	err := c.send(ctx, method, path, auth, body, out)
+	if auth && IsUnauthorized(err) {
+		c.mu.Lock()
+		creds := c.credentials
+		c.mu.Unlock()
+		if creds != nil {
+			if _, loginErr := c.Login(ctx, creds.username, creds.password); loginErr == nil {
+				return c.send(ctx, method, path, auth, body, out)
+			}
+		}
+	}
+	return err
+}
+
+func (c *Client) send(ctx context.Context, method, path string, auth bool, body, out interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	retries := 0
+	if method != http.MethodPost {
+		retries = c.maxRetries
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := c.attempt(ctx, method, path, auth, payload)
+		if (err == nil && !retryable(resp.StatusCode)) || attempt >= retries {
+			if err != nil {
+				return err
+			}
+			return decodeResponse(resp, out)
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		delay := c.backoff << attempt
+		delay += time.Duration(rand.Int63n(int64(delay)/2 + 1))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (c *Client) attempt(ctx context.Context, method, path string, auth bool, payload []byte) (*http.Response, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if auth {
+		req.Header.Set("Authorization", "Bearer "+c.Token())
+	}
+
+	return c.httpClient.Do(req)
+}
+
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+func decodeResponse(resp *http.Response, out interface{}) error {
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		apiErr := &Error{StatusCode: resp.StatusCode}
+		data, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(data, apiErr) != nil || apiErr.Code == "" {
+			apiErr.Code = codeForStatus(resp.StatusCode)
+			apiErr.Message = strings.TrimSpace(string(data))
+		}
+		return apiErr
+	}
+
+	if out == nil {
+		return nil
+	}
+	if w, ok := out.(io.Writer); ok {
+		_, err := io.Copy(w, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func codeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeBadRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusUnprocessableEntity:
+		return CodeValidation
+	case http.StatusRequestEntityTooLarge:
+		return CodePayloadTooLarge
+	}
+	return CodeInternal
+}
+
+func (c *Client) refreshIfExpiring(ctx context.Context) error {
+	token := c.Token()
+	if token == "" {
+		return nil
+	}
+	expiry, ok := tokenExpiry(token)
+	// Expired tokens cannot be refreshed; the 401 handling in do re-logs in.
+	if !ok || time.Until(expiry) > refreshWindow || time.Now().After(expiry) {
+		return nil
+	}
+	_, err := c.Refresh(ctx)
+	return err
+}
+
+// tokenExpiry reads the exp claim of a JWT without verifying it; the server
+// remains the authority on whether the token is valid.
+func tokenExpiry(token string) (time.Time, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, false
+	}
+	var claims struct {
+		ExpiresAt int64 `json:"exp"`
+	}
+	if json.Unmarshal(payload, &claims) != nil || claims.ExpiresAt == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(claims.ExpiresAt, 0), true
+}
+
+func (c *Client) Signup(ctx context.Context, username, email, password string) (*Session, error) {
+	var session Session
+	body := map[string]string{"username": username, "email": email, "password": password}
+	if err := c.do(ctx, http.MethodPost, "/signup", false, body, &session); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.token = session.Token
+	c.credentials = &credentials{username: username, password: password}
+	c.mu.Unlock()
+	return &session, nil
+}
+
+// Login authenticates and remembers the credentials so that an expired
+// session can be renewed transparently.
+func (c *Client) Login(ctx context.Context, username, password string) (*Session, error) {
+	var session Session
+	body := map[string]string{"username": username, "password": password}
+	if err := c.send(ctx, http.MethodPost, "/login", false, body, &session); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.token = session.Token
+	c.credentials = &credentials{username: username, password: password}
+	c.mu.Unlock()
+	return &session, nil
+}
+
+// Refresh exchanges the current token for a new one.
+func (c *Client) Refresh(ctx context.Context) (*Session, error) {
+	var session Session
+	if err := c.send(ctx, http.MethodPost, "/token/refresh", true, nil, &session); err != nil {
+		return nil, err
+	}
+	c.setToken(session.Token)
+	return &session, nil
+}
+
+func (c *Client) Logout(ctx context.Context) error {
+	err := c.do(ctx, http.MethodPost, "/logout", false, nil, nil)
+	c.mu.Lock()
+	c.token = ""
+	c.credentials = nil
+	c.mu.Unlock()
+	return err
+}
+
+func (c *Client) ListBoards(ctx context.Context) ([]Board, error) {
+	var boards []Board
+	err := c.do(ctx, http.MethodGet, "/boards", true, nil, &boards)
+	return boards, err
+}
+
+func (c *Client) CreateBoard(ctx context.Context, board Board) (*Board, error) {
+	var created Board
+	if err := c.do(ctx, http.MethodPost, "/boards", true, board, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+func (c *Client) UpdateBoard(ctx context.Context, board Board) (*Board, error) {
+	var updated Board
+	if err := c.do(ctx, http.MethodPut, fmt.Sprintf("/boards/%d", board.ID), true, board, &updated); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+func (c *Client) DeleteBoard(ctx context.Context, boardID int) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("/boards/%d", boardID), true, nil, nil)
+}
+
+func (c *Client) ListContainers(ctx context.Context, boardID int) ([]Container, error) {
+	var containers []Container
+	err := c.do(ctx, http.MethodGet, fmt.Sprintf("/boards/%d/containers", boardID), true, nil, &containers)
+	return containers, err
+}
+
+func (c *Client) CreateContainer(ctx context.Context, boardID int, container Container) (*Container, error) {
+	var created Container
+	if err := c.do(ctx, http.MethodPost, fmt.Sprintf("/boards/%d/containers", boardID), true, container, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+func (c *Client) UpdateContainer(ctx context.Context, container Container) error {
+	return c.do(ctx, http.MethodPut, fmt.Sprintf("/containers/%d", container.ID), true, container, nil)
+}
+
+func (c *Client) DeleteContainer(ctx context.Context, containerID int) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("/containers/%d", containerID), true, nil, nil)
+}
+
+func (c *Client) CreateSwimlane(ctx context.Context, boardID int, swimlane Swimlane) (*Swimlane, error) {
+	var created Swimlane
+	if err := c.do(ctx, http.MethodPost, fmt.Sprintf("/boards/%d/swimlanes", boardID), true, swimlane, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+func (c *Client) ListCustomFields(ctx context.Context, boardID int) ([]CustomField, error) {
+	var fields []CustomField
+	err := c.do(ctx, http.MethodGet, fmt.Sprintf("/boards/%d/custom-fields", boardID), true, nil, &fields)
+	return fields, err
+}
+
+func (c *Client) CreateCustomField(ctx context.Context, boardID int, field CustomField) (*CustomField, error) {
+	var created CustomField
+	if err := c.do(ctx, http.MethodPost, fmt.Sprintf("/boards/%d/custom-fields", boardID), true, field, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+func (c *Client) ListTasks(ctx context.Context, containerID int) ([]Task, error) {
+	var tasks []Task
+	err := c.do(ctx, http.MethodGet, fmt.Sprintf("/containers/%d/tasks", containerID), true, nil, &tasks)
+	return tasks, err
+}
+
+func (c *Client) CreateTask(ctx context.Context, containerID int, task Task) (*Task, error) {
+	var created Task
+	if err := c.do(ctx, http.MethodPost, fmt.Sprintf("/containers/%d/tasks", containerID), true, task, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+func (c *Client) UpdateTask(ctx context.Context, task Task) (*Task, error) {
+	var updated Task
+	if err := c.do(ctx, http.MethodPut, fmt.Sprintf("/tasks/%d", task.ID), true, task, &updated); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+func (c *Client) MoveTask(ctx context.Context, taskID, containerID int) (*Task, error) {
+	var moved Task
+	body := map[string]int{"container_id": containerID}
+	if err := c.do(ctx, http.MethodPost, fmt.Sprintf("/tasks/%d/move", taskID), true, body, &moved); err != nil {
+		return nil, err
+	}
+	return &moved, nil
+}
+
+func (c *Client) DeleteTask(ctx context.Context, taskID int) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("/tasks/%d", taskID), true, nil, nil)
+}
+
+func (c *Client) ListLinks(ctx context.Context, taskID int) ([]TaskLink, error) {
+	var links []TaskLink
+	err := c.do(ctx, http.MethodGet, fmt.Sprintf("/tasks/%d/links", taskID), true, nil, &links)
+	return links, err
+}
+
+func (c *Client) CreateLink(ctx context.Context, taskID int, link TaskLink) (*TaskLink, error) {
+	var created TaskLink
+	if err := c.do(ctx, http.MethodPost, fmt.Sprintf("/tasks/%d/links", taskID), true, link, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+func (c *Client) GetUserData(ctx context.Context) (*UserData, error) {
+	var data UserData
+	if err := c.do(ctx, http.MethodGet, "/user-data", true, nil, &data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// SyncBoard replaces the containers and tasks of data.BoardID.
+func (c *Client) SyncBoard(ctx context.Context, data SyncData) error {
+	return c.do(ctx, http.MethodPost, "/update-user-data", true, data, nil)
+}
@@ -0,0 +1,371 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testToken builds an unsigned JWT expiring at exp; the client only reads
+// the claims.
+func testToken(exp time.Time, n int) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	claims := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"user_id":1,"exp":%d,"n":%d}`, exp.Unix(), n)))
+	return header + "." + claims + ".signature"
+}
+
+// fakeServer records the requests it receives and answers them with the
+// handler registered for the path.
+type fakeServer struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	calls map[string]int
+	times map[string][]time.Time
+}
+
+func newFakeServer(t *testing.T, handlers map[string]http.HandlerFunc) *fakeServer {
+	fs := &fakeServer{calls: map[string]int{}, times: map[string][]time.Time{}}
+	fs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Method + " " + r.URL.Path
+		fs.mu.Lock()
+		fs.calls[key]++
+		fs.times[key] = append(fs.times[key], time.Now())
+		fs.mu.Unlock()
+
+		h, ok := handlers[key]
+		if !ok {
+			writeEnvelope(w, http.StatusNotFound, CodeNotFound, "no route "+key)
+			return
+		}
+		h(w, r)
+	}))
+	t.Cleanup(fs.Close)
+	return fs
+}
+
+func (fs *fakeServer) count(key string) int {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.calls[key]
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeEnvelope(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, map[string]string{"code": code, "message": message, "request_id": "req-1"})
+}
+
+func TestRefreshesTokenCloseToExpiry(t *testing.T) {
+	fresh := testToken(time.Now().Add(time.Hour), 2)
+	fs := newFakeServer(t, map[string]http.HandlerFunc{
+		"POST /token/refresh": func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, Session{ID: 1, Username: "ada", Token: fresh})
+		},
+		"GET /boards": func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer "+fresh {
+				writeEnvelope(w, http.StatusUnauthorized, CodeUnauthorized, "invalid token")
+				return
+			}
+			writeJSON(w, http.StatusOK, []Board{{ID: 7, Title: "Roadmap"}})
+		},
+	})
+
+	c := New(fs.URL, WithToken(testToken(time.Now().Add(time.Minute), 1)))
+	boards, err := c.ListBoards(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(boards) != 1 || boards[0].ID != 7 {
+		t.Errorf("boards = %+v", boards)
+	}
+	if n := fs.count("POST /token/refresh"); n != 1 {
+		t.Errorf("refreshed %d times, want 1", n)
+	}
+	if c.Token() != fresh {
+		t.Errorf("token was not replaced by the refreshed one")
+	}
+}
+
+func TestDoesNotRefreshFreshOrExpiredTokens(t *testing.T) {
+	for name, exp := range map[string]time.Time{
+		"fresh":   time.Now().Add(time.Hour),
+		"expired": time.Now().Add(-time.Minute),
+	} {
+		t.Run(name, func(t *testing.T) {
+			fs := newFakeServer(t, map[string]http.HandlerFunc{
+				"GET /boards": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(w, http.StatusOK, []Board{})
+				},
+			})
+
+			c := New(fs.URL, WithToken(testToken(exp, 1)))
+			if _, err := c.ListBoards(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if n := fs.count("POST /token/refresh"); n != 0 {
+				t.Errorf("refreshed %d times, want 0", n)
+			}
+		})
+	}
+}
+
+func TestLogsInAgainOnUnauthorized(t *testing.T) {
+	var mu sync.Mutex
+	logins := 0
+	fs := newFakeServer(t, map[string]http.HandlerFunc{
+		"POST /login": func(w http.ResponseWriter, r *http.Request) {
+			var creds map[string]string
+			json.NewDecoder(r.Body).Decode(&creds)
+			if creds["username"] != "ada" || creds["password"] != "lovelace" {
+				writeEnvelope(w, http.StatusUnauthorized, CodeUnauthorized, "invalid credentials")
+				return
+			}
+			mu.Lock()
+			logins++
+			n := logins
+			mu.Unlock()
+			writeJSON(w, http.StatusOK, Session{ID: 1, Username: "ada", Token: fmt.Sprintf("token-%d", n)})
+		},
+		"GET /boards": func(w http.ResponseWriter, r *http.Request) {
+			// The server has revoked the first session.
+			if r.Header.Get("Authorization") != "Bearer token-2" {
+				writeEnvelope(w, http.StatusUnauthorized, CodeUnauthorized, "invalid token")
+				return
+			}
+			writeJSON(w, http.StatusOK, []Board{{ID: 1}})
+		},
+	})
+
+	c := New(fs.URL)
+	if _, err := c.Login(context.Background(), "ada", "lovelace"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ListBoards(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := fs.count("POST /login"); n != 2 {
+		t.Errorf("logged in %d times, want 2", n)
+	}
+	if c.Token() != "token-2" {
+		t.Errorf("token = %q, want token-2", c.Token())
+	}
+}
+
+func TestUnauthorizedWithoutCredentials(t *testing.T) {
+	fs := newFakeServer(t, map[string]http.HandlerFunc{
+		"GET /boards": func(w http.ResponseWriter, r *http.Request) {
+			writeEnvelope(w, http.StatusUnauthorized, CodeUnauthorized, "invalid token")
+		},
+	})
+
+	c := New(fs.URL, WithToken("stale"))
+	_, err := c.ListBoards(context.Background())
+	if !IsUnauthorized(err) {
+		t.Fatalf("err = %v, want unauthorized", err)
+	}
+	if n := fs.count("POST /login"); n != 0 {
+		t.Errorf("logged in %d times without credentials", n)
+	}
+}
+
+func TestRetriesIdempotentRequestsWithBackoff(t *testing.T) {
+	var mu sync.Mutex
+	failures := 2
+	fs := newFakeServer(t, map[string]http.HandlerFunc{
+		"GET /boards": func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			if failures > 0 {
+				failures--
+				writeEnvelope(w, http.StatusServiceUnavailable, CodeInternal, "try again")
+				return
+			}
+			writeJSON(w, http.StatusOK, []Board{{ID: 3}})
+		},
+		"PUT /boards/3": func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTooManyRequests)
+		},
+	})
+
+	backoff := 20 * time.Millisecond
+	c := New(fs.URL, WithToken("token"), WithRetries(2, backoff))
+	boards, err := c.ListBoards(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(boards) != 1 {
+		t.Errorf("boards = %+v", boards)
+	}
+
+	times := fs.times["GET /boards"]
+	if len(times) != 3 {
+		t.Fatalf("sent %d requests, want 3", len(times))
+	}
+	// The delay doubles after each attempt.
+	for i := 1; i < len(times); i++ {
+		if gap, min := times[i].Sub(times[i-1]), backoff<<(i-1); gap < min {
+			t.Errorf("retry %d after %v, want at least %v", i, gap, min)
+		}
+	}
+
+	// Retries give up after maxRetries and report the last response.
+	_, err = c.UpdateBoard(context.Background(), Board{ID: 3, Title: "Roadmap"})
+	if n := fs.count("PUT /boards/3"); n != 3 {
+		t.Errorf("sent %d PUT requests, want 3", n)
+	}
+	if apiErr, ok := err.(*Error); !ok || apiErr.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("err = %v, want the 429 response", err)
+	}
+}
+
+func TestDoesNotRetryPost(t *testing.T) {
+	fs := newFakeServer(t, map[string]http.HandlerFunc{
+		"POST /boards": func(w http.ResponseWriter, r *http.Request) {
+			writeEnvelope(w, http.StatusServiceUnavailable, CodeInternal, "try again")
+		},
+	})
+
+	c := New(fs.URL, WithToken("token"), WithRetries(3, time.Millisecond))
+	if _, err := c.CreateBoard(context.Background(), Board{Title: "Roadmap"}); err == nil {
+		t.Fatal("expected an error")
+	}
+	if n := fs.count("POST /boards"); n != 1 {
+		t.Errorf("sent %d requests, want 1", n)
+	}
+}
+
+func TestStopsRetryingWhenContextIsDone(t *testing.T) {
+	fs := newFakeServer(t, map[string]http.HandlerFunc{
+		"GET /boards": func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	c := New(fs.URL, WithToken("token"), WithRetries(5, time.Second))
+	if _, err := c.ListBoards(ctx); err != context.DeadlineExceeded {
+		t.Errorf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if n := fs.count("GET /boards"); n != 1 {
+		t.Errorf("sent %d requests, want 1", n)
+	}
+}
+
+func TestDecodesErrorEnvelope(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		body        string
+		code        string
+		message     string
+		requestID   string
+		is          func(error) bool
+	}{
+		{
+			name:   "validation",
+			status: http.StatusUnprocessableEntity, contentType: "application/json",
+			body: `{"code":"validation_failed","message":"invalid request body","details":[{"field":"title","message":"is required"}],"request_id":"abc"}`,
+			code: CodeValidation, message: "invalid request body", requestID: "abc",
+			is: IsValidation,
+		},
+		{
+			name:   "wip limit",
+			status: http.StatusConflict, contentType: "application/json",
+			body: `{"code":"wip_limit_exceeded","message":"container 4 allows 3 tasks"}`,
+			code: CodeWIPLimit, message: "container 4 allows 3 tasks",
+			is: IsWIPLimit,
+		},
+		{
+			name:   "conflict",
+			status: http.StatusConflict, contentType: "application/json",
+			body: `{"code":"conflict","message":"username taken"}`,
+			code: CodeConflict, message: "username taken",
+			is: IsConflict,
+		},
+		{
+			name:   "forbidden",
+			status: http.StatusForbidden, contentType: "application/json",
+			body: `{"code":"forbidden","message":"forbidden"}`,
+			code: CodeForbidden, message: "forbidden",
+			is: IsForbidden,
+		},
+		{
+			name:   "plain text",
+			status: http.StatusNotFound, contentType: "text/plain",
+			body: "404 page not found\n",
+			code: CodeNotFound, message: "404 page not found",
+			is: IsNotFound,
+		},
+		{
+			name:   "proxy error",
+			status: http.StatusBadGateway, contentType: "text/html",
+			body: "<html>bad gateway</html>",
+			code: CodeInternal, message: "<html>bad gateway</html>",
+			is: func(err error) bool { return hasCode(err, CodeInternal) },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := newFakeServer(t, map[string]http.HandlerFunc{
+				"POST /tasks/1/move": func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Content-Type", tt.contentType)
+					w.WriteHeader(tt.status)
+					fmt.Fprint(w, tt.body)
+				},
+			})
+
+			c := New(fs.URL, WithToken("token"), WithRetries(0, 0))
+			_, err := c.MoveTask(context.Background(), 1, 2)
+			apiErr, ok := err.(*Error)
+			if !ok {
+				t.Fatalf("err = %#v, want *Error", err)
+			}
+			if apiErr.StatusCode != tt.status || apiErr.Code != tt.code || apiErr.Message != tt.message || apiErr.RequestID != tt.requestID {
+				t.Errorf("err = %+v", apiErr)
+			}
+			if !tt.is(err) {
+				t.Errorf("%s is not classified as %s", err, tt.code)
+			}
+		})
+	}
+}
+
+func TestErrorDetails(t *testing.T) {
+	fs := newFakeServer(t, map[string]http.HandlerFunc{
+		"POST /boards": func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+				"code":    CodeValidation,
+				"message": "invalid request body",
+				"details": []map[string]string{{"field": "title", "message": "is required"}},
+			})
+		},
+	})
+
+	c := New(fs.URL, WithToken("token"))
+	_, err := c.CreateBoard(context.Background(), Board{})
+	apiErr, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("err = %#v, want *Error", err)
+	}
+	details, ok := apiErr.Details.([]interface{})
+	if !ok || len(details) != 1 {
+		t.Fatalf("details = %#v", apiErr.Details)
+	}
+	if field := details[0].(map[string]interface{})["field"]; field != "title" {
+		t.Errorf("details[0].field = %v, want title", field)
+	}
+}
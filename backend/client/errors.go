@@ -0,0 +1,47 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Error mirrors the server's JSON error envelope.
+type Error struct {
+	StatusCode int         `json:"-"`
+	Code       string      `json:"code"`
+	Message    string      `json:"message"`
+	Details    interface{} `json:"details,omitempty"`
+	RequestID  string      `json:"request_id,omitempty"`
+}
+
+func (e *Error) Error() string {
+	if e.RequestID != "" {
+		return fmt.Sprintf("taskapp: %s (%d %s, request %s)", e.Message, e.StatusCode, e.Code, e.RequestID)
+	}
+	return fmt.Sprintf("taskapp: %s (%d %s)", e.Message, e.StatusCode, e.Code)
+}
+
+// Error codes returned by the server.
+const (
+	CodeBadRequest      = "bad_request"
+	CodeUnauthorized    = "unauthorized"
+	CodeForbidden       = "forbidden"
+	CodeNotFound        = "not_found"
+	CodeConflict        = "conflict"
+	CodeValidation      = "validation_failed"
+	CodePayloadTooLarge = "payload_too_large"
+	CodeWIPLimit        = "wip_limit_exceeded"
+	CodeInternal        = "internal"
+)
+
+func hasCode(err error, code string) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
+
+func IsNotFound(err error) bool     { return hasCode(err, CodeNotFound) }
+func IsForbidden(err error) bool    { return hasCode(err, CodeForbidden) }
+func IsUnauthorized(err error) bool { return hasCode(err, CodeUnauthorized) }
+func IsConflict(err error) bool     { return hasCode(err, CodeConflict) }
+func IsValidation(err error) bool   { return hasCode(err, CodeValidation) }
+func IsWIPLimit(err error) bool     { return hasCode(err, CodeWIPLimit) }
@@ -0,0 +1,119 @@
+package client
+
+import "time"
+
+type Board struct {
+	ID              int        `json:"id"`
+	UserID          int        `json:"user_id"`
+	Title           string     `json:"title"`
+	Background      string     `json:"background"`
+	EnforceBlockers bool       `json:"enforce_blockers"`
+	Labels          []string   `json:"labels"`
+	ArchivedAt      *time.Time `json:"archived_at"`
+	ContainerIDs    []int      `json:"container_ids"`
+	SwimlaneIDs     []int      `json:"swimlane_ids"`
+}
+
+type Swimlane struct {
+	ID       int    `json:"id"`
+	BoardID  int    `json:"board_id"`
+	Title    string `json:"title"`
+	Position int    `json:"position"`
+}
+
+type Container struct {
+	ID         int        `json:"id"`
+	BoardID    int        `json:"board_id"`
+	Title      string     `json:"title"`
+	WIPLimit   *int       `json:"wip_limit"`
+	WIPMode    string     `json:"wip_mode"`
+	SortMode   string     `json:"sort_mode"`
+	ArchivedAt *time.Time `json:"archived_at"`
+	TaskIDs    []int      `json:"task_ids"`
+}
+
+// Task.CustomFields maps field IDs to values; nil leaves them unchanged on
+// update.
+type Task struct {
+	ID           int                    `json:"id"`
+	ContainerID  int                    `json:"container_id"`
+	Title        string                 `json:"title"`
+	Description  string                 `json:"description"`
+	Completed    bool                   `json:"completed"`
+	Priority     string                 `json:"priority"`
+	Estimate     *float64               `json:"estimate"`
+	Labels       []string               `json:"labels"`
+	AssigneeID   *int                   `json:"assignee_id"`
+	StartsAt     *time.Time             `json:"starts_at"`
+	DueAt        *time.Time             `json:"due_at"`
+	SwimlaneID   *int                   `json:"swimlane_id"`
+	SprintID     *int                   `json:"sprint_id"`
+	Checklist    []ChecklistItem        `json:"checklist"`
+	CustomFields map[string]interface{} `json:"custom_fields"`
+	ArchivedAt   *time.Time             `json:"archived_at"`
+	Blocked      bool                   `json:"blocked"`
+}
+
+// CustomField is a board-defined task attribute. Options are the choices of
+// single_select and multi_select fields.
+type CustomField struct {
+	ID      int      `json:"id"`
+	BoardID int      `json:"board_id"`
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	Options []string `json:"options"`
+}
+
+// TaskLink relates TaskID to OtherTaskID. Type is one of blocks,
+// blocked_by, relates_to, duplicates and duplicated_by.
+type TaskLink struct {
+	ID             int    `json:"id"`
+	TaskID         int    `json:"task_id"`
+	Type           string `json:"type"`
+	OtherTaskID    int    `json:"other_task_id"`
+	OtherTitle     string `json:"other_title"`
+	OtherCompleted bool   `json:"other_completed"`
+}
+
+type ChecklistItem struct {
+	Text string `json:"text"`
+	Done bool   `json:"done"`
+}
+
+// Session is returned by Login, Signup and Refresh.
+type Session struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Token    string `json:"token"`
+}
+
+type UserData struct {
+	Boards       []Board       `json:"boards"`
+	Containers   []Container   `json:"containers"`
+	Swimlanes    []Swimlane    `json:"swimlanes"`
+	CustomFields []CustomField `json:"custom_fields"`
+	Tasks        []Task        `json:"tasks"`
+	Background   string        `json:"background"`
+}
+
+// SyncData replaces a board's containers and tasks in one request.
+type SyncData struct {
+	BoardID    int             `json:"boardId"`
+	Containers []SyncContainer `json:"containers"`
+	Tasks      []SyncTask      `json:"tasks"`
+}
+
+type SyncContainer struct {
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+}
+
+type SyncTask struct {
+	ID          int      `json:"id"`
+	ContainerID int      `json:"container_id"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Priority    string   `json:"priority,omitempty"`
+	Estimate    *float64 `json:"estimate,omitempty"`
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"taskapp/client"
+)
+
+// TestClientAgainstServer runs the Go client against the real API, so the
+// two cannot drift apart unnoticed.
+func TestClientAgainstServer(t *testing.T) {
+	ts := newTestServer(t)
+	ctx := context.Background()
+	ts.signup(t, "alice")
+
+	c := client.New(ts.URL, client.WithHTTPClient(ts.Client()))
+	if _, err := c.Login(ctx, "alice", "wrong password"); !client.IsUnauthorized(err) {
+		t.Fatalf("login with a wrong password: %v, want unauthorized", err)
+	}
+	session, err := c.Login(ctx, "alice", "correct horse battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Token == "" || c.Token() != session.Token {
+		t.Fatalf("login did not keep the session token")
+	}
+
+	board, err := c.CreateBoard(ctx, client.Board{Title: "Chores", Labels: []string{"home"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	board.Title = "House chores"
+	if board, err = c.UpdateBoard(ctx, *board); err != nil {
+		t.Fatal(err)
+	}
+	boards, err := c.ListBoards(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(boards) != 1 || boards[0].Title != "House chores" {
+		t.Errorf("boards = %+v, want the renamed board", boards)
+	}
+
+	todo, err := c.CreateContainer(ctx, board.ID, client.Container{Title: "Todo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done, err := c.CreateContainer(ctx, board.ID, client.Container{Title: "Done"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	limit := 1
+	done.WIPLimit, done.WIPMode = &limit, "enforce"
+	if err := c.UpdateContainer(ctx, *done); err != nil {
+		t.Fatal(err)
+	}
+	containers, err := c.ListContainers(ctx, board.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(containers) != 2 {
+		t.Fatalf("containers = %+v, want Todo and Done", containers)
+	}
+	for _, ct := range containers {
+		if ct.ID == done.ID && (ct.WIPLimit == nil || *ct.WIPLimit != 1 || ct.WIPMode != "enforce") {
+			t.Errorf("Done = %+v, want an enforced limit of 1", ct)
+		}
+	}
+
+	dishes, err := c.CreateTask(ctx, todo.ID, client.Task{Title: "Dishes", Priority: "high"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	laundry, err := c.CreateTask(ctx, todo.ID, client.Task{Title: "Laundry"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dishes.Description = "Before dinner"
+	if dishes, err = c.UpdateTask(ctx, *dishes); err != nil {
+		t.Fatal(err)
+	}
+	if dishes.Description != "Before dinner" || dishes.Priority != "high" {
+		t.Errorf("updated task = %+v", dishes)
+	}
+	if _, err := c.MoveTask(ctx, dishes.ID, done.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.MoveTask(ctx, laundry.ID, done.ID); !client.IsWIPLimit(err) {
+		t.Errorf("moving past the enforced limit: %v, want wip_limit_exceeded", err)
+	}
+	tasks, err := c.ListTasks(ctx, done.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 1 || tasks[0].ID != dishes.ID {
+		t.Errorf("tasks in Done = %+v, want only the dishes", tasks)
+	}
+	if err := c.DeleteTask(ctx, laundry.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteTask(ctx, laundry.ID); !client.IsNotFound(err) {
+		t.Errorf("deleting a deleted task: %v, want not_found", err)
+	}
+
+	// Error envelopes decode into *client.Error with the server's details.
+	_, err = c.CreateTask(ctx, todo.ID, client.Task{})
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnprocessableEntity || apiErr.Code != client.CodeValidation || apiErr.Details == nil {
+		t.Errorf("creating an untitled task: %#v, want a 422 validation error with details", err)
+	}
+	if apiErr != nil && apiErr.RequestID == "" {
+		t.Errorf("error %v has no request ID", apiErr)
+	}
+
+	bob := client.New(ts.URL, client.WithHTTPClient(ts.Client()))
+	if _, err := bob.Signup(ctx, "bob", "bob@example.com", "correct horse battery"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bob.ListTasks(ctx, todo.ID); !client.IsForbidden(err) {
+		t.Errorf("listing another user's tasks: %v, want forbidden", err)
+	}
+	if err := bob.DeleteBoard(ctx, board.ID); !client.IsForbidden(err) {
+		t.Errorf("deleting another user's board: %v, want forbidden", err)
+	}
+
+	if err := c.DeleteContainer(ctx, todo.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteBoard(ctx, board.ID); err != nil {
+		t.Fatal(err)
+	}
+	if boards, err = c.ListBoards(ctx); err != nil || len(boards) != 0 {
+		t.Errorf("boards after deleting = %+v, %v; want none", boards, err)
+	}
+
+	if err := c.Logout(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ListBoards(ctx); !client.IsUnauthorized(err) {
+		t.Errorf("listing boards after logout: %v, want unauthorized", err)
+	}
+}
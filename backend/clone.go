@@ -0,0 +1,526 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// CloneRequest names the copy and, for containers and tasks, where it goes.
+// Without a destination the copy is placed next to the original. Titles are
+// limited like those of the copied type: 100 characters for boards and
+// containers, 200 for tasks.
+type CloneRequest struct {
+	Title       string `json:"title" validate:"max=200"`
+	BoardID     int    `json:"board_id,omitempty"`
+	ContainerID int    `json:"container_id,omitempty"`
+}
+
+// cloneMapping translates IDs of the original into IDs of the copy while a
+// clone is in progress.
+type cloneMapping struct {
+	boardID    int
+	sameBoard  bool
+	containers map[int]int
+	swimlanes  map[int]int
+	fields     map[int]int
+	tasks      map[int]int
+}
+
+// swimlane returns the swimlane a copied task belongs in: the copy of its
+// swimlane, its own swimlane when copied within the board, or none.
+func (m *cloneMapping) swimlane(id *int) *int {
+	if id == nil {
+		return nil
+	}
+	if copied, ok := m.swimlanes[*id]; ok {
+		return &copied
+	}
+	if m.sameBoard {
+		return id
+	}
+	return nil
+}
+
+// sprint returns the sprint a copied task belongs in. Sprints are not copied
+// with boards, so tasks only keep their sprint when copied within the board.
+func (m *cloneMapping) sprint(id *int) *int {
+	if m.sameBoard {
+		return id
+	}
+	return nil
+}
+
+// customFields returns a copied task's custom field values: unchanged when
+// copied within the board, keyed by the copied fields' IDs when the fields
+// were copied too, and without the values of fields left behind.
+func (m *cloneMapping) customFields(values CustomFieldValues) CustomFieldValues {
+	if m.sameBoard {
+		return values
+	}
+	copied := CustomFieldValues{}
+	for key, value := range values {
+		id, _ := strconv.Atoi(key)
+		if newID, ok := m.fields[id]; ok {
+			copied[strconv.Itoa(newID)] = value
+		}
+	}
+	return copied
+}
+
+func (tm *TaskManager) CloneBoardHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+	boardID := mux.Vars(r)["id"]
+
+	var req CloneRequest
+	err := decodeJSON(w, r, &req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = checkCloneTitle(req.Title, 100)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tm.checkBoardOwnership(userID, boardID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var board Board
+	err = tm.db.Get(&board, "SELECT "+boardColumns+" FROM boards WHERE id = $1", boardID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if req.Title != "" {
+		board.Title = req.Title
+	} else {
+		board.Title = copyTitle(board.Title, 100)
+	}
+
+	tx, err := tm.db.Beginx()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow("INSERT INTO boards (user_id, title, background, enforce_blockers, labels) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		userID, board.Title, board.Background, board.EnforceBlockers, board.Labels).Scan(&board.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	m := &cloneMapping{boardID: board.ID, containers: map[int]int{}, swimlanes: map[int]int{}, fields: map[int]int{}, tasks: map[int]int{}}
+
+	swimlanes, err := tm.getSwimlanes(boardID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	board.SwimlaneIDs = []int{}
+	for _, swimlane := range swimlanes {
+		var id int
+		err = tx.QueryRow("INSERT INTO swimlanes (board_id, title, position) VALUES ($1, $2, $3) RETURNING id",
+			board.ID, swimlane.Title, swimlane.Position).Scan(&id)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		m.swimlanes[swimlane.ID] = id
+		board.SwimlaneIDs = append(board.SwimlaneIDs, id)
+	}
+
+	fields, err := tm.getCustomFields(boardID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	for _, field := range fields {
+		var id int
+		err = tx.QueryRow("INSERT INTO custom_fields (board_id, name, type, options) VALUES ($1, $2, $3, $4) RETURNING id",
+			board.ID, field.Name, field.Type, field.Options).Scan(&id)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		m.fields[field.ID] = id
+	}
+
+	containers := []Container{}
+	err = tx.Select(&containers, "SELECT "+containerColumns+" FROM containers WHERE board_id = $1 AND archived_at IS NULL ORDER BY id", boardID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	board.ContainerIDs = []int{}
+	for _, container := range containers {
+		id, err := cloneContainer(tx, container, container.Title, m)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		board.ContainerIDs = append(board.ContainerIDs, id)
+	}
+
+	rules := []AutomationRule{}
+	err = tx.Select(&rules, "SELECT * FROM automation_rules WHERE board_id = $1 ORDER BY id", boardID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	for _, rule := range rules {
+		if !rule.onlyUses(m.containers) {
+			continue
+		}
+		rule.Trigger.ContainerID = m.containers[rule.Trigger.ContainerID]
+		for i := range rule.Actions {
+			rule.Actions[i].ContainerID = m.containers[rule.Actions[i].ContainerID]
+		}
+		_, err = tx.Exec(`
+			INSERT INTO automation_rules (board_id, name, trigger, conditions, actions, enabled)
+			VALUES ($1, $2, $3, $4, $5, $6)
+		`, board.ID, rule.Name, rule.Trigger, rule.Conditions, rule.Actions, rule.Enabled)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+
+	err = cloneLinks(tx, m)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	board.UserID = userID
+	board.ArchivedAt = nil
+
+	tm.emit(r, EventBoardCreated, board.ID, board)
+	for _, id := range board.ContainerIDs {
+		err = tm.emitClonedContainer(r, id)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+	err = tm.emitClonedTasks(r, board.ID, m)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(board)
+}
+
+func (tm *TaskManager) CloneContainerHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+	containerID := mux.Vars(r)["id"]
+
+	var req CloneRequest
+	err := decodeJSON(w, r, &req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = checkCloneTitle(req.Title, 100)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var container Container
+	err = tm.db.Get(&container, "SELECT "+containerColumns+" FROM containers WHERE id = $1", containerID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	err = tm.checkBoardOwnership(userID, strconv.Itoa(container.BoardID))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	targetBoardID := container.BoardID
+	if req.BoardID != 0 {
+		targetBoardID = req.BoardID
+		err = tm.checkBoardOwnership(userID, strconv.Itoa(targetBoardID))
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+
+	title := req.Title
+	if title == "" {
+		title = copyTitle(container.Title, 100)
+	}
+
+	tx, err := tm.db.Beginx()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	m := &cloneMapping{boardID: targetBoardID, sameBoard: targetBoardID == container.BoardID, containers: map[int]int{}, tasks: map[int]int{}}
+	id, err := cloneContainer(tx, container, title, m)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	err = cloneLinks(tx, m)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	clone, err := tm.getClonedContainer(id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	tm.emit(r, EventContainerCreated, clone.BoardID, clone)
+	err = tm.emitClonedTasks(r, targetBoardID, m)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(clone)
+}
+
+func (tm *TaskManager) CloneTaskHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	var req CloneRequest
+	err := decodeJSON(w, r, &req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	task, err := tm.checkTaskAccess(userID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	sourceBoardID, err := tm.boardIDForContainer(task.ContainerID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	targetContainerID := task.ContainerID
+	if req.ContainerID != 0 {
+		targetContainerID = req.ContainerID
+	}
+	targetBoardID, err := tm.boardIDForContainer(targetContainerID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	err = tm.checkBoardOwnership(userID, strconv.Itoa(targetBoardID))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	tx, err := tm.db.Beginx()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	violation, err := tm.checkWIP(tx, targetContainerID, 1)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if req.Title != "" {
+		task.Title = req.Title
+	} else {
+		task.Title = copyTitle(task.Title, 200)
+	}
+
+	m := &cloneMapping{boardID: targetBoardID, sameBoard: targetBoardID == sourceBoardID}
+	var clone Task
+	err = tx.Get(&clone, `
+		INSERT INTO tasks (container_id, title, description, completed, priority, estimate, labels, assignee_id, starts_at, due_at, swimlane_id, sprint_id, checklist, custom_fields)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		RETURNING `+taskColumns,
+		targetContainerID, task.Title, task.Description, task.Completed, task.Priority, task.Estimate, task.Labels, task.AssigneeID, task.StartsAt, task.DueAt, m.swimlane(task.SwimlaneID), m.sprint(task.SprintID), task.Checklist, m.customFields(task.CustomFields))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	tm.emit(r, EventTaskCreated, targetBoardID, clone)
+
+	flagWIP(w, violation)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(clone)
+}
+
+// getClonedContainer loads a copied container with its task IDs.
+func (tm *TaskManager) getClonedContainer(id int) (Container, error) {
+	var container Container
+	err := tm.db.Get(&container, "SELECT "+containerColumns+" FROM containers WHERE id = $1", id)
+	if err != nil {
+		return container, err
+	}
+	container.TaskIDs, err = tm.getTasksForContainer(container.ID)
+	return container, err
+}
+
+// emitClonedContainer announces a container copied with its board.
+func (tm *TaskManager) emitClonedContainer(r *http.Request, id int) error {
+	container, err := tm.getClonedContainer(id)
+	if err != nil {
+		return err
+	}
+	tm.emit(r, EventContainerCreated, container.BoardID, container)
+	return nil
+}
+
+// emitClonedTasks announces the tasks of a committed clone, in the order
+// they were copied.
+func (tm *TaskManager) emitClonedTasks(r *http.Request, boardID int, m *cloneMapping) error {
+	if len(m.tasks) == 0 {
+		return nil
+	}
+	ids := make([]int64, 0, len(m.tasks))
+	for _, id := range m.tasks {
+		ids = append(ids, int64(id))
+	}
+
+	tasks := []Task{}
+	err := tm.db.Select(&tasks, "SELECT "+taskColumns+" FROM tasks WHERE id = ANY($1) ORDER BY id", pq.Int64Array(ids))
+	if err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		tm.emit(r, EventTaskCreated, boardID, task)
+	}
+	return nil
+}
+
+// checkCloneTitle applies the title limit of the copied type, which the
+// shared CloneRequest cannot express in its tag.
+func checkCloneTitle(title string, max int) error {
+	if len([]rune(title)) > max {
+		return validationError([]FieldError{{Field: "title", Message: fmt.Sprintf("must be at most %d characters", max)}}, "invalid request body")
+	}
+	return nil
+}
+
+// cloneContainer copies a container and its unarchived tasks, in ID order so
+// the copy keeps the original's ordering, onto m.boardID.
+func cloneContainer(tx *sqlx.Tx, container Container, title string, m *cloneMapping) (int, error) {
+	var id int
+	err := tx.QueryRow("INSERT INTO containers (board_id, title, wip_limit, wip_mode, sort_mode) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		m.boardID, title, container.WIPLimit, container.WIPMode, container.SortMode).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	m.containers[container.ID] = id
+
+	tasks := []Task{}
+	err = tx.Select(&tasks, "SELECT "+taskColumns+" FROM tasks WHERE container_id = $1 AND archived_at IS NULL ORDER BY id", container.ID)
+	if err != nil {
+		return 0, err
+	}
+	for _, task := range tasks {
+		var taskID int
+		err = tx.QueryRow(`
+			INSERT INTO tasks (container_id, title, description, completed, priority, estimate, labels, assignee_id, starts_at, due_at, swimlane_id, sprint_id, checklist, custom_fields)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+			RETURNING id
+		`, id, task.Title, task.Description, task.Completed, task.Priority, task.Estimate, task.Labels, task.AssigneeID, task.StartsAt, task.DueAt, m.swimlane(task.SwimlaneID), m.sprint(task.SprintID), task.Checklist, m.customFields(task.CustomFields)).Scan(&taskID)
+		if err != nil {
+			return 0, err
+		}
+		m.tasks[task.ID] = taskID
+	}
+	return id, nil
+}
+
+// cloneLinks copies the links whose both ends were copied. relates_to links
+// go through linkEdge again since the copies' IDs may sort differently.
+func cloneLinks(tx *sqlx.Tx, m *cloneMapping) error {
+	if len(m.tasks) < 2 {
+		return nil
+	}
+	ids := make([]int64, 0, len(m.tasks))
+	for id := range m.tasks {
+		ids = append(ids, int64(id))
+	}
+
+	var links []struct {
+		TaskID      int    `db:"task_id"`
+		OtherTaskID int    `db:"other_task_id"`
+		Kind        string `db:"kind"`
+	}
+	err := tx.Select(&links, "SELECT task_id, other_task_id, kind FROM task_links WHERE task_id = ANY($1) AND other_task_id = ANY($1)", pq.Int64Array(ids))
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		from, to, kind := linkEdge(m.tasks[link.TaskID], m.tasks[link.OtherTaskID], link.Kind)
+		_, err = tx.Exec("INSERT INTO task_links (task_id, other_task_id, kind) VALUES ($1, $2, $3)", from, to, kind)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyTitle appends " (copy)" to title, trimming it to stay within max
+// characters.
+func copyTitle(title string, max int) string {
+	const suffix = " (copy)"
+	runes := []rune(title)
+	if len(runes)+len(suffix) > max {
+		runes = runes[:max-len(suffix)]
+	}
+	return string(runes) + suffix
+}
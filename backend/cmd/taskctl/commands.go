@@ -0,0 +1,559 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"golang.org/x/term"
+
+	"taskapp/client"
+)
+
+func (c *cli) login(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("login", flag.ContinueOnError)
+	username := fs.String("u", c.cfg.Username, "username")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	in := bufio.NewReader(os.Stdin)
+	if *username == "" {
+		fmt.Fprint(os.Stderr, "username: ")
+		line, _ := in.ReadString('\n')
+		*username = strings.TrimSpace(line)
+	}
+	password := os.Getenv("TASKCTL_PASSWORD")
+	if password == "" {
+		fmt.Fprint(os.Stderr, "password: ")
+		var err error
+		password, err = readPassword(in)
+		if err != nil {
+			return fmt.Errorf("reading password: %w", err)
+		}
+	}
+
+	session, err := c.client.Login(ctx, *username, password)
+	if err != nil {
+		return err
+	}
+
+	c.cfg.Username = session.Username
+	c.cfg.Token = session.Token
+	if err := c.cfg.save(); err != nil {
+		return fmt.Errorf("saving config: %w", err)
+	}
+
+	return c.print(session, func() {
+		fmt.Fprintf(c.out, "logged in as %s\n", session.Username)
+	})
+}
+
+// readPassword reads a line from the terminal without echoing it, or from in
+// when stdin is not a terminal.
+func readPassword(in *bufio.Reader) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		line, err := in.ReadString('\n')
+		if err != nil && line == "" {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	return string(password), err
+}
+
+func (c *cli) logout(ctx context.Context, args []string) error {
+	c.client.Logout(ctx)
+	c.cfg.Token = ""
+	return c.cfg.save()
+}
+
+func (c *cli) boards(ctx context.Context, args []string) error {
+	boards, err := c.client.ListBoards(ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.print(boards, func() {
+		tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tTITLE\tCONTAINERS")
+		for _, b := range boards {
+			fmt.Fprintf(tw, "%d\t%s\t%d\n", b.ID, b.Title, len(b.ContainerIDs))
+		}
+		tw.Flush()
+	})
+}
+
+// boardExport is the file format of export and import. Links are only
+// filled in by export, which is the only command that needs them.
+type boardExport struct {
+	Board        client.Board         `json:"board"`
+	CustomFields []client.CustomField `json:"custom_fields,omitempty"`
+	Swimlanes    []client.Swimlane    `json:"swimlanes,omitempty"`
+	Containers   []client.Container   `json:"containers"`
+	Tasks        []client.Task        `json:"tasks"`
+	Links        []client.TaskLink    `json:"links,omitempty"`
+}
+
+// loadBoard resolves ref (an ID or exact title) and collects the board's
+// custom fields, swimlanes, containers and tasks from /user-data.
+func (c *cli) loadBoard(ctx context.Context, ref string) (*boardExport, error) {
+	data, err := c.client.GetUserData(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	export := &boardExport{}
+	found := false
+	for _, b := range data.Boards {
+		if strconv.Itoa(b.ID) == ref || b.Title == ref {
+			export.Board = b
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("board %q not found", ref)
+	}
+
+	for _, f := range data.CustomFields {
+		if f.BoardID == export.Board.ID {
+			export.CustomFields = append(export.CustomFields, f)
+		}
+	}
+	for _, sl := range data.Swimlanes {
+		if sl.BoardID == export.Board.ID {
+			export.Swimlanes = append(export.Swimlanes, sl)
+		}
+	}
+	containerIDs := map[int]bool{}
+	for _, ct := range data.Containers {
+		if ct.BoardID == export.Board.ID {
+			export.Containers = append(export.Containers, ct)
+			containerIDs[ct.ID] = true
+		}
+	}
+	for _, t := range data.Tasks {
+		if containerIDs[t.ContainerID] {
+			export.Tasks = append(export.Tasks, t)
+		}
+	}
+	return export, nil
+}
+
+// loadLinks collects the links of the board's tasks. Every link is listed on
+// both of its tasks, so it is kept once, as seen from the first.
+func (c *cli) loadLinks(ctx context.Context, export *boardExport) error {
+	seen := map[int]bool{}
+	for _, t := range export.Tasks {
+		links, err := c.client.ListLinks(ctx, t.ID)
+		if err != nil {
+			return err
+		}
+		for _, l := range links {
+			if !seen[l.ID] {
+				seen[l.ID] = true
+				export.Links = append(export.Links, l)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *cli) show(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: taskctl show <board>")
+	}
+	board, err := c.loadBoard(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	return c.print(board, func() {
+		columns := make([][]client.Task, len(board.Containers))
+		rows := 0
+		for i, ct := range board.Containers {
+			for _, t := range board.Tasks {
+				if t.ContainerID == ct.ID {
+					columns[i] = append(columns[i], t)
+				}
+			}
+			if len(columns[i]) > rows {
+				rows = len(columns[i])
+			}
+		}
+
+		fmt.Fprintf(c.out, "%s (#%d)\n\n", board.Board.Title, board.Board.ID)
+		tw := tabwriter.NewWriter(c.out, 0, 4, 3, ' ', 0)
+		for _, ct := range board.Containers {
+			fmt.Fprintf(tw, "%s (#%d)\t", ct.Title, ct.ID)
+		}
+		fmt.Fprintln(tw)
+		for range board.Containers {
+			fmt.Fprint(tw, "----\t")
+		}
+		fmt.Fprintln(tw)
+		for row := 0; row < rows; row++ {
+			for i := range board.Containers {
+				if row < len(columns[i]) {
+					fmt.Fprintf(tw, "%s\t", taskCell(columns[i][row]))
+				} else {
+					fmt.Fprint(tw, "\t")
+				}
+			}
+			fmt.Fprintln(tw)
+		}
+		tw.Flush()
+	})
+}
+
+func taskCell(t client.Task) string {
+	mark := "[ ]"
+	if t.Completed {
+		mark = "[x]"
+	}
+	title := t.Title
+	if r := []rune(title); len(r) > 28 {
+		title = string(r[:27]) + "…"
+	}
+	return fmt.Sprintf("%s #%d %s", mark, t.ID, title)
+}
+
+func (c *cli) add(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("add", flag.ContinueOnError)
+	description := fs.String("d", "", "task description")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 2 {
+		return fmt.Errorf("usage: taskctl add [-d text] <container> <title>")
+	}
+	containerID, err := strconv.Atoi(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("invalid container ID %q", fs.Arg(0))
+	}
+
+	task, err := c.client.CreateTask(ctx, containerID, client.Task{
+		Title:       strings.Join(fs.Args()[1:], " "),
+		Description: *description,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.print(task, func() {
+		fmt.Fprintf(c.out, "created task #%d\n", task.ID)
+	})
+}
+
+func (c *cli) move(ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: taskctl move <task> <container>")
+	}
+	ids, err := parseIDs(args)
+	if err != nil {
+		return err
+	}
+
+	task, err := c.client.MoveTask(ctx, ids[0], ids[1])
+	if err != nil {
+		return err
+	}
+
+	return c.print(task, func() {
+		fmt.Fprintf(c.out, "moved task #%d to container #%d\n", task.ID, task.ContainerID)
+	})
+}
+
+func (c *cli) complete(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("complete", flag.ContinueOnError)
+	undo := fs.Bool("undo", false, "mark the task as not completed")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: taskctl complete [--undo] <task>")
+	}
+	ids, err := parseIDs(fs.Args())
+	if err != nil {
+		return err
+	}
+
+	task, err := c.findTask(ctx, ids[0])
+	if err != nil {
+		return err
+	}
+	task.Completed = !*undo
+
+	updated, err := c.client.UpdateTask(ctx, *task)
+	if err != nil {
+		return err
+	}
+
+	return c.print(updated, func() {
+		state := "completed"
+		if !updated.Completed {
+			state = "reopened"
+		}
+		fmt.Fprintf(c.out, "%s task #%d\n", state, updated.ID)
+	})
+}
+
+// findTask looks a task up in /user-data since the API has no single-task
+// read endpoint.
+func (c *cli) findTask(ctx context.Context, taskID int) (*client.Task, error) {
+	data, err := c.client.GetUserData(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range data.Tasks {
+		if t.ID == taskID {
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("task #%d not found", taskID)
+}
+
+func (c *cli) deleteTask(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: taskctl delete <task>")
+	}
+	ids, err := parseIDs(args)
+	if err != nil {
+		return err
+	}
+
+	if err := c.client.DeleteTask(ctx, ids[0]); err != nil {
+		return err
+	}
+
+	return c.print(map[string]int{"deleted": ids[0]}, func() {
+		fmt.Fprintf(c.out, "deleted task #%d\n", ids[0])
+	})
+}
+
+func (c *cli) exportBoard(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	output := fs.String("o", "", "write to file instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: taskctl export [-o file] <board>")
+	}
+
+	board, err := c.loadBoard(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if err := c.loadLinks(ctx, board); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(board, "", "  ")
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		_, err = fmt.Fprintln(c.out, string(data))
+		return err
+	}
+	return os.WriteFile(*output, append(data, '\n'), 0644)
+}
+
+// importBoard recreates an exported board under fresh IDs. The export is
+// checked before anything is created, and the new board is deleted again if
+// a request fails partway through.
+func (c *cli) importBoard(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: taskctl import <file>")
+	}
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	var export boardExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return fmt.Errorf("parsing %s: %w", args[0], err)
+	}
+	if err := export.check(); err != nil {
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+
+	board, err := c.client.CreateBoard(ctx, client.Board{
+		Title:           export.Board.Title,
+		Background:      export.Board.Background,
+		EnforceBlockers: export.Board.EnforceBlockers,
+		Labels:          export.Board.Labels,
+	})
+	if err != nil {
+		return err
+	}
+	if err := c.populateBoard(ctx, board.ID, &export); err != nil {
+		if derr := c.client.DeleteBoard(context.Background(), board.ID); derr != nil {
+			return fmt.Errorf("%w; removing the partly imported board #%d: %v", err, board.ID, derr)
+		}
+		return err
+	}
+
+	return c.print(board, func() {
+		fmt.Fprintf(c.out, "imported board #%d with %d containers and %d tasks\n", board.ID, len(export.Containers), len(export.Tasks))
+	})
+}
+
+// check refuses exports that import cannot reproduce: archived items,
+// sprints, which are not exported, and references to anything outside the
+// board. A task's blocked flag follows from its links.
+func (e *boardExport) check() error {
+	if e.Board.ArchivedAt != nil {
+		return fmt.Errorf("board %q is archived", e.Board.Title)
+	}
+	fields := map[string]bool{}
+	for _, f := range e.CustomFields {
+		fields[strconv.Itoa(f.ID)] = true
+	}
+	swimlanes := map[int]bool{}
+	for _, sl := range e.Swimlanes {
+		swimlanes[sl.ID] = true
+	}
+	containers := map[int]bool{}
+	for _, ct := range e.Containers {
+		if ct.ArchivedAt != nil {
+			return fmt.Errorf("container %q is archived", ct.Title)
+		}
+		containers[ct.ID] = true
+	}
+	tasks := map[int]bool{}
+	for _, t := range e.Tasks {
+		switch {
+		case !containers[t.ContainerID]:
+			return fmt.Errorf("task %q references unknown container %d", t.Title, t.ContainerID)
+		case t.ArchivedAt != nil:
+			return fmt.Errorf("task %q is archived", t.Title)
+		case t.SprintID != nil:
+			return fmt.Errorf("task %q belongs to sprint %d, and sprints cannot be imported", t.Title, *t.SprintID)
+		case t.SwimlaneID != nil && !swimlanes[*t.SwimlaneID]:
+			return fmt.Errorf("task %q references unknown swimlane %d", t.Title, *t.SwimlaneID)
+		}
+		for id := range t.CustomFields {
+			if !fields[id] {
+				return fmt.Errorf("task %q has a value for unknown custom field %s", t.Title, id)
+			}
+		}
+		tasks[t.ID] = true
+	}
+	for _, l := range e.Links {
+		if !tasks[l.TaskID] || !tasks[l.OtherTaskID] {
+			return fmt.Errorf("link %d between tasks %d and %d leaves the board", l.ID, l.TaskID, l.OtherTaskID)
+		}
+	}
+	return nil
+}
+
+// populateBoard creates the contents of export on boardID. WIP limits are
+// set last so they cannot refuse the tasks being imported.
+func (c *cli) populateBoard(ctx context.Context, boardID int, export *boardExport) error {
+	fieldIDs := map[string]string{}
+	for _, f := range export.CustomFields {
+		created, err := c.client.CreateCustomField(ctx, boardID, client.CustomField{Name: f.Name, Type: f.Type, Options: f.Options})
+		if err != nil {
+			return err
+		}
+		fieldIDs[strconv.Itoa(f.ID)] = strconv.Itoa(created.ID)
+	}
+
+	// Swimlanes are appended in order, which recreates their positions.
+	swimlaneIDs := map[int]int{}
+	for _, sl := range export.Swimlanes {
+		created, err := c.client.CreateSwimlane(ctx, boardID, client.Swimlane{Title: sl.Title})
+		if err != nil {
+			return err
+		}
+		swimlaneIDs[sl.ID] = created.ID
+	}
+
+	containerIDs := map[int]int{}
+	for _, ct := range export.Containers {
+		created, err := c.client.CreateContainer(ctx, boardID, client.Container{Title: ct.Title, SortMode: ct.SortMode})
+		if err != nil {
+			return err
+		}
+		containerIDs[ct.ID] = created.ID
+	}
+
+	taskIDs := map[int]int{}
+	for _, t := range export.Tasks {
+		values := map[string]interface{}{}
+		for id, value := range t.CustomFields {
+			values[fieldIDs[id]] = value
+		}
+		var swimlaneID *int
+		if t.SwimlaneID != nil {
+			id := swimlaneIDs[*t.SwimlaneID]
+			swimlaneID = &id
+		}
+		created, err := c.client.CreateTask(ctx, containerIDs[t.ContainerID], client.Task{
+			Title:        t.Title,
+			Description:  t.Description,
+			Completed:    t.Completed,
+			Priority:     t.Priority,
+			Estimate:     t.Estimate,
+			Labels:       t.Labels,
+			AssigneeID:   t.AssigneeID,
+			StartsAt:     t.StartsAt,
+			DueAt:        t.DueAt,
+			SwimlaneID:   swimlaneID,
+			Checklist:    t.Checklist,
+			CustomFields: values,
+		})
+		if err != nil {
+			return fmt.Errorf("importing task %q: %w", t.Title, err)
+		}
+		taskIDs[t.ID] = created.ID
+	}
+
+	for _, l := range export.Links {
+		_, err := c.client.CreateLink(ctx, taskIDs[l.TaskID], client.TaskLink{Type: l.Type, OtherTaskID: taskIDs[l.OtherTaskID]})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, ct := range export.Containers {
+		if ct.WIPLimit == nil && ct.WIPMode == "" {
+			continue
+		}
+		err := c.client.UpdateContainer(ctx, client.Container{
+			ID:       containerIDs[ct.ID],
+			Title:    ct.Title,
+			WIPLimit: ct.WIPLimit,
+			WIPMode:  ct.WIPMode,
+			SortMode: ct.SortMode,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseIDs(args []string) ([]int, error) {
+	ids := make([]int, len(args))
+	for i, arg := range args {
+		id, err := strconv.Atoi(strings.TrimPrefix(arg, "#"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid ID %q", arg)
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
@@ -0,0 +1,565 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"taskapp/client"
+)
+
+// fakeAPI keeps boards in memory and serves the routes export and import
+// use. It refuses tasks over an enforced WIP limit like the real server.
+type fakeAPI struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	nextID     int
+	boards     []client.Board
+	fields     []client.CustomField
+	swimlanes  []client.Swimlane
+	containers []client.Container
+	tasks      []client.Task
+	links      []client.TaskLink
+
+	// failTask makes creating a task with this title fail.
+	failTask string
+}
+
+func newFakeAPI(t *testing.T) *fakeAPI {
+	api := &fakeAPI{}
+	api.Server = httptest.NewServer(http.HandlerFunc(api.serve))
+	t.Cleanup(api.Close)
+	return api
+}
+
+func (api *fakeAPI) id() int {
+	api.nextID++
+	return api.nextID
+}
+
+var reverseLink = map[string]string{
+	"blocks": "blocked_by", "blocked_by": "blocks",
+	"duplicates": "duplicated_by", "duplicated_by": "duplicates",
+	"relates_to": "relates_to",
+}
+
+func (api *fakeAPI) serve(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	fail := func(status int, message string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(client.Error{Code: "error", Message: message})
+	}
+	decode := func(v interface{}) bool {
+		if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+			fail(http.StatusBadRequest, err.Error())
+			return false
+		}
+		return true
+	}
+	var out interface{}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	id := 0
+	if len(parts) > 1 {
+		id, _ = strconv.Atoi(parts[1])
+	}
+	route := r.Method + " " + parts[0]
+	if len(parts) > 2 {
+		route += " " + parts[2]
+	}
+
+	switch route {
+	case "GET user-data":
+		data := client.UserData{Boards: []client.Board{}, Containers: api.containers, Swimlanes: api.swimlanes, CustomFields: api.fields, Tasks: api.tasks}
+		for _, b := range api.boards {
+			b.ContainerIDs, b.SwimlaneIDs = []int{}, []int{}
+			for _, ct := range api.containers {
+				if ct.BoardID == b.ID {
+					b.ContainerIDs = append(b.ContainerIDs, ct.ID)
+				}
+			}
+			for _, sl := range api.swimlanes {
+				if sl.BoardID == b.ID {
+					b.SwimlaneIDs = append(b.SwimlaneIDs, sl.ID)
+				}
+			}
+			data.Boards = append(data.Boards, b)
+		}
+		out = data
+
+	case "POST boards":
+		var b client.Board
+		if !decode(&b) {
+			return
+		}
+		b.ID, b.UserID = api.id(), 1
+		api.boards = append(api.boards, b)
+		out = b
+
+	case "DELETE boards":
+		for i, b := range api.boards {
+			if b.ID == id {
+				api.boards = append(api.boards[:i], api.boards[i+1:]...)
+				api.deleteBoardContents(id)
+				return
+			}
+		}
+		fail(http.StatusNotFound, "board not found")
+		return
+
+	case "POST boards custom-fields":
+		var f client.CustomField
+		if !decode(&f) {
+			return
+		}
+		f.ID, f.BoardID = api.id(), id
+		api.fields = append(api.fields, f)
+		out = f
+
+	case "POST boards swimlanes":
+		var sl client.Swimlane
+		if !decode(&sl) {
+			return
+		}
+		sl.ID, sl.BoardID = api.id(), id
+		for _, other := range api.swimlanes {
+			if other.BoardID == id && other.Position >= sl.Position {
+				sl.Position = other.Position + 1
+			}
+		}
+		api.swimlanes = append(api.swimlanes, sl)
+		out = sl
+
+	case "POST boards containers":
+		var ct client.Container
+		if !decode(&ct) {
+			return
+		}
+		ct.ID, ct.BoardID = api.id(), id
+		api.containers = append(api.containers, ct)
+		out = ct
+
+	case "PUT containers":
+		var ct client.Container
+		if !decode(&ct) {
+			return
+		}
+		for i := range api.containers {
+			if api.containers[i].ID == id {
+				ct.ID, ct.BoardID = id, api.containers[i].BoardID
+				api.containers[i] = ct
+				return
+			}
+		}
+		fail(http.StatusNotFound, "container not found")
+		return
+
+	case "POST containers tasks":
+		var t client.Task
+		if !decode(&t) {
+			return
+		}
+		if t.Title == api.failTask {
+			fail(http.StatusUnprocessableEntity, "task refused")
+			return
+		}
+		count := 0
+		for _, other := range api.tasks {
+			if other.ContainerID == id {
+				count++
+			}
+		}
+		for _, ct := range api.containers {
+			if ct.ID == id && ct.WIPMode == "enforce" && ct.WIPLimit != nil && count >= *ct.WIPLimit {
+				fail(http.StatusConflict, "wip limit exceeded")
+				return
+			}
+		}
+		t.ID, t.ContainerID = api.id(), id
+		api.tasks = append(api.tasks, t)
+		out = t
+
+	case "GET tasks links":
+		links := []client.TaskLink{}
+		for _, l := range api.links {
+			switch id {
+			case l.TaskID:
+				links = append(links, l)
+			case l.OtherTaskID:
+				links = append(links, client.TaskLink{ID: l.ID, TaskID: id, Type: reverseLink[l.Type], OtherTaskID: l.TaskID})
+			}
+		}
+		out = links
+
+	case "POST tasks links":
+		var l client.TaskLink
+		if !decode(&l) {
+			return
+		}
+		l.ID, l.TaskID = api.id(), id
+		api.links = append(api.links, l)
+		for i := range api.tasks {
+			if api.tasks[i].ID == l.OtherTaskID && l.Type == "blocks" || api.tasks[i].ID == id && l.Type == "blocked_by" {
+				api.tasks[i].Blocked = true
+			}
+		}
+		out = l
+
+	default:
+		fail(http.StatusNotFound, "no route for "+r.Method+" "+r.URL.Path)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+func (api *fakeAPI) deleteBoardContents(boardID int) {
+	containers := map[int]bool{}
+	var keptContainers []client.Container
+	for _, ct := range api.containers {
+		if ct.BoardID == boardID {
+			containers[ct.ID] = true
+		} else {
+			keptContainers = append(keptContainers, ct)
+		}
+	}
+	api.containers = keptContainers
+
+	var keptTasks []client.Task
+	for _, t := range api.tasks {
+		if !containers[t.ContainerID] {
+			keptTasks = append(keptTasks, t)
+		}
+	}
+	api.tasks = keptTasks
+
+	var keptFields []client.CustomField
+	for _, f := range api.fields {
+		if f.BoardID != boardID {
+			keptFields = append(keptFields, f)
+		}
+	}
+	api.fields = keptFields
+
+	var keptSwimlanes []client.Swimlane
+	for _, sl := range api.swimlanes {
+		if sl.BoardID != boardID {
+			keptSwimlanes = append(keptSwimlanes, sl)
+		}
+	}
+	api.swimlanes = keptSwimlanes
+}
+
+func (api *fakeAPI) boardCount() int {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	return len(api.boards)
+}
+
+func newTestCLI(api *fakeAPI) (*cli, *bytes.Buffer) {
+	out := &bytes.Buffer{}
+	return &cli{
+		cfg:    &config{Server: api.URL, Token: "token"},
+		client: client.New(api.URL, client.WithToken("token")),
+		out:    out,
+	}, out
+}
+
+// seedBoard creates a board that uses every field export writes.
+func seedBoard(t *testing.T, c *cli) {
+	t.Helper()
+	ctx := context.Background()
+
+	board, err := c.client.CreateBoard(ctx, client.Board{Title: "Roadmap", Background: "img-2.jpg", EnforceBlockers: true, Labels: []string{"bug", "feature"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, err := c.client.CreateCustomField(ctx, board.ID, client.CustomField{Name: "Size", Type: "single_select", Options: []string{"S", "L"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lanes []*client.Swimlane
+	for _, title := range []string{"Backend", "Frontend"} {
+		lane, err := c.client.CreateSwimlane(ctx, board.ID, client.Swimlane{Title: title})
+		if err != nil {
+			t.Fatal(err)
+		}
+		lanes = append(lanes, lane)
+	}
+	todo, err := c.client.CreateContainer(ctx, board.ID, client.Container{Title: "Todo", SortMode: "priority"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	doing, err := c.client.CreateContainer(ctx, board.ID, client.Container{Title: "Doing", SortMode: "manual"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	estimate, assignee := 3.5, 7
+	starts := time.Date(2026, 5, 1, 9, 0, 0, 0, time.UTC)
+	due := time.Date(2026, 5, 8, 17, 0, 0, 0, time.UTC)
+	first, err := c.client.CreateTask(ctx, todo.ID, client.Task{
+		Title: "Design API", Description: "Sketch the routes", Priority: "high", Estimate: &estimate,
+		Labels: []string{"feature"}, AssigneeID: &assignee, StartsAt: &starts, DueAt: &due,
+		SwimlaneID: &lanes[0].ID, Checklist: []client.ChecklistItem{{Text: "Draft", Done: true}, {Text: "Review"}},
+		CustomFields: map[string]interface{}{strconv.Itoa(size.ID): "L"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := c.client.CreateTask(ctx, doing.ID, client.Task{Title: "Build UI", Priority: "low", SwimlaneID: &lanes[1].ID, Labels: []string{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	third, err := c.client.CreateTask(ctx, doing.ID, client.Task{Title: "Write docs", Completed: true, Priority: "none"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.client.CreateLink(ctx, first.ID, client.TaskLink{Type: "blocks", OtherTaskID: second.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.client.CreateLink(ctx, third.ID, client.TaskLink{Type: "relates_to", OtherTaskID: first.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Doing is already full, so import must set the limit after its tasks.
+	limit := 1
+	if err := c.client.UpdateContainer(ctx, client.Container{ID: doing.ID, Title: "Doing", WIPLimit: &limit, WIPMode: "enforce", SortMode: "manual"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// normalize replaces the IDs in an export with positions so exports of
+// different copies of a board compare equal.
+func normalize(t *testing.T, e *boardExport) string {
+	t.Helper()
+
+	index := func(ids []int) map[int]int {
+		m := map[int]int{}
+		for i, id := range ids {
+			m[id] = i
+		}
+		return m
+	}
+	var fieldIDs, swimlaneIDs, containerIDs, taskIDs []int
+	for _, f := range e.CustomFields {
+		fieldIDs = append(fieldIDs, f.ID)
+	}
+	for _, sl := range e.Swimlanes {
+		swimlaneIDs = append(swimlaneIDs, sl.ID)
+	}
+	for _, ct := range e.Containers {
+		containerIDs = append(containerIDs, ct.ID)
+	}
+	for _, task := range e.Tasks {
+		taskIDs = append(taskIDs, task.ID)
+	}
+	fields, swimlanes, containers, tasks := index(fieldIDs), index(swimlaneIDs), index(containerIDs), index(taskIDs)
+
+	n := boardExport{Board: e.Board}
+	n.Board.ID, n.Board.ContainerIDs, n.Board.SwimlaneIDs = 0, nil, nil
+	for _, f := range e.CustomFields {
+		f.ID, f.BoardID = fields[f.ID], 0
+		n.CustomFields = append(n.CustomFields, f)
+	}
+	for _, sl := range e.Swimlanes {
+		sl.ID, sl.BoardID = swimlanes[sl.ID], 0
+		n.Swimlanes = append(n.Swimlanes, sl)
+	}
+	for _, ct := range e.Containers {
+		ct.ID, ct.BoardID, ct.TaskIDs = containers[ct.ID], 0, nil
+		n.Containers = append(n.Containers, ct)
+	}
+	for _, task := range e.Tasks {
+		task.ID, task.ContainerID = tasks[task.ID], containers[task.ContainerID]
+		if task.SwimlaneID != nil {
+			id := swimlanes[*task.SwimlaneID]
+			task.SwimlaneID = &id
+		}
+		values := map[string]interface{}{}
+		for id, value := range task.CustomFields {
+			old, _ := strconv.Atoi(id)
+			values[strconv.Itoa(fields[old])] = value
+		}
+		task.CustomFields = values
+		n.Tasks = append(n.Tasks, task)
+	}
+	for _, l := range e.Links {
+		l.ID, l.TaskID, l.OtherTaskID = 0, tasks[l.TaskID], tasks[l.OtherTaskID]
+		n.Links = append(n.Links, l)
+	}
+
+	data, err := json.MarshalIndent(n, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	api := newFakeAPI(t)
+	c, out := newTestCLI(api)
+	seedBoard(t, c)
+	ctx := context.Background()
+
+	file := filepath.Join(t.TempDir(), "roadmap.json")
+	if err := c.exportBoard(ctx, []string{"-o", file, "Roadmap"}); err != nil {
+		t.Fatal(err)
+	}
+	c.json = true
+	if err := c.importBoard(ctx, []string{file}); err != nil {
+		t.Fatal(err)
+	}
+	var imported client.Board
+	if err := json.Unmarshal(out.Bytes(), &imported); err != nil {
+		t.Fatal(err)
+	}
+
+	original, err := c.loadBoard(ctx, "Roadmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.loadLinks(ctx, original); err != nil {
+		t.Fatal(err)
+	}
+	copied, err := c.loadBoard(ctx, strconv.Itoa(imported.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.loadLinks(ctx, copied); err != nil {
+		t.Fatal(err)
+	}
+	if copied.Board.ID == original.Board.ID {
+		t.Fatalf("import reused board #%d", original.Board.ID)
+	}
+
+	want, got := normalize(t, original), normalize(t, copied)
+	if got != want {
+		t.Errorf("imported board differs from the original:\ngot  %s\nwant %s", got, want)
+	}
+	if !copied.Tasks[1].Blocked {
+		t.Errorf("the link blocking %q was not imported", copied.Tasks[1].Title)
+	}
+}
+
+func TestImportFailureRemovesBoard(t *testing.T) {
+	api := newFakeAPI(t)
+	c, _ := newTestCLI(api)
+	seedBoard(t, c)
+	ctx := context.Background()
+
+	file := filepath.Join(t.TempDir(), "roadmap.json")
+	if err := c.exportBoard(ctx, []string{"-o", file, "Roadmap"}); err != nil {
+		t.Fatal(err)
+	}
+
+	api.mu.Lock()
+	api.failTask = "Write docs"
+	api.mu.Unlock()
+	err := c.importBoard(ctx, []string{file})
+	if err == nil || !strings.Contains(err.Error(), "Write docs") {
+		t.Fatalf("import error = %v, want one naming the refused task", err)
+	}
+	if n := api.boardCount(); n != 1 {
+		t.Errorf("%d boards after the failed import, want only the original", n)
+	}
+}
+
+func TestImportRefusesWhatItCannotRecreate(t *testing.T) {
+	archived := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	sprint, lane := 9, 99
+	base := func() boardExport {
+		return boardExport{
+			Board:        client.Board{ID: 1, Title: "Roadmap"},
+			CustomFields: []client.CustomField{{ID: 2, Name: "Size", Type: "text"}},
+			Containers:   []client.Container{{ID: 3, Title: "Todo"}},
+			Tasks:        []client.Task{{ID: 4, ContainerID: 3, Title: "Plan"}, {ID: 5, ContainerID: 3, Title: "Ship"}},
+			Links:        []client.TaskLink{{ID: 6, TaskID: 4, Type: "blocks", OtherTaskID: 5}},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		change func(e *boardExport)
+		want   string
+	}{
+		{"archived board", func(e *boardExport) { e.Board.ArchivedAt = &archived }, `board "Roadmap" is archived`},
+		{"archived container", func(e *boardExport) { e.Containers[0].ArchivedAt = &archived }, `container "Todo" is archived`},
+		{"archived task", func(e *boardExport) { e.Tasks[0].ArchivedAt = &archived }, `task "Plan" is archived`},
+		{"sprint", func(e *boardExport) { e.Tasks[0].SprintID = &sprint }, `task "Plan" belongs to sprint 9`},
+		{"unknown container", func(e *boardExport) { e.Tasks[0].ContainerID = 42 }, `task "Plan" references unknown container 42`},
+		{"unknown swimlane", func(e *boardExport) { e.Tasks[0].SwimlaneID = &lane }, `task "Plan" references unknown swimlane 99`},
+		{"unknown field", func(e *boardExport) { e.Tasks[0].CustomFields = map[string]interface{}{"8": "x"} }, "unknown custom field 8"},
+		{"link off the board", func(e *boardExport) { e.Links[0].OtherTaskID = 77 }, "link 6 between tasks 4 and 77 leaves the board"},
+	}
+
+	api := newFakeAPI(t)
+	c, _ := newTestCLI(api)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			export := base()
+			if err := export.check(); err != nil {
+				t.Fatalf("unchanged export: %v", err)
+			}
+			tt.change(&export)
+
+			data, err := json.Marshal(export)
+			if err != nil {
+				t.Fatal(err)
+			}
+			file := filepath.Join(t.TempDir(), "board.json")
+			if err := os.WriteFile(file, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			err = c.importBoard(context.Background(), []string{file})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("import error = %v, want %q", err, tt.want)
+			}
+			if n := api.boardCount(); n != 0 {
+				t.Errorf("import created %d boards before refusing", n)
+			}
+		})
+	}
+}
+
+func TestParseIDs(t *testing.T) {
+	tests := []struct {
+		args    []string
+		want    []int
+		wantErr string
+	}{
+		{[]string{}, []int{}, ""},
+		{[]string{"12"}, []int{12}, ""},
+		{[]string{"#12", "7"}, []int{12, 7}, ""},
+		{[]string{"12", "abc"}, nil, `invalid ID "abc"`},
+		{[]string{"##3"}, nil, `invalid ID "##3"`},
+		{[]string{""}, nil, `invalid ID ""`},
+	}
+	for _, tt := range tests {
+		got, err := parseIDs(tt.args)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("parseIDs(%q) error = %v, want %q", tt.args, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseIDs(%q) = %v, %v; want %v", tt.args, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunArguments(t *testing.T) {
+	api := newFakeAPI(t)
+	path := filepath.Join(t.TempDir(), "config.json")
+	t.Setenv("TASKCTL_CONFIG", path)
+	t.Setenv("TASKCTL_PASSWORD", "")
+
+	// Without a stored token only login runs.
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{nil, "missing command"},
+		{[]string{"--json"}, "missing command"},
+		{[]string{"frob"}, `unknown command "frob"`},
+		{[]string{"--bogus", "boards"}, "flag provided but not defined: -bogus"},
+		{[]string{"boards"}, "not logged in; run taskctl login"},
+	} {
+		err := run(tt.args)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("run(%q) error = %v, want %q", tt.args, err, tt.want)
+		}
+	}
+
+	data, err := json.Marshal(config{Server: api.URL, Token: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"show"}, "usage: taskctl show <board>"},
+		{[]string{"show", "a", "b"}, "usage: taskctl show <board>"},
+		{[]string{"show", "Missing"}, `board "Missing" not found`},
+		{[]string{"add", "3"}, "usage: taskctl add [-d text] <container> <title>"},
+		{[]string{"add", "-d"}, "flag needs an argument: -d"},
+		{[]string{"add", "todo", "Buy", "milk"}, `invalid container ID "todo"`},
+		{[]string{"move", "1"}, "usage: taskctl move <task> <container>"},
+		{[]string{"move", "1", "x"}, `invalid ID "x"`},
+		{[]string{"complete"}, "usage: taskctl complete [--undo] <task>"},
+		{[]string{"complete", "--undo", "1", "2"}, "usage: taskctl complete [--undo] <task>"},
+		{[]string{"complete", "#5"}, "task #5 not found"},
+		{[]string{"delete"}, "usage: taskctl delete <task>"},
+		{[]string{"delete", "five"}, `invalid ID "five"`},
+		{[]string{"export"}, "usage: taskctl export [-o file] <board>"},
+		{[]string{"export", "-o", "out.json"}, "usage: taskctl export [-o file] <board>"},
+		{[]string{"import"}, "usage: taskctl import <file>"},
+		{[]string{"import", filepath.Join(t.TempDir(), "missing.json")}, "no such file or directory"},
+		{[]string{"--server", api.URL + "/nowhere", "boards"}, "no route for GET /nowhere/boards"},
+	} {
+		err := run(tt.args)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("run(%q) error = %v, want %q", tt.args, err, tt.want)
+		}
+	}
+}
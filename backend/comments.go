@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// TaskComment is a note on a task, written by a user or by an automation
+// rule (RuleID set, UserID nil).
+type TaskComment struct {
+	ID        int       `json:"id" db:"id"`
+	TaskID    int       `json:"task_id" db:"task_id"`
+	UserID    *int      `json:"user_id" db:"user_id"`
+	RuleID    *int      `json:"rule_id" db:"rule_id"`
+	Body      string    `json:"body" db:"body" validate:"required,max=5000"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+func (tm *TaskManager) GetCommentsHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	task, err := tm.checkTaskAccess(userID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	comments := []TaskComment{}
+	err = tm.db.Select(&comments, "SELECT * FROM task_comments WHERE task_id = $1 ORDER BY id", task.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(comments)
+}
+
+func (tm *TaskManager) CreateCommentHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	var comment TaskComment
+	err := decodeJSON(w, r, &comment)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	task, err := tm.checkTaskAccess(userID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	boardID, err := tm.boardIDForContainer(task.ContainerID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tm.db.Get(&comment, "INSERT INTO task_comments (task_id, user_id, body) VALUES ($1, $2, $3) RETURNING *", task.ID, userID, comment.Body)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	tm.emit(r, EventCommentCreated, boardID, comment)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(comment)
+}
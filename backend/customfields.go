@@ -0,0 +1,552 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+const (
+	FieldText         = "text"
+	FieldNumber       = "number"
+	FieldDate         = "date"
+	FieldSingleSelect = "single_select"
+	FieldMultiSelect  = "multi_select"
+	FieldCheckbox     = "checkbox"
+	FieldUser         = "user"
+)
+
+// CustomField is an attribute a board defines for its tasks. Options lists
+// the choices of select fields and must be empty for other types. The type
+// of a field cannot change once created.
+type CustomField struct {
+	ID        int            `json:"id" db:"id"`
+	BoardID   int            `json:"board_id" db:"board_id"`
+	Name      string         `json:"name" db:"name" validate:"required,max=50"`
+	Type      string         `json:"type" db:"type" validate:"required,oneof=text|number|date|single_select|multi_select|checkbox|user"`
+	Options   pq.StringArray `json:"options" db:"options" validate:"max=100"`
+	CreatedAt time.Time      `json:"created_at" db:"created_at"`
+}
+
+// CustomFieldValues holds a task's custom field values keyed by field ID.
+// Text, date and single-select values are strings, dates as YYYY-MM-DD;
+// numbers are JSON numbers, multi-select values arrays of options,
+// checkboxes booleans and user fields user IDs.
+type CustomFieldValues map[string]interface{}
+
+func (v CustomFieldValues) Value() (driver.Value, error) {
+	if v == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(v)
+}
+
+func (v *CustomFieldValues) Scan(src interface{}) error { return scanJSON(src, v) }
+
+// keptCustomFields copies a task's custom_fields when its new container $1
+// is on the same board, and clears them otherwise since field IDs belong to
+// a board.
+const keptCustomFields = "CASE WHEN (SELECT board_id FROM containers WHERE id = $1) = " +
+	"(SELECT board_id FROM containers WHERE id = tasks.container_id) THEN custom_fields ELSE '{}' END"
+
+func (tm *TaskManager) GetCustomFieldsHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+	boardID := mux.Vars(r)["id"]
+
+	err := tm.checkBoardOwnership(userID, boardID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	fields, err := tm.getCustomFields(boardID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(fields)
+}
+
+func (tm *TaskManager) CreateCustomFieldHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+	boardID := mux.Vars(r)["id"]
+
+	var field CustomField
+	err := decodeJSON(w, r, &field)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tm.checkBoardOwnership(userID, boardID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = normalizeCustomField(&field)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tm.db.Get(&field, "INSERT INTO custom_fields (board_id, name, type, options) VALUES ($1, $2, $3, $4) RETURNING *",
+		boardID, field.Name, field.Type, field.Options)
+	if isUniqueViolation(err) {
+		writeError(w, r, conflictError("the board already has a field named %q", field.Name))
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(field)
+}
+
+// UpdateCustomFieldHandler renames a field or changes its options. Values
+// of removed options are dropped from the board's tasks.
+func (tm *TaskManager) UpdateCustomFieldHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	var data CustomField
+	err := decodeJSON(w, r, &data)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	field, err := tm.getCustomField(userID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if data.Type != field.Type {
+		writeError(w, r, validationError([]FieldError{{Field: "type", Message: "cannot be changed"}}, "invalid custom field"))
+		return
+	}
+	err = normalizeCustomField(&data)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	tx, err := tm.db.Beginx()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	err = tx.Get(&field, "UPDATE custom_fields SET name = $1, options = $2 WHERE id = $3 RETURNING *", data.Name, data.Options, field.ID)
+	if isUniqueViolation(err) {
+		writeError(w, r, conflictError("the board already has a field named %q", data.Name))
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	key := strconv.Itoa(field.ID)
+	switch field.Type {
+	case FieldSingleSelect:
+		_, err = tx.Exec(`
+			UPDATE tasks SET custom_fields = custom_fields - $1::text
+			WHERE container_id IN (SELECT id FROM containers WHERE board_id = $2)
+			AND custom_fields->>$1::text IS NOT NULL AND NOT (custom_fields->>$1::text = ANY($3))
+		`, key, field.BoardID, field.Options)
+	case FieldMultiSelect:
+		_, err = tx.Exec(`
+			UPDATE tasks SET custom_fields = CASE WHEN kept = '[]' THEN custom_fields - $1::text
+				ELSE jsonb_set(custom_fields, ARRAY[$1::text], kept) END
+			FROM (
+				SELECT t.id, COALESCE((SELECT jsonb_agg(v) FROM jsonb_array_elements_text(t.custom_fields->$1::text) v
+					WHERE v = ANY($3)), '[]') AS kept
+				FROM tasks t JOIN containers c ON c.id = t.container_id
+				WHERE c.board_id = $2 AND t.custom_fields->$1::text IS NOT NULL
+			) filtered
+			WHERE tasks.id = filtered.id
+		`, key, field.BoardID, field.Options)
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(field)
+}
+
+func (tm *TaskManager) DeleteCustomFieldHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	field, err := tm.getCustomField(userID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	tx, err := tm.db.Beginx()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE tasks SET custom_fields = custom_fields - $1::text
+		WHERE container_id IN (SELECT id FROM containers WHERE board_id = $2)
+	`, strconv.Itoa(field.ID), field.BoardID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	_, err = tx.Exec("DELETE FROM custom_fields WHERE id = $1", field.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (tm *TaskManager) getCustomFields(boardID string) ([]CustomField, error) {
+	fields := []CustomField{}
+	err := tm.db.Select(&fields, "SELECT * FROM custom_fields WHERE board_id = $1 ORDER BY id", boardID)
+	return fields, err
+}
+
+// getCustomField loads a field and checks that userID owns its board.
+func (tm *TaskManager) getCustomField(userID int, fieldID string) (CustomField, error) {
+	var field CustomField
+	err := tm.db.Get(&field, "SELECT * FROM custom_fields WHERE id = $1", fieldID)
+	if err == sql.ErrNoRows {
+		return field, notFoundError("custom field %s not found", fieldID)
+	}
+	if err != nil {
+		return field, err
+	}
+	return field, tm.checkBoardOwnership(userID, strconv.Itoa(field.BoardID))
+}
+
+// normalizeCustomField trims the name and options and checks that select
+// fields, and only those, have a list of distinct options.
+func normalizeCustomField(field *CustomField) error {
+	field.Name = strings.TrimSpace(field.Name)
+	options := pq.StringArray{}
+	seen := map[string]bool{}
+	for _, option := range field.Options {
+		option = strings.TrimSpace(option)
+		if option == "" || len([]rune(option)) > 50 {
+			return validationError([]FieldError{{Field: "options", Message: "must be between 1 and 50 characters each"}}, "invalid custom field")
+		}
+		if !seen[option] {
+			seen[option] = true
+			options = append(options, option)
+		}
+	}
+	field.Options = options
+
+	selectable := field.Type == FieldSingleSelect || field.Type == FieldMultiSelect
+	if selectable && len(options) == 0 {
+		return validationError([]FieldError{{Field: "options", Message: "is required for select fields"}}, "invalid custom field")
+	}
+	if !selectable && len(options) > 0 {
+		return validationError([]FieldError{{Field: "options", Message: "is only allowed for select fields"}}, "invalid custom field")
+	}
+	return nil
+}
+
+// checkCustomFields validates a task's values against its board's fields and
+// returns them normalized: null, empty text and empty selections are
+// dropped, and user IDs become integers.
+func (tm *TaskManager) checkCustomFields(values CustomFieldValues, boardID int) (CustomFieldValues, error) {
+	clean := CustomFieldValues{}
+	if len(values) == 0 {
+		return clean, nil
+	}
+
+	fields, err := tm.getCustomFields(strconv.Itoa(boardID))
+	if err != nil {
+		return nil, err
+	}
+	byKey := map[string]CustomField{}
+	for _, field := range fields {
+		byKey[strconv.Itoa(field.ID)] = field
+	}
+
+	var fieldErrs []FieldError
+	for key, value := range values {
+		name := "custom_fields." + key
+		field, ok := byKey[key]
+		if !ok {
+			fieldErrs = append(fieldErrs, FieldError{Field: name, Message: "is not a field of the task's board"})
+			continue
+		}
+		if value == nil {
+			continue
+		}
+		normalized, msg := normalizeFieldValue(field, value)
+		if msg == "" && field.Type == FieldUser && normalized != nil {
+			var exists bool
+			err = tm.db.Get(&exists, "SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)", normalized)
+			if err != nil {
+				return nil, err
+			}
+			if !exists {
+				msg = "unknown user"
+			}
+		}
+		if msg != "" {
+			fieldErrs = append(fieldErrs, FieldError{Field: name, Message: msg})
+			continue
+		}
+		if normalized != nil {
+			clean[key] = normalized
+		}
+	}
+
+	if len(fieldErrs) > 0 {
+		return nil, validationError(fieldErrs, "invalid custom field values")
+	}
+	return clean, nil
+}
+
+// normalizeFieldValue checks one decoded JSON value against field, returning
+// the value to store (nil to drop it) or a validation message.
+func normalizeFieldValue(field CustomField, value interface{}) (interface{}, string) {
+	switch field.Type {
+	case FieldText:
+		s, ok := value.(string)
+		if !ok {
+			return nil, "must be a string"
+		}
+		if len([]rune(s)) > 1000 {
+			return nil, "must be at most 1000 characters"
+		}
+		if strings.TrimSpace(s) == "" {
+			return nil, ""
+		}
+		return s, ""
+
+	case FieldNumber:
+		n, ok := value.(float64)
+		if !ok || math.IsInf(n, 0) || math.IsNaN(n) {
+			return nil, "must be a number"
+		}
+		return n, ""
+
+	case FieldDate:
+		s, ok := value.(string)
+		if !ok {
+			return nil, "must be a date formatted YYYY-MM-DD"
+		}
+		if _, err := time.Parse("2006-01-02", s); err != nil {
+			return nil, "must be a date formatted YYYY-MM-DD"
+		}
+		return s, ""
+
+	case FieldSingleSelect:
+		s, ok := value.(string)
+		if !ok || !containsString(field.Options, s) {
+			return nil, "must be one of " + strings.Join(field.Options, ", ")
+		}
+		return s, ""
+
+	case FieldMultiSelect:
+		items, ok := value.([]interface{})
+		if !ok {
+			return nil, "must be a list of options"
+		}
+		selected := []string{}
+		for _, item := range items {
+			s, ok := item.(string)
+			if !ok || !containsString(field.Options, s) {
+				return nil, "must only contain " + strings.Join(field.Options, ", ")
+			}
+			if !containsString(selected, s) {
+				selected = append(selected, s)
+			}
+		}
+		if len(selected) == 0 {
+			return nil, ""
+		}
+		return selected, ""
+
+	case FieldCheckbox:
+		b, ok := value.(bool)
+		if !ok {
+			return nil, "must be true or false"
+		}
+		return b, ""
+
+	case FieldUser:
+		n, ok := value.(float64)
+		if !ok || n != math.Trunc(n) || n < 1 || n > math.MaxInt32 {
+			return nil, "must be a user ID"
+		}
+		return int(n), ""
+	}
+	return nil, "has an unknown type"
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// customFieldQuery turns cf.<field ID> filters and a sort=[-]cf.<field ID>
+// parameter into SQL on the tasks table; other sort values are left to the
+// caller. Arguments are numbered from len(args)+1 and appended to args.
+// Numbers and dates may be prefixed with <, <=, > or >=; text matches
+// substrings and multi-select fields match tasks that have the option
+// selected.
+func customFieldQuery(fields []CustomField, query url.Values, args []interface{}) (string, string, []interface{}, error) {
+	byKey := map[string]CustomField{}
+	for _, field := range fields {
+		byKey[strconv.Itoa(field.ID)] = field
+	}
+
+	var params []string
+	for param := range query {
+		if strings.HasPrefix(param, "cf.") {
+			params = append(params, param)
+		}
+	}
+	sort.Strings(params)
+
+	var where []string
+	var fieldErrs []FieldError
+	for _, param := range params {
+		values := query[param]
+		field, ok := byKey[strings.TrimPrefix(param, "cf.")]
+		if !ok {
+			fieldErrs = append(fieldErrs, FieldError{Field: param, Message: "is not a field of the board"})
+			continue
+		}
+		for _, value := range values {
+			cond, arg, msg := customFieldFilter(field, value, len(args)+1)
+			if msg != "" {
+				fieldErrs = append(fieldErrs, FieldError{Field: param, Message: msg})
+				continue
+			}
+			where = append(where, cond)
+			args = append(args, arg)
+		}
+	}
+
+	order := ""
+	if by := query.Get("sort"); strings.HasPrefix(strings.TrimPrefix(by, "-"), "cf.") {
+		key := strings.TrimPrefix(by, "-")
+		field, ok := byKey[strings.TrimPrefix(key, "cf.")]
+		if !ok {
+			fieldErrs = append(fieldErrs, FieldError{Field: "sort", Message: "is not a field of the board"})
+		} else {
+			order = customFieldExpr(field)
+			if key != by {
+				order += " DESC"
+			}
+			order += " NULLS LAST"
+		}
+	}
+
+	if len(fieldErrs) > 0 {
+		return "", "", nil, validationError(fieldErrs, "invalid query")
+	}
+	return strings.Join(where, " AND "), order, args, nil
+}
+
+// customFieldExpr is the typed SQL value of field on a task row.
+func customFieldExpr(field CustomField) string {
+	expr := fmt.Sprintf("(tasks.custom_fields->>'%d')", field.ID)
+	switch field.Type {
+	case FieldNumber:
+		return expr + "::numeric"
+	case FieldCheckbox:
+		return expr + "::boolean"
+	}
+	return expr
+}
+
+func customFieldFilter(field CustomField, value string, n int) (string, interface{}, string) {
+	expr := customFieldExpr(field)
+
+	switch field.Type {
+	case FieldNumber, FieldDate:
+		op := "="
+		for _, prefix := range []string{"<=", ">=", "<", ">"} {
+			if strings.HasPrefix(value, prefix) {
+				op, value = prefix, strings.TrimPrefix(value, prefix)
+				break
+			}
+		}
+		if field.Type == FieldNumber {
+			if _, err := strconv.ParseFloat(value, 64); err != nil {
+				return "", nil, "must be a number, optionally prefixed with <, <=, > or >="
+			}
+			return fmt.Sprintf("%s %s $%d::numeric", expr, op, n), value, ""
+		}
+		if _, err := time.Parse("2006-01-02", value); err != nil {
+			return "", nil, "must be a date formatted YYYY-MM-DD, optionally prefixed with <, <=, > or >="
+		}
+		return fmt.Sprintf("%s %s $%d::text", expr, op, n), value, ""
+
+	case FieldText:
+		pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value) + "%"
+		return fmt.Sprintf("%s ILIKE $%d::text", expr, n), pattern, ""
+
+	case FieldMultiSelect:
+		return fmt.Sprintf("tasks.custom_fields->'%d' @> jsonb_build_array($%d::text)", field.ID, n), value, ""
+
+	case FieldCheckbox:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", nil, "must be true or false"
+		}
+		return fmt.Sprintf("COALESCE(%s, false) = $%d::boolean", expr, n), b, ""
+
+	case FieldUser:
+		if _, err := strconv.Atoi(value); err != nil {
+			return "", nil, "must be a user ID"
+		}
+		return fmt.Sprintf("%s = $%d::text", expr, n), value, ""
+	}
+
+	return fmt.Sprintf("%s = $%d::text", expr, n), value, ""
+}
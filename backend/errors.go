@@ -1,7 +1,174 @@
 package main
 
-import "errors"
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/lib/pq"
+)
 
 var (
-	ErrForbidden = errors.New("forbidden")
+	ErrForbidden    = errors.New("forbidden")
+	ErrNotFound     = errors.New("not found")
+	ErrValidation   = errors.New("validation failed")
+	ErrConflict     = errors.New("conflict")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrBadRequest   = errors.New("bad request")
+
+	ErrPayloadTooLarge = errors.New("payload too large")
+	ErrWIPLimit        = errors.New("wip limit exceeded")
 )
+
+// DomainError attaches a client-facing message and optional details to one
+// of the sentinel errors above. errors.Is matches it against its Kind.
+type DomainError struct {
+	Kind    error
+	Message string
+	Details interface{}
+}
+
+func (e *DomainError) Error() string {
+	return e.Message
+}
+
+func (e *DomainError) Unwrap() error {
+	return e.Kind
+}
+
+func newDomainError(kind error, format string, args ...interface{}) *DomainError {
+	return &DomainError{Kind: kind, Message: fmt.Sprintf(format, args...)}
+}
+
+func notFoundError(format string, args ...interface{}) error {
+	return newDomainError(ErrNotFound, format, args...)
+}
+
+func forbiddenError(format string, args ...interface{}) error {
+	return newDomainError(ErrForbidden, format, args...)
+}
+
+func validationError(details interface{}, format string, args ...interface{}) error {
+	err := newDomainError(ErrValidation, format, args...)
+	err.Details = details
+	return err
+}
+
+func conflictError(format string, args ...interface{}) error {
+	return newDomainError(ErrConflict, format, args...)
+}
+
+func badRequestError(format string, args ...interface{}) error {
+	return newDomainError(ErrBadRequest, format, args...)
+}
+
+func wipLimitError(violations []WIPViolation) error {
+	err := newDomainError(ErrWIPLimit, "container %d is over its WIP limit of %d", violations[0].ContainerID, violations[0].Limit)
+	err.Details = violations
+	return err
+}
+
+// isUniqueViolation reports whether err is a postgres unique constraint
+// violation, which handlers surface as ErrConflict.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+func isForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23503"
+}
+
+// APIError is the JSON body written for every failed request.
+type APIError struct {
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
+}
+
+var errorStatuses = []struct {
+	kind   error
+	status int
+	code   string
+}{
+	{ErrBadRequest, http.StatusBadRequest, "bad_request"},
+	{ErrUnauthorized, http.StatusUnauthorized, "unauthorized"},
+	{ErrForbidden, http.StatusForbidden, "forbidden"},
+	{ErrNotFound, http.StatusNotFound, "not_found"},
+	{sql.ErrNoRows, http.StatusNotFound, "not_found"},
+	{ErrConflict, http.StatusConflict, "conflict"},
+	{ErrValidation, http.StatusUnprocessableEntity, "validation_failed"},
+	{ErrPayloadTooLarge, http.StatusRequestEntityTooLarge, "payload_too_large"},
+	{ErrWIPLimit, http.StatusConflict, "wip_limit_exceeded"},
+}
+
+// describeError maps err to a status code and APIError body. Errors that
+// are not domain errors are reported as a generic internal error, with
+// matched false, so database messages never reach the client.
+func describeError(err error) (status int, body APIError, matched bool) {
+	status = http.StatusInternalServerError
+	body = APIError{Code: "internal", Message: "internal server error"}
+
+	for _, s := range errorStatuses {
+		if errors.Is(err, s.kind) {
+			status = s.status
+			body.Code = s.code
+			body.Message = s.kind.Error()
+			matched = true
+			break
+		}
+	}
+
+	var domainErr *DomainError
+	if errors.As(err, &domainErr) {
+		body.Message = domainErr.Message
+		body.Details = domainErr.Details
+	}
+	return status, body, matched
+}
+
+// writeError writes err as an APIError, logging errors that are not domain
+// errors.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	requestID, _ := r.Context().Value("requestID").(string)
+
+	status, body, matched := describeError(err)
+	body.RequestID = requestID
+
+	if !matched {
+		log.Printf("request %s: %s %s: %v", requestID, r.Method, r.URL.Path, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get("X-Request-ID")
+		if requestID == "" {
+			requestID = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", requestID)
+
+		ctx := context.WithValue(r.Context(), "requestID", requestID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
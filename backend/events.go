@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+const (
+	EventBoardCreated     = "board.created"
+	EventBoardUpdated     = "board.updated"
+	EventBoardDeleted     = "board.deleted"
+	EventBoardSynced      = "board.synced"
+	EventContainerCreated = "container.created"
+	EventContainerUpdated = "container.updated"
+	EventContainerDeleted = "container.deleted"
+	EventTaskCreated      = "task.created"
+	EventTaskUpdated      = "task.updated"
+	EventTaskMoved        = "task.moved"
+	EventTaskCompleted    = "task.completed"
+	EventTaskDeleted      = "task.deleted"
+	EventTaskAssigned     = "task.assigned"
+	EventCommentCreated   = "comment.created"
+)
+
+var eventTypes = []string{
+	EventBoardCreated, EventBoardUpdated, EventBoardDeleted, EventBoardSynced,
+	EventContainerCreated, EventContainerUpdated, EventContainerDeleted,
+	EventTaskCreated, EventTaskUpdated, EventTaskMoved, EventTaskCompleted, EventTaskDeleted,
+	EventTaskAssigned, EventCommentCreated,
+}
+
+// Event describes a committed change to a board's contents. RuleID is set
+// when an automation rule caused the change, and Depth counts how many rule
+// runs led to it.
+type Event struct {
+	Type       string      `json:"event"`
+	BoardID    int         `json:"board_id"`
+	UserID     int         `json:"user_id"`
+	RuleID     int         `json:"rule_id,omitempty"`
+	Depth      int         `json:"-"`
+	OccurredAt time.Time   `json:"occurred_at"`
+	Data       interface{} `json:"data"`
+}
+
+// EventListener receives every event emitted by the TaskManager. Listeners
+// run synchronously on the request goroutine, so slow work such as network
+// calls belongs in a background worker.
+type EventListener interface {
+	HandleEvent(Event)
+}
+
+func (tm *TaskManager) Subscribe(l EventListener) {
+	tm.listeners = append(tm.listeners, l)
+}
+
+func (tm *TaskManager) emit(r *http.Request, eventType string, boardID int, data interface{}) {
+	userID, _ := r.Context().Value("userID").(int)
+	event := Event{
+		Type:       eventType,
+		BoardID:    boardID,
+		UserID:     userID,
+		OccurredAt: time.Now().UTC(),
+		Data:       data,
+	}
+	tm.publish(event)
+}
+
+func (tm *TaskManager) publish(event Event) {
+	for _, l := range tm.listeners {
+		l.HandleEvent(event)
+	}
+}
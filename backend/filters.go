@@ -0,0 +1,241 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// SavedFilter is a named task query of a user, see parseTaskQuery.
+type SavedFilter struct {
+	ID        int       `json:"id" db:"id"`
+	UserID    int       `json:"user_id" db:"user_id"`
+	Name      string    `json:"name" db:"name" validate:"required,max=100"`
+	Query     string    `json:"query" db:"query" validate:"required,max=1000"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+type TaskPage struct {
+	Items  []Task `json:"items"`
+	Total  int    `json:"total"`
+	Limit  int    `json:"limit"`
+	Offset int    `json:"offset"`
+}
+
+// userTasks restricts a tasks query to userID's ($1) unarchived tasks on
+// unarchived boards and containers.
+const userTasks = `
+	tasks.container_id IN (
+		SELECT c.id FROM containers c JOIN boards b ON b.id = c.board_id
+		WHERE b.user_id = $1 AND b.archived_at IS NULL AND c.archived_at IS NULL
+	) AND tasks.archived_at IS NULL`
+
+var pageParams = []queryParam{
+	{name: "limit", description: "page size, at most 200", typ: "integer"},
+	{name: "offset", description: "number of tasks to skip", typ: "integer"},
+}
+
+func (tm *TaskManager) GetFiltersHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	filters := []SavedFilter{}
+	err := tm.db.Select(&filters, "SELECT * FROM saved_filters WHERE user_id = $1 ORDER BY name", userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(filters)
+}
+
+func (tm *TaskManager) CreateFilterHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	var filter SavedFilter
+	err := decodeJSON(w, r, &filter)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	_, _, err = taskQuery(filter.Query, userID, time.Now(), nil)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tm.db.Get(&filter, `
+		INSERT INTO saved_filters (user_id, name, query) VALUES ($1, $2, $3)
+		RETURNING *
+	`, userID, filter.Name, filter.Query)
+	if isUniqueViolation(err) {
+		writeError(w, r, conflictError("a filter named %q already exists", filter.Name))
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(filter)
+}
+
+func (tm *TaskManager) GetFilterHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	filter, err := tm.getFilter(userID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(filter)
+}
+
+func (tm *TaskManager) UpdateFilterHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	var data SavedFilter
+	err := decodeJSON(w, r, &data)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	filter, err := tm.getFilter(userID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	_, _, err = taskQuery(data.Query, userID, time.Now(), nil)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tm.db.Get(&filter, "UPDATE saved_filters SET name = $1, query = $2 WHERE id = $3 RETURNING *",
+		data.Name, data.Query, filter.ID)
+	if isUniqueViolation(err) {
+		writeError(w, r, conflictError("a filter named %q already exists", data.Name))
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(filter)
+}
+
+func (tm *TaskManager) DeleteFilterHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	filter, err := tm.getFilter(userID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	_, err = tm.db.Exec("DELETE FROM saved_filters WHERE id = $1", filter.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetFilterTasksHandler runs a saved filter over the caller's boards.
+func (tm *TaskManager) GetFilterTasksHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	filter, err := tm.getFilter(userID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	tm.writeTaskQuery(w, r, userID, filter.Query)
+}
+
+// SearchTasksHandler runs the query in ?q= over the caller's boards, to try
+// out a query before saving it.
+func (tm *TaskManager) SearchTasksHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	tm.writeTaskQuery(w, r, userID, r.URL.Query().Get("q"))
+}
+
+// writeTaskQuery responds with a page of the tasks matching query, soonest
+// due first.
+func (tm *TaskManager) writeTaskQuery(w http.ResponseWriter, r *http.Request, userID int, query string) {
+	var fieldErrs []FieldError
+	limit, ok := queryInt(r.URL.Query().Get("limit"), 50, 1, 200)
+	if !ok {
+		fieldErrs = append(fieldErrs, FieldError{Field: "limit", Message: "must be between 1 and 200"})
+	}
+	offset, ok := queryInt(r.URL.Query().Get("offset"), 0, 0, 1<<31-1)
+	if !ok {
+		fieldErrs = append(fieldErrs, FieldError{Field: "offset", Message: "must not be negative"})
+	}
+	if len(fieldErrs) > 0 {
+		writeError(w, r, validationError(fieldErrs, "invalid query"))
+		return
+	}
+
+	cond, args, err := taskQuery(query, userID, time.Now(), []interface{}{userID})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	page := TaskPage{Items: []Task{}, Limit: limit, Offset: offset}
+	err = tm.db.Get(&page.Total, "SELECT COUNT(*) FROM tasks WHERE "+userTasks+" AND "+cond, args...)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	n := len(args)
+	err = tm.db.Select(&page.Items, "SELECT "+taskColumns+" FROM tasks WHERE "+userTasks+" AND "+cond+
+		" ORDER BY tasks.due_at NULLS LAST, tasks.id LIMIT $"+strconv.Itoa(n+1)+" OFFSET $"+strconv.Itoa(n+2),
+		append(args, limit, offset)...)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+func (tm *TaskManager) getFilter(userID int, filterID string) (SavedFilter, error) {
+	var filter SavedFilter
+	err := tm.db.Get(&filter, "SELECT * FROM saved_filters WHERE id = $1", filterID)
+	if err == sql.ErrNoRows {
+		return filter, notFoundError("filter %s not found", filterID)
+	}
+	if err != nil {
+		return filter, err
+	}
+	if filter.UserID != userID {
+		return filter, ErrForbidden
+	}
+	return filter, nil
+}
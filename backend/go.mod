@@ -10,11 +10,13 @@ require (
 require golang.org/x/crypto v0.11.0
 
 require (
+	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/gorilla/handlers v1.5.1
 	github.com/lib/pq v1.10.9
+	golang.org/x/term v0.10.0
 )
 
 require (
-	github.com/dgrijalva/jwt-go v3.2.0+incompatible // indirect
 	github.com/felixge/httpsnoop v1.0.1 // indirect
+	golang.org/x/sys v0.10.0 // indirect
 )
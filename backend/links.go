@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	LinkBlocks       = "blocks"
+	LinkBlockedBy    = "blocked_by"
+	LinkRelatesTo    = "relates_to"
+	LinkDuplicates   = "duplicates"
+	LinkDuplicatedBy = "duplicated_by"
+)
+
+// taskLinksLock serializes link inserts so two concurrent requests cannot
+// together close a cycle that neither would on its own.
+const taskLinksLock = 0x7461736b6c6e6b
+
+// TaskLink relates two tasks, described from the point of view of TaskID.
+// blocked_by and duplicated_by are stored as the reverse blocks and
+// duplicates links, so each link shows up on both tasks.
+type TaskLink struct {
+	ID             int       `json:"id" db:"id"`
+	TaskID         int       `json:"task_id" db:"task_id"`
+	Type           string    `json:"type" db:"type" validate:"required,oneof=blocks|blocked_by|relates_to|duplicates|duplicated_by"`
+	OtherTaskID    int       `json:"other_task_id" db:"other_task_id" validate:"required"`
+	OtherTitle     string    `json:"other_title" db:"other_title"`
+	OtherCompleted bool      `json:"other_completed" db:"other_completed"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+}
+
+func (tm *TaskManager) GetLinksHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	task, err := tm.checkTaskAccess(userID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	links := []TaskLink{}
+	err = tm.db.Select(&links, `
+		SELECT v.*, t.title AS other_title, t.completed AS other_completed
+		FROM (
+			SELECT id, task_id, other_task_id, kind AS type, created_at
+			FROM task_links WHERE task_id = $1
+			UNION ALL
+			SELECT id, other_task_id, task_id,
+				CASE kind WHEN 'blocks' THEN 'blocked_by' WHEN 'duplicates' THEN 'duplicated_by' ELSE kind END,
+				created_at
+			FROM task_links WHERE other_task_id = $1
+		) v
+		JOIN tasks t ON t.id = v.other_task_id
+		ORDER BY v.id
+	`, task.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(links)
+}
+
+func (tm *TaskManager) CreateLinkHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	var link TaskLink
+	err := decodeJSON(w, r, &link)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	task, err := tm.checkTaskAccess(userID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	other, err := tm.checkTaskAccess(userID, strconv.Itoa(link.OtherTaskID))
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrForbidden) {
+		writeError(w, r, validationError([]FieldError{{Field: "other_task_id", Message: "unknown task"}}, "invalid link"))
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if other.ID == task.ID {
+		writeError(w, r, validationError([]FieldError{{Field: "other_task_id", Message: "a task cannot be linked to itself"}}, "invalid link"))
+		return
+	}
+
+	from, to, kind := linkEdge(task.ID, other.ID, link.Type)
+
+	tx, err := tm.db.Beginx()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("SELECT pg_advisory_xact_lock($1)", taskLinksLock)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// A blocks or duplicates link from -> to closes a cycle when from is
+	// already reachable from to along links of the same kind.
+	if kind != LinkRelatesTo {
+		var cycle bool
+		err = tx.Get(&cycle, `
+			WITH RECURSIVE reachable(id) AS (
+				SELECT $1::integer
+				UNION
+				SELECT l.other_task_id FROM task_links l JOIN reachable ON l.task_id = reachable.id
+				WHERE l.kind = $3
+			)
+			SELECT EXISTS (SELECT 1 FROM reachable WHERE id = $2)
+		`, to, from, kind)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if cycle {
+			writeError(w, r, conflictError("linking task %d to task %d would create a %s cycle", task.ID, other.ID, kind))
+			return
+		}
+	}
+
+	err = tx.QueryRow("INSERT INTO task_links (task_id, other_task_id, kind) VALUES ($1, $2, $3) RETURNING id, created_at",
+		from, to, kind).Scan(&link.ID, &link.CreatedAt)
+	if isUniqueViolation(err) {
+		writeError(w, r, conflictError("task %d is already linked to task %d", task.ID, other.ID))
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	link.TaskID = task.ID
+	link.OtherTitle = other.Title
+	link.OtherCompleted = other.Completed
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(link)
+}
+
+func (tm *TaskManager) DeleteLinkHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+	vars := mux.Vars(r)
+
+	task, err := tm.checkTaskAccess(userID, vars["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	res, err := tm.db.Exec("DELETE FROM task_links WHERE id = $1 AND (task_id = $2 OR other_task_id = $2)", vars["linkID"], task.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(w, r, notFoundError("link %s not found on task %d", vars["linkID"], task.ID))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// linkEdge converts a link type seen from taskID into the stored edge.
+// relates_to is symmetric, so it is stored with the lower id first to keep
+// the unique index meaningful.
+func linkEdge(taskID, otherID int, linkType string) (from, to int, kind string) {
+	switch linkType {
+	case LinkBlockedBy:
+		return otherID, taskID, LinkBlocks
+	case LinkDuplicatedBy:
+		return otherID, taskID, LinkDuplicates
+	case LinkRelatesTo:
+		if otherID < taskID {
+			return otherID, taskID, LinkRelatesTo
+		}
+	}
+	return taskID, otherID, linkType
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// ContainerEntry is a stay of a task in a container. LeftAt is nil while
+// the task is still there.
+type ContainerEntry struct {
+	ContainerID int        `json:"container_id"`
+	EnteredAt   time.Time  `json:"entered_at"`
+	LeftAt      *time.Time `json:"left_at"`
+}
+
+// TaskFlow is a task's way through the board, as recorded in its history.
+// CreatedAt is when the task first entered a container, or when recording
+// began for older tasks. CompletedAt is when it was last completed, and nil
+// unless it still is.
+type TaskFlow struct {
+	TaskID      int              `json:"task_id"`
+	CreatedAt   time.Time        `json:"created_at"`
+	CompletedAt *time.Time       `json:"completed_at"`
+	Entries     []ContainerEntry `json:"entries"`
+}
+
+// DurationStats summarizes durations in hours. Percentiles use the nearest
+// rank.
+type DurationStats struct {
+	Count int     `json:"count"`
+	Mean  float64 `json:"mean"`
+	P50   float64 `json:"p50"`
+	P85   float64 `json:"p85"`
+	P95   float64 `json:"p95"`
+	Max   float64 `json:"max"`
+}
+
+type WeeklyThroughput struct {
+	WeekStart string `json:"week_start"`
+	Completed int    `json:"completed"`
+}
+
+// TaskFlowTimes are the lead and cycle times of a completed task in hours.
+// CycleTime is nil if the task never passed through the cycle's containers.
+type TaskFlowTimes struct {
+	TaskID      int       `json:"task_id"`
+	Title       string    `json:"title"`
+	CompletedAt time.Time `json:"completed_at"`
+	LeadTime    float64   `json:"lead_time"`
+	CycleTime   *float64  `json:"cycle_time"`
+}
+
+// FlowMetrics measures the tasks of a board completed between From and To.
+// Lead time runs from creation to completion. Cycle time runs from the first
+// entry into StartContainerID, or from first leaving the container the task
+// was created in, to the first entry into EndContainerID after that, or to
+// completion.
+type FlowMetrics struct {
+	BoardID          int                `json:"board_id"`
+	From             string             `json:"from"`
+	To               string             `json:"to"`
+	StartContainerID *int               `json:"start_container_id"`
+	EndContainerID   *int               `json:"end_container_id"`
+	LeadTime         DurationStats      `json:"lead_time"`
+	CycleTime        DurationStats      `json:"cycle_time"`
+	Throughput       []WeeklyThroughput `json:"throughput"`
+	Tasks            []TaskFlowTimes    `json:"tasks"`
+}
+
+type historyRow struct {
+	TaskID      int       `db:"task_id"`
+	ContainerID *int      `db:"container_id"`
+	Completed   bool      `db:"completed"`
+	RecordedAt  time.Time `db:"recorded_at"`
+}
+
+// GetTaskHistoryHandler lists when a task entered and left each container
+// and when it was completed.
+func (a *Analytics) GetTaskHistoryHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	task, err := a.tm.checkTaskAccess(userID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	flows, err := a.taskFlows([]int64{int64(task.ID)})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	flow, ok := flows[task.ID]
+	if !ok {
+		flow = TaskFlow{TaskID: task.ID, Entries: []ContainerEntry{}}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(flow)
+}
+
+// GetFlowMetricsHandler reports lead time, cycle time and weekly throughput
+// of the board's completed tasks, optionally only those with a label or
+// assignee.
+func (a *Analytics) GetFlowMetricsHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+	boardID := mux.Vars(r)["id"]
+	query := r.URL.Query()
+
+	err := a.tm.checkBoardOwnership(userID, boardID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var fieldErrs []FieldError
+	startID, ok := queryInt(query.Get("start_container_id"), 0, 1, 1<<31-1)
+	if !ok {
+		fieldErrs = append(fieldErrs, FieldError{Field: "start_container_id", Message: "must be a container ID"})
+	}
+	endID, ok := queryInt(query.Get("end_container_id"), 0, 1, 1<<31-1)
+	if !ok {
+		fieldErrs = append(fieldErrs, FieldError{Field: "end_container_id", Message: "must be a container ID"})
+	}
+	assigneeID, ok := queryInt(query.Get("assignee_id"), 0, 1, 1<<31-1)
+	if !ok {
+		fieldErrs = append(fieldErrs, FieldError{Field: "assignee_id", Message: "must be a user ID"})
+	}
+	if len(fieldErrs) > 0 {
+		writeError(w, r, validationError(fieldErrs, "invalid query"))
+		return
+	}
+
+	from, to, err := analyticsRange(query.Get("from"), query.Get("to"), time.Time{}, time.Time{}, 84)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	metrics := FlowMetrics{From: from.Format("2006-01-02"), To: to.Format("2006-01-02"), Throughput: []WeeklyThroughput{}, Tasks: []TaskFlowTimes{}}
+	metrics.BoardID, _ = strconv.Atoi(boardID)
+	for field, id := range map[string]int{"start_container_id": startID, "end_container_id": endID} {
+		if id == 0 {
+			continue
+		}
+		var containerBoardID int
+		err = a.db.Get(&containerBoardID, "SELECT board_id FROM containers WHERE id = $1", id)
+		if err != nil || containerBoardID != metrics.BoardID {
+			fieldErrs = append(fieldErrs, FieldError{Field: field, Message: "must be a container of the board"})
+		}
+	}
+	if len(fieldErrs) > 0 {
+		writeError(w, r, validationError(fieldErrs, "invalid query"))
+		return
+	}
+	if startID != 0 {
+		metrics.StartContainerID = &startID
+	}
+	if endID != 0 {
+		metrics.EndContainerID = &endID
+	}
+
+	var tasks []struct {
+		ID    int    `db:"id"`
+		Title string `db:"title"`
+	}
+	err = a.db.Select(&tasks, `
+		SELECT t.id, t.title FROM tasks t JOIN containers c ON c.id = t.container_id
+		WHERE c.board_id = $1 AND t.completed AND t.archived_at IS NULL AND c.archived_at IS NULL
+		AND ($2::text = '' OR $2::text = ANY(t.labels))
+		AND ($3::integer = 0 OR t.assignee_id = $3::integer)
+		ORDER BY t.id
+	`, boardID, query.Get("label"), assigneeID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	ids := make([]int64, len(tasks))
+	for i, task := range tasks {
+		ids[i] = int64(task.ID)
+	}
+	flows, err := a.taskFlows(ids)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	end := to.AddDate(0, 0, 1)
+	weekStart := func(t time.Time) time.Time {
+		day := t.UTC().Truncate(24 * time.Hour)
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	}
+	weeks := map[string]int{}
+	for week := weekStart(from); week.Before(end); week = week.AddDate(0, 0, 7) {
+		weeks[week.Format("2006-01-02")] = len(metrics.Throughput)
+		metrics.Throughput = append(metrics.Throughput, WeeklyThroughput{WeekStart: week.Format("2006-01-02")})
+	}
+
+	var leadTimes, cycleTimes []float64
+	for _, task := range tasks {
+		flow, ok := flows[task.ID]
+		if !ok || flow.CompletedAt == nil || flow.CompletedAt.Before(from) || !flow.CompletedAt.Before(end) {
+			continue
+		}
+		times := TaskFlowTimes{TaskID: task.ID, Title: task.Title, CompletedAt: *flow.CompletedAt, LeadTime: hours(flow.CompletedAt.Sub(flow.CreatedAt))}
+		if cycle, ok := flow.cycleTime(startID, endID); ok {
+			h := hours(cycle)
+			times.CycleTime = &h
+			cycleTimes = append(cycleTimes, h)
+		}
+		leadTimes = append(leadTimes, times.LeadTime)
+		metrics.Tasks = append(metrics.Tasks, times)
+		metrics.Throughput[weeks[weekStart(*flow.CompletedAt).Format("2006-01-02")]].Completed++
+	}
+	metrics.LeadTime = durationStats(leadTimes)
+	metrics.CycleTime = durationStats(cycleTimes)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(metrics)
+}
+
+// taskFlows replays the history of the given tasks. Tasks without history
+// are left out.
+func (a *Analytics) taskFlows(taskIDs []int64) (map[int]TaskFlow, error) {
+	var rows []historyRow
+	err := a.db.Select(&rows, `
+		SELECT task_id, container_id, completed, recorded_at FROM task_history
+		WHERE task_id = ANY($1) ORDER BY task_id, recorded_at, id
+	`, pq.Int64Array(taskIDs))
+	if err != nil {
+		return nil, err
+	}
+
+	flows := map[int]TaskFlow{}
+	for start := 0; start < len(rows); {
+		end := start
+		for end < len(rows) && rows[end].TaskID == rows[start].TaskID {
+			end++
+		}
+		flows[rows[start].TaskID] = replayHistory(rows[start:end])
+		start = end
+	}
+	return flows, nil
+}
+
+// replayHistory turns the recorded states of one task into its container
+// entries and completion time. The first row dates the task even if it has
+// no container because the task or its container was archived.
+func replayHistory(rows []historyRow) TaskFlow {
+	flow := TaskFlow{TaskID: rows[0].TaskID, CreatedAt: rows[0].RecordedAt, Entries: []ContainerEntry{}}
+	var current *int
+	completed := false
+	for _, row := range rows {
+		at := row.RecordedAt
+		changed := row.ContainerID != nil && (current == nil || *current != *row.ContainerID)
+		if current != nil && (row.ContainerID == nil || changed) {
+			flow.Entries[len(flow.Entries)-1].LeftAt = &at
+		}
+		if changed {
+			flow.Entries = append(flow.Entries, ContainerEntry{ContainerID: *row.ContainerID, EnteredAt: at})
+		}
+		if row.Completed && !completed {
+			flow.CompletedAt = &at
+		}
+		if !row.Completed {
+			flow.CompletedAt = nil
+		}
+		current = row.ContainerID
+		completed = row.Completed
+	}
+	return flow
+}
+
+// cycleTime is the time from the task's first entry into startID, or from
+// first leaving its initial container when startID is 0, to its first entry
+// into endID after that, or to its completion when endID is 0.
+func (f TaskFlow) cycleTime(startID, endID int) (time.Duration, bool) {
+	var start, end *time.Time
+	for i, entry := range f.Entries {
+		entry := entry
+		if start == nil && (startID == 0 && i > 0 || startID != 0 && entry.ContainerID == startID) {
+			start = &entry.EnteredAt
+			if endID == 0 || entry.ContainerID != endID {
+				continue
+			}
+		}
+		if start != nil && endID != 0 && entry.ContainerID == endID {
+			end = &entry.EnteredAt
+			break
+		}
+	}
+	if endID == 0 {
+		end = f.CompletedAt
+	}
+	if start == nil || end == nil || end.Before(*start) {
+		return 0, false
+	}
+	return end.Sub(*start), true
+}
+
+func durationStats(values []float64) DurationStats {
+	stats := DurationStats{Count: len(values)}
+	if len(values) == 0 {
+		return stats
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	sum := 0.0
+	for _, v := range sorted {
+		sum += v
+	}
+	percentile := func(p float64) float64 {
+		return sorted[int(math.Ceil(p/100*float64(len(sorted))))-1]
+	}
+	stats.Mean = math.Round(sum/float64(len(sorted))*100) / 100
+	stats.P50 = percentile(50)
+	stats.P85 = percentile(85)
+	stats.P95 = percentile(95)
+	stats.Max = sorted[len(sorted)-1]
+	return stats
+}
+
+func hours(d time.Duration) float64 {
+	return math.Round(d.Hours()*100) / 100
+}
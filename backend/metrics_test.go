@@ -0,0 +1,154 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReplayHistory(t *testing.T) {
+	base := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	at := func(hours int) time.Time { return base.Add(time.Duration(hours) * time.Hour) }
+	ptr := func(hours int) *time.Time { t := at(hours); return &t }
+	in := func(containerID int) *int { return &containerID }
+	row := func(containerID *int, completed bool, hours int) historyRow {
+		return historyRow{TaskID: 7, ContainerID: containerID, Completed: completed, RecordedAt: at(hours)}
+	}
+
+	tests := []struct {
+		name string
+		rows []historyRow
+		want TaskFlow
+	}{
+		{
+			"moved and completed",
+			[]historyRow{row(in(1), false, 0), row(in(2), false, 5), row(in(2), true, 8)},
+			TaskFlow{TaskID: 7, CreatedAt: at(0), CompletedAt: ptr(8), Entries: []ContainerEntry{
+				{ContainerID: 1, EnteredAt: at(0), LeftAt: ptr(5)},
+				{ContainerID: 2, EnteredAt: at(5)},
+			}},
+		},
+		{
+			"completed while moving on",
+			[]historyRow{row(in(1), true, 0), row(in(2), true, 3)},
+			TaskFlow{TaskID: 7, CreatedAt: at(0), CompletedAt: ptr(0), Entries: []ContainerEntry{
+				{ContainerID: 1, EnteredAt: at(0), LeftAt: ptr(3)},
+				{ContainerID: 2, EnteredAt: at(3)},
+			}},
+		},
+		{
+			"reopened and completed again",
+			[]historyRow{row(in(1), false, 0), row(in(1), true, 2), row(in(1), false, 4), row(in(1), true, 6)},
+			TaskFlow{TaskID: 7, CreatedAt: at(0), CompletedAt: ptr(6), Entries: []ContainerEntry{
+				{ContainerID: 1, EnteredAt: at(0)},
+			}},
+		},
+		{
+			"reopened",
+			[]historyRow{row(in(1), true, 0), row(in(1), false, 4)},
+			TaskFlow{TaskID: 7, CreatedAt: at(0), Entries: []ContainerEntry{
+				{ContainerID: 1, EnteredAt: at(0)},
+			}},
+		},
+		{
+			"archived and restored",
+			[]historyRow{row(in(1), false, 0), row(nil, false, 2), row(in(1), false, 5)},
+			TaskFlow{TaskID: 7, CreatedAt: at(0), Entries: []ContainerEntry{
+				{ContainerID: 1, EnteredAt: at(0), LeftAt: ptr(2)},
+				{ContainerID: 1, EnteredAt: at(5)},
+			}},
+		},
+		{
+			"recorded while archived",
+			[]historyRow{row(nil, false, 0), row(in(3), false, 4)},
+			TaskFlow{TaskID: 7, CreatedAt: at(0), Entries: []ContainerEntry{
+				{ContainerID: 3, EnteredAt: at(4)},
+			}},
+		},
+		{
+			"never in a container",
+			[]historyRow{row(nil, true, 1)},
+			TaskFlow{TaskID: 7, CreatedAt: at(1), CompletedAt: ptr(1), Entries: []ContainerEntry{}},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := replayHistory(tt.rows); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: replayHistory = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCycleTime(t *testing.T) {
+	base := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	at := func(hours int) time.Time { return base.Add(time.Duration(hours) * time.Hour) }
+	completed := at(30)
+
+	// Backlog (1), Doing (2), Review (3) and Done (4), then completed.
+	flow := TaskFlow{TaskID: 7, CreatedAt: at(0), CompletedAt: &completed, Entries: []ContainerEntry{
+		{ContainerID: 1, EnteredAt: at(0)},
+		{ContainerID: 2, EnteredAt: at(4)},
+		{ContainerID: 3, EnteredAt: at(10)},
+		{ContainerID: 4, EnteredAt: at(20)},
+	}}
+	open := flow
+	open.CompletedAt = nil
+	backwards := TaskFlow{TaskID: 7, CreatedAt: at(0), CompletedAt: &completed, Entries: []ContainerEntry{
+		{ContainerID: 4, EnteredAt: at(0)},
+		{ContainerID: 2, EnteredAt: at(4)},
+	}}
+	unmoved := TaskFlow{TaskID: 7, CreatedAt: at(0), CompletedAt: &completed, Entries: []ContainerEntry{
+		{ContainerID: 1, EnteredAt: at(0)},
+	}}
+
+	tests := []struct {
+		name           string
+		flow           TaskFlow
+		startID, endID int
+		want           time.Duration
+		ok             bool
+	}{
+		{"leaving the first container to completion", flow, 0, 0, 26 * time.Hour, true},
+		{"between two containers", flow, 2, 4, 16 * time.Hour, true},
+		{"from a container to completion", flow, 3, 0, 20 * time.Hour, true},
+		{"leaving the first container to a container", flow, 0, 3, 6 * time.Hour, true},
+		{"start and end the same", flow, 2, 2, 0, true},
+		{"never started", flow, 5, 0, 0, false},
+		{"never ended", flow, 2, 5, 0, false},
+		{"not completed", open, 2, 0, 0, false},
+		{"end only before start", backwards, 2, 4, 0, false},
+		{"never left the first container", unmoved, 0, 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := tt.flow.cycleTime(tt.startID, tt.endID)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%s: cycleTime(%d, %d) = %v, %v; want %v, %v", tt.name, tt.startID, tt.endID, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestDurationStats(t *testing.T) {
+	var oneToTwenty []float64
+	for i := 1; i <= 20; i++ {
+		oneToTwenty = append(oneToTwenty, float64(i))
+	}
+
+	tests := []struct {
+		name   string
+		values []float64
+		want   DurationStats
+	}{
+		{"none", nil, DurationStats{}},
+		{"one", []float64{5}, DurationStats{Count: 1, Mean: 5, P50: 5, P85: 5, P95: 5, Max: 5}},
+		{"unsorted", []float64{3, 1, 2}, DurationStats{Count: 3, Mean: 2, P50: 2, P85: 3, P95: 3, Max: 3}},
+		{"rounded mean", []float64{1, 2, 2}, DurationStats{Count: 3, Mean: 1.67, P50: 2, P85: 2, P95: 2, Max: 2}},
+		{"nearest rank", oneToTwenty, DurationStats{Count: 20, Mean: 10.5, P50: 10, P85: 17, P95: 19, Max: 20}},
+	}
+
+	for _, tt := range tests {
+		if got := durationStats(tt.values); got != tt.want {
+			t.Errorf("%s: durationStats = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
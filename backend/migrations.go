@@ -0,0 +1,280 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// migrations are applied in order on every start. Each statement must be
+// idempotent so that existing databases are left untouched.
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS users (
+		id SERIAL PRIMARY KEY,
+		username TEXT NOT NULL UNIQUE,
+		email TEXT NOT NULL,
+		password TEXT NOT NULL,
+		background TEXT NOT NULL DEFAULT 'img-3.jpg'
+	)`,
+	`CREATE TABLE IF NOT EXISTS boards (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id),
+		title TEXT NOT NULL,
+		background TEXT NOT NULL DEFAULT ''
+	)`,
+	`CREATE TABLE IF NOT EXISTS containers (
+		id SERIAL PRIMARY KEY,
+		board_id INTEGER NOT NULL REFERENCES boards(id),
+		title TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS tasks (
+		id SERIAL PRIMARY KEY,
+		container_id INTEGER NOT NULL REFERENCES containers(id),
+		title TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		completed BOOLEAN NOT NULL DEFAULT FALSE
+	)`,
+
+	`CREATE TABLE IF NOT EXISTS webhooks (
+		id SERIAL PRIMARY KEY,
+		board_id INTEGER NOT NULL REFERENCES boards(id) ON DELETE CASCADE,
+		url TEXT NOT NULL,
+		events TEXT[] NOT NULL,
+		secret TEXT NOT NULL,
+		active BOOLEAN NOT NULL DEFAULT TRUE,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id SERIAL PRIMARY KEY,
+		webhook_id INTEGER NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+		event TEXT NOT NULL,
+		payload JSONB NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		last_status_code INTEGER,
+		last_error TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		delivered_at TIMESTAMPTZ
+	)`,
+	`CREATE INDEX IF NOT EXISTS webhook_deliveries_pending_idx
+		ON webhook_deliveries (next_attempt_at) WHERE status = 'pending'`,
+
+	`ALTER TABLE tasks ADD COLUMN IF NOT EXISTS labels TEXT[] NOT NULL DEFAULT '{}'`,
+	`ALTER TABLE tasks ADD COLUMN IF NOT EXISTS assignee_id INTEGER REFERENCES users(id) ON DELETE SET NULL`,
+	`ALTER TABLE tasks ADD COLUMN IF NOT EXISTS due_at TIMESTAMPTZ`,
+	`CREATE TABLE IF NOT EXISTS task_comments (
+		id SERIAL PRIMARY KEY,
+		task_id INTEGER NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+		user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+		rule_id INTEGER,
+		body TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE TABLE IF NOT EXISTS automation_rules (
+		id SERIAL PRIMARY KEY,
+		board_id INTEGER NOT NULL REFERENCES boards(id) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		trigger JSONB NOT NULL,
+		conditions JSONB NOT NULL DEFAULT '[]',
+		actions JSONB NOT NULL,
+		enabled BOOLEAN NOT NULL DEFAULT TRUE,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE TABLE IF NOT EXISTS automation_runs (
+		id SERIAL PRIMARY KEY,
+		rule_id INTEGER NOT NULL REFERENCES automation_rules(id) ON DELETE CASCADE,
+		task_id INTEGER REFERENCES tasks(id) ON DELETE SET NULL,
+		event TEXT NOT NULL,
+		status TEXT NOT NULL,
+		message TEXT NOT NULL DEFAULT '',
+		depth INTEGER NOT NULL DEFAULT 0,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE INDEX IF NOT EXISTS automation_runs_rule_idx ON automation_runs (rule_id, task_id, event)`,
+	`CREATE TABLE IF NOT EXISTS task_recurrences (
+		id SERIAL PRIMARY KEY,
+		task_id INTEGER NOT NULL UNIQUE REFERENCES tasks(id) ON DELETE CASCADE,
+		container_id INTEGER NOT NULL REFERENCES containers(id) ON DELETE CASCADE,
+		frequency TEXT NOT NULL,
+		interval_count INTEGER NOT NULL DEFAULT 1,
+		weekdays INTEGER[] NOT NULL DEFAULT '{}',
+		month_day INTEGER NOT NULL DEFAULT 0,
+		time_of_day TEXT NOT NULL DEFAULT '09:00',
+		timezone TEXT NOT NULL DEFAULT 'UTC',
+		starts_on TEXT NOT NULL,
+		current_task_id INTEGER REFERENCES tasks(id) ON DELETE SET NULL,
+		next_run_at TIMESTAMPTZ,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE INDEX IF NOT EXISTS task_recurrences_next_run_idx ON task_recurrences (next_run_at) WHERE next_run_at IS NOT NULL`,
+	`CREATE TABLE IF NOT EXISTS task_links (
+		id SERIAL PRIMARY KEY,
+		task_id INTEGER NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+		other_task_id INTEGER NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+		kind TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		UNIQUE (task_id, other_task_id, kind)
+	)`,
+	`CREATE INDEX IF NOT EXISTS task_links_other_idx ON task_links (other_task_id)`,
+	`ALTER TABLE boards ADD COLUMN IF NOT EXISTS enforce_blockers BOOLEAN NOT NULL DEFAULT FALSE`,
+	`CREATE TABLE IF NOT EXISTS time_entries (
+		id SERIAL PRIMARY KEY,
+		task_id INTEGER NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		started_at TIMESTAMPTZ NOT NULL,
+		ended_at TIMESTAMPTZ,
+		note TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS time_entries_running_idx ON time_entries (user_id) WHERE ended_at IS NULL`,
+	`CREATE INDEX IF NOT EXISTS time_entries_task_idx ON time_entries (task_id, started_at)`,
+	`ALTER TABLE containers ADD COLUMN IF NOT EXISTS wip_limit INTEGER`,
+	`ALTER TABLE containers ADD COLUMN IF NOT EXISTS wip_mode TEXT NOT NULL DEFAULT 'warn'`,
+	`CREATE TABLE IF NOT EXISTS swimlanes (
+		id SERIAL PRIMARY KEY,
+		board_id INTEGER NOT NULL REFERENCES boards(id) ON DELETE CASCADE,
+		title TEXT NOT NULL,
+		position INTEGER NOT NULL DEFAULT 0,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`ALTER TABLE tasks ADD COLUMN IF NOT EXISTS swimlane_id INTEGER REFERENCES swimlanes(id) ON DELETE SET NULL`,
+	`ALTER TABLE boards ADD COLUMN IF NOT EXISTS labels TEXT[] NOT NULL DEFAULT '{}'`,
+	`ALTER TABLE tasks ADD COLUMN IF NOT EXISTS checklist JSONB NOT NULL DEFAULT '[]'`,
+	`CREATE TABLE IF NOT EXISTS board_templates (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		content JSONB NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE TABLE IF NOT EXISTS task_templates (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		title TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		labels TEXT[] NOT NULL DEFAULT '{}',
+		checklist JSONB NOT NULL DEFAULT '[]',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`ALTER TABLE tasks ADD COLUMN IF NOT EXISTS archived_at TIMESTAMPTZ`,
+	`ALTER TABLE boards ADD COLUMN IF NOT EXISTS archived_at TIMESTAMPTZ`,
+	`ALTER TABLE containers ADD COLUMN IF NOT EXISTS archived_at TIMESTAMPTZ`,
+	`CREATE TABLE IF NOT EXISTS custom_fields (
+		id SERIAL PRIMARY KEY,
+		board_id INTEGER NOT NULL REFERENCES boards(id) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		type TEXT NOT NULL,
+		options TEXT[] NOT NULL DEFAULT '{}',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		UNIQUE (board_id, name)
+	)`,
+	`ALTER TABLE tasks ADD COLUMN IF NOT EXISTS custom_fields JSONB NOT NULL DEFAULT '{}'`,
+	`ALTER TABLE tasks ADD COLUMN IF NOT EXISTS priority TEXT NOT NULL DEFAULT 'none'`,
+	`ALTER TABLE tasks ADD COLUMN IF NOT EXISTS estimate DOUBLE PRECISION`,
+	`ALTER TABLE containers ADD COLUMN IF NOT EXISTS sort_mode TEXT NOT NULL DEFAULT 'manual'`,
+	`CREATE TABLE IF NOT EXISTS sprints (
+		id SERIAL PRIMARY KEY,
+		board_id INTEGER NOT NULL REFERENCES boards(id) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		goal TEXT NOT NULL DEFAULT '',
+		starts_on TEXT NOT NULL DEFAULT '',
+		ends_on TEXT NOT NULL DEFAULT '',
+		state TEXT NOT NULL DEFAULT 'planned',
+		started_at TIMESTAMPTZ,
+		completed_at TIMESTAMPTZ,
+		carried_over_to INTEGER REFERENCES sprints(id) ON DELETE SET NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS sprints_one_active ON sprints (board_id) WHERE state = 'active'`,
+	`ALTER TABLE tasks ADD COLUMN IF NOT EXISTS sprint_id INTEGER REFERENCES sprints(id) ON DELETE SET NULL`,
+	`CREATE TABLE IF NOT EXISTS sprint_tasks (
+		sprint_id INTEGER NOT NULL REFERENCES sprints(id) ON DELETE CASCADE,
+		task_id INTEGER NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+		committed BOOLEAN NOT NULL DEFAULT false,
+		committed_estimate DOUBLE PRECISION,
+		in_sprint BOOLEAN NOT NULL DEFAULT false,
+		completed BOOLEAN NOT NULL DEFAULT false,
+		estimate DOUBLE PRECISION,
+		PRIMARY KEY (sprint_id, task_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS task_history (
+		id BIGSERIAL PRIMARY KEY,
+		task_id INTEGER NOT NULL,
+		board_id INTEGER NOT NULL REFERENCES boards(id) ON DELETE CASCADE,
+		container_id INTEGER,
+		completed BOOLEAN NOT NULL,
+		estimate DOUBLE PRECISION,
+		sprint_id INTEGER,
+		recorded_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE INDEX IF NOT EXISTS task_history_task_idx ON task_history (task_id, recorded_at)`,
+	`CREATE INDEX IF NOT EXISTS task_history_board_idx ON task_history (board_id)`,
+	`CREATE TABLE IF NOT EXISTS calendar_tokens (
+		user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+		token TEXT NOT NULL UNIQUE,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`ALTER TABLE tasks ADD COLUMN IF NOT EXISTS starts_at TIMESTAMPTZ`,
+	`CREATE TABLE IF NOT EXISTS saved_filters (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		query TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		UNIQUE (user_id, name)
+	)`,
+	`CREATE TABLE IF NOT EXISTS notifications (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		type TEXT NOT NULL,
+		task_id INTEGER,
+		board_id INTEGER,
+		actor_id INTEGER,
+		title TEXT NOT NULL,
+		body TEXT NOT NULL DEFAULT '',
+		read_at TIMESTAMPTZ,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE INDEX IF NOT EXISTS notifications_user_idx ON notifications (user_id, read_at)`,
+	`CREATE TABLE IF NOT EXISTS notification_preferences (
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		type TEXT NOT NULL,
+		channel TEXT NOT NULL,
+		enabled BOOLEAN NOT NULL,
+		PRIMARY KEY (user_id, type, channel)
+	)`,
+	`CREATE TABLE IF NOT EXISTS task_watchers (
+		task_id INTEGER NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		PRIMARY KEY (task_id, user_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS due_reminders (
+		task_id INTEGER NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+		due_at TIMESTAMPTZ NOT NULL,
+		PRIMARY KEY (task_id, due_at)
+	)`,
+	`CREATE TABLE IF NOT EXISTS email_outbox (
+		id SERIAL PRIMARY KEY,
+		to_address TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		body TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT '',
+		next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		sent_at TIMESTAMPTZ,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE INDEX IF NOT EXISTS email_outbox_pending_idx ON email_outbox (next_attempt_at) WHERE sent_at IS NULL`,
+}
+
+func migrate(db *sqlx.DB) error {
+	for i, stmt := range migrations {
+		if _, err := db.Exec(stmt); err != nil {
+			return fmt.Errorf("migration %d: %w", i, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,686 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const (
+	NotifyAssigned    = "assigned"
+	NotifyMentioned   = "mentioned"
+	NotifyTaskChanged = "task_changed"
+	NotifyDueSoon     = "due_soon"
+)
+
+const (
+	ChannelInApp = "in_app"
+	ChannelEmail = "email"
+)
+
+var notificationTypes = []string{NotifyAssigned, NotifyMentioned, NotifyTaskChanged, NotifyDueSoon}
+
+// dueSoonWindow is how long before a task is due its reminder goes out.
+const dueSoonWindow = 24 * time.Hour
+
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@])@([A-Za-z0-9_.-]+)`)
+
+// Notification tells a user about something that happened to a task. ActorID
+// is the user who caused it, and nil for reminders.
+type Notification struct {
+	ID        int        `json:"id" db:"id"`
+	UserID    int        `json:"user_id" db:"user_id"`
+	Type      string     `json:"type" db:"type"`
+	TaskID    *int       `json:"task_id" db:"task_id"`
+	BoardID   *int       `json:"board_id" db:"board_id"`
+	ActorID   *int       `json:"actor_id" db:"actor_id"`
+	Title     string     `json:"title" db:"title"`
+	Body      string     `json:"body" db:"body"`
+	ReadAt    *time.Time `json:"read_at" db:"read_at"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+}
+
+type NotificationPage struct {
+	Items  []Notification `json:"items"`
+	Total  int            `json:"total"`
+	Unread int            `json:"unread"`
+	Limit  int            `json:"limit"`
+	Offset int            `json:"offset"`
+}
+
+type UnreadCount struct {
+	Unread int `json:"unread"`
+}
+
+// NotificationPreference turns one type of notification on or off for one
+// channel. Without a stored preference in-app notifications are on and the
+// other channels off.
+type NotificationPreference struct {
+	Type    string `json:"type" db:"type" validate:"required,oneof=assigned|mentioned|task_changed|due_soon"`
+	Channel string `json:"channel" db:"channel" validate:"required,oneof=in_app|email"`
+	Enabled bool   `json:"enabled" db:"enabled"`
+}
+
+// Recipient is the user a notification is delivered to.
+type Recipient struct {
+	ID       int    `db:"id"`
+	Username string `db:"username"`
+	Email    string `db:"email"`
+}
+
+// NotificationChannel delivers notifications by one means. Deliver runs on
+// the goroutine that caused the notification, so channels that talk to the
+// network should hand the work to a background worker.
+type NotificationChannel interface {
+	Name() string
+	Deliver(n *Notification, to Recipient) error
+}
+
+// Notifier turns board events and upcoming due dates into notifications and
+// hands them to each channel the recipient has enabled.
+type Notifier struct {
+	db           *sqlx.DB
+	tm           *TaskManager
+	channels     []NotificationChannel
+	pollInterval time.Duration
+}
+
+func NewNotifier(db *sqlx.DB, tm *TaskManager, channels ...NotificationChannel) *Notifier {
+	return &Notifier{db: db, tm: tm, channels: channels, pollInterval: time.Minute}
+}
+
+func (n *Notifier) routes() []route {
+	return []route{
+		{name: "listNotifications", method: "GET", path: "/notifications", handler: n.GetNotificationsHandler, auth: true, tag: "notifications",
+			summary: "List the caller's in-app notifications, newest first", response: NotificationPage{},
+			query: []queryParam{
+				{name: "unread", description: "only list unread notifications", typ: "boolean"},
+				{name: "limit", description: "page size, at most 200", typ: "integer"},
+				{name: "offset", description: "number of notifications to skip", typ: "integer"},
+			}},
+		{name: "getUnreadCount", method: "GET", path: "/notifications/unread-count", handler: n.GetUnreadCountHandler, auth: true, tag: "notifications",
+			summary: "Count the caller's unread notifications", response: UnreadCount{}},
+		{name: "markNotificationRead", method: "POST", path: "/notifications/{id}/read", handler: n.MarkReadHandler, auth: true, tag: "notifications",
+			summary: "Mark a notification as read", response: Notification{}},
+		{name: "markAllNotificationsRead", method: "POST", path: "/notifications/read-all", handler: n.MarkAllReadHandler, auth: true, tag: "notifications",
+			summary: "Mark all of the caller's notifications as read", response: UnreadCount{}},
+		{name: "getNotificationPreferences", method: "GET", path: "/notifications/preferences", handler: n.GetPreferencesHandler, auth: true, tag: "notifications",
+			summary: "List which notifications the caller gets on which channel", response: []NotificationPreference{}},
+		{name: "updateNotificationPreferences", method: "PUT", path: "/notifications/preferences", handler: n.UpdatePreferencesHandler, auth: true, tag: "notifications",
+			summary: "Turn notification types on or off per channel", request: []NotificationPreference{}, response: []NotificationPreference{}},
+		{name: "watchTask", method: "POST", path: "/tasks/{id}/watch", handler: n.WatchTaskHandler, auth: true, tag: "notifications",
+			summary: "Get notified when a task changes"},
+		{name: "unwatchTask", method: "DELETE", path: "/tasks/{id}/watch", handler: n.UnwatchTaskHandler, auth: true, tag: "notifications",
+			summary: "Stop getting notified when a task changes"},
+	}
+}
+
+// HandleEvent notifies assignees of their new tasks, users mentioned in
+// comments, and watchers of changes to their watched tasks. Nobody is
+// notified of their own actions, or of tasks on boards they cannot see.
+func (n *Notifier) HandleEvent(event Event) {
+	var err error
+	switch data := event.Data.(type) {
+	case Task:
+		switch event.Type {
+		case EventTaskAssigned:
+			err = n.notify(*data.AssigneeID, event, NotifyAssigned, data.ID,
+				"You were assigned "+data.Title, "")
+		case EventTaskUpdated:
+			err = n.notifyWatchers(event, data, "updated", "")
+		}
+	case TaskMove:
+		err = n.notifyWatchers(event, data.Task, "moved", "")
+	case TaskComment:
+		var task Task
+		task, err = n.tm.getTask(strconv.Itoa(data.TaskID))
+		if err == nil {
+			err = n.notifyMentions(event, task, data.Body)
+		}
+		if err == nil {
+			err = n.notifyWatchers(event, task, "commented on", data.Body)
+		}
+	}
+	if err != nil {
+		log.Printf("notifications: handling %s on board %d: %v", event.Type, event.BoardID, err)
+	}
+}
+
+func (n *Notifier) notifyWatchers(event Event, task Task, verb, body string) error {
+	var watchers []int
+	err := n.db.Select(&watchers, "SELECT user_id FROM task_watchers WHERE task_id = $1", task.ID)
+	if err != nil {
+		return err
+	}
+	for _, userID := range watchers {
+		err = n.notify(userID, event, NotifyTaskChanged, task.ID, fmt.Sprintf("%s %s %s", n.actorName(event.UserID), verb, task.Title), body)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *Notifier) notifyMentions(event Event, task Task, body string) error {
+	var names []string
+	for _, m := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		names = append(names, strings.ToLower(m[1]))
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	var mentioned []int
+	err := n.db.Select(&mentioned, "SELECT id FROM users WHERE lower(username) = ANY($1)", pq.StringArray(names))
+	if err != nil {
+		return err
+	}
+	for _, userID := range mentioned {
+		err = n.notify(userID, event, NotifyMentioned, task.ID, n.actorName(event.UserID)+" mentioned you on "+task.Title, body)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notify delivers a notification caused by event to userID, unless userID
+// caused it or does not own the board it happened on. Boards are private to
+// their owner, so anyone else is ignored rather than told about its tasks.
+func (n *Notifier) notify(userID int, event Event, kind string, taskID int, title, body string) error {
+	if userID == event.UserID {
+		return nil
+	}
+	var ownerID int
+	err := n.db.Get(&ownerID, "SELECT user_id FROM boards WHERE id = $1", event.BoardID)
+	if err == sql.ErrNoRows || err == nil && ownerID != userID {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	note := Notification{UserID: userID, Type: kind, TaskID: &taskID, Title: title, Body: body}
+	if event.BoardID != 0 {
+		note.BoardID = &event.BoardID
+	}
+	if event.UserID != 0 {
+		note.ActorID = &event.UserID
+	}
+	return n.deliver(&note)
+}
+
+// deliver hands a notification to each channel its recipient enabled for its
+// type. A failing channel does not keep the others from delivering.
+func (n *Notifier) deliver(note *Notification) error {
+	var to Recipient
+	err := n.db.Get(&to, "SELECT id, username, email FROM users WHERE id = $1", note.UserID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	enabled, err := n.preferences(note.UserID)
+	if err != nil {
+		return err
+	}
+	for _, channel := range n.channels {
+		if !enabled[note.Type+"/"+channel.Name()] {
+			continue
+		}
+		if err := channel.Deliver(note, to); err != nil {
+			log.Printf("notifications: delivering %s to user %d by %s: %v", note.Type, note.UserID, channel.Name(), err)
+		}
+	}
+	return nil
+}
+
+// preferences returns whether each "type/channel" is enabled for userID.
+func (n *Notifier) preferences(userID int) (map[string]bool, error) {
+	enabled := map[string]bool{}
+	for _, t := range notificationTypes {
+		enabled[t+"/"+ChannelInApp] = true
+		enabled[t+"/"+ChannelEmail] = false
+	}
+	var stored []NotificationPreference
+	err := n.db.Select(&stored, "SELECT type, channel, enabled FROM notification_preferences WHERE user_id = $1", userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range stored {
+		enabled[p.Type+"/"+p.Channel] = p.Enabled
+	}
+	return enabled, nil
+}
+
+func (n *Notifier) actorName(userID int) string {
+	var name string
+	err := n.db.Get(&name, "SELECT username FROM users WHERE id = $1", userID)
+	if err != nil {
+		return "An automation"
+	}
+	return name
+}
+
+// Run sends due reminders until ctx is cancelled.
+func (n *Notifier) Run(ctx context.Context) {
+	ticker := time.NewTicker(n.pollInterval)
+	defer ticker.Stop()
+	for {
+		if err := n.remindDue(ctx); err != nil {
+			log.Printf("notifications: sending due reminders: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// remindDue notifies the board owner of each open task due within
+// dueSoonWindow that is unassigned or assigned to them. Tasks assigned to
+// anyone else are skipped, since only the owner can see the board. Each due
+// date is reminded of once.
+func (n *Notifier) remindDue(ctx context.Context) error {
+	var due []struct {
+		TaskID  int       `db:"task_id"`
+		BoardID int       `db:"board_id"`
+		UserID  int       `db:"user_id"`
+		Title   string    `db:"title"`
+		DueAt   time.Time `db:"due_at"`
+	}
+	err := n.db.SelectContext(ctx, &due, `
+		WITH due AS (
+			SELECT t.id AS task_id, t.due_at, c.board_id, b.user_id, t.title
+			FROM tasks t JOIN containers c ON c.id = t.container_id JOIN boards b ON b.id = c.board_id
+			WHERE NOT t.completed AND t.archived_at IS NULL AND c.archived_at IS NULL AND b.archived_at IS NULL
+			AND t.due_at > now() AND t.due_at <= now() + make_interval(secs => $1)
+			AND (t.assignee_id IS NULL OR t.assignee_id = b.user_id)
+		), claimed AS (
+			INSERT INTO due_reminders (task_id, due_at) SELECT task_id, due_at FROM due
+			ON CONFLICT DO NOTHING
+			RETURNING task_id, due_at
+		)
+		SELECT due.* FROM due JOIN claimed USING (task_id, due_at)
+	`, dueSoonWindow.Seconds())
+	if err != nil {
+		return err
+	}
+
+	for _, d := range due {
+		taskID, boardID := d.TaskID, d.BoardID
+		note := Notification{UserID: d.UserID, Type: NotifyDueSoon, TaskID: &taskID, BoardID: &boardID,
+			Title: d.Title + " is due " + d.DueAt.UTC().Format("Jan 2 15:04 UTC")}
+		if err := n.deliver(&note); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *Notifier) GetNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+	query := r.URL.Query()
+
+	var fieldErrs []FieldError
+	unread, err := strconv.ParseBool(query.Get("unread"))
+	if err != nil && query.Get("unread") != "" {
+		fieldErrs = append(fieldErrs, FieldError{Field: "unread", Message: "must be true or false"})
+	}
+	limit, ok := queryInt(query.Get("limit"), 50, 1, 200)
+	if !ok {
+		fieldErrs = append(fieldErrs, FieldError{Field: "limit", Message: "must be between 1 and 200"})
+	}
+	offset, ok := queryInt(query.Get("offset"), 0, 0, 1<<31-1)
+	if !ok {
+		fieldErrs = append(fieldErrs, FieldError{Field: "offset", Message: "must not be negative"})
+	}
+	if len(fieldErrs) > 0 {
+		writeError(w, r, validationError(fieldErrs, "invalid query"))
+		return
+	}
+
+	page := NotificationPage{Items: []Notification{}, Limit: limit, Offset: offset}
+	err = n.db.QueryRow(`
+		SELECT COUNT(*) FILTER (WHERE NOT $2 OR read_at IS NULL), COUNT(*) FILTER (WHERE read_at IS NULL)
+		FROM notifications WHERE user_id = $1
+	`, userID, unread).Scan(&page.Total, &page.Unread)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	err = n.db.Select(&page.Items, `
+		SELECT * FROM notifications WHERE user_id = $1 AND (NOT $2 OR read_at IS NULL)
+		ORDER BY created_at DESC, id DESC LIMIT $3 OFFSET $4
+	`, userID, unread, limit, offset)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+func (n *Notifier) GetUnreadCountHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	var count UnreadCount
+	err := n.db.Get(&count.Unread, "SELECT COUNT(*) FROM notifications WHERE user_id = $1 AND read_at IS NULL", userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(count)
+}
+
+func (n *Notifier) MarkReadHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+	notificationID := mux.Vars(r)["id"]
+
+	var note Notification
+	err := n.db.Get(&note, `
+		UPDATE notifications SET read_at = COALESCE(read_at, now())
+		WHERE id = $1 AND user_id = $2 RETURNING *
+	`, notificationID, userID)
+	if err == sql.ErrNoRows {
+		writeError(w, r, notFoundError("notification %s not found", notificationID))
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(note)
+}
+
+func (n *Notifier) MarkAllReadHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	_, err := n.db.Exec("UPDATE notifications SET read_at = now() WHERE user_id = $1 AND read_at IS NULL", userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(UnreadCount{})
+}
+
+func (n *Notifier) GetPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	n.writePreferences(w, r, userID)
+}
+
+// UpdatePreferencesHandler stores the given preferences, leaving the ones
+// not mentioned as they are.
+func (n *Notifier) UpdatePreferencesHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	var prefs []NotificationPreference
+	err := decodeJSON(w, r, &prefs)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	tx, err := n.db.Beginx()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	for _, p := range prefs {
+		_, err = tx.Exec(`
+			INSERT INTO notification_preferences (user_id, type, channel, enabled) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (user_id, type, channel) DO UPDATE SET enabled = EXCLUDED.enabled
+		`, userID, p.Type, p.Channel, p.Enabled)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	n.writePreferences(w, r, userID)
+}
+
+func (n *Notifier) writePreferences(w http.ResponseWriter, r *http.Request, userID int) {
+	enabled, err := n.preferences(userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	prefs := []NotificationPreference{}
+	for _, t := range notificationTypes {
+		for _, channel := range []string{ChannelInApp, ChannelEmail} {
+			prefs = append(prefs, NotificationPreference{Type: t, Channel: channel, Enabled: enabled[t+"/"+channel]})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(prefs)
+}
+
+func (n *Notifier) WatchTaskHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	task, err := n.tm.checkTaskAccess(userID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	_, err = n.db.Exec("INSERT INTO task_watchers (task_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", task.ID, userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (n *Notifier) UnwatchTaskHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	task, err := n.tm.checkTaskAccess(userID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	_, err = n.db.Exec("DELETE FROM task_watchers WHERE task_id = $1 AND user_id = $2", task.ID, userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// InAppChannel stores notifications for the notification center.
+type InAppChannel struct {
+	db *sqlx.DB
+}
+
+func NewInAppChannel(db *sqlx.DB) *InAppChannel {
+	return &InAppChannel{db: db}
+}
+
+func (c *InAppChannel) Name() string { return ChannelInApp }
+
+func (c *InAppChannel) Deliver(n *Notification, to Recipient) error {
+	return c.db.Get(n, `
+		INSERT INTO notifications (user_id, type, task_id, board_id, actor_id, title, body)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING *
+	`, n.UserID, n.Type, n.TaskID, n.BoardID, n.ActorID, n.Title, n.Body)
+}
+
+// Mail is an email message to one recipient.
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends email. Deployments plug in an SMTP or API backed mailer;
+// LogMailer and MemoryMailer are local sinks for development and tests.
+type Mailer interface {
+	Send(ctx context.Context, m Mail) error
+}
+
+// LogMailer writes messages to the log instead of sending them.
+type LogMailer struct{}
+
+func (LogMailer) Send(ctx context.Context, m Mail) error {
+	log.Printf("mail: to %s: %s", m.To, m.Subject)
+	return nil
+}
+
+// MemoryMailer keeps sent messages in memory.
+type MemoryMailer struct {
+	mu   sync.Mutex
+	sent []Mail
+}
+
+func (mm *MemoryMailer) Send(ctx context.Context, m Mail) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	mm.sent = append(mm.sent, m)
+	return nil
+}
+
+// Sent returns the messages sent so far.
+func (mm *MemoryMailer) Sent() []Mail {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	return append([]Mail(nil), mm.sent...)
+}
+
+// EmailChannel queues notifications as emails in an outbox and sends them
+// through its Mailer from Run, retrying failed sends with backoff.
+type EmailChannel struct {
+	db     *sqlx.DB
+	mailer Mailer
+
+	maxAttempts  int
+	baseDelay    time.Duration
+	pollInterval time.Duration
+}
+
+func NewEmailChannel(db *sqlx.DB, mailer Mailer) *EmailChannel {
+	return &EmailChannel{
+		db:           db,
+		mailer:       mailer,
+		maxAttempts:  5,
+		baseDelay:    time.Minute,
+		pollInterval: 10 * time.Second,
+	}
+}
+
+func (c *EmailChannel) Name() string { return ChannelEmail }
+
+func (c *EmailChannel) Deliver(n *Notification, to Recipient) error {
+	if to.Email == "" {
+		return nil
+	}
+	body := n.Body
+	if body != "" {
+		body += "\n\n"
+	}
+	body += "You can change which emails you get in your notification preferences."
+	_, err := c.db.Exec("INSERT INTO email_outbox (to_address, subject, body) VALUES ($1, $2, $3)",
+		to.Email, n.Title, body)
+	return err
+}
+
+// Run sends queued emails until ctx is cancelled.
+func (c *EmailChannel) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.sendPending(ctx); err != nil {
+				log.Printf("email: %v", err)
+			}
+		}
+	}
+}
+
+// sendPending claims a batch of due emails the same way webhook deliveries
+// are claimed, and gives up on an email after maxAttempts.
+func (c *EmailChannel) sendPending(ctx context.Context) error {
+	var pending []struct {
+		ID       int    `db:"id"`
+		To       string `db:"to_address"`
+		Subject  string `db:"subject"`
+		Body     string `db:"body"`
+		Attempts int    `db:"attempts"`
+	}
+	err := c.db.SelectContext(ctx, &pending, `
+		UPDATE email_outbox SET next_attempt_at = now() + interval '1 minute'
+		WHERE id IN (
+			SELECT id FROM email_outbox
+			WHERE sent_at IS NULL AND attempts < $1 AND next_attempt_at <= now()
+			ORDER BY next_attempt_at
+			LIMIT 20
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, to_address, subject, body, attempts
+	`, c.maxAttempts)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range pending {
+		sendErr := c.mailer.Send(ctx, Mail{To: m.To, Subject: m.Subject, Body: m.Body})
+		if sendErr == nil {
+			_, err = c.db.Exec("UPDATE email_outbox SET attempts = attempts + 1, last_error = '', sent_at = now() WHERE id = $1", m.ID)
+		} else {
+			delay := c.baseDelay << m.Attempts
+			_, err = c.db.Exec("UPDATE email_outbox SET attempts = attempts + 1, last_error = $1, next_attempt_at = $2 WHERE id = $3",
+				sendErr.Error(), time.Now().Add(delay), m.ID)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotificationPreferencesAndChannels(t *testing.T) {
+	ts := newTestServer(t)
+	owner := ts.signup(t, "owner")
+	other := ts.signup(t, "other")
+
+	// Assignments only by email, due reminders both ways, everything else
+	// left at the in-app default.
+	var prefs []NotificationPreference
+	ts.call(t, "PUT", "/notifications/preferences", owner.Token, []NotificationPreference{
+		{Type: NotifyAssigned, Channel: ChannelInApp, Enabled: false},
+		{Type: NotifyAssigned, Channel: ChannelEmail, Enabled: true},
+		{Type: NotifyDueSoon, Channel: ChannelEmail, Enabled: true},
+	}, &prefs)
+	if len(prefs) != len(notificationTypes)*2 {
+		t.Fatalf("got %d preferences, want %d", len(prefs), len(notificationTypes)*2)
+	}
+	for _, p := range prefs {
+		want := p.Channel == ChannelInApp
+		switch p.Type + "/" + p.Channel {
+		case NotifyAssigned + "/" + ChannelInApp:
+			want = false
+		case NotifyAssigned + "/" + ChannelEmail, NotifyDueSoon + "/" + ChannelEmail:
+			want = true
+		}
+		if p.Enabled != want {
+			t.Errorf("%s by %s enabled = %v, want %v", p.Type, p.Channel, p.Enabled, want)
+		}
+	}
+
+	var boardID, containerID, taskID int
+	if err := ts.db.Get(&boardID, "INSERT INTO boards (user_id, title) VALUES ($1, 'Home') RETURNING id", owner.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.db.Get(&containerID, "INSERT INTO containers (board_id, title) VALUES ($1, 'Todo') RETURNING id", boardID); err != nil {
+		t.Fatal(err)
+	}
+	err := ts.db.Get(&taskID, `
+		INSERT INTO tasks (container_id, title, due_at) VALUES ($1, 'Water plants', now() + interval '1 hour') RETURNING id
+	`, containerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Assigned to someone who cannot see the board, so never reminded of.
+	_, err = ts.db.Exec(`
+		INSERT INTO tasks (container_id, title, due_at, assignee_id) VALUES ($1, 'Feed cat', now() + interval '1 hour', $2)
+	`, containerID, other.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ts.db.Exec("INSERT INTO task_watchers (task_id, user_id) VALUES ($1, $2), ($1, $3)", taskID, owner.ID, other.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tm := NewTaskManager(ts.db)
+	ec := NewEmailChannel(ts.db, ts.mailer)
+	nt := NewNotifier(ts.db, tm, NewInAppChannel(ts.db), ec)
+
+	task, err := tm.getTask(strconv.Itoa(taskID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assigned := func(userID int) Task {
+		a := task
+		a.AssigneeID = &userID
+		return a
+	}
+	// Events caused by automations, so nobody is skipped as the actor.
+	nt.HandleEvent(Event{Type: EventTaskAssigned, BoardID: boardID, Data: assigned(owner.ID)})
+	nt.HandleEvent(Event{Type: EventTaskAssigned, BoardID: boardID, Data: assigned(other.ID)})
+	nt.HandleEvent(Event{Type: EventCommentCreated, BoardID: boardID, Data: TaskComment{TaskID: taskID, Body: "@owner and @other, have a look"}})
+	if err := nt.remindDue(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// Each due date is reminded of once.
+	if err := nt.remindDue(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var notes []Notification
+	if err := ts.db.Select(&notes, "SELECT * FROM notifications ORDER BY id"); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, n := range notes {
+		if n.UserID != owner.ID {
+			t.Errorf("user %d got %q, but cannot see the board", n.UserID, n.Title)
+		}
+		got = append(got, n.Type)
+	}
+	want := []string{NotifyMentioned, NotifyTaskChanged, NotifyDueSoon}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("in-app notifications = %v, want %v", got, want)
+	}
+
+	if err := ec.sendPending(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// The server's own email worker may have sent some already.
+	deadline := time.Now().Add(5 * time.Second)
+	for len(ts.mailer.Sent()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	sent := ts.mailer.Sent()
+	if len(sent) != 2 {
+		t.Fatalf("sent %d emails, want 2: %+v", len(sent), sent)
+	}
+	subjects := map[string]bool{}
+	for _, m := range sent {
+		if m.To != owner.Email {
+			t.Errorf("email %q went to %s, want %s", m.Subject, m.To, owner.Email)
+		}
+		if !strings.Contains(m.Body, "notification preferences") {
+			t.Errorf("email %q does not say how to turn it off: %q", m.Subject, m.Body)
+		}
+		subjects[strings.SplitN(m.Subject, " is due ", 2)[0]] = true
+	}
+	if !subjects["You were assigned Water plants"] || !subjects["Water plants"] {
+		t.Errorf("email subjects = %v, want the assignment and the reminder", subjects)
+	}
+
+	var unsent int
+	if err := ts.db.Get(&unsent, "SELECT COUNT(*) FROM email_outbox WHERE sent_at IS NULL"); err != nil {
+		t.Fatal(err)
+	}
+	if unsent != 0 {
+		t.Errorf("%d emails left unsent", unsent)
+	}
+}
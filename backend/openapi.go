@@ -0,0 +1,318 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed docs.html
+var docsPage []byte
+
+// route describes one API operation. The router and the OpenAPI document are
+// both built from the same table so the spec cannot drift from main.
+type route struct {
+	name     string
+	method   string
+	path     string
+	handler  http.HandlerFunc
+	auth     bool
+	tag      string
+	summary  string
+	query    []queryParam
+	request  interface{}
+	response interface{}
+	status   int
+	produces string
+}
+
+type queryParam struct {
+	name        string
+	description string
+	typ         string
+}
+
+type openAPIBuilder struct {
+	schemas map[string]interface{}
+}
+
+// buildOpenAPI renders routes as an OpenAPI 3 document. Request and response
+// schemas are derived from the Go types by reflection, honouring json and
+// validate tags.
+func buildOpenAPI(routes []route) map[string]interface{} {
+	b := &openAPIBuilder{schemas: map[string]interface{}{}}
+	b.schemaFor(reflect.TypeOf(APIError{}))
+	b.schemaFor(reflect.TypeOf(FieldError{}))
+
+	paths := map[string]map[string]interface{}{}
+	for _, rt := range routes {
+		if paths[rt.path] == nil {
+			paths[rt.path] = map[string]interface{}{}
+		}
+		paths[rt.path][strings.ToLower(rt.method)] = b.operation(rt)
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "taskapp API",
+			"version": "1.0.0",
+		},
+		"servers": []interface{}{
+			map[string]interface{}{"url": "https://localhost:8000"},
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": b.schemas,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{
+					"type":         "http",
+					"scheme":       "bearer",
+					"bearerFormat": "JWT",
+				},
+			},
+		},
+	}
+}
+
+func (b *openAPIBuilder) operation(rt route) map[string]interface{} {
+	op := map[string]interface{}{
+		"summary":     rt.summary,
+		"operationId": rt.name,
+		"tags":        []string{rt.tag},
+	}
+
+	var params []interface{}
+	for _, segment := range strings.Split(rt.path, "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			params = append(params, map[string]interface{}{
+				"name":     strings.Trim(segment, "{}"),
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": "integer"},
+			})
+		}
+	}
+	for _, q := range rt.query {
+		typ := q.typ
+		if typ == "" {
+			typ = "string"
+		}
+		params = append(params, map[string]interface{}{
+			"name":        q.name,
+			"in":          "query",
+			"description": q.description,
+			"schema":      map[string]interface{}{"type": typ},
+		})
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+
+	if rt.request != nil {
+		op["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{
+					"schema": b.schemaFor(reflect.TypeOf(rt.request)),
+				},
+			},
+		}
+	}
+
+	status := rt.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := map[string]interface{}{"description": http.StatusText(status)}
+	if rt.response != nil {
+		produces := rt.produces
+		if produces == "" {
+			produces = "application/json"
+		}
+		success["content"] = map[string]interface{}{
+			produces: map[string]interface{}{
+				"schema": b.schemaFor(reflect.TypeOf(rt.response)),
+			},
+		}
+	}
+
+	errorResponse := map[string]interface{}{
+		"description": "Error",
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{
+				"schema": map[string]interface{}{"$ref": "#/components/schemas/APIError"},
+			},
+		},
+	}
+	op["responses"] = map[string]interface{}{
+		strconv.Itoa(status): success,
+		"default":            errorResponse,
+	}
+
+	if rt.auth {
+		op["security"] = []interface{}{
+			map[string]interface{}{"bearerAuth": []string{}},
+		}
+	}
+
+	return op
+}
+
+var (
+	timeType   = reflect.TypeOf(time.Time{})
+	rawMessage = reflect.TypeOf(json.RawMessage{})
+)
+
+func (b *openAPIBuilder) schemaFor(t reflect.Type) map[string]interface{} {
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t == rawMessage:
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := b.schemaFor(t.Elem())
+		if _, isRef := schema["$ref"]; isRef {
+			return map[string]interface{}{"allOf": []interface{}{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": b.schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schemaFor(t.Elem())}
+	case reflect.Interface:
+		return map[string]interface{}{}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		ref := map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+		if _, ok := b.schemas[t.Name()]; !ok {
+			// Reserve the name before recursing so self-referencing types terminate.
+			b.schemas[t.Name()] = map[string]interface{}{}
+			b.schemas[t.Name()] = b.structSchema(t)
+		}
+		return ref
+	}
+	return map[string]interface{}{}
+}
+
+func (b *openAPIBuilder) structSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	var required []string
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		if field.Anonymous && field.Tag.Get("json") == "" {
+			embedded := b.structSchema(field.Type)
+			for name, prop := range embedded["properties"].(map[string]interface{}) {
+				properties[name] = prop
+			}
+			if req, ok := embedded["required"].([]string); ok {
+				required = append(required, req...)
+			}
+			continue
+		}
+
+		name := jsonName(field)
+		if name == "-" {
+			continue
+		}
+
+		prop := b.schemaFor(field.Type)
+		isRequired := false
+		for _, rule := range strings.Split(field.Tag.Get("validate"), ",") {
+			if rule == "required" {
+				required = append(required, name)
+				isRequired = true
+			}
+			applyRule(prop, field.Type, rule)
+		}
+		// Nil slices and maps are encoded as null.
+		kind := field.Type.Kind()
+		if (kind == reflect.Slice || kind == reflect.Map) && field.Type != rawMessage && !isRequired {
+			prop["nullable"] = true
+		}
+		properties[name] = prop
+	}
+
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func applyRule(prop map[string]interface{}, t reflect.Type, rule string) {
+	name, arg := rule, ""
+	if i := strings.Index(rule, "="); i >= 0 {
+		name, arg = rule[:i], rule[i+1:]
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	limit, _ := strconv.Atoi(arg)
+
+	switch name {
+	case "min", "max":
+		key := map[reflect.Kind]string{reflect.String: "Length", reflect.Slice: "Items"}[t.Kind()]
+		switch {
+		case key != "":
+			prop[name+key] = limit
+		case name == "min":
+			prop["minimum"] = limit
+		default:
+			prop["maximum"] = limit
+		}
+	case "email":
+		prop["format"] = "email"
+	case "oneof":
+		prop["enum"] = strings.Split(arg, "|")
+	}
+}
+
+// OpenAPIHandler serves the generated document and a bundled docs page.
+type OpenAPIHandler struct {
+	routes []route
+	once   sync.Once
+	spec   []byte
+}
+
+func NewOpenAPIHandler(routes []route) *OpenAPIHandler {
+	return &OpenAPIHandler{routes: routes}
+}
+
+func (oh *OpenAPIHandler) SpecHandler(w http.ResponseWriter, r *http.Request) {
+	oh.once.Do(func() {
+		oh.spec, _ = json.MarshalIndent(buildOpenAPI(oh.routes), "", "  ")
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(oh.spec)
+}
+
+func (oh *OpenAPIHandler) DocsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(docsPage)
+}
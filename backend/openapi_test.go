@@ -0,0 +1,511 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// contract calls routes on a test server and checks each response against
+// the operation the OpenAPI document describes for it.
+type contract struct {
+	t      *testing.T
+	ts     *testServer
+	spec   map[string]interface{}
+	routes map[string]route
+	called map[string]bool
+	token  string
+}
+
+func newContract(t *testing.T, ts *testServer) *contract {
+	// Round-trip the document through JSON so it is checked as clients see it.
+	data, err := json.Marshal(buildOpenAPI(ts.routes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var spec map[string]interface{}
+	if err := json.Unmarshal(data, &spec); err != nil {
+		t.Fatal(err)
+	}
+
+	routes := map[string]route{}
+	for _, rt := range ts.routes {
+		if _, dup := routes[rt.name]; dup {
+			t.Fatalf("duplicate route name %s", rt.name)
+		}
+		routes[rt.name] = rt
+	}
+
+	return &contract{t: t, ts: ts, spec: spec, routes: routes, called: map[string]bool{}}
+}
+
+// call requests path, which must match the path of the named route, and
+// fails the test unless the response has the documented success status,
+// content type and schema. The JSON response is decoded into out, if given.
+func (c *contract) call(name, path string, body, out interface{}) {
+	c.t.Helper()
+
+	rt := c.route(name, path)
+	status := rt.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	data := c.send(rt, path, body, status)
+	c.check(rt, strconv.Itoa(status), data)
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			c.t.Fatalf("%s: decoding response: %v", name, err)
+		}
+	}
+}
+
+// callError is like call but expects the documented error envelope with
+// status.
+func (c *contract) callError(name, path string, body interface{}, status int) {
+	c.t.Helper()
+
+	rt := c.route(name, path)
+	data := c.send(rt, path, body, status)
+	c.check(rt, "default", data)
+}
+
+func (c *contract) route(name, path string) route {
+	c.t.Helper()
+
+	rt, ok := c.routes[name]
+	if !ok {
+		c.t.Fatalf("no route named %s", name)
+	}
+	segments := strings.Split(rt.path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, "{") {
+			segments[i] = "[0-9]+"
+		} else {
+			segments[i] = regexp.QuoteMeta(segment)
+		}
+	}
+	pattern := regexp.MustCompile("^" + strings.Join(segments, "/") + "$")
+	if p := strings.SplitN(path, "?", 2)[0]; !pattern.MatchString(p) {
+		c.t.Fatalf("%s: path %s does not match %s", name, p, rt.path)
+	}
+	c.called[name] = true
+	return rt
+}
+
+func (c *contract) send(rt route, path string, body interface{}, status int) []byte {
+	c.t.Helper()
+
+	token := ""
+	if rt.auth {
+		token = c.token
+	}
+	resp := c.ts.do(c.t, rt.method, path, token, body)
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if resp.StatusCode != status {
+		c.t.Fatalf("%s %s: got %s, want %d: %s", rt.method, path, resp.Status, status, data)
+	}
+
+	want := rt.produces
+	if want == "" && (rt.response != nil || status >= 400) {
+		want = "application/json"
+	}
+	if contentType := resp.Header.Get("Content-Type"); want != "" && !strings.HasPrefix(contentType, want) {
+		c.t.Errorf("%s: Content-Type is %q, want %s", rt.name, contentType, want)
+	}
+	return data
+}
+
+// check validates data against the response of rt's operation for status.
+func (c *contract) check(rt route, status string, data []byte) {
+	c.t.Helper()
+
+	op := c.lookup("paths", rt.path, strings.ToLower(rt.method))
+	response, ok := op["responses"].(map[string]interface{})[status].(map[string]interface{})
+	if !ok {
+		c.t.Fatalf("%s: no %s response documented", rt.name, status)
+	}
+	content, ok := response["content"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	media, ok := content["application/json"].(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		c.t.Fatalf("%s: response is not JSON: %v: %s", rt.name, err, data)
+	}
+
+	for _, problem := range c.validate("$", media["schema"].(map[string]interface{}), value) {
+		c.t.Errorf("%s: %s", rt.name, problem)
+	}
+}
+
+func (c *contract) lookup(keys ...string) map[string]interface{} {
+	c.t.Helper()
+
+	node := c.spec
+	for _, key := range keys {
+		next, ok := node[key].(map[string]interface{})
+		if !ok {
+			c.t.Fatalf("OpenAPI document has no %s", strings.Join(keys, "."))
+		}
+		node = next
+	}
+	return node
+}
+
+// validate reports where value does not conform to schema. It understands
+// the subset of OpenAPI that buildOpenAPI emits.
+func (c *contract) validate(at string, schema map[string]interface{}, value interface{}) []string {
+	if ref, ok := schema["$ref"].(string); ok {
+		return c.validate(at, c.lookup("components", "schemas", strings.TrimPrefix(ref, "#/components/schemas/")), value)
+	}
+	if value == nil {
+		if schema["nullable"] == true || len(schema) == 0 {
+			return nil
+		}
+		return []string{at + " is null"}
+	}
+
+	var problems []string
+	if allOf, ok := schema["allOf"].([]interface{}); ok {
+		for _, sub := range allOf {
+			problems = append(problems, c.validate(at, sub.(map[string]interface{}), value)...)
+		}
+	}
+
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return append(problems, fmt.Sprintf("%s is %T, want object", at, value))
+		}
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				if _, ok := object[name.(string)]; !ok {
+					problems = append(problems, fmt.Sprintf("%s.%s is required", at, name))
+				}
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		additional, _ := schema["additionalProperties"].(map[string]interface{})
+		for name, v := range object {
+			switch prop, ok := properties[name].(map[string]interface{}); {
+			case ok:
+				problems = append(problems, c.validate(at+"."+name, prop, v)...)
+			case additional != nil:
+				problems = append(problems, c.validate(at+"."+name, additional, v)...)
+			default:
+				problems = append(problems, fmt.Sprintf("%s.%s is not documented", at, name))
+			}
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return append(problems, fmt.Sprintf("%s is %T, want array", at, value))
+		}
+		items := schema["items"].(map[string]interface{})
+		for i, v := range array {
+			problems = append(problems, c.validate(fmt.Sprintf("%s[%d]", at, i), items, v)...)
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return append(problems, fmt.Sprintf("%s is %T, want string", at, value))
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+				problems = append(problems, fmt.Sprintf("%s is %q, want a date-time", at, s))
+			}
+		}
+		if enum, ok := schema["enum"].([]interface{}); ok && s != "" {
+			found := false
+			for _, option := range enum {
+				found = found || option == s
+			}
+			if !found {
+				problems = append(problems, fmt.Sprintf("%s is %q, want one of %v", at, s, enum))
+			}
+		}
+	case "integer":
+		n, ok := value.(json.Number)
+		if _, err := n.Int64(); !ok || err != nil {
+			problems = append(problems, fmt.Sprintf("%s is %v, want integer", at, value))
+		}
+	case "number":
+		if _, ok := value.(json.Number); !ok {
+			problems = append(problems, fmt.Sprintf("%s is %T, want number", at, value))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			problems = append(problems, fmt.Sprintf("%s is %T, want boolean", at, value))
+		}
+	}
+	return problems
+}
+
+// TestRoutesMatchOpenAPI walks through every route the server registers and
+// checks its responses against the published OpenAPI document.
+func TestRoutesMatchOpenAPI(t *testing.T) {
+	allowLocalWebhooks(t)
+	ts := newTestServer(t)
+	c := newContract(t, ts)
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	var login LoginResponse
+	c.call("signup", "/signup", SignupData{Username: "contract", Email: "contract@example.com", Password: "correct horse battery"}, &login)
+	c.call("login", "/login", Credentials{Username: "contract", Password: "correct horse battery"}, &login)
+	c.token = login.Token
+	c.call("refreshToken", "/token/refresh", nil, &login)
+	c.token = login.Token
+
+	// Boards, containers, swimlanes and custom fields.
+	var board Board
+	c.call("createBoard", "/boards", Board{Title: "Contract"}, &board)
+	c.call("updateBoard", fmt.Sprintf("/boards/%d", board.ID), Board{Title: "Contract board", Labels: []string{"bug"}}, &board)
+	c.call("listBoards", "/boards", nil, nil)
+	c.call("getBoardSummary", fmt.Sprintf("/boards/%d/summary", board.ID), nil, nil)
+
+	var todo, done Container
+	c.call("createContainer", fmt.Sprintf("/boards/%d/containers", board.ID), Container{Title: "To do"}, &todo)
+	c.call("createContainer", fmt.Sprintf("/boards/%d/containers", board.ID), Container{Title: "Done"}, &done)
+	c.call("updateContainer", fmt.Sprintf("/containers/%d", todo.ID), Container{Title: "Backlog"}, nil)
+	c.call("listContainers", fmt.Sprintf("/boards/%d/containers", board.ID), nil, nil)
+
+	var lane Swimlane
+	c.call("createSwimlane", fmt.Sprintf("/boards/%d/swimlanes", board.ID), Swimlane{Title: "Expedite"}, &lane)
+	c.call("updateSwimlane", fmt.Sprintf("/swimlanes/%d", lane.ID), Swimlane{Title: "Urgent"}, &lane)
+	c.call("reorderSwimlanes", fmt.Sprintf("/boards/%d/swimlanes/order", board.ID), SwimlaneOrder{SwimlaneIDs: []int{lane.ID}}, nil)
+	c.call("listSwimlanes", fmt.Sprintf("/boards/%d/swimlanes", board.ID), nil, nil)
+
+	var field CustomField
+	c.call("createCustomField", fmt.Sprintf("/boards/%d/custom-fields", board.ID), CustomField{Name: "Points", Type: "number"}, &field)
+	c.call("updateCustomField", fmt.Sprintf("/custom-fields/%d", field.ID), CustomField{Name: "Story points", Type: "number"}, &field)
+	c.call("listCustomFields", fmt.Sprintf("/boards/%d/custom-fields", board.ID), nil, nil)
+
+	// Automation rules and webhooks, so the task changes below trigger them.
+	var rule AutomationRule
+	newRule := AutomationRule{
+		Name:    "Triage new tasks",
+		Trigger: RuleTrigger{Type: "task.created"},
+		Actions: RuleActions{{Type: "add_label", Label: "triage"}},
+		Enabled: true,
+	}
+	c.call("createAutomationRule", fmt.Sprintf("/boards/%d/automation-rules", board.ID), newRule, &rule)
+	newRule.Name = "Triage incoming tasks"
+	c.call("updateAutomationRule", fmt.Sprintf("/automation-rules/%d", rule.ID), newRule, &rule)
+	c.call("listAutomationRules", fmt.Sprintf("/boards/%d/automation-rules", board.ID), nil, nil)
+
+	var hook Webhook
+	c.call("createWebhook", fmt.Sprintf("/boards/%d/webhooks", board.ID), WebhookRequest{URL: receiver.URL, Events: []string{"*"}}, &hook)
+	c.call("updateWebhook", fmt.Sprintf("/webhooks/%d", hook.ID), WebhookRequest{URL: receiver.URL + "/tasks", Events: []string{"task.created", "task.moved"}}, &hook)
+	c.call("listWebhooks", fmt.Sprintf("/boards/%d/webhooks", board.ID), nil, nil)
+
+	// Sprints and tasks.
+	today := time.Now().UTC().Truncate(time.Second)
+	var sprint Sprint
+	newSprint := Sprint{Name: "Sprint 1", StartsOn: today.Format("2006-01-02"), EndsOn: today.AddDate(0, 0, 13).Format("2006-01-02")}
+	c.call("createSprint", fmt.Sprintf("/boards/%d/sprints", board.ID), newSprint, &sprint)
+	newSprint.Goal = "Publish the contract"
+	c.call("updateSprint", fmt.Sprintf("/sprints/%d", sprint.ID), newSprint, &sprint)
+
+	var task, other Task
+	c.call("createTask", fmt.Sprintf("/containers/%d/tasks", todo.ID), Task{Title: "Write contract tests", Priority: "high", Labels: []string{"bug"}}, &task)
+	c.call("createTask", fmt.Sprintf("/containers/%d/tasks", todo.ID), Task{Title: "Review contract tests"}, &other)
+	due := today.Add(48 * time.Hour)
+	estimate := 3.0
+	task.StartsAt, task.DueAt, task.Estimate = &today, &due, &estimate
+	task.SwimlaneID, task.SprintID, task.AssigneeID = &lane.ID, &sprint.ID, &login.ID
+	task.Checklist = Checklist{{Text: "Cover every route"}}
+	c.call("updateTask", fmt.Sprintf("/tasks/%d", task.ID), task, &task)
+	c.call("moveTask", fmt.Sprintf("/tasks/%d/move", other.ID), MoveTaskRequest{ContainerID: done.ID}, &other)
+	c.call("listTasks", fmt.Sprintf("/containers/%d/tasks?sort=-priority", todo.ID), nil, nil)
+
+	c.call("listSprints", fmt.Sprintf("/boards/%d/sprints", board.ID), nil, nil)
+	c.call("getSprint", fmt.Sprintf("/sprints/%d", sprint.ID), nil, nil)
+	c.call("startSprint", fmt.Sprintf("/sprints/%d/start", sprint.ID), nil, &sprint)
+	c.call("getSprintReport", fmt.Sprintf("/sprints/%d/report", sprint.ID), nil, nil)
+	c.call("getBurndown", fmt.Sprintf("/boards/%d/analytics/burndown?sprint_id=%d", board.ID, sprint.ID), nil, nil)
+	c.call("completeSprint", fmt.Sprintf("/sprints/%d/complete", sprint.ID), CompleteSprintRequest{ToBacklog: true}, nil)
+
+	// Comments, links, watchers and history.
+	c.call("createComment", fmt.Sprintf("/tasks/%d/comments", task.ID), TaskComment{Body: "Looks good"}, nil)
+	c.call("listComments", fmt.Sprintf("/tasks/%d/comments", task.ID), nil, nil)
+
+	var link TaskLink
+	c.call("createLink", fmt.Sprintf("/tasks/%d/links", task.ID), TaskLink{Type: "relates_to", OtherTaskID: other.ID}, &link)
+	c.call("listLinks", fmt.Sprintf("/tasks/%d/links", task.ID), nil, nil)
+
+	c.call("watchTask", fmt.Sprintf("/tasks/%d/watch", task.ID), nil, nil)
+	c.call("getTaskHistory", fmt.Sprintf("/tasks/%d/history", other.ID), nil, nil)
+	c.call("getCumulativeFlow", fmt.Sprintf("/boards/%d/analytics/cfd", board.ID), nil, nil)
+	c.call("getFlowMetrics", fmt.Sprintf("/boards/%d/analytics/flow", board.ID), nil, nil)
+	c.call("listAutomationRuns", fmt.Sprintf("/automation-rules/%d/runs", rule.ID), nil, nil)
+
+	// Time tracking.
+	c.call("startTimer", fmt.Sprintf("/tasks/%d/timer", task.ID), TimerRequest{Note: "pairing"}, nil)
+	c.call("getTimer", "/timer", nil, nil)
+	c.call("stopTimer", "/timer/stop", nil, nil)
+
+	started := today.Add(-2 * time.Hour)
+	ended := started.Add(time.Hour)
+	var entry TimeEntry
+	c.call("createTimeEntry", fmt.Sprintf("/tasks/%d/time-entries", task.ID), TimeEntry{StartedAt: started, EndedAt: &ended}, &entry)
+	entry.Note = "review"
+	c.call("updateTimeEntry", fmt.Sprintf("/time-entries/%d", entry.ID), entry, &entry)
+	c.call("listTimeEntries", fmt.Sprintf("/tasks/%d/time-entries", task.ID), nil, nil)
+	c.call("timeReport", "/reports/time?group_by=label", nil, nil)
+	c.call("exportTimeReport", "/reports/time/export?group_by=board", nil, nil)
+
+	// Recurrence.
+	c.call("setRecurrence", fmt.Sprintf("/tasks/%d/recurrence", task.ID), Recurrence{ContainerID: todo.ID, Frequency: "weekly"}, nil)
+	c.call("getRecurrence", fmt.Sprintf("/tasks/%d/recurrence", task.ID), nil, nil)
+	c.call("previewRecurrence", fmt.Sprintf("/tasks/%d/recurrence/preview?count=3", task.ID), nil, nil)
+
+	// Queries, saved filters and the timeline.
+	query := url.Values{"q": {"label:bug -completed"}}
+	c.call("searchTasks", "/tasks/search?"+query.Encode(), nil, nil)
+
+	var filter SavedFilter
+	c.call("createFilter", "/filters", SavedFilter{Name: "Bugs", Query: "label:bug"}, &filter)
+	c.call("updateFilter", fmt.Sprintf("/filters/%d", filter.ID), SavedFilter{Name: "Open bugs", Query: "label:bug -completed"}, &filter)
+	c.call("getFilter", fmt.Sprintf("/filters/%d", filter.ID), nil, nil)
+	c.call("listFilters", "/filters", nil, nil)
+	c.call("getFilterTasks", fmt.Sprintf("/filters/%d/tasks", filter.ID), nil, nil)
+	c.call("getTimeline", "/timeline?group_by=assignee", nil, nil)
+
+	// Templates.
+	var boardTemplate BoardTemplate
+	c.call("saveBoardTemplate", fmt.Sprintf("/boards/%d/template", board.ID), SaveBoardTemplateRequest{Name: "Contract"}, &boardTemplate)
+	c.call("updateBoardTemplate", fmt.Sprintf("/board-templates/%d", boardTemplate.ID), SaveBoardTemplateRequest{Name: "Contract layout"}, &boardTemplate)
+	c.call("listBoardTemplates", "/board-templates", nil, nil)
+	var templated Board
+	c.call("createBoard", fmt.Sprintf("/boards?template=%d", boardTemplate.ID), Board{Title: "From a template"}, &templated)
+
+	var taskTemplate TaskTemplate
+	c.call("createTaskTemplate", "/task-templates", TaskTemplate{Name: "Bug", Title: "Fix a bug", Labels: []string{"bug"}}, &taskTemplate)
+	taskTemplate.Description = "Steps to reproduce:"
+	c.call("updateTaskTemplate", fmt.Sprintf("/task-templates/%d", taskTemplate.ID), taskTemplate, &taskTemplate)
+	c.call("listTaskTemplates", "/task-templates", nil, nil)
+	c.call("createTask", fmt.Sprintf("/containers/%d/tasks?template=%d", todo.ID, taskTemplate.ID), map[string]interface{}{}, nil)
+
+	// Copies, bulk changes and the archive.
+	var taskCopy Task
+	c.call("cloneTask", fmt.Sprintf("/tasks/%d/clone", task.ID), CloneRequest{}, &taskCopy)
+	c.call("bulkTasks", "/tasks/bulk", BulkRequest{TaskIDs: []int{taskCopy.ID}, Operation: "add_label", Label: "copied"}, nil)
+	c.call("archiveTask", fmt.Sprintf("/tasks/%d/archive", taskCopy.ID), nil, nil)
+	c.call("listArchive", "/archive?type=task", nil, nil)
+	c.call("unarchiveTask", fmt.Sprintf("/tasks/%d/unarchive", taskCopy.ID), nil, nil)
+
+	var containerCopy Container
+	c.call("cloneContainer", fmt.Sprintf("/containers/%d/clone", todo.ID), CloneRequest{}, &containerCopy)
+	c.call("archiveContainer", fmt.Sprintf("/containers/%d/archive", containerCopy.ID), nil, nil)
+	c.call("unarchiveContainer", fmt.Sprintf("/containers/%d/unarchive", containerCopy.ID), nil, nil)
+
+	var boardCopy Board
+	c.call("cloneBoard", fmt.Sprintf("/boards/%d/clone", board.ID), CloneRequest{Title: "Contract copy"}, &boardCopy)
+	c.call("archiveBoard", fmt.Sprintf("/boards/%d/archive", boardCopy.ID), nil, nil)
+	c.call("listBoards", "/boards?include_archived=true", nil, nil)
+	c.call("unarchiveBoard", fmt.Sprintf("/boards/%d/unarchive", boardCopy.ID), nil, nil)
+
+	// Calendar feeds.
+	var feed CalendarFeed
+	c.call("getCalendarFeed", "/calendar/feed", nil, &feed)
+	c.call("regenerateCalendarFeed", "/calendar/feed/regenerate", nil, &feed)
+	c.call("calendarFeed", "/calendar.ics?token="+url.QueryEscape(feed.Token), nil, nil)
+	c.call("boardCalendarFeed", fmt.Sprintf("/boards/%d/calendar.ics?format=todo&token=%s", board.ID, url.QueryEscape(feed.Token)), nil, nil)
+
+	// Webhook deliveries; the dead-lettered one is a fixture since real
+	// deliveries only die after hours of retries.
+	var deliveryID int
+	err := ts.db.Get(&deliveryID, `
+		INSERT INTO webhook_deliveries (webhook_id, event, payload, status, attempts)
+		VALUES ($1, $2, '{}', $3, 8) RETURNING id
+	`, hook.ID, EventTaskCreated, DeliveryDead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.call("listWebhookDeliveries", fmt.Sprintf("/webhooks/%d/deliveries?status=dead", hook.ID), nil, nil)
+	c.call("retryWebhookDelivery", fmt.Sprintf("/webhooks/%d/deliveries/%d/retry", hook.ID, deliveryID), nil, nil)
+
+	// Notifications; the caller is never notified of their own changes, so
+	// one is inserted directly.
+	var notificationID int
+	err = ts.db.Get(&notificationID, `
+		INSERT INTO notifications (user_id, type, task_id, board_id, title)
+		VALUES ($1, 'assigned', $2, $3, 'Assigned to you') RETURNING id
+	`, login.ID, task.ID, board.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.call("listNotifications", "/notifications?unread=true", nil, nil)
+	c.call("getUnreadCount", "/notifications/unread-count", nil, nil)
+	c.call("markNotificationRead", fmt.Sprintf("/notifications/%d/read", notificationID), nil, nil)
+	c.call("markAllNotificationsRead", "/notifications/read-all", nil, nil)
+	c.call("getNotificationPreferences", "/notifications/preferences", nil, nil)
+	c.call("updateNotificationPreferences", "/notifications/preferences", []NotificationPreference{{Type: "task_changed", Channel: "email", Enabled: false}}, nil)
+	c.call("unwatchTask", fmt.Sprintf("/tasks/%d/watch", task.ID), nil, nil)
+
+	// Syncing replaces a board's containers and tasks with a snapshot.
+	c.call("getUserData", "/user-data", nil, nil)
+	c.call("syncBoard", "/update-user-data", SyncData{
+		BoardID:    board.ID,
+		Containers: []SyncContainer{{ID: todo.ID, Title: "Backlog"}, {ID: done.ID, Title: "Done"}},
+		Tasks:      []SyncTask{{ID: other.ID, ContainerID: done.ID, Title: "Review contract tests"}},
+	}, nil)
+
+	// Errors use the documented envelope.
+	intruder := ts.signup(t, "intruder")
+	owner := c.token
+	c.token = intruder.Token
+	c.callError("updateBoard", fmt.Sprintf("/boards/%d", board.ID), Board{Title: "Mine now"}, http.StatusForbidden)
+	c.token = owner
+	c.callError("createBoard", "/boards", Board{}, http.StatusUnprocessableEntity)
+
+	// Deletes, last so everything above had something to act on.
+	c.call("deleteLink", fmt.Sprintf("/tasks/%d/links/%d", task.ID, link.ID), nil, nil)
+	c.call("deleteTimeEntry", fmt.Sprintf("/time-entries/%d", entry.ID), nil, nil)
+	c.call("deleteRecurrence", fmt.Sprintf("/tasks/%d/recurrence", task.ID), nil, nil)
+	c.call("deleteAutomationRule", fmt.Sprintf("/automation-rules/%d", rule.ID), nil, nil)
+	c.call("deleteWebhook", fmt.Sprintf("/webhooks/%d", hook.ID), nil, nil)
+	c.call("deleteFilter", fmt.Sprintf("/filters/%d", filter.ID), nil, nil)
+	c.call("deleteTaskTemplate", fmt.Sprintf("/task-templates/%d", taskTemplate.ID), nil, nil)
+	c.call("deleteBoardTemplate", fmt.Sprintf("/board-templates/%d", boardTemplate.ID), nil, nil)
+	c.call("deleteSprint", fmt.Sprintf("/sprints/%d", sprint.ID), nil, nil)
+	c.call("deleteCustomField", fmt.Sprintf("/custom-fields/%d", field.ID), nil, nil)
+	c.call("deleteSwimlane", fmt.Sprintf("/swimlanes/%d", lane.ID), nil, nil)
+	c.call("deleteTask", fmt.Sprintf("/tasks/%d", taskCopy.ID), nil, nil)
+	c.call("deleteContainer", fmt.Sprintf("/containers/%d", containerCopy.ID), nil, nil)
+	c.call("deleteBoard", fmt.Sprintf("/boards/%d", boardCopy.ID), nil, nil)
+	c.call("logout", "/logout", nil, nil)
+
+	for _, rt := range ts.routes {
+		if !c.called[rt.name] {
+			t.Errorf("route %s (%s %s) is not covered", rt.name, rt.method, rt.path)
+		}
+	}
+}
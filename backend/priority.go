@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	PriorityNone   = "none"
+	PriorityLow    = "low"
+	PriorityMedium = "medium"
+	PriorityHigh   = "high"
+	PriorityUrgent = "urgent"
+)
+
+// Container sort modes. Manual keeps tasks in the order they were added.
+const (
+	SortManual   = "manual"
+	SortPriority = "priority"
+	SortDueDate  = "due_date"
+)
+
+// priorityRank orders tasks most urgent first.
+const priorityRank = "array_position(ARRAY['urgent', 'high', 'medium', 'low', 'none'], tasks.priority)"
+
+// taskSortKeys are the built-in sort parameters of task listings, besides
+// custom fields.
+var taskSortKeys = map[string]string{
+	"priority": priorityRank,
+	"due_date": "tasks.due_at",
+	"estimate": "tasks.estimate",
+}
+
+// taskOrder is the ORDER BY clause listing a container's tasks in its sort
+// mode. Tasks without a due date go last.
+func taskOrder(sortMode string) string {
+	switch sortMode {
+	case SortPriority:
+		return priorityRank + ", tasks.id"
+	case SortDueDate:
+		return "tasks.due_at NULLS LAST, tasks.id"
+	}
+	return "tasks.id"
+}
+
+// taskSortOrder is the ORDER BY clause for a listing's sort parameter: one of
+// taskSortKeys, prefixed with "-" for descending order, or the container's
+// sort mode when empty.
+func taskSortOrder(by, sortMode string) (string, error) {
+	if by == "" {
+		return taskOrder(sortMode), nil
+	}
+	expr, ok := taskSortKeys[strings.TrimPrefix(by, "-")]
+	if !ok {
+		return "", validationError([]FieldError{{Field: "sort", Message: "must be priority, due_date, estimate or cf.<field ID>, optionally prefixed with -"}}, "invalid query")
+	}
+	if strings.HasPrefix(by, "-") {
+		expr += " DESC"
+	}
+	return expr + " NULLS LAST, tasks.id", nil
+}
+
+// ContainerSummary totals a container's unarchived tasks. Estimates are
+// summed over the tasks that have one.
+type ContainerSummary struct {
+	ContainerID       int            `json:"container_id" db:"container_id"`
+	Title             string         `json:"title" db:"title"`
+	Tasks             int            `json:"tasks" db:"tasks"`
+	Completed         int            `json:"completed" db:"completed"`
+	Estimate          float64        `json:"estimate" db:"estimate"`
+	CompletedEstimate float64        `json:"completed_estimate" db:"completed_estimate"`
+	Unestimated       int            `json:"unestimated" db:"unestimated"`
+	ByPriority        map[string]int `json:"by_priority"`
+}
+
+type BoardSummary struct {
+	BoardID           int                `json:"board_id"`
+	Tasks             int                `json:"tasks"`
+	Completed         int                `json:"completed"`
+	Estimate          float64            `json:"estimate"`
+	CompletedEstimate float64            `json:"completed_estimate"`
+	Unestimated       int                `json:"unestimated"`
+	Containers        []ContainerSummary `json:"containers"`
+}
+
+// GetBoardSummaryHandler totals task counts and estimates per container of a
+// board, leaving out archived containers and tasks.
+func (tm *TaskManager) GetBoardSummaryHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+	boardID := mux.Vars(r)["id"]
+
+	err := tm.checkBoardOwnership(userID, boardID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	containers := []ContainerSummary{}
+	err = tm.db.Select(&containers, `
+		SELECT c.id AS container_id, c.title,
+			COUNT(t.id) AS tasks,
+			COUNT(t.id) FILTER (WHERE t.completed) AS completed,
+			COALESCE(SUM(t.estimate), 0) AS estimate,
+			COALESCE(SUM(t.estimate) FILTER (WHERE t.completed), 0) AS completed_estimate,
+			COUNT(t.id) FILTER (WHERE t.estimate IS NULL) AS unestimated
+		FROM containers c
+		LEFT JOIN tasks t ON t.container_id = c.id AND t.archived_at IS NULL
+		WHERE c.board_id = $1 AND c.archived_at IS NULL
+		GROUP BY c.id ORDER BY c.id
+	`, boardID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var counts []struct {
+		ContainerID int    `db:"container_id"`
+		Priority    string `db:"priority"`
+		Count       int    `db:"count"`
+	}
+	err = tm.db.Select(&counts, `
+		SELECT t.container_id, t.priority, COUNT(*) AS count
+		FROM tasks t JOIN containers c ON c.id = t.container_id
+		WHERE c.board_id = $1 AND c.archived_at IS NULL AND t.archived_at IS NULL
+		GROUP BY t.container_id, t.priority
+	`, boardID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	summary := BoardSummary{Containers: containers}
+	summary.BoardID, _ = strconv.Atoi(boardID)
+	index := map[int]int{}
+	for i := range containers {
+		c := &containers[i]
+		c.ByPriority = map[string]int{}
+		index[c.ContainerID] = i
+		summary.Tasks += c.Tasks
+		summary.Completed += c.Completed
+		summary.Estimate += c.Estimate
+		summary.CompletedEstimate += c.CompletedEstimate
+		summary.Unestimated += c.Unestimated
+	}
+	for _, count := range counts {
+		containers[index[count.ContainerID]].ByPriority[count.Priority] = count.Count
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
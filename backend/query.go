@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A task query is a list of space-separated terms that must all match, for
+// example:
+//
+//	board:"Sprint" label:bug assignee:me due<7d -completed
+//
+// A term is a key, an operator (:, =, <, <=, > or >=) and a value, or a bare
+// word. Bare words search titles and descriptions, except for the flags
+// completed, blocked and overdue. Double quotes group words and escape
+// keys; a leading - negates a term.
+type queryTerm struct {
+	Negate bool
+	Key    string
+	Op     string
+	Value  string
+	Quoted bool
+}
+
+// queryFlags are the bare words that filter on a task state instead of
+// searching for the word.
+var queryFlags = map[string]string{
+	"completed": "tasks.completed",
+	"blocked":   blockedExpr,
+	"overdue":   "(tasks.due_at < $now AND NOT tasks.completed)",
+}
+
+var relativeTime = regexp.MustCompile(`^([+-]?\d{1,5})([hdw])$`)
+
+// parseTaskQuery splits a task query into terms.
+func parseTaskQuery(q string) ([]queryTerm, error) {
+	var terms []queryTerm
+	i := 0
+	for {
+		for i < len(q) && isQuerySpace(q[i]) {
+			i++
+		}
+		if i == len(q) {
+			return terms, nil
+		}
+
+		start := i
+		var term queryTerm
+		if q[i] == '-' && i+1 < len(q) && !isQuerySpace(q[i+1]) {
+			term.Negate = true
+			i++
+		}
+		var key, value strings.Builder
+		cur := &key
+		for i < len(q) && !isQuerySpace(q[i]) {
+			c := q[i]
+			switch {
+			case c == '"':
+				end := i + 1
+				for end < len(q) && q[end] != '"' {
+					if q[end] == '\\' && end+1 < len(q) {
+						end++
+					}
+					cur.WriteByte(q[end])
+					end++
+				}
+				if end == len(q) {
+					return nil, queryError("unterminated quote at position %d", i+1)
+				}
+				if term.Op == "" {
+					term.Quoted = true
+				}
+				i = end + 1
+			case term.Op == "" && key.Len() > 0 && strings.IndexByte(":=<>", c) >= 0:
+				term.Op = string(c)
+				if (c == '<' || c == '>') && i+1 < len(q) && q[i+1] == '=' {
+					term.Op += "="
+					i++
+				}
+				cur = &value
+				i++
+			default:
+				cur.WriteByte(c)
+				i++
+			}
+		}
+
+		if term.Op == "" {
+			term.Value = key.String()
+		} else {
+			term.Key = strings.ToLower(key.String())
+			term.Value = value.String()
+			if term.Value == "" {
+				return nil, queryError("%s at position %d has no value", term.Key, start+1)
+			}
+		}
+		terms = append(terms, term)
+	}
+}
+
+// taskQuery compiles a task query into a condition on the tasks table for
+// userID, relative to now. Arguments are numbered from len(args)+1 and
+// appended to args.
+func taskQuery(q string, userID int, now time.Time, args []interface{}) (string, []interface{}, error) {
+	terms, err := parseTaskQuery(q)
+	if err != nil {
+		return "", nil, err
+	}
+
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	nowArg := ""
+
+	var where []string
+	for _, term := range terms {
+		var cond string
+		switch {
+		case term.Key == "" && !term.Quoted && queryFlags[strings.ToLower(term.Value)] != "":
+			cond = queryFlags[strings.ToLower(term.Value)]
+			if strings.Contains(cond, "$now") {
+				if nowArg == "" {
+					nowArg = arg(now)
+				}
+				cond = strings.ReplaceAll(cond, "$now", nowArg+"::timestamptz")
+			}
+		case term.Key == "" || term.Key == "text":
+			p := arg("%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(term.Value) + "%")
+			cond = fmt.Sprintf("(tasks.title ILIKE %s OR tasks.description ILIKE %s)", p, p)
+		case term.Key == "board" || term.Key == "container" || term.Key == "column":
+			if term.Op != ":" && term.Op != "=" {
+				return "", nil, queryError("%s only supports : and =", term.Key)
+			}
+			if term.Key == "board" {
+				cond = "tasks.container_id IN (SELECT c.id FROM containers c JOIN boards b ON b.id = c.board_id WHERE lower(b.title) = lower(" + arg(term.Value) + "))"
+			} else {
+				cond = "tasks.container_id IN (SELECT id FROM containers WHERE lower(title) = lower(" + arg(term.Value) + "))"
+			}
+		case term.Key == "label":
+			if term.Op != ":" && term.Op != "=" {
+				return "", nil, queryError("label only supports : and =")
+			}
+			cond = "EXISTS (SELECT 1 FROM unnest(tasks.labels) l WHERE lower(l) = lower(" + arg(term.Value) + "))"
+		case term.Key == "assignee":
+			if term.Op != ":" && term.Op != "=" {
+				return "", nil, queryError("assignee only supports : and =")
+			}
+			switch strings.ToLower(term.Value) {
+			case "me":
+				cond = "tasks.assignee_id = " + arg(userID)
+			case "none":
+				cond = "tasks.assignee_id IS NULL"
+			default:
+				cond = "tasks.assignee_id IN (SELECT id FROM users WHERE lower(username) = lower(" + arg(term.Value) + "))"
+			}
+		case term.Key == "sprint":
+			if term.Op != ":" && term.Op != "=" {
+				return "", nil, queryError("sprint only supports : and =")
+			}
+			switch strings.ToLower(term.Value) {
+			case "none":
+				cond = "tasks.sprint_id IS NULL"
+			case SprintActive:
+				cond = "tasks.sprint_id IN (SELECT id FROM sprints WHERE state = 'active')"
+			default:
+				cond = "tasks.sprint_id IN (SELECT id FROM sprints WHERE lower(name) = lower(" + arg(term.Value) + "))"
+			}
+		case term.Key == "priority":
+			rank := map[string]int{PriorityUrgent: 1, PriorityHigh: 2, PriorityMedium: 3, PriorityLow: 4, PriorityNone: 5}[strings.ToLower(term.Value)]
+			if rank == 0 {
+				return "", nil, queryError("priority must be one of none, low, medium, high, urgent")
+			}
+			// Ranks count down from urgent, so comparisons flip.
+			op := map[string]string{":": "=", "=": "=", "<": ">", "<=": ">=", ">": "<", ">=": "<="}[term.Op]
+			cond = fmt.Sprintf("%s %s %s", priorityRank, op, arg(rank))
+		case term.Key == "estimate":
+			if strings.EqualFold(term.Value, "none") && (term.Op == ":" || term.Op == "=") {
+				cond = "tasks.estimate IS NULL"
+				break
+			}
+			n, err := strconv.ParseFloat(term.Value, 64)
+			if err != nil {
+				return "", nil, queryError("estimate must be a number or none")
+			}
+			op := term.Op
+			if op == ":" {
+				op = "="
+			}
+			cond = fmt.Sprintf("tasks.estimate %s %s", op, arg(n))
+		case term.Key == "due" || term.Key == "start":
+			column := "tasks.due_at"
+			if term.Key == "start" {
+				column = "tasks.starts_at"
+			}
+			if strings.EqualFold(term.Value, "none") && (term.Op == ":" || term.Op == "=") {
+				cond = column + " IS NULL"
+				break
+			}
+			cond, err = timeCondition(column, term.Op, term.Value, now, arg)
+			if err != nil {
+				return "", nil, err
+			}
+		default:
+			return "", nil, queryError("unknown key %q", term.Key)
+		}
+
+		if term.Negate {
+			cond = "NOT COALESCE(" + cond + ", false)"
+		}
+		where = append(where, cond)
+	}
+
+	if len(where) == 0 {
+		return "true", args, nil
+	}
+	return strings.Join(where, " AND "), args, nil
+}
+
+// timeCondition compares column with a date (YYYY-MM-DD, today, tomorrow or
+// yesterday), which stands for the whole UTC day, or with a time relative
+// to now such as 7d, -12h or 2w.
+func timeCondition(column, op, value string, now time.Time, arg func(interface{}) string) (string, error) {
+	if m := relativeTime.FindStringSubmatch(strings.ToLower(value)); m != nil {
+		n, _ := strconv.ParseInt(m[1], 10, 64)
+		unit := map[string]time.Duration{"h": time.Hour, "d": 24 * time.Hour, "w": 7 * 24 * time.Hour}[m[2]]
+		if n > int64(math.MaxInt64/unit) || n < -int64(math.MaxInt64/unit) {
+			return "", queryError("%s is too far from now", value)
+		}
+		t := now.Add(time.Duration(n) * unit)
+		if op == ":" || op == "=" {
+			day := t.UTC().Truncate(24 * time.Hour)
+			return fmt.Sprintf("(%s >= %s AND %s < %s)", column, arg(day), column, arg(day.AddDate(0, 0, 1))), nil
+		}
+		return fmt.Sprintf("%s %s %s", column, op, arg(t)), nil
+	}
+
+	today := now.UTC().Truncate(24 * time.Hour)
+	var day time.Time
+	switch strings.ToLower(value) {
+	case "today":
+		day = today
+	case "tomorrow":
+		day = today.AddDate(0, 0, 1)
+	case "yesterday":
+		day = today.AddDate(0, 0, -1)
+	default:
+		var err error
+		day, err = time.Parse("2006-01-02", value)
+		if err != nil {
+			return "", queryError("%s must be a date, today, tomorrow, yesterday, none or a relative time like 7d", strings.TrimPrefix(column, "tasks."))
+		}
+	}
+	next := day.AddDate(0, 0, 1)
+	switch op {
+	case "<":
+		return fmt.Sprintf("%s < %s", column, arg(day)), nil
+	case "<=":
+		return fmt.Sprintf("%s < %s", column, arg(next)), nil
+	case ">":
+		return fmt.Sprintf("%s >= %s", column, arg(next)), nil
+	case ">=":
+		return fmt.Sprintf("%s >= %s", column, arg(day)), nil
+	}
+	return fmt.Sprintf("(%s >= %s AND %s < %s)", column, arg(day), column, arg(next)), nil
+}
+
+func isQuerySpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func queryError(format string, args ...interface{}) error {
+	return validationError([]FieldError{{Field: "query", Message: fmt.Sprintf(format, args...)}}, "invalid query")
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// queryErrorMessage returns the message queryError put in err's details.
+func queryErrorMessage(t *testing.T, err error) string {
+	t.Helper()
+
+	var de *DomainError
+	if !errors.As(err, &de) || !errors.Is(err, ErrValidation) {
+		t.Fatalf("error %v is not a validation error", err)
+	}
+	details, ok := de.Details.([]FieldError)
+	if !ok || len(details) != 1 || details[0].Field != "query" {
+		t.Fatalf("error details = %#v, want one query field error", de.Details)
+	}
+	return details[0].Message
+}
+
+func TestParseTaskQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []queryTerm
+	}{
+		{"", nil},
+		{"  \t ", nil},
+		{"fix login", []queryTerm{{Value: "fix"}, {Value: "login"}}},
+		{"fix\tlogin\n", []queryTerm{{Value: "fix"}, {Value: "login"}}},
+
+		// Quoting
+		{`"fix login"`, []queryTerm{{Value: "fix login", Quoted: true}}},
+		{`"completed"`, []queryTerm{{Value: "completed", Quoted: true}}},
+		{`"due<7d"`, []queryTerm{{Value: "due<7d", Quoted: true}}},
+		{`"say \"hi\" \\ bye"`, []queryTerm{{Value: `say "hi" \ bye`, Quoted: true}}},
+		{`pre"fix me"post`, []queryTerm{{Value: "prefix mepost", Quoted: true}}},
+		{`board:"Sprint 1"`, []queryTerm{{Key: "board", Op: ":", Value: "Sprint 1"}}},
+		{`"board":x`, []queryTerm{{Key: "board", Op: ":", Value: "x", Quoted: true}}},
+		{`""`, []queryTerm{{Value: "", Quoted: true}}},
+
+		// Negation
+		{"-completed", []queryTerm{{Negate: true, Value: "completed"}}},
+		{"-label:bug", []queryTerm{{Negate: true, Key: "label", Op: ":", Value: "bug"}}},
+		{`-"fix login"`, []queryTerm{{Negate: true, Value: "fix login", Quoted: true}}},
+		{"- completed", []queryTerm{{Value: "-"}, {Value: "completed"}}},
+		{"--x", []queryTerm{{Negate: true, Value: "-x"}}},
+		{"re-open", []queryTerm{{Value: "re-open"}}},
+
+		// Operators
+		{"label:bug", []queryTerm{{Key: "label", Op: ":", Value: "bug"}}},
+		{"LABEL=Bug", []queryTerm{{Key: "label", Op: "=", Value: "Bug"}}},
+		{"due<7d", []queryTerm{{Key: "due", Op: "<", Value: "7d"}}},
+		{"due<=7d", []queryTerm{{Key: "due", Op: "<=", Value: "7d"}}},
+		{"estimate>3", []queryTerm{{Key: "estimate", Op: ">", Value: "3"}}},
+		{"estimate>=3", []queryTerm{{Key: "estimate", Op: ">=", Value: "3"}}},
+		{"due<-12h", []queryTerm{{Key: "due", Op: "<", Value: "-12h"}}},
+		{"note:a:b", []queryTerm{{Key: "note", Op: ":", Value: "a:b"}}},
+		{"x==y", []queryTerm{{Key: "x", Op: "=", Value: "=y"}}},
+		{":bare", []queryTerm{{Value: ":bare"}}},
+	}
+	for _, tt := range tests {
+		got, err := parseTaskQuery(tt.query)
+		if err != nil {
+			t.Errorf("parseTaskQuery(%q): %v", tt.query, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseTaskQuery(%q) = %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestParseTaskQueryErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`"fix`, "unterminated quote at position 1"},
+		{`fix "login`, "unterminated quote at position 5"},
+		{`label:"bug`, "unterminated quote at position 7"},
+		{`"a\"`, "unterminated quote at position 1"},
+		{"label:", "label at position 1 has no value"},
+		{"bug due<", "due at position 5 has no value"},
+		{"a -due<=", "due at position 3 has no value"},
+		{`label:""`, "label at position 1 has no value"},
+	}
+	for _, tt := range tests {
+		_, err := parseTaskQuery(tt.query)
+		if err == nil {
+			t.Errorf("parseTaskQuery(%q) succeeded, want %q", tt.query, tt.want)
+			continue
+		}
+		if got := queryErrorMessage(t, err); got != tt.want {
+			t.Errorf("parseTaskQuery(%q) error = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestTaskQuery(t *testing.T) {
+	now := time.Date(2026, 3, 4, 15, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		query string
+		where string
+		args  []interface{}
+	}{
+		{"", "true", nil},
+		{"-completed", "NOT COALESCE(tasks.completed, false)", nil},
+		{`"completed"`, "(tasks.title ILIKE $1 OR tasks.description ILIKE $1)", []interface{}{"%completed%"}},
+		{"50%_off", "(tasks.title ILIKE $1 OR tasks.description ILIKE $1)", []interface{}{`%50\%\_off%`}},
+		{"priority>=high", "priority_rank <= $1", []interface{}{2}},
+		{"estimate:none", "tasks.estimate IS NULL", nil},
+		{"due<2d", "tasks.due_at < $1", []interface{}{now.Add(48 * time.Hour)}},
+		{"due>=-1w", "tasks.due_at >= $1", []interface{}{now.Add(-7 * 24 * time.Hour)}},
+		{"due<15000w", "tasks.due_at < $1", []interface{}{now.Add(15000 * 7 * 24 * time.Hour)}},
+		{"start:today", "(tasks.starts_at >= $1 AND tasks.starts_at < $2)", []interface{}{
+			time.Date(2026, 3, 4, 0, 0, 0, 0, time.UTC), time.Date(2026, 3, 5, 0, 0, 0, 0, time.UTC),
+		}},
+	}
+	for _, tt := range tests {
+		where, args, err := taskQuery(tt.query, 1, now, nil)
+		if err != nil {
+			t.Errorf("taskQuery(%q): %v", tt.query, err)
+			continue
+		}
+		where = strings.ReplaceAll(where, priorityRank, "priority_rank")
+		if where != tt.where || !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("taskQuery(%q) = %q %v, want %q %v", tt.query, where, args, tt.where, tt.args)
+		}
+	}
+}
+
+func TestTaskQueryErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"colour:red", `unknown key "colour"`},
+		{"label<bug", "label only supports : and ="},
+		{"priority:soon", "priority must be one of none, low, medium, high, urgent"},
+		{"estimate>lots", "estimate must be a number or none"},
+		{"due:someday", "due_at must be a date, today, tomorrow, yesterday, none or a relative time like 7d"},
+		{"due<99999w", "99999w is too far from now"},
+		{"start>-99999w", "-99999w is too far from now"},
+		{"due:99999d", ""},
+	}
+	for _, tt := range tests {
+		_, _, err := taskQuery(tt.query, 1, time.Now(), nil)
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("taskQuery(%q): %v", tt.query, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("taskQuery(%q) succeeded, want %q", tt.query, tt.want)
+			continue
+		}
+		if got := queryErrorMessage(t, err); got != tt.want {
+			t.Errorf("taskQuery(%q) error = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,549 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const (
+	RecurDaily           = "daily"
+	RecurWeekly          = "weekly"
+	RecurMonthly         = "monthly"
+	RecurAfterCompletion = "after_completion"
+)
+
+// Recurrence makes a task repeat. Scheduled frequencies (daily, weekly,
+// monthly) spawn a copy of the task into ContainerID whenever NextRunAt is
+// reached; after_completion spawns the next copy, due Interval days later,
+// when the current one is completed. Times are interpreted in Timezone.
+type Recurrence struct {
+	ID            int           `json:"id" db:"id"`
+	TaskID        int           `json:"task_id" db:"task_id"`
+	ContainerID   int           `json:"container_id" db:"container_id" validate:"required"`
+	Frequency     string        `json:"frequency" db:"frequency" validate:"required,oneof=daily|weekly|monthly|after_completion"`
+	Interval      int           `json:"interval" db:"interval_count" validate:"min=0,max=365"`
+	Weekdays      pq.Int64Array `json:"weekdays" db:"weekdays" validate:"max=7"`
+	MonthDay      int           `json:"month_day" db:"month_day" validate:"min=0,max=31"`
+	TimeOfDay     string        `json:"time_of_day" db:"time_of_day"`
+	Timezone      string        `json:"timezone" db:"timezone" validate:"max=64"`
+	StartsOn      string        `json:"starts_on" db:"starts_on"`
+	CurrentTaskID *int          `json:"current_task_id" db:"current_task_id"`
+	NextRunAt     *time.Time    `json:"next_run_at" db:"next_run_at"`
+	CreatedAt     time.Time     `json:"created_at" db:"created_at"`
+}
+
+type RecurrencePreview struct {
+	Timezone    string      `json:"timezone"`
+	Occurrences []time.Time `json:"occurrences"`
+}
+
+type RecurrenceManager struct {
+	db           *sqlx.DB
+	tm           *TaskManager
+	pollInterval time.Duration
+}
+
+func NewRecurrenceManager(db *sqlx.DB, tm *TaskManager) *RecurrenceManager {
+	return &RecurrenceManager{db: db, tm: tm, pollInterval: time.Minute}
+}
+
+func (rm *RecurrenceManager) routes() []route {
+	return []route{
+		{name: "getRecurrence", method: "GET", path: "/tasks/{id}/recurrence", handler: rm.GetRecurrenceHandler, auth: true, tag: "recurrence",
+			summary: "Show a task's recurrence rule", response: Recurrence{}},
+		{name: "setRecurrence", method: "PUT", path: "/tasks/{id}/recurrence", handler: rm.SetRecurrenceHandler, auth: true, tag: "recurrence",
+			summary: "Make a task recur, replacing any existing rule", request: Recurrence{}, response: Recurrence{}},
+		{name: "deleteRecurrence", method: "DELETE", path: "/tasks/{id}/recurrence", handler: rm.DeleteRecurrenceHandler, auth: true, tag: "recurrence",
+			summary: "Stop a task from recurring"},
+		{name: "previewRecurrence", method: "GET", path: "/tasks/{id}/recurrence/preview", handler: rm.PreviewHandler, auth: true, tag: "recurrence",
+			summary: "List the upcoming occurrences of a task", response: RecurrencePreview{},
+			query: []queryParam{{name: "count", description: "number of occurrences, at most 50", typ: "integer"}}},
+	}
+}
+
+func (rm *RecurrenceManager) GetRecurrenceHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+	taskID := mux.Vars(r)["id"]
+
+	_, err := rm.tm.checkTaskAccess(userID, taskID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	rec, err := rm.getRecurrence(taskID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rec)
+}
+
+func (rm *RecurrenceManager) SetRecurrenceHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	var rec Recurrence
+	err := decodeJSON(w, r, &rec)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	task, err := rm.tm.checkTaskAccess(userID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = rm.normalize(&rec, task)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	rec.TaskID = task.ID
+	rec.CurrentTaskID = &task.ID
+	rec.NextRunAt = nil
+	if rec.Frequency != RecurAfterCompletion {
+		next, err := rec.nextOccurrence(time.Now())
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		rec.NextRunAt = &next
+	}
+
+	err = rm.db.Get(&rec, `
+		INSERT INTO task_recurrences
+			(task_id, container_id, frequency, interval_count, weekdays, month_day, time_of_day, timezone, starts_on, current_task_id, next_run_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (task_id) DO UPDATE SET
+			container_id = EXCLUDED.container_id, frequency = EXCLUDED.frequency, interval_count = EXCLUDED.interval_count,
+			weekdays = EXCLUDED.weekdays, month_day = EXCLUDED.month_day, time_of_day = EXCLUDED.time_of_day,
+			timezone = EXCLUDED.timezone, starts_on = EXCLUDED.starts_on, current_task_id = EXCLUDED.current_task_id,
+			next_run_at = EXCLUDED.next_run_at
+		RETURNING *
+	`, rec.TaskID, rec.ContainerID, rec.Frequency, rec.Interval, rec.Weekdays, rec.MonthDay, rec.TimeOfDay, rec.Timezone, rec.StartsOn, rec.CurrentTaskID, rec.NextRunAt)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rec)
+}
+
+func (rm *RecurrenceManager) DeleteRecurrenceHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+	taskID := mux.Vars(r)["id"]
+
+	_, err := rm.tm.checkTaskAccess(userID, taskID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	rec, err := rm.getRecurrence(taskID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	_, err = rm.db.Exec("DELETE FROM task_recurrences WHERE id = $1", rec.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (rm *RecurrenceManager) PreviewHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+	taskID := mux.Vars(r)["id"]
+
+	_, err := rm.tm.checkTaskAccess(userID, taskID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	rec, err := rm.getRecurrence(taskID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	count := 10
+	if v := r.URL.Query().Get("count"); v != "" {
+		count, err = strconv.Atoi(v)
+		if err != nil || count < 1 || count > 50 {
+			writeError(w, r, validationError([]FieldError{{Field: "count", Message: "must be between 1 and 50"}}, "invalid query"))
+			return
+		}
+	}
+
+	loc, _ := time.LoadLocation(rec.Timezone)
+	preview := RecurrencePreview{Timezone: rec.Timezone, Occurrences: []time.Time{}}
+
+	// after_completion has no fixed schedule, so the preview assumes each
+	// occurrence is completed on its due date.
+	after := time.Now()
+	if rec.NextRunAt != nil {
+		after = rec.NextRunAt.Add(-time.Second)
+	}
+	for len(preview.Occurrences) < count {
+		next, err := rec.nextOccurrence(after)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		preview.Occurrences = append(preview.Occurrences, next.In(loc))
+		after = next
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(preview)
+}
+
+func (rm *RecurrenceManager) getRecurrence(taskID string) (Recurrence, error) {
+	var rec Recurrence
+	err := rm.db.Get(&rec, "SELECT * FROM task_recurrences WHERE task_id = $1", taskID)
+	if err == sql.ErrNoRows {
+		return rec, notFoundError("task %s has no recurrence", taskID)
+	}
+	return rec, err
+}
+
+// normalize fills in defaults and validates the fields that depend on each
+// other or on the task's board.
+func (rm *RecurrenceManager) normalize(rec *Recurrence, task Task) error {
+	var fieldErrs []FieldError
+
+	if rec.Interval == 0 {
+		rec.Interval = 1
+	}
+	if rec.TimeOfDay == "" {
+		rec.TimeOfDay = "09:00"
+	}
+	if rec.Timezone == "" {
+		rec.Timezone = "UTC"
+	}
+	if rec.Weekdays == nil {
+		rec.Weekdays = pq.Int64Array{}
+	}
+
+	loc, err := time.LoadLocation(rec.Timezone)
+	if err != nil {
+		fieldErrs = append(fieldErrs, FieldError{Field: "timezone", Message: "must be an IANA time zone name"})
+		loc = time.UTC
+	}
+	if _, err := time.Parse("15:04", rec.TimeOfDay); err != nil {
+		fieldErrs = append(fieldErrs, FieldError{Field: "time_of_day", Message: "must be formatted as HH:MM"})
+	}
+
+	if rec.StartsOn == "" {
+		rec.StartsOn = time.Now().In(loc).Format("2006-01-02")
+	}
+	start, err := time.ParseInLocation("2006-01-02", rec.StartsOn, loc)
+	if err != nil {
+		fieldErrs = append(fieldErrs, FieldError{Field: "starts_on", Message: "must be formatted as YYYY-MM-DD"})
+	}
+
+	switch rec.Frequency {
+	case RecurWeekly:
+		if len(rec.Weekdays) == 0 && err == nil {
+			rec.Weekdays = pq.Int64Array{int64(start.Weekday())}
+		}
+		for i, day := range rec.Weekdays {
+			if day < 0 || day > 6 {
+				fieldErrs = append(fieldErrs, FieldError{Field: fmt.Sprintf("weekdays[%d]", i), Message: "must be between 0 (Sunday) and 6 (Saturday)"})
+			}
+		}
+	case RecurMonthly:
+		if rec.MonthDay == 0 && err == nil {
+			rec.MonthDay = start.Day()
+		}
+	}
+
+	boardID, err := rm.tm.boardIDForContainer(task.ContainerID)
+	if err != nil {
+		return err
+	}
+	if targetBoardID, err := rm.tm.boardIDForContainer(rec.ContainerID); err != nil || targetBoardID != boardID {
+		fieldErrs = append(fieldErrs, FieldError{Field: "container_id", Message: "must be a container of the task's board"})
+	}
+
+	if len(fieldErrs) > 0 {
+		return validationError(fieldErrs, "invalid recurrence")
+	}
+	return nil
+}
+
+// nextOccurrence returns the first occurrence strictly after t. Dates are
+// stepped in the rule's time zone so occurrences keep their wall-clock time
+// across DST changes.
+func (rec Recurrence) nextOccurrence(after time.Time) (time.Time, error) {
+	loc, err := time.LoadLocation(rec.Timezone)
+	if err != nil {
+		return time.Time{}, err
+	}
+	clock, err := time.Parse("15:04", rec.TimeOfDay)
+	if err != nil {
+		return time.Time{}, err
+	}
+	start, err := time.ParseInLocation("2006-01-02", rec.StartsOn, loc)
+	if err != nil {
+		return time.Time{}, err
+	}
+	interval := rec.Interval
+	if interval < 1 {
+		interval = 1
+	}
+
+	at := func(day time.Time) time.Time {
+		return time.Date(day.Year(), day.Month(), day.Day(), clock.Hour(), clock.Minute(), 0, 0, loc)
+	}
+	local := after.In(loc)
+	from := start
+	if local.After(start) {
+		from = time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	}
+
+	switch rec.Frequency {
+	case RecurDaily:
+		skip := civilDays(start, from) / interval * interval
+		for day := start.AddDate(0, 0, skip); ; day = day.AddDate(0, 0, interval) {
+			if occ := at(day); occ.After(after) {
+				return occ, nil
+			}
+		}
+
+	case RecurWeekly:
+		if len(rec.Weekdays) == 0 {
+			return time.Time{}, fmt.Errorf("weekly recurrence without weekdays")
+		}
+		weekStart := start.AddDate(0, 0, -int(start.Weekday()))
+		for day, i := from, 0; i < 7*interval+14; day, i = day.AddDate(0, 0, 1), i+1 {
+			week := civilDays(weekStart, day) / 7
+			if week%interval != 0 || !containsWeekday(rec.Weekdays, day.Weekday()) {
+				continue
+			}
+			if occ := at(day); occ.After(after) && !day.Before(start) {
+				return occ, nil
+			}
+		}
+
+	case RecurMonthly:
+		months := (from.Year()-start.Year())*12 + int(from.Month()-start.Month())
+		if months < 0 {
+			months = 0
+		}
+		for k := months / interval * interval; ; k += interval {
+			first := time.Date(start.Year(), start.Month()+time.Month(k), 1, 0, 0, 0, 0, loc)
+			day := rec.MonthDay
+			if last := first.AddDate(0, 1, -1).Day(); day > last {
+				day = last
+			}
+			if occ := at(first.AddDate(0, 0, day-1)); occ.After(after) && !occ.Before(at(start)) {
+				return occ, nil
+			}
+		}
+
+	case RecurAfterCompletion:
+		return at(local.AddDate(0, 0, interval)), nil
+	}
+
+	return time.Time{}, fmt.Errorf("no occurrence found for %s recurrence", rec.Frequency)
+}
+
+// civilDays counts calendar days from a to b, ignoring DST offsets.
+func civilDays(a, b time.Time) int {
+	ua := time.Date(a.Year(), a.Month(), a.Day(), 0, 0, 0, 0, time.UTC)
+	ub := time.Date(b.Year(), b.Month(), b.Day(), 0, 0, 0, 0, time.UTC)
+	return int(ub.Sub(ua).Hours() / 24)
+}
+
+func containsWeekday(days pq.Int64Array, day time.Weekday) bool {
+	for _, d := range days {
+		if time.Weekday(d) == day {
+			return true
+		}
+	}
+	return false
+}
+
+// spawn copies the recurrence's template task into its container in tx,
+// due at due and starting as long before that as the template. The caller
+// commits tx and then announces the task.
+func (rm *RecurrenceManager) spawn(tx *sqlx.Tx, rec Recurrence, due time.Time) (Task, error) {
+	var task Task
+	if _, err := rm.tm.checkWIP(tx, rec.ContainerID, 1); err != nil {
+		return task, err
+	}
+	err := tx.Get(&task, `
+		INSERT INTO tasks (container_id, title, description, priority, estimate, labels, assignee_id, starts_at, due_at, swimlane_id, checklist, custom_fields)
+		SELECT $1, title, description, priority, estimate, labels, assignee_id, $2::timestamptz - (due_at - starts_at), $2, swimlane_id, `+uncheckedChecklist+`, `+keptCustomFields+` FROM tasks WHERE id = $3
+		RETURNING `+taskColumns, rec.ContainerID, due, rec.TaskID)
+	if err != nil {
+		return task, err
+	}
+
+	_, err = tx.Exec("UPDATE task_recurrences SET current_task_id = $1 WHERE id = $2", task.ID, rec.ID)
+	return task, err
+}
+
+// announce publishes task.created for a spawned task.
+func (rm *RecurrenceManager) announce(task Task, userID int) error {
+	boardID, err := rm.tm.boardIDForContainer(task.ContainerID)
+	if err != nil {
+		return err
+	}
+	rm.tm.publish(Event{Type: EventTaskCreated, BoardID: boardID, UserID: userID, OccurredAt: time.Now().UTC(), Data: task})
+	return nil
+}
+
+// HandleEvent spawns the next copy of an after_completion recurrence when
+// its current task is completed.
+func (rm *RecurrenceManager) HandleEvent(event Event) {
+	task, ok := event.Data.(Task)
+	if event.Type != EventTaskCompleted || !ok {
+		return
+	}
+
+	var rec Recurrence
+	err := rm.db.Get(&rec, `
+		SELECT * FROM task_recurrences
+		WHERE frequency = $1 AND COALESCE(current_task_id, task_id) = $2
+	`, RecurAfterCompletion, task.ID)
+	if err == sql.ErrNoRows {
+		return
+	}
+	if err == nil {
+		err = rm.spawnAfter(rec, event)
+	}
+	if err != nil {
+		log.Printf("recurrence: spawning after task %d: %v", task.ID, err)
+	}
+}
+
+// spawnAfter spawns the copy that follows the completion in event.
+func (rm *RecurrenceManager) spawnAfter(rec Recurrence, event Event) error {
+	due, err := rec.nextOccurrence(event.OccurredAt)
+	if err != nil {
+		return err
+	}
+	tx, err := rm.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	task, err := rm.spawn(tx, rec, due)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return rm.announce(task, event.UserID)
+}
+
+// Run spawns scheduled occurrences until ctx is cancelled.
+func (rm *RecurrenceManager) Run(ctx context.Context) {
+	ticker := time.NewTicker(rm.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := rm.spawnDue(ctx); err != nil {
+				log.Printf("recurrence: %v", err)
+			}
+		}
+	}
+}
+
+// spawnDue spawns the recurrences whose next_run_at has passed.
+func (rm *RecurrenceManager) spawnDue(ctx context.Context) error {
+	due := []Recurrence{}
+	err := rm.db.SelectContext(ctx, &due, "SELECT * FROM task_recurrences WHERE next_run_at <= now() ORDER BY next_run_at LIMIT 100")
+	if err != nil {
+		return err
+	}
+
+	for _, rec := range due {
+		if err := rm.spawnScheduled(ctx, rec, time.Now()); err != nil {
+			log.Printf("recurrence %d: %v", rec.ID, err)
+		}
+	}
+	return nil
+}
+
+// spawnScheduled spawns a single copy of rec, due at its last occurrence up
+// to now, and advances next_run_at past now, so occurrences missed while the
+// server was down are not spawned one by one. next_run_at is advanced in the
+// spawn's transaction, guarded on its previous value, so an occurrence is
+// spawned once even with several servers and is retried while the container
+// is at its enforced WIP limit.
+func (rm *RecurrenceManager) spawnScheduled(ctx context.Context, rec Recurrence, now time.Time) error {
+	scheduled := *rec.NextRunAt
+	occurrence, next, err := rec.catchUp(scheduled, now)
+	if err != nil {
+		return err
+	}
+
+	tx, err := rm.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("UPDATE task_recurrences SET next_run_at = $1 WHERE id = $2 AND next_run_at = $3", next, rec.ID, scheduled)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil
+	}
+
+	task, err := rm.spawn(tx, rec, occurrence)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return rm.announce(task, 0)
+}
+
+// catchUp returns the last occurrence from scheduled up to now, which is
+// the one to spawn, and the first occurrence after it and now.
+func (rec Recurrence) catchUp(scheduled, now time.Time) (last, next time.Time, err error) {
+	last = scheduled
+	for {
+		next, err = rec.nextOccurrence(last)
+		if err != nil || next.After(now) {
+			return last, next, err
+		}
+		last = next
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func mustTime(t *testing.T, value string) time.Time {
+	t.Helper()
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func TestNextOccurrence(t *testing.T) {
+	daily := func(interval int, tz string) Recurrence {
+		return Recurrence{Frequency: RecurDaily, Interval: interval, TimeOfDay: "09:00", Timezone: tz, StartsOn: "2026-03-01"}
+	}
+	weekly := func(interval int, days ...int64) Recurrence {
+		return Recurrence{Frequency: RecurWeekly, Interval: interval, Weekdays: pq.Int64Array(days), TimeOfDay: "09:00", Timezone: "UTC", StartsOn: "2026-03-02"}
+	}
+	monthly := func(interval, day int, startsOn string) Recurrence {
+		return Recurrence{Frequency: RecurMonthly, Interval: interval, MonthDay: day, TimeOfDay: "09:00", Timezone: "UTC", StartsOn: startsOn}
+	}
+
+	tests := []struct {
+		name  string
+		rec   Recurrence
+		after string
+		want  string
+	}{
+		{"daily, later that day", daily(1, "UTC"), "2026-03-05T10:00:00Z", "2026-03-06T09:00:00Z"},
+		{"daily, strictly after", daily(1, "UTC"), "2026-03-05T09:00:00Z", "2026-03-06T09:00:00Z"},
+		{"daily, before start", daily(1, "UTC"), "2026-02-01T00:00:00Z", "2026-03-01T09:00:00Z"},
+		{"every third day", daily(3, "UTC"), "2026-03-05T12:00:00Z", "2026-03-07T09:00:00Z"},
+		{"interval 0 means 1", daily(0, "UTC"), "2026-03-05T10:00:00Z", "2026-03-06T09:00:00Z"},
+
+		{"weekly, next weekday", weekly(1, 1, 4), "2026-03-03T10:00:00Z", "2026-03-05T09:00:00Z"},
+		{"weekly, into next week", weekly(1, 1, 4), "2026-03-05T10:00:00Z", "2026-03-09T09:00:00Z"},
+		{"every other week", weekly(2, 1), "2026-03-02T10:00:00Z", "2026-03-16T09:00:00Z"},
+
+		{"monthly", monthly(1, 15, "2026-01-15"), "2026-03-15T10:00:00Z", "2026-04-15T09:00:00Z"},
+		{"quarterly", monthly(3, 15, "2026-01-15"), "2026-02-01T00:00:00Z", "2026-04-15T09:00:00Z"},
+		{"Jan 31 to Feb 28", monthly(1, 31, "2026-01-31"), "2026-01-31T10:00:00Z", "2026-02-28T09:00:00Z"},
+		{"Jan 31 to Feb 29 in a leap year", monthly(1, 31, "2028-01-31"), "2028-01-31T10:00:00Z", "2028-02-29T09:00:00Z"},
+		{"back to the 31st after February", monthly(1, 31, "2026-01-31"), "2026-02-28T10:00:00Z", "2026-03-31T09:00:00Z"},
+		{"30th in February", monthly(1, 30, "2026-01-30"), "2026-02-01T00:00:00Z", "2026-02-28T09:00:00Z"},
+
+		// 09:00 New York is 14:00 UTC in winter and 13:00 UTC in summer.
+		{"into daylight saving time", daily(1, "America/New_York"), "2026-03-07T14:00:00Z", "2026-03-08T13:00:00Z"},
+		{"out of daylight saving time", daily(1, "America/New_York"), "2026-10-31T13:00:00Z", "2026-11-01T14:00:00Z"},
+		{"weekly across the change", Recurrence{Frequency: RecurWeekly, Interval: 1, Weekdays: pq.Int64Array{6}, TimeOfDay: "09:00", Timezone: "America/New_York", StartsOn: "2026-03-01"},
+			"2026-03-07T14:00:00Z", "2026-03-14T13:00:00Z"},
+		{"monthly across the change", Recurrence{Frequency: RecurMonthly, Interval: 1, MonthDay: 1, TimeOfDay: "09:00", Timezone: "Europe/Berlin", StartsOn: "2026-03-01"},
+			"2026-03-01T08:00:00Z", "2026-04-01T07:00:00Z"},
+
+		{"after completion", Recurrence{Frequency: RecurAfterCompletion, Interval: 2, TimeOfDay: "09:00", Timezone: "UTC", StartsOn: "2026-03-01"},
+			"2026-03-05T18:00:00Z", "2026-03-07T09:00:00Z"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.rec.nextOccurrence(mustTime(t, tt.after))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := mustTime(t, tt.want); !got.Equal(want) {
+				t.Errorf("nextOccurrence(%s) = %s, want %s", tt.after, got.UTC().Format(time.RFC3339), tt.want)
+			}
+		})
+	}
+}
+
+func TestNextOccurrenceErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		rec  Recurrence
+	}{
+		{"weekly without weekdays", Recurrence{Frequency: RecurWeekly, TimeOfDay: "09:00", Timezone: "UTC", StartsOn: "2026-03-02"}},
+		{"unknown time zone", Recurrence{Frequency: RecurDaily, TimeOfDay: "09:00", Timezone: "Mars/Olympus", StartsOn: "2026-03-02"}},
+		{"bad time of day", Recurrence{Frequency: RecurDaily, TimeOfDay: "9am", Timezone: "UTC", StartsOn: "2026-03-02"}},
+	}
+	for _, tt := range tests {
+		if _, err := tt.rec.nextOccurrence(time.Now()); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+}
+
+func TestCatchUp(t *testing.T) {
+	rec := Recurrence{Frequency: RecurDaily, Interval: 1, TimeOfDay: "09:00", Timezone: "UTC", StartsOn: "2026-03-01"}
+
+	tests := []struct {
+		name               string
+		scheduled, now     string
+		wantLast, wantNext string
+	}{
+		{"on time", "2026-03-05T09:00:00Z", "2026-03-05T09:00:30Z", "2026-03-05T09:00:00Z", "2026-03-06T09:00:00Z"},
+		{"after a few days down", "2026-03-01T09:00:00Z", "2026-03-05T12:00:00Z", "2026-03-05T09:00:00Z", "2026-03-06T09:00:00Z"},
+		{"down until just before the next", "2026-03-01T09:00:00Z", "2026-03-05T08:59:00Z", "2026-03-04T09:00:00Z", "2026-03-05T09:00:00Z"},
+		{"down for a year", "2026-03-01T09:00:00Z", "2027-03-01T10:00:00Z", "2027-03-01T09:00:00Z", "2027-03-02T09:00:00Z"},
+	}
+	for _, tt := range tests {
+		last, next, err := rec.catchUp(mustTime(t, tt.scheduled), mustTime(t, tt.now))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !last.Equal(mustTime(t, tt.wantLast)) || !next.Equal(mustTime(t, tt.wantNext)) {
+			t.Errorf("%s: catchUp = %s, %s; want %s, %s", tt.name, last.UTC().Format(time.RFC3339), next.UTC().Format(time.RFC3339), tt.wantLast, tt.wantNext)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+func apiRoutes(tm *TaskManager, uh *UserHandler) []route {
+	return []route{
+		{name: "signup", method: "POST", path: "/signup", handler: uh.signupHandler, tag: "auth",
+			summary: "Create an account and its first board", request: SignupData{}, response: LoginResponse{}},
+		{name: "login", method: "POST", path: "/login", handler: uh.loginHandler, tag: "auth",
+			summary: "Exchange credentials for a bearer token", request: Credentials{}, response: LoginResponse{}},
+		{name: "refreshToken", method: "POST", path: "/token/refresh", handler: uh.refreshHandler, auth: true, tag: "auth",
+			summary: "Issue a fresh bearer token", response: LoginResponse{}},
+		{name: "logout", method: "POST", path: "/logout", handler: uh.logoutHandler, tag: "auth",
+			summary: "End the current session"},
+
+		{name: "listBoards", method: "GET", path: "/boards", handler: tm.GetBoardsHandler, auth: true, tag: "boards",
+			summary: "List the caller's boards", response: []Board{},
+			query: []queryParam{includeArchivedParam}},
+		{name: "createBoard", method: "POST", path: "/boards", handler: tm.CreateBoardHandler, auth: true, tag: "boards",
+			summary: "Create a board", request: Board{}, response: Board{},
+			query: []queryParam{{name: "template", description: "ID of a saved board template or key of a built-in one"}}},
+		{name: "updateBoard", method: "PUT", path: "/boards/{id}", handler: tm.UpdateBoardHandler, auth: true, tag: "boards",
+			summary: "Rename a board or change its background", request: Board{}, response: Board{}},
+		{name: "deleteBoard", method: "DELETE", path: "/boards/{id}", handler: tm.DeleteBoardHandler, auth: true, tag: "boards",
+			summary: "Delete a board with its containers and tasks"},
+		{name: "cloneBoard", method: "POST", path: "/boards/{id}/clone", handler: tm.CloneBoardHandler, auth: true, tag: "boards",
+			summary: "Copy a board with its containers, tasks, swimlanes, rules and links", request: CloneRequest{}, response: Board{}, status: http.StatusCreated},
+		{name: "getBoardSummary", method: "GET", path: "/boards/{id}/summary", handler: tm.GetBoardSummaryHandler, auth: true, tag: "boards",
+			summary: "Total task counts and estimates per container", response: BoardSummary{}},
+		{name: "archiveBoard", method: "POST", path: "/boards/{id}/archive", handler: tm.ArchiveBoardHandler, auth: true, tag: "boards",
+			summary: "Archive a board, hiding it from listings without deleting it", response: Board{}},
+		{name: "unarchiveBoard", method: "POST", path: "/boards/{id}/unarchive", handler: tm.UnarchiveBoardHandler, auth: true, tag: "boards",
+			summary: "Restore an archived board", response: Board{}},
+
+		{name: "listContainers", method: "GET", path: "/boards/{id}/containers", handler: tm.GetContainersHandler, auth: true, tag: "containers",
+			summary: "List the containers of a board", response: []Container{},
+			query: []queryParam{includeArchivedParam}},
+		{name: "createContainer", method: "POST", path: "/boards/{id}/containers", handler: tm.CreateContainerHandler, auth: true, tag: "containers",
+			summary: "Add a container to a board", request: Container{}, response: Container{}},
+		{name: "updateContainer", method: "PUT", path: "/containers/{id}", handler: tm.UpdateContainerHandler, auth: true, tag: "containers",
+			summary: "Rename a container", request: Container{}},
+		{name: "deleteContainer", method: "DELETE", path: "/containers/{id}", handler: tm.DeleteContainerHandler, auth: true, tag: "containers",
+			summary: "Delete a container and its tasks"},
+		{name: "cloneContainer", method: "POST", path: "/containers/{id}/clone", handler: tm.CloneContainerHandler, auth: true, tag: "containers",
+			summary: "Copy a container and its tasks, optionally onto another board", request: CloneRequest{}, response: Container{}, status: http.StatusCreated},
+		{name: "archiveContainer", method: "POST", path: "/containers/{id}/archive", handler: tm.ArchiveContainerHandler, auth: true, tag: "containers",
+			summary: "Archive a container, hiding it and its tasks from listings", response: Container{}},
+		{name: "unarchiveContainer", method: "POST", path: "/containers/{id}/unarchive", handler: tm.UnarchiveContainerHandler, auth: true, tag: "containers",
+			summary: "Restore an archived container", response: Container{}},
+
+		{name: "listSwimlanes", method: "GET", path: "/boards/{id}/swimlanes", handler: tm.GetSwimlanesHandler, auth: true, tag: "swimlanes",
+			summary: "List the swimlanes of a board in display order", response: []Swimlane{}},
+		{name: "createSwimlane", method: "POST", path: "/boards/{id}/swimlanes", handler: tm.CreateSwimlaneHandler, auth: true, tag: "swimlanes",
+			summary: "Add a swimlane at the bottom of a board", request: Swimlane{}, response: Swimlane{}, status: http.StatusCreated},
+		{name: "reorderSwimlanes", method: "PUT", path: "/boards/{id}/swimlanes/order", handler: tm.ReorderSwimlanesHandler, auth: true, tag: "swimlanes",
+			summary: "Reorder the swimlanes of a board", request: SwimlaneOrder{}, response: []Swimlane{}},
+		{name: "updateSwimlane", method: "PUT", path: "/swimlanes/{id}", handler: tm.UpdateSwimlaneHandler, auth: true, tag: "swimlanes",
+			summary: "Rename a swimlane", request: Swimlane{}, response: Swimlane{}},
+		{name: "deleteSwimlane", method: "DELETE", path: "/swimlanes/{id}", handler: tm.DeleteSwimlaneHandler, auth: true, tag: "swimlanes",
+			summary: "Delete a swimlane, keeping its tasks"},
+
+		{name: "listCustomFields", method: "GET", path: "/boards/{id}/custom-fields", handler: tm.GetCustomFieldsHandler, auth: true, tag: "custom-fields",
+			summary: "List the custom fields of a board", response: []CustomField{}},
+		{name: "createCustomField", method: "POST", path: "/boards/{id}/custom-fields", handler: tm.CreateCustomFieldHandler, auth: true, tag: "custom-fields",
+			summary: "Add a custom field to a board's tasks", request: CustomField{}, response: CustomField{}, status: http.StatusCreated},
+		{name: "updateCustomField", method: "PUT", path: "/custom-fields/{id}", handler: tm.UpdateCustomFieldHandler, auth: true, tag: "custom-fields",
+			summary: "Rename a custom field or change its options", request: CustomField{}, response: CustomField{}},
+		{name: "deleteCustomField", method: "DELETE", path: "/custom-fields/{id}", handler: tm.DeleteCustomFieldHandler, auth: true, tag: "custom-fields",
+			summary: "Delete a custom field and its values"},
+
+		{name: "searchTasks", method: "GET", path: "/tasks/search", handler: tm.SearchTasksHandler, auth: true, tag: "filters",
+			summary: "List the caller's tasks matching a task query", response: TaskPage{},
+			query: append([]queryParam{{name: "q", description: "task query, e.g. board:\"Sprint\" label:bug assignee:me due<7d -completed"}}, pageParams...)},
+		{name: "listFilters", method: "GET", path: "/filters", handler: tm.GetFiltersHandler, auth: true, tag: "filters",
+			summary: "List the caller's saved filters by name", response: []SavedFilter{}},
+		{name: "createFilter", method: "POST", path: "/filters", handler: tm.CreateFilterHandler, auth: true, tag: "filters",
+			summary: "Save a task query as a named filter", request: SavedFilter{}, response: SavedFilter{}, status: http.StatusCreated},
+		{name: "getFilter", method: "GET", path: "/filters/{id}", handler: tm.GetFilterHandler, auth: true, tag: "filters",
+			summary: "Get a saved filter", response: SavedFilter{}},
+		{name: "updateFilter", method: "PUT", path: "/filters/{id}", handler: tm.UpdateFilterHandler, auth: true, tag: "filters",
+			summary: "Rename a saved filter or change its query", request: SavedFilter{}, response: SavedFilter{}},
+		{name: "deleteFilter", method: "DELETE", path: "/filters/{id}", handler: tm.DeleteFilterHandler, auth: true, tag: "filters",
+			summary: "Delete a saved filter"},
+		{name: "getFilterTasks", method: "GET", path: "/filters/{id}/tasks", handler: tm.GetFilterTasksHandler, auth: true, tag: "filters",
+			summary: "List the caller's tasks matching a saved filter, soonest due first", response: TaskPage{}, query: pageParams},
+
+		{name: "getTimeline", method: "GET", path: "/timeline", handler: tm.GetTimelineHandler, auth: true, tag: "timeline",
+			summary: "Lay out the caller's tasks by start and due date for calendar and timeline views", response: Timeline{},
+			query: []queryParam{
+				{name: "from", description: "first day, formatted as YYYY-MM-DD; defaults to today"},
+				{name: "to", description: "last day, formatted as YYYY-MM-DD; defaults to 29 days after from"},
+				{name: "group_by", description: "board (default) or assignee"},
+				{name: "board_id", description: "only list tasks on this board", typ: "integer"},
+				{name: "assignee_id", description: "only list tasks assigned to this user", typ: "integer"},
+				{name: "hide_completed", description: "leave out completed tasks", typ: "boolean"},
+			}},
+
+		{name: "listSprints", method: "GET", path: "/boards/{id}/sprints", handler: tm.GetSprintsHandler, auth: true, tag: "sprints",
+			summary: "List the sprints of a board by start date", response: []Sprint{},
+			query: []queryParam{{name: "state", description: "only list planned, active or completed sprints"}}},
+		{name: "createSprint", method: "POST", path: "/boards/{id}/sprints", handler: tm.CreateSprintHandler, auth: true, tag: "sprints",
+			summary: "Plan a sprint on a board", request: Sprint{}, response: Sprint{}, status: http.StatusCreated},
+		{name: "getSprint", method: "GET", path: "/sprints/{id}", handler: tm.GetSprintHandler, auth: true, tag: "sprints",
+			summary: "Get a sprint", response: Sprint{}},
+		{name: "updateSprint", method: "PUT", path: "/sprints/{id}", handler: tm.UpdateSprintHandler, auth: true, tag: "sprints",
+			summary: "Change a sprint's name, goal or dates", request: Sprint{}, response: Sprint{}},
+		{name: "deleteSprint", method: "DELETE", path: "/sprints/{id}", handler: tm.DeleteSprintHandler, auth: true, tag: "sprints",
+			summary: "Delete a sprint, moving its tasks to the backlog"},
+		{name: "startSprint", method: "POST", path: "/sprints/{id}/start", handler: tm.StartSprintHandler, auth: true, tag: "sprints",
+			summary: "Start a planned sprint, committing to its current tasks", response: Sprint{}},
+		{name: "completeSprint", method: "POST", path: "/sprints/{id}/complete", handler: tm.CompleteSprintHandler, auth: true, tag: "sprints",
+			summary: "Complete the active sprint, carrying unfinished tasks over", request: CompleteSprintRequest{}, response: SprintReport{}},
+		{name: "getSprintReport", method: "GET", path: "/sprints/{id}/report", handler: tm.GetSprintReportHandler, auth: true, tag: "sprints",
+			summary: "Compare a sprint's committed and completed tasks and estimates", response: SprintReport{}},
+
+		{name: "listBoardTemplates", method: "GET", path: "/board-templates", handler: tm.GetBoardTemplatesHandler, auth: true, tag: "templates",
+			summary: "List the built-in and saved board templates", response: []BoardTemplate{}},
+		{name: "saveBoardTemplate", method: "POST", path: "/boards/{id}/template", handler: tm.SaveBoardTemplateHandler, auth: true, tag: "templates",
+			summary: "Save a board's layout as a template", request: SaveBoardTemplateRequest{}, response: BoardTemplate{}, status: http.StatusCreated},
+		{name: "updateBoardTemplate", method: "PUT", path: "/board-templates/{id}", handler: tm.UpdateBoardTemplateHandler, auth: true, tag: "templates",
+			summary: "Rename a board template", request: SaveBoardTemplateRequest{}, response: BoardTemplate{}},
+		{name: "deleteBoardTemplate", method: "DELETE", path: "/board-templates/{id}", handler: tm.DeleteBoardTemplateHandler, auth: true, tag: "templates",
+			summary: "Delete a board template"},
+		{name: "listTaskTemplates", method: "GET", path: "/task-templates", handler: tm.GetTaskTemplatesHandler, auth: true, tag: "templates",
+			summary: "List the caller's task templates", response: []TaskTemplate{}},
+		{name: "createTaskTemplate", method: "POST", path: "/task-templates", handler: tm.CreateTaskTemplateHandler, auth: true, tag: "templates",
+			summary: "Create a task template", request: TaskTemplate{}, response: TaskTemplate{}, status: http.StatusCreated},
+		{name: "updateTaskTemplate", method: "PUT", path: "/task-templates/{id}", handler: tm.UpdateTaskTemplateHandler, auth: true, tag: "templates",
+			summary: "Update a task template", request: TaskTemplate{}, response: TaskTemplate{}},
+		{name: "deleteTaskTemplate", method: "DELETE", path: "/task-templates/{id}", handler: tm.DeleteTaskTemplateHandler, auth: true, tag: "templates",
+			summary: "Delete a task template"},
+
+		{name: "listTasks", method: "GET", path: "/containers/{id}/tasks", handler: tm.GetTasksHandler, auth: true, tag: "tasks",
+			summary: "List the tasks of a container", response: []Task{},
+			query: []queryParam{
+				includeArchivedParam,
+				{name: "cf.{field}", description: "filter by a custom field; numbers and dates take a <, <=, > or >= prefix"},
+				{name: "sort", description: "priority, due_date, estimate or cf.{field}, prefixed with - to reverse; defaults to the container's sort mode"},
+			}},
+		{name: "createTask", method: "POST", path: "/containers/{id}/tasks", handler: tm.CreateTaskHandler, auth: true, tag: "tasks",
+			summary: "Add a task to a container", request: Task{}, response: Task{},
+			query: []queryParam{{name: "template", description: "ID of a task template to prefill the task from", typ: "integer"}}},
+		{name: "updateTask", method: "PUT", path: "/tasks/{id}", handler: tm.UpdateTaskHandler, auth: true, tag: "tasks",
+			summary: "Update a task", request: Task{}, response: Task{}},
+		{name: "moveTask", method: "POST", path: "/tasks/{id}/move", handler: tm.MoveTaskHandler, auth: true, tag: "tasks",
+			summary: "Move a task to another container", request: MoveTaskRequest{}, response: Task{}},
+		{name: "deleteTask", method: "DELETE", path: "/tasks/{id}", handler: tm.DeleteTaskHandler, auth: true, tag: "tasks",
+			summary: "Delete a task"},
+		{name: "cloneTask", method: "POST", path: "/tasks/{id}/clone", handler: tm.CloneTaskHandler, auth: true, tag: "tasks",
+			summary: "Copy a task, optionally into another container", request: CloneRequest{}, response: Task{}, status: http.StatusCreated},
+		{name: "bulkTasks", method: "POST", path: "/tasks/bulk", handler: tm.BulkTasksHandler, auth: true, tag: "tasks",
+			summary: "Apply one operation to many tasks, all or nothing", request: BulkRequest{}, response: BulkResult{}},
+		{name: "archiveTask", method: "POST", path: "/tasks/{id}/archive", handler: tm.ArchiveTaskHandler, auth: true, tag: "tasks",
+			summary: "Archive a task, hiding it from listings without deleting it", response: Task{}},
+		{name: "unarchiveTask", method: "POST", path: "/tasks/{id}/unarchive", handler: tm.UnarchiveTaskHandler, auth: true, tag: "tasks",
+			summary: "Restore an archived task", response: Task{}},
+
+		{name: "listComments", method: "GET", path: "/tasks/{id}/comments", handler: tm.GetCommentsHandler, auth: true, tag: "tasks",
+			summary: "List the comments on a task", response: []TaskComment{}},
+		{name: "createComment", method: "POST", path: "/tasks/{id}/comments", handler: tm.CreateCommentHandler, auth: true, tag: "tasks",
+			summary: "Comment on a task", request: TaskComment{}, response: TaskComment{}, status: http.StatusCreated},
+		{name: "listLinks", method: "GET", path: "/tasks/{id}/links", handler: tm.GetLinksHandler, auth: true, tag: "tasks",
+			summary: "List a task's dependencies and related tasks", response: []TaskLink{}},
+		{name: "createLink", method: "POST", path: "/tasks/{id}/links", handler: tm.CreateLinkHandler, auth: true, tag: "tasks",
+			summary: "Link a task to another task", request: TaskLink{}, response: TaskLink{}, status: http.StatusCreated},
+		{name: "deleteLink", method: "DELETE", path: "/tasks/{id}/links/{linkID}", handler: tm.DeleteLinkHandler, auth: true, tag: "tasks",
+			summary: "Remove a link between two tasks"},
+
+		{name: "listArchive", method: "GET", path: "/archive", handler: tm.GetArchiveHandler, auth: true, tag: "archive",
+			summary: "Browse the caller's archived boards, containers and tasks", response: ArchivePage{},
+			query: []queryParam{
+				{name: "type", description: "board, container or task"},
+				{name: "board_id", description: "only list items on this board", typ: "integer"},
+				{name: "q", description: "text the title must contain"},
+				{name: "limit", description: "page size, at most 200 (default 50)", typ: "integer"},
+				{name: "offset", description: "number of items to skip", typ: "integer"},
+			}},
+
+		{name: "getUserData", method: "GET", path: "/user-data", handler: uh.GetUserData, auth: true, tag: "user-data",
+			summary: "Load every board, container and task of the caller", response: UserData{}},
+		{name: "syncBoard", method: "POST", path: "/update-user-data", handler: uh.UpdateUserData, auth: true, tag: "user-data",
+			summary: "Replace a board's containers and tasks with the client's snapshot", request: SyncData{}},
+	}
+}
+
+// registerRoutes mounts routes on r, wrapping authenticated ones in
+// authMiddleware. Path variables only match numeric IDs, and OPTIONS is
+// accepted on every path for CORS preflight.
+func registerRoutes(r *mux.Router, routes []route) {
+	for _, rt := range routes {
+		var h http.HandlerFunc = rt.handler
+		if rt.auth {
+			h = authMiddleware(h)
+		}
+		path := strings.ReplaceAll(rt.path, "}", ":[0-9]+}")
+		r.HandleFunc(path, h).Methods(rt.method, "OPTIONS")
+	}
+}
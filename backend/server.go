@@ -21,6 +21,7 @@ func CORSMiddleware(next http.Handler) http.Handler {
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, X-WIP-Warning")
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(204)
@@ -35,14 +36,13 @@ func authMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
-			log.Println("auth header not set")
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			writeError(w, r, newDomainError(ErrUnauthorized, "authorization header not set"))
 			return
 		}
 
 		authParts := strings.Split(authHeader, " ")
 		if len(authParts) != 2 || authParts[0] != "Bearer" {
-			http.Error(w, "Invalid authorization header", http.StatusBadRequest)
+			writeError(w, r, badRequestError("invalid authorization header"))
 			return
 		}
 
@@ -50,7 +50,7 @@ func authMiddleware(next http.HandlerFunc) http.HandlerFunc {
 
 		userID, err := getUserIDFromToken(token)
 		if err != nil {
-			http.Error(w, "Invalid token", http.StatusBadRequest)
+			writeError(w, r, newDomainError(ErrUnauthorized, "invalid token"))
 			return
 		}
 		ctx := r.Context()
@@ -84,33 +84,64 @@ func main() {
 	}
 	defer db.Close()
 
+	err = migrate(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, _ := newServer(ctx, db, LogMailer{})
+
+	log.Println("Server listening on port 8000")
+	log.Fatal(http.ListenAndServeTLS("localhost:8000", "ssl/certificate.crt", "ssl/private.key", r))
+}
+
+// newServer wires the managers to db and returns the router serving every
+// route, along with the route table. Notification emails go out through
+// mailer. Background workers run until ctx is cancelled.
+func newServer(ctx context.Context, db *sqlx.DB, mailer Mailer) (*mux.Router, []route) {
 	tm := NewTaskManager(db)
 	uh := NewUserHandler(db, tm)
+	wm := NewWebhookManager(db, tm)
+	ae := NewAutomationEngine(db, tm)
+	rm := NewRecurrenceManager(db, tm)
+	tt := NewTimeTracker(db, tm)
+	an := NewAnalytics(db, tm)
+	cf := NewCalendarFeeds(db, tm)
+	ec := NewEmailChannel(db, mailer)
+	nt := NewNotifier(db, tm, NewInAppChannel(db), ec)
+	tm.Subscribe(wm)
+	tm.Subscribe(ae)
+	tm.Subscribe(rm)
+	tm.Subscribe(an)
+	tm.Subscribe(nt)
+
+	go wm.Run(ctx)
+	go ae.Run(ctx)
+	go rm.Run(ctx)
+	go nt.Run(ctx)
+	go ec.Run(ctx)
 
 	r := mux.NewRouter()
 
 	// r.Use(authMiddleware)
+	r.Use(requestIDMiddleware)
 	r.Use(CORSMiddleware)
 
-	r.HandleFunc("/boards", authMiddleware(tm.GetBoardsHandler))                       //.Methods("GET")
-	r.HandleFunc("/boards", authMiddleware(tm.CreateBoardHandler))                     //.Methods("POST")
-	r.HandleFunc("/boards/{id}", authMiddleware(tm.UpdateBoardHandler))                //.Methods("PUT")
-	r.HandleFunc("/boards/{id}", authMiddleware(tm.DeleteBoardHandler))                //.Methods("DELETE")
-	r.HandleFunc("/boards/{id}/containers", authMiddleware(tm.GetContainersHandler))   //.Methods("GET")
-	r.HandleFunc("/boards/{id}/containers", authMiddleware(tm.CreateContainerHandler)) //.Methods("POST")
-	r.HandleFunc("/containers/{id}", authMiddleware(tm.UpdateContainerHandler))        //.Methods("PUT")
-	r.HandleFunc("/containers/{id}", authMiddleware(tm.DeleteContainerHandler))        //.Methods("DELETE")
-	r.HandleFunc("/containers/{id}/tasks", authMiddleware(tm.GetTasksHandler))         //.Methods("GET")
-	r.HandleFunc("/containers/{id}/tasks", authMiddleware(tm.CreateTaskHandler))       //.Methods("POST")
-	r.HandleFunc("/tasks/{id}", authMiddleware(tm.UpdateTaskHandler))                  //.Methods("PUT")
-	r.HandleFunc("/tasks/{id}", authMiddleware(tm.DeleteTaskHandler))                  //.Methods("DELETE")
-
-	r.HandleFunc("/signup", uh.signupHandler)                            //.Methods("POST")
-	r.HandleFunc("/login", uh.loginHandler)                              //.Methods("POST")
-	r.HandleFunc("/logout", uh.logoutHandler)                            //.Methods("POST")
-	r.HandleFunc("/user-data", authMiddleware(uh.GetUserData))           //.Methods("GET")
-	r.HandleFunc("/update-user-data", authMiddleware(uh.UpdateUserData)) //.Methods("POST")
-
-	log.Println("Server listening on port 8000")
-	log.Fatal(http.ListenAndServeTLS("localhost:8000", "ssl/certificate.crt", "ssl/private.key", r))
+	routes := apiRoutes(tm, uh)
+	routes = append(routes, wm.routes()...)
+	routes = append(routes, ae.routes()...)
+	routes = append(routes, rm.routes()...)
+	routes = append(routes, tt.routes()...)
+	routes = append(routes, an.routes()...)
+	routes = append(routes, cf.routes()...)
+	routes = append(routes, nt.routes()...)
+	registerRoutes(r, routes)
+
+	oh := NewOpenAPIHandler(routes)
+	r.HandleFunc("/openapi.json", oh.SpecHandler).Methods("GET", "OPTIONS")
+	r.HandleFunc("/docs", oh.DocsHandler).Methods("GET")
+
+	return r, routes
 }
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// testDatabaseEnv names the variable holding the Postgres DSN used by tests
+// that need a database. They are skipped when it is unset.
+const testDatabaseEnv = "TASKAPP_TEST_DATABASE_URL"
+
+// openTestDB migrates a fresh schema on the test database and drops it when
+// the test ends, so tests neither see nor leave behind each other's rows.
+func openTestDB(t *testing.T) *sqlx.DB {
+	t.Helper()
+
+	dsn := os.Getenv(testDatabaseEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", testDatabaseEnv)
+	}
+
+	admin, err := sqlx.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := fmt.Sprintf("taskapp_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		admin.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		admin.Close()
+	})
+
+	db, err := sqlx.Open("postgres", withSearchPath(dsn, schema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// withSearchPath points every connection opened with dsn at schema. lib/pq
+// passes unknown parameters on to the server as run-time settings.
+func withSearchPath(dsn, schema string) string {
+	if u, err := url.Parse(dsn); err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql") {
+		q := u.Query()
+		q.Set("search_path", schema)
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+	return strings.TrimSpace(dsn) + " search_path=" + schema
+}
+
+type testServer struct {
+	*httptest.Server
+	db     *sqlx.DB
+	routes []route
+	mailer *MemoryMailer
+}
+
+// newTestServer serves the full API on a test database, keeping sent emails
+// in memory. Background workers stop when the test ends.
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	db := openTestDB(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	mailer := &MemoryMailer{}
+	r, routes := newServer(ctx, db, mailer)
+	srv := httptest.NewServer(r)
+	t.Cleanup(func() {
+		srv.Close()
+		cancel()
+	})
+
+	return &testServer{Server: srv, db: db, routes: routes, mailer: mailer}
+}
+
+// do sends body as JSON with token as the bearer token, if any. The caller
+// closes the response body.
+func (ts *testServer) do(t *testing.T, method, path, token string, body interface{}) *http.Response {
+	t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, ts.URL+path, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// call is do for requests expected to succeed; it decodes the JSON response
+// into out, if given.
+func (ts *testServer) call(t *testing.T, method, path, token string, body, out interface{}) {
+	t.Helper()
+
+	resp := ts.do(t, method, path, token, body)
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(resp.Body)
+		t.Fatalf("%s %s: %s: %s", method, path, resp.Status, data)
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: decoding response: %v", method, path, err)
+		}
+	}
+}
+
+// signup creates an account named username and returns its login.
+func (ts *testServer) signup(t *testing.T, username string) LoginResponse {
+	t.Helper()
+
+	resp := ts.do(t, "POST", "/signup", "", SignupData{
+		Username: username,
+		Email:    username + "@example.com",
+		Password: "correct horse battery",
+	})
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("signup %s: %s: %s", username, resp.Status, body)
+	}
+
+	var login LoginResponse
+	if err := json.NewDecoder(resp.Body).Decode(&login); err != nil {
+		t.Fatal(err)
+	}
+	return login
+}
@@ -0,0 +1,516 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	SprintPlanned   = "planned"
+	SprintActive    = "active"
+	SprintCompleted = "completed"
+)
+
+// Sprint is an iteration of a board. Sprints start out planned, at most one
+// per board is active at a time, and completing one carries its unfinished
+// tasks over to CarriedOverTo, or back to the backlog when that is null.
+type Sprint struct {
+	ID            int        `json:"id" db:"id"`
+	BoardID       int        `json:"board_id" db:"board_id"`
+	Name          string     `json:"name" db:"name" validate:"required,max=100"`
+	Goal          string     `json:"goal" db:"goal" validate:"max=1000"`
+	StartsOn      string     `json:"starts_on" db:"starts_on"`
+	EndsOn        string     `json:"ends_on" db:"ends_on"`
+	State         string     `json:"state" db:"state"`
+	StartedAt     *time.Time `json:"started_at" db:"started_at"`
+	CompletedAt   *time.Time `json:"completed_at" db:"completed_at"`
+	CarriedOverTo *int       `json:"carried_over_to" db:"carried_over_to"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+}
+
+// CompleteSprintRequest picks the sprint unfinished tasks move to. Without
+// one they go to the board's next planned sprint by start date, if any.
+type CompleteSprintRequest struct {
+	NextSprintID int  `json:"next_sprint_id,omitempty"`
+	ToBacklog    bool `json:"to_backlog,omitempty"`
+}
+
+// SprintReportTask is one task of a sprint report. Committed tasks were in
+// the sprint when it started; InSprint tells whether the task was still in
+// it at the end, or is now for sprints that have not ended.
+type SprintReportTask struct {
+	TaskID            int      `json:"task_id" db:"task_id"`
+	Title             string   `json:"title" db:"title"`
+	Committed         bool     `json:"committed" db:"committed"`
+	CommittedEstimate *float64 `json:"committed_estimate" db:"committed_estimate"`
+	InSprint          bool     `json:"in_sprint" db:"in_sprint"`
+	Completed         bool     `json:"completed" db:"completed"`
+	Estimate          *float64 `json:"estimate" db:"estimate"`
+}
+
+type SprintTotals struct {
+	Tasks    int     `json:"tasks"`
+	Estimate float64 `json:"estimate"`
+}
+
+// SprintReport compares what a sprint committed to with what it delivered.
+// Committed estimates are those at the sprint's start; the others are
+// current, or as of completion for completed sprints.
+type SprintReport struct {
+	Sprint     Sprint             `json:"sprint"`
+	Committed  SprintTotals       `json:"committed"`
+	Added      SprintTotals       `json:"added"`
+	Removed    SprintTotals       `json:"removed"`
+	Completed  SprintTotals       `json:"completed"`
+	Unfinished SprintTotals       `json:"unfinished"`
+	Tasks      []SprintReportTask `json:"tasks"`
+}
+
+func (tm *TaskManager) GetSprintsHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+	boardID := mux.Vars(r)["id"]
+
+	state := r.URL.Query().Get("state")
+	if state != "" && state != SprintPlanned && state != SprintActive && state != SprintCompleted {
+		writeError(w, r, validationError([]FieldError{{Field: "state", Message: "must be one of planned, active, completed"}}, "invalid query"))
+		return
+	}
+
+	err := tm.checkBoardOwnership(userID, boardID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	sprints := []Sprint{}
+	err = tm.db.Select(&sprints, `
+		SELECT * FROM sprints WHERE board_id = $1
+		AND ($2::text = '' OR state = $2::text)
+		ORDER BY NULLIF(starts_on, '') NULLS LAST, id
+	`, boardID, state)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sprints)
+}
+
+func (tm *TaskManager) CreateSprintHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+	boardID := mux.Vars(r)["id"]
+
+	var sprint Sprint
+	err := decodeJSON(w, r, &sprint)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tm.checkBoardOwnership(userID, boardID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = validateSprintDates(sprint)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tm.db.Get(&sprint, `
+		INSERT INTO sprints (board_id, name, goal, starts_on, ends_on)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING *
+	`, boardID, sprint.Name, sprint.Goal, sprint.StartsOn, sprint.EndsOn)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(sprint)
+}
+
+func (tm *TaskManager) GetSprintHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	sprint, err := tm.getSprint(userID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sprint)
+}
+
+// UpdateSprintHandler changes a sprint's name, goal and dates. The state
+// only changes through the start and complete operations.
+func (tm *TaskManager) UpdateSprintHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	var data Sprint
+	err := decodeJSON(w, r, &data)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	sprint, err := tm.getSprint(userID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = validateSprintDates(data)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tm.db.Get(&sprint, `
+		UPDATE sprints SET name = $1, goal = $2, starts_on = $3, ends_on = $4
+		WHERE id = $5 RETURNING *
+	`, data.Name, data.Goal, data.StartsOn, data.EndsOn, sprint.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sprint)
+}
+
+// DeleteSprintHandler deletes a sprint; its tasks go back to the backlog.
+func (tm *TaskManager) DeleteSprintHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	sprint, err := tm.getSprint(userID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	_, err = tm.db.Exec("DELETE FROM sprints WHERE id = $1", sprint.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// StartSprintHandler activates a planned sprint and records its tasks and
+// their estimates as the sprint's commitment.
+func (tm *TaskManager) StartSprintHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	sprint, err := tm.getSprint(userID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if sprint.State != SprintPlanned {
+		writeError(w, r, conflictError("sprint %d is %s, only planned sprints can be started", sprint.ID, sprint.State))
+		return
+	}
+
+	tx, err := tm.db.Beginx()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// The partial unique index on active sprints rejects a second one.
+	err = tx.Get(&sprint, `
+		UPDATE sprints SET state = 'active', started_at = now(),
+			starts_on = CASE WHEN starts_on = '' THEN to_char(now(), 'YYYY-MM-DD') ELSE starts_on END
+		WHERE id = $1 AND state = 'planned' RETURNING *
+	`, sprint.ID)
+	if isUniqueViolation(err) {
+		writeError(w, r, conflictError("board %d already has an active sprint", sprint.BoardID))
+		return
+	}
+	if err == sql.ErrNoRows {
+		writeError(w, r, conflictError("sprint %d is no longer planned", sprint.ID))
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO sprint_tasks (sprint_id, task_id, committed, committed_estimate)
+		SELECT $1, id, true, estimate FROM tasks WHERE sprint_id = $1 AND archived_at IS NULL
+	`, sprint.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sprint)
+}
+
+// CompleteSprintHandler ends the active sprint, records how each of its
+// tasks ended up, carries the unfinished ones over and returns the report.
+func (tm *TaskManager) CompleteSprintHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	var req CompleteSprintRequest
+	if r.ContentLength != 0 {
+		err := decodeJSON(w, r, &req)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+
+	sprint, err := tm.getSprint(userID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if sprint.State != SprintActive {
+		writeError(w, r, conflictError("sprint %d is %s, only the active sprint can be completed", sprint.ID, sprint.State))
+		return
+	}
+
+	var next *int
+	switch {
+	case req.ToBacklog:
+	case req.NextSprintID != 0:
+		var nextSprint Sprint
+		err = tm.db.Get(&nextSprint, "SELECT * FROM sprints WHERE id = $1", req.NextSprintID)
+		if err == sql.ErrNoRows || (err == nil && (nextSprint.BoardID != sprint.BoardID || nextSprint.State != SprintPlanned)) {
+			writeError(w, r, validationError([]FieldError{{Field: "next_sprint_id", Message: "must be a planned sprint of the same board"}}, "invalid sprint"))
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		next = &nextSprint.ID
+	default:
+		var id int
+		err = tm.db.Get(&id, `
+			SELECT id FROM sprints WHERE board_id = $1 AND state = 'planned'
+			ORDER BY NULLIF(starts_on, '') NULLS LAST, id LIMIT 1
+		`, sprint.BoardID)
+		if err != nil && err != sql.ErrNoRows {
+			writeError(w, r, err)
+			return
+		}
+		if err == nil {
+			next = &id
+		}
+	}
+
+	tx, err := tm.db.Beginx()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	err = tx.Get(&sprint, `
+		UPDATE sprints SET state = 'completed', completed_at = now(), carried_over_to = $2
+		WHERE id = $1 AND state = 'active' RETURNING *
+	`, sprint.ID, next)
+	if err == sql.ErrNoRows {
+		writeError(w, r, conflictError("sprint %d is no longer active", sprint.ID))
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO sprint_tasks (sprint_id, task_id, in_sprint, completed, estimate)
+		SELECT $1, id, true, completed, estimate FROM tasks WHERE sprint_id = $1 AND archived_at IS NULL
+		ON CONFLICT (sprint_id, task_id) DO UPDATE
+		SET in_sprint = true, completed = EXCLUDED.completed, estimate = EXCLUDED.estimate
+	`, sprint.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var carried []Task
+	err = tx.Select(&carried, `
+		UPDATE tasks SET sprint_id = $2 WHERE sprint_id = $1 AND NOT completed AND archived_at IS NULL
+		RETURNING `+taskColumns, sprint.ID, next)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	for _, task := range carried {
+		tm.emit(r, EventTaskUpdated, sprint.BoardID, task)
+	}
+
+	report, err := tm.sprintReport(sprint)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+func (tm *TaskManager) GetSprintReportHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	sprint, err := tm.getSprint(userID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	report, err := tm.sprintReport(sprint)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// sprintReport lists the sprint's committed tasks together with the tasks in
+// it now, or at completion once it is completed.
+func (tm *TaskManager) sprintReport(sprint Sprint) (SprintReport, error) {
+	report := SprintReport{Sprint: sprint, Tasks: []SprintReportTask{}}
+
+	query := `
+		SELECT t.id AS task_id, t.title, COALESCE(st.committed, false) AS committed, st.committed_estimate,
+			t.sprint_id IS NOT DISTINCT FROM $1 AND t.archived_at IS NULL AS in_sprint, t.completed, t.estimate
+		FROM tasks t LEFT JOIN sprint_tasks st ON st.task_id = t.id AND st.sprint_id = $1
+		WHERE (t.sprint_id = $1 AND t.archived_at IS NULL) OR st.task_id IS NOT NULL
+		ORDER BY t.id
+	`
+	if sprint.State == SprintCompleted {
+		query = `
+			SELECT t.id AS task_id, t.title, st.committed, st.committed_estimate, st.in_sprint, st.completed, st.estimate
+			FROM sprint_tasks st JOIN tasks t ON t.id = st.task_id
+			WHERE st.sprint_id = $1
+			ORDER BY t.id
+		`
+	}
+	err := tm.db.Select(&report.Tasks, query, sprint.ID)
+	if err != nil {
+		return report, err
+	}
+
+	for _, task := range report.Tasks {
+		switch {
+		case task.Committed && !task.InSprint:
+			report.Removed.add(task.CommittedEstimate)
+		case !task.Committed && task.InSprint && sprint.State != SprintPlanned:
+			report.Added.add(task.Estimate)
+		}
+		if task.Committed {
+			report.Committed.add(task.CommittedEstimate)
+		}
+		if task.InSprint && task.Completed {
+			report.Completed.add(task.Estimate)
+		} else if task.InSprint {
+			report.Unfinished.add(task.Estimate)
+		}
+	}
+	return report, nil
+}
+
+func (t *SprintTotals) add(estimate *float64) {
+	t.Tasks++
+	if estimate != nil {
+		t.Estimate += *estimate
+	}
+}
+
+// getSprint loads a sprint and checks that userID owns its board.
+func (tm *TaskManager) getSprint(userID int, sprintID string) (Sprint, error) {
+	var sprint Sprint
+	err := tm.db.Get(&sprint, "SELECT * FROM sprints WHERE id = $1", sprintID)
+	if err == sql.ErrNoRows {
+		return sprint, notFoundError("sprint %s not found", sprintID)
+	}
+	if err != nil {
+		return sprint, err
+	}
+	return sprint, tm.checkBoardOwnership(userID, strconv.Itoa(sprint.BoardID))
+}
+
+// checkSprint checks that a task's sprint, if any, is a sprint of the board
+// of its container that has not been completed.
+func (tm *TaskManager) checkSprint(task Task, boardID int) error {
+	if task.SprintID == nil {
+		return nil
+	}
+	var sprint Sprint
+	err := tm.db.Get(&sprint, "SELECT * FROM sprints WHERE id = $1", *task.SprintID)
+	if err == sql.ErrNoRows || (err == nil && sprint.BoardID != boardID) {
+		return validationError([]FieldError{{Field: "sprint_id", Message: "must be a sprint of the task's board"}}, "invalid task")
+	}
+	if err == nil && sprint.State == SprintCompleted {
+		return validationError([]FieldError{{Field: "sprint_id", Message: "must not be a completed sprint"}}, "invalid task")
+	}
+	return err
+}
+
+func validateSprintDates(sprint Sprint) error {
+	var fieldErrs []FieldError
+	var start, end time.Time
+	var err error
+	if sprint.StartsOn != "" {
+		start, err = time.Parse("2006-01-02", sprint.StartsOn)
+		if err != nil {
+			fieldErrs = append(fieldErrs, FieldError{Field: "starts_on", Message: "must be formatted as YYYY-MM-DD"})
+		}
+	}
+	if sprint.EndsOn != "" {
+		end, err = time.Parse("2006-01-02", sprint.EndsOn)
+		if err != nil {
+			fieldErrs = append(fieldErrs, FieldError{Field: "ends_on", Message: "must be formatted as YYYY-MM-DD"})
+		}
+	}
+	if len(fieldErrs) == 0 && !start.IsZero() && !end.IsZero() && end.Before(start) {
+		fieldErrs = append(fieldErrs, FieldError{Field: "ends_on", Message: "must not be before starts_on"})
+	}
+	if len(fieldErrs) > 0 {
+		return validationError(fieldErrs, "invalid sprint")
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// Swimlane is a horizontal band across a board's containers, used to group
+// tasks by team, epic or priority. Tasks without a swimlane sit outside
+// every lane.
+type Swimlane struct {
+	ID        int       `json:"id" db:"id"`
+	BoardID   int       `json:"board_id" db:"board_id"`
+	Title     string    `json:"title" db:"title" validate:"required,max=100"`
+	Position  int       `json:"position" db:"position"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+type SwimlaneOrder struct {
+	SwimlaneIDs []int `json:"swimlane_ids" validate:"required"`
+}
+
+func (tm *TaskManager) GetSwimlanesHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+	boardID := mux.Vars(r)["id"]
+
+	err := tm.checkBoardOwnership(userID, boardID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	swimlanes, err := tm.getSwimlanes(boardID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(swimlanes)
+}
+
+func (tm *TaskManager) CreateSwimlaneHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+	boardID := mux.Vars(r)["id"]
+
+	var swimlane Swimlane
+	err := decodeJSON(w, r, &swimlane)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tm.checkBoardOwnership(userID, boardID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// New swimlanes go to the bottom of the board.
+	err = tm.db.Get(&swimlane, `
+		INSERT INTO swimlanes (board_id, title, position)
+		SELECT $1, $2, COALESCE(MAX(position) + 1, 0) FROM swimlanes WHERE board_id = $1
+		RETURNING *
+	`, boardID, swimlane.Title)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(swimlane)
+}
+
+func (tm *TaskManager) UpdateSwimlaneHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	var data Swimlane
+	err := decodeJSON(w, r, &data)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	swimlane, err := tm.getSwimlane(userID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tm.db.Get(&swimlane, "UPDATE swimlanes SET title = $1 WHERE id = $2 RETURNING *", data.Title, swimlane.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(swimlane)
+}
+
+func (tm *TaskManager) DeleteSwimlaneHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	swimlane, err := tm.getSwimlane(userID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Tasks in the lane are kept; the foreign key clears their swimlane_id.
+	_, err = tm.db.Exec("DELETE FROM swimlanes WHERE id = $1", swimlane.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// ReorderSwimlanesHandler sets the order of a board's swimlanes. The body
+// must list every swimlane of the board exactly once.
+func (tm *TaskManager) ReorderSwimlanesHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+	boardID := mux.Vars(r)["id"]
+
+	var order SwimlaneOrder
+	err := decodeJSON(w, r, &order)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tm.checkBoardOwnership(userID, boardID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	swimlanes, err := tm.getSwimlanes(boardID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	remaining := map[int]bool{}
+	for _, swimlane := range swimlanes {
+		remaining[swimlane.ID] = true
+	}
+	for _, id := range order.SwimlaneIDs {
+		if !remaining[id] {
+			writeError(w, r, validationError([]FieldError{{Field: "swimlane_ids", Message: "must list each of the board's swimlanes once"}}, "invalid order"))
+			return
+		}
+		delete(remaining, id)
+	}
+	if len(remaining) > 0 {
+		writeError(w, r, validationError([]FieldError{{Field: "swimlane_ids", Message: "must list each of the board's swimlanes once"}}, "invalid order"))
+		return
+	}
+
+	// array_position gives each swimlane its 1-based index in the list.
+	ids := make([]int64, len(order.SwimlaneIDs))
+	for i, id := range order.SwimlaneIDs {
+		ids[i] = int64(id)
+	}
+	_, err = tm.db.Exec(`
+		UPDATE swimlanes SET position = array_position($1::integer[], id) - 1
+		WHERE board_id = $2
+	`, pq.Int64Array(ids), boardID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	swimlanes, err = tm.getSwimlanes(boardID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(swimlanes)
+}
+
+func (tm *TaskManager) getSwimlanes(boardID string) ([]Swimlane, error) {
+	swimlanes := []Swimlane{}
+	err := tm.db.Select(&swimlanes, "SELECT * FROM swimlanes WHERE board_id = $1 ORDER BY position, id", boardID)
+	return swimlanes, err
+}
+
+// getSwimlane loads a swimlane and checks that userID owns its board.
+func (tm *TaskManager) getSwimlane(userID int, swimlaneID string) (Swimlane, error) {
+	var swimlane Swimlane
+	err := tm.db.Get(&swimlane, "SELECT * FROM swimlanes WHERE id = $1", swimlaneID)
+	if err == sql.ErrNoRows {
+		return swimlane, notFoundError("swimlane %s not found", swimlaneID)
+	}
+	if err != nil {
+		return swimlane, err
+	}
+	return swimlane, tm.checkBoardOwnership(userID, strconv.Itoa(swimlane.BoardID))
+}
+
+// checkSwimlane checks that a task's swimlane, if any, belongs to the board
+// of its container.
+func (tm *TaskManager) checkSwimlane(task Task, boardID int) error {
+	if task.SwimlaneID == nil {
+		return nil
+	}
+	var swimlaneBoardID int
+	err := tm.db.Get(&swimlaneBoardID, "SELECT board_id FROM swimlanes WHERE id = $1", *task.SwimlaneID)
+	if err == sql.ErrNoRows || (err == nil && swimlaneBoardID != boardID) {
+		return validationError([]FieldError{{Field: "swimlane_id", Message: "must be a swimlane of the task's board"}}, "invalid task")
+	}
+	return err
+}
@@ -1,41 +1,124 @@
 package main
 
 import (
+	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
-	"log"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 type Board struct {
-	ID           int    `json:"id"`
-	UserID       int    `json:"user_id"`
-	Title        string `json:"title"`
-	Background   string `json:"background"`
-	ContainerIDs []int  `json:"container_ids"`
+	ID         int    `json:"id" db:"id"`
+	UserID     int    `json:"user_id" db:"user_id"`
+	Title      string `json:"title" db:"title" validate:"required,max=100"`
+	Background string `json:"background" db:"background" validate:"max=255"`
+	// EnforceBlockers refuses to complete tasks with incomplete blockers.
+	EnforceBlockers bool           `json:"enforce_blockers" db:"enforce_blockers"`
+	Labels          pq.StringArray `json:"labels" db:"labels" validate:"max=50"`
+	ArchivedAt      *time.Time     `json:"archived_at" db:"archived_at"`
+	ContainerIDs    []int          `json:"container_ids"`
+	SwimlaneIDs     []int          `json:"swimlane_ids"`
 }
 
+// boardColumns lists the boards columns in Board field order.
+const boardColumns = "id, user_id, title, background, enforce_blockers, labels, archived_at"
+
 type Container struct {
 	ID      int    `json:"id" db:"id"`
 	BoardID int    `json:"board_id" db:"board_id"`
-	Title   string `json:"title" db:"title"`
-	TaskIDs []int  `json:"task_ids"`
+	Title   string `json:"title" db:"title" validate:"required,max=100"`
+	// WIPLimit caps the number of tasks in the container. In "warn" mode
+	// exceeding it is only flagged; in "enforce" mode it is refused.
+	WIPLimit   *int       `json:"wip_limit" db:"wip_limit" validate:"min=1,max=1000"`
+	WIPMode    string     `json:"wip_mode" db:"wip_mode" validate:"oneof=warn|enforce"`
+	SortMode   string     `json:"sort_mode" db:"sort_mode" validate:"oneof=manual|priority|due_date"`
+	ArchivedAt *time.Time `json:"archived_at" db:"archived_at"`
+	TaskIDs    []int      `json:"task_ids"`
 }
 
+// containerColumns lists the containers columns in Container field order.
+const containerColumns = "id, board_id, title, wip_limit, wip_mode, sort_mode, archived_at"
+
 type Task struct {
-	ID          int    `json:"id"`
-	ContainerID int    `json:"container_id"`
-	Title       string `json:"title"`
-	Description string `json:"description"`
-	Completed   bool   `json:"completed"`
+	ID          int            `json:"id" db:"id"`
+	ContainerID int            `json:"container_id" db:"container_id"`
+	Title       string         `json:"title" db:"title" validate:"required,max=200"`
+	Description string         `json:"description" db:"description" validate:"max=5000"`
+	Completed   bool           `json:"completed" db:"completed"`
+	Priority    string         `json:"priority" db:"priority" validate:"oneof=none|low|medium|high|urgent"`
+	Estimate    *float64       `json:"estimate" db:"estimate" validate:"min=0,max=1000"`
+	Labels      pq.StringArray `json:"labels" db:"labels" validate:"max=20"`
+	AssigneeID  *int           `json:"assignee_id" db:"assignee_id"`
+	StartsAt    *time.Time     `json:"starts_at" db:"starts_at"`
+	DueAt       *time.Time     `json:"due_at" db:"due_at"`
+	SwimlaneID  *int           `json:"swimlane_id" db:"swimlane_id"`
+	SprintID    *int           `json:"sprint_id" db:"sprint_id"`
+	Checklist   Checklist      `json:"checklist" db:"checklist" validate:"max=100"`
+	// CustomFields is left unchanged by updates that omit it or send null.
+	CustomFields CustomFieldValues `json:"custom_fields" db:"custom_fields"`
+	ArchivedAt   *time.Time        `json:"archived_at" db:"archived_at"`
+	Blocked      bool              `json:"blocked" db:"blocked"`
+}
+
+type ChecklistItem struct {
+	Text string `json:"text" validate:"required,max=200"`
+	Done bool   `json:"done"`
+}
+
+// Checklist is stored as a JSONB array on the task.
+type Checklist []ChecklistItem
+
+func (c Checklist) Value() (driver.Value, error) {
+	if c == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(c)
+}
+
+func (c *Checklist) Scan(src interface{}) error { return scanJSON(src, c) }
+
+// taskColumns lists the tasks columns in Task field order. blocked is
+// computed by blockedExpr.
+const taskColumns = "id, container_id, title, description, completed, priority, estimate, labels, assignee_id, starts_at, due_at, swimlane_id, sprint_id, checklist, custom_fields, archived_at, " +
+	blockedExpr + " AS blocked"
+
+// blockedExpr is true while any task blocking the task is incomplete.
+const blockedExpr = "EXISTS (SELECT 1 FROM task_links bl JOIN tasks bt ON bt.id = bl.task_id " +
+	"WHERE bl.kind = 'blocks' AND bl.other_task_id = tasks.id AND NOT bt.completed)"
+
+// uncheckedChecklist copies the checklist column with every item not done.
+const uncheckedChecklist = `COALESCE((SELECT jsonb_agg(item || '{"done": false}') FROM jsonb_array_elements(checklist) item), '[]')`
+
+// moveTaskQuery moves task $2 to container $1. The task keeps its swimlane
+// and sprint only if they belong to the destination board.
+const moveTaskQuery = "UPDATE tasks SET container_id = $1, swimlane_id = (" +
+	"SELECT s.id FROM swimlanes s JOIN containers c ON c.board_id = s.board_id " +
+	"WHERE s.id = tasks.swimlane_id AND c.id = $1), sprint_id = (" +
+	"SELECT s.id FROM sprints s JOIN containers c ON c.board_id = s.board_id " +
+	"WHERE s.id = tasks.sprint_id AND c.id = $1), custom_fields = " + keptCustomFields +
+	" WHERE id = $2 RETURNING " + taskColumns
+
+type MoveTaskRequest struct {
+	ContainerID int `json:"container_id" validate:"required"`
+}
+
+// TaskMove is the payload of task.moved events.
+type TaskMove struct {
+	Task            Task `json:"task"`
+	FromContainerID int  `json:"from_container_id"`
 }
 
 type TaskManager struct {
-	db *sqlx.DB
+	db        *sqlx.DB
+	listeners []EventListener
 }
 
 func NewTaskManager(db *sqlx.DB) *TaskManager {
@@ -46,27 +129,18 @@ func (tm *TaskManager) GetBoardsHandler(w http.ResponseWriter, r *http.Request)
 
 	userID := r.Context().Value("userID").(int)
 
-	rows, err := tm.db.Query("SELECT * FROM boards WHERE user_id = $1", userID)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer rows.Close()
-
 	boards := []Board{}
-
-	for rows.Next() {
-		board := Board{}
-		err := rows.Scan(&board.ID, &board.UserID, &board.Title, &board.Background, &board.ContainerIDs)
-		if err != nil {
-			log.Fatal(err)
-		}
-		boards = append(boards, board)
+	err := tm.db.Select(&boards, "SELECT "+boardColumns+" FROM boards WHERE user_id = $1 AND ($2 OR archived_at IS NULL)", userID, includeArchived(r))
+	if err != nil {
+		writeError(w, r, err)
+		return
 	}
 
 	for i := range boards {
 		containers, err := tm.getContainersForBoard(boards[i].ID)
 		if err != nil {
-			log.Fatal(err)
+			writeError(w, r, err)
+			return
 		}
 		boards[i].ContainerIDs = containers
 
@@ -87,18 +161,31 @@ func (tm *TaskManager) CreateBoardHandler(w http.ResponseWriter, r *http.Request
 
 	userID := r.Context().Value("userID").(int)
 
-	board := Board{}
-	err := json.NewDecoder(r.Body).Decode(&board)
+	// A template prefills the board; fields in the body take precedence.
+	content := BoardTemplateContent{}
+	if ref := r.URL.Query().Get("template"); ref != "" {
+		var err error
+		content, err = tm.boardTemplate(userID, ref)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+
+	board := Board{Background: content.Background, Labels: content.Labels}
+	err := decodeJSON(w, r, &board)
 	if err != nil {
-		board.Title = r.Context().Value("title").(string)
-		board.Background = r.Context().Value("background").(string)
+		writeError(w, r, err)
+		return
 	}
 
-	var id int
-	tm.db.QueryRow("INSERT INTO boards (user_id, title, background) VALUES ($1, $2, $3) RETURNING id", userID, board.Title, board.Background).Scan(&id)
+	board, err = tm.instantiateBoard(userID, board, content)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 
-	board.ID = int(id)
-	board.UserID = userID
+	tm.emit(r, EventBoardCreated, board.ID, board)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(board)
@@ -112,30 +199,34 @@ func (tm *TaskManager) UpdateBoardHandler(w http.ResponseWriter, r *http.Request
 	boardID := vars["id"]
 
 	board := Board{}
-	err := json.NewDecoder(r.Body).Decode(&board)
+	err := decodeJSON(w, r, &board)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 
-	err = tm.checkBoardOwnership(userID, boardID)
+	board.ID, err = strconv.Atoi(boardID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusForbidden)
+		writeError(w, r, badRequestError("invalid board id %q", boardID))
 		return
 	}
+	board.UserID = userID
 
-	_, err = tm.db.Exec("UPDATE boards SET title = $1, background = $2 WHERE id = $3", board.Title, board.Background, boardID)
+	err = tm.checkBoardOwnership(userID, boardID)
 	if err != nil {
-		log.Fatal(err)
+		writeError(w, r, err)
+		return
 	}
 
-	board.ID, err = strconv.Atoi(boardID)
-	board.UserID = userID
+	_, err = tm.db.Exec("UPDATE boards SET title = $1, background = $2, enforce_blockers = $3, labels = $4 WHERE id = $5",
+		board.Title, board.Background, board.EnforceBlockers, normalizeBoardLabels(board.Labels), boardID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 
+	tm.emit(r, EventBoardUpdated, board.ID, board)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(board)
 }
@@ -149,18 +240,31 @@ func (tm *TaskManager) DeleteBoardHandler(w http.ResponseWriter, r *http.Request
 
 	err := tm.checkBoardOwnership(userID, boardID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusForbidden)
+		writeError(w, r, err)
+		return
+	}
+
+	var board Board
+	err = tm.db.Get(&board, "SELECT "+boardColumns+" FROM boards WHERE id = $1", boardID)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
 	err = tm.deleteContainersForBoard(boardID)
 	if err != nil {
-		log.Fatal(err)
+		writeError(w, r, err)
+		return
 	}
 
+	// Webhook subscriptions go with the board, so listeners hear about the
+	// deletion while they can still be matched.
+	tm.emit(r, EventBoardDeleted, board.ID, board)
+
 	_, err = tm.db.Exec("DELETE FROM boards WHERE id = $1", boardID)
 	if err != nil {
-		log.Fatal(err)
+		writeError(w, r, err)
+		return
 	}
 
 	w.WriteHeader(http.StatusOK)
@@ -168,30 +272,29 @@ func (tm *TaskManager) DeleteBoardHandler(w http.ResponseWriter, r *http.Request
 
 func (tm *TaskManager) GetContainersHandler(w http.ResponseWriter, r *http.Request) {
 
+	userID := r.Context().Value("userID").(int)
+
 	vars := mux.Vars(r)
 	boardID := vars["id"]
 
-	rows, err := tm.db.Query("SELECT * FROM containers WHERE board_id = $1", boardID)
+	err := tm.checkBoardOwnership(userID, boardID)
 	if err != nil {
-		log.Fatal(err)
+		writeError(w, r, err)
+		return
 	}
-	defer rows.Close()
 
 	containers := []Container{}
-
-	for rows.Next() {
-		container := Container{}
-		err := rows.Scan(&container.ID, &container.BoardID, &container.Title, &container.TaskIDs)
-		if err != nil {
-			log.Fatal(err)
-		}
-		containers = append(containers, container)
+	err = tm.db.Select(&containers, "SELECT "+containerColumns+" FROM containers WHERE board_id = $1 AND ($2 OR archived_at IS NULL)", boardID, includeArchived(r))
+	if err != nil {
+		writeError(w, r, err)
+		return
 	}
 
 	for i := range containers {
 		tasks, err := tm.getTasksForContainer(containers[i].ID)
 		if err != nil {
-			log.Fatal(err)
+			writeError(w, r, err)
+			return
 		}
 		containers[i].TaskIDs = tasks
 	}
@@ -202,185 +305,483 @@ func (tm *TaskManager) GetContainersHandler(w http.ResponseWriter, r *http.Reque
 
 func (tm *TaskManager) CreateContainerHandler(w http.ResponseWriter, r *http.Request) {
 
+	userID := r.Context().Value("userID").(int)
+
 	vars := mux.Vars(r)
 	boardID := vars["id"]
 
 	container := Container{}
-	err := json.NewDecoder(r.Body).Decode(&container)
+	err := decodeJSON(w, r, &container)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 
-	result, err := tm.db.Exec("INSERT INTO containers (board_id, title) VALUES ($1, $2)", boardID, container.Title)
+	container.BoardID, err = strconv.Atoi(boardID)
 	if err != nil {
-		log.Fatal(err)
+		writeError(w, r, badRequestError("invalid board id %q", boardID))
+		return
 	}
 
-	id, err := result.LastInsertId()
+	err = tm.checkBoardOwnership(userID, boardID)
 	if err != nil {
-		log.Fatal(err)
+		writeError(w, r, err)
+		return
 	}
-	container.ID = int(id)
-	container.BoardID, err = strconv.Atoi(boardID)
+
+	if container.WIPMode == "" {
+		container.WIPMode = WIPWarn
+	}
+	if container.SortMode == "" {
+		container.SortMode = SortManual
+	}
+
+	err = tm.db.QueryRow("INSERT INTO containers (board_id, title, wip_limit, wip_mode, sort_mode) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		boardID, container.Title, container.WIPLimit, container.WIPMode, container.SortMode).Scan(&container.ID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 
+	tm.emit(r, EventContainerCreated, container.BoardID, container)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(container)
 }
 
 func (tm *TaskManager) UpdateContainerHandler(w http.ResponseWriter, r *http.Request) {
 
+	userID := r.Context().Value("userID").(int)
+	containerID := mux.Vars(r)["id"]
+
 	var containerData Container
-	err := json.NewDecoder(r.Body).Decode(&containerData)
+	err := decodeJSON(w, r, &containerData)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	_, err = tm.checkContainerAccess(userID, containerID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 
-	_, err = tm.db.Exec("UPDATE containers SET title = $1 WHERE id = $1", containerData.Title, containerData.ID)
+	if containerData.WIPMode == "" {
+		containerData.WIPMode = WIPWarn
+	}
+	if containerData.SortMode == "" {
+		containerData.SortMode = SortManual
+	}
+
+	err = tm.db.QueryRow("UPDATE containers SET title = $1, wip_limit = $2, wip_mode = $3, sort_mode = $4 WHERE id = $5 RETURNING id, board_id",
+		containerData.Title, containerData.WIPLimit, containerData.WIPMode, containerData.SortMode, containerID).Scan(&containerData.ID, &containerData.BoardID)
+	if err == sql.ErrNoRows {
+		writeError(w, r, notFoundError("container %s not found", containerID))
+		return
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
+	tm.emit(r, EventContainerUpdated, containerData.BoardID, containerData)
+
 	w.WriteHeader(http.StatusOK)
 }
 
 func (tm *TaskManager) DeleteContainerHandler(w http.ResponseWriter, r *http.Request) {
 
+	userID := r.Context().Value("userID").(int)
 	containerID := mux.Vars(r)["id"]
 
-	_, err := tm.db.Exec("DELETE FROM tasks WHERE container_id = $1", containerID)
+	container, err := tm.checkContainerAccess(userID, containerID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	_, err = tm.db.Exec("DELETE FROM tasks WHERE container_id = $1", containerID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
-	_, err = tm.db.Exec("DELETE FROM containers WHERE id = $!", containerID)
+	_, err = tm.db.Exec("DELETE FROM containers WHERE id = $1", containerID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
+	tm.emit(r, EventContainerDeleted, container.BoardID, container)
+
 	w.WriteHeader(http.StatusOK)
 }
 
+// GetTasksHandler lists a container's tasks in the container's sort mode,
+// optionally filtered by the board's custom fields (see customFieldQuery)
+// and sorted by a sort parameter.
 func (tm *TaskManager) GetTasksHandler(w http.ResponseWriter, r *http.Request) {
 
-	containerID := r.URL.Query().Get("container_id")
+	userID := r.Context().Value("userID").(int)
 
-	var tasks []Task
-	err := tm.db.Select(&tasks, "SELECT * FROM tasks WHERE container_id = $1", containerID)
+	containerID, err := strconv.Atoi(mux.Vars(r)["id"])
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, badRequestError("invalid container id %q", mux.Vars(r)["id"]))
 		return
 	}
 
-	var response []Task
-	for _, task := range tasks {
-		response = append(response, Task{
-			ID:          task.ID,
-			Title:       task.Title,
-			Description: task.Description,
-			Completed:   task.Completed,
-		})
+	container, err := tm.checkContainerAccess(userID, strconv.Itoa(containerID))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	fields, err := tm.getCustomFields(strconv.Itoa(container.BoardID))
+	if err != nil {
+		writeError(w, r, err)
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(response)
+	query := "SELECT " + taskColumns + " FROM tasks WHERE container_id = $1 AND ($2 OR archived_at IS NULL)"
+	where, order, args, err := customFieldQuery(fields, r.URL.Query(), []interface{}{containerID, includeArchived(r)})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if order != "" {
+		order += ", tasks.id"
+	} else {
+		order, err = taskSortOrder(r.URL.Query().Get("sort"), container.SortMode)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+	if where != "" {
+		query += " AND " + where
+	}
+	query += " ORDER BY " + order
+
+	tasks := []Task{}
+	err = tm.db.Select(&tasks, query, args...)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tasks)
 }
 
 func (tm *TaskManager) CreateTaskHandler(w http.ResponseWriter, r *http.Request) {
 
+	userID := r.Context().Value("userID").(int)
+
+	// A task template prefills the task; fields in the body take precedence,
+	// so "{}" is enough to create a task straight from the template.
 	var taskData Task
-	err := json.NewDecoder(r.Body).Decode(&taskData)
+	if ref := r.URL.Query().Get("template"); ref != "" {
+		template, err := tm.getTaskTemplate(userID, ref)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		taskData = template.task()
+	}
+
+	err := decodeJSON(w, r, &taskData)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 
-	result, err := tm.db.Exec("INSERT INTO tasks (title, description, completed, container_id) VALUES ($1, $2, $3, $4)", taskData.Title, taskData.Description, taskData.Completed, taskData.ContainerID)
+	if containerID := mux.Vars(r)["id"]; containerID != "" {
+		taskData.ContainerID, err = strconv.Atoi(containerID)
+		if err != nil {
+			writeError(w, r, badRequestError("invalid container id %q", containerID))
+			return
+		}
+	}
+
+	boardID, err := tm.boardIDForContainer(taskData.ContainerID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
-	taskID, err := result.LastInsertId()
+	err = tm.checkBoardOwnership(userID, strconv.Itoa(boardID))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
-	response := Task{
-		ID:          int(taskID),
-		Title:       taskData.Title,
-		Description: taskData.Description,
-		Completed:   taskData.Completed,
-		ContainerID: taskData.ContainerID,
+	err = normalizeLabels(&taskData)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	err = checkTaskDates(taskData)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if taskData.Priority == "" {
+		taskData.Priority = PriorityNone
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(response)
+	err = tm.checkSwimlane(taskData, boardID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tm.checkSprint(taskData, boardID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	taskData.CustomFields, err = tm.checkCustomFields(taskData.CustomFields, boardID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	tx, err := tm.db.Beginx()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
+	defer tx.Rollback()
+
+	violation, err := tm.checkWIP(tx, taskData.ContainerID, 1)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var response Task
+	err = tx.Get(&response, `
+		INSERT INTO tasks (title, description, completed, priority, estimate, container_id, labels, assignee_id, starts_at, due_at, swimlane_id, sprint_id, checklist, custom_fields)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		RETURNING `+taskColumns,
+		taskData.Title, taskData.Description, taskData.Completed, taskData.Priority, taskData.Estimate, taskData.ContainerID, taskData.Labels, taskData.AssigneeID, taskData.StartsAt, taskData.DueAt, taskData.SwimlaneID, taskData.SprintID, taskData.Checklist, taskData.CustomFields)
+	if isForeignKeyViolation(err) {
+		writeError(w, r, validationError([]FieldError{{Field: "assignee_id", Message: "unknown user"}}, "invalid task"))
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	tm.emit(r, EventTaskCreated, boardID, response)
+	if response.AssigneeID != nil {
+		tm.emit(r, EventTaskAssigned, boardID, response)
+	}
+
+	flagWIP(w, violation)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
 }
 
 func (tm *TaskManager) UpdateTaskHandler(w http.ResponseWriter, r *http.Request) {
 
+	userID := r.Context().Value("userID").(int)
+	taskID := mux.Vars(r)["id"]
+
 	var taskData Task
-	err := json.NewDecoder(r.Body).Decode(&taskData)
+	err := decodeJSON(w, r, &taskData)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	previous, err := tm.checkTaskAccess(userID, taskID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = normalizeLabels(&taskData)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	err = checkTaskDates(taskData)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if taskData.Priority == "" {
+		taskData.Priority = PriorityNone
+	}
+
+	if taskData.Completed {
+		err = tm.checkCompletable(previous)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+
+	boardID, err := tm.boardIDForContainer(previous.ContainerID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 
-	_, err = tm.db.Exec("UPDATE tasks SET title = $1, description = $2, completed = $3 WHERE id = $4", taskData.Title, taskData.Description, taskData.Completed, taskData.ID)
+	err = tm.checkSwimlane(taskData, boardID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
-	response := Task{
-		ID:          taskData.ID,
-		Title:       taskData.Title,
-		Description: taskData.Description,
-		Completed:   taskData.Completed,
-		ContainerID: taskData.ContainerID,
+	// Tasks completed in a sprint stay in it after it is completed.
+	if !sameID(taskData.SprintID, previous.SprintID) {
+		err = tm.checkSprint(taskData, boardID)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+
+	if taskData.CustomFields == nil {
+		taskData.CustomFields = previous.CustomFields
+	}
+	taskData.CustomFields, err = tm.checkCustomFields(taskData.CustomFields, boardID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var response Task
+	err = tm.db.Get(&response, `
+		UPDATE tasks SET title = $1, description = $2, completed = $3, priority = $4, estimate = $5, labels = $6, assignee_id = $7, starts_at = $8, due_at = $9, swimlane_id = $10, sprint_id = $11, checklist = $12, custom_fields = $13
+		WHERE id = $14
+		RETURNING `+taskColumns,
+		taskData.Title, taskData.Description, taskData.Completed, taskData.Priority, taskData.Estimate, taskData.Labels, taskData.AssigneeID, taskData.StartsAt, taskData.DueAt, taskData.SwimlaneID, taskData.SprintID, taskData.Checklist, taskData.CustomFields, previous.ID)
+	if isForeignKeyViolation(err) {
+		writeError(w, r, validationError([]FieldError{{Field: "assignee_id", Message: "unknown user"}}, "invalid task"))
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	tm.emit(r, EventTaskUpdated, boardID, response)
+	if response.Completed && !previous.Completed {
+		tm.emit(r, EventTaskCompleted, boardID, response)
+	}
+	if response.AssigneeID != nil && !sameID(response.AssigneeID, previous.AssigneeID) {
+		tm.emit(r, EventTaskAssigned, boardID, response)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(response)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (tm *TaskManager) MoveTaskHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+	taskID := mux.Vars(r)["id"]
+
+	var req MoveTaskRequest
+	err := decodeJSON(w, r, &req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	previous, err := tm.checkTaskAccess(userID, taskID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	boardID, err := tm.boardIDForContainer(req.ContainerID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tm.checkBoardOwnership(userID, strconv.Itoa(boardID))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	tx, err := tm.db.Beginx()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	var violation *WIPViolation
+	if req.ContainerID != previous.ContainerID {
+		violation, err = tm.checkWIP(tx, req.ContainerID, 1)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+
+	var task Task
+	err = tx.Get(&task, moveTaskQuery, req.ContainerID, previous.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	err = tx.Commit()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
+
+	tm.emit(r, EventTaskMoved, boardID, TaskMove{Task: task, FromContainerID: previous.ContainerID})
+
+	flagWIP(w, violation)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(task)
 }
 
 func (tm *TaskManager) DeleteTaskHandler(w http.ResponseWriter, r *http.Request) {
 
+	userID := r.Context().Value("userID").(int)
 	taskID := mux.Vars(r)["id"]
 
-	_, err := tm.db.Exec("DELETE FROM tasks WHERE id = $1", taskID)
+	task, err := tm.checkTaskAccess(userID, taskID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
+	boardID, err := tm.boardIDForContainer(task.ContainerID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	_, err = tm.db.Exec("DELETE FROM tasks WHERE id = $1", taskID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	tm.emit(r, EventTaskDeleted, boardID, task)
+
 	w.WriteHeader(http.StatusOK)
 }
 
+// getContainersForBoard returns the IDs of the board's unarchived containers.
 func (tm *TaskManager) getContainersForBoard(boardID int) ([]int, error) {
 
-	rows, err := tm.db.Query("SELECT id FROM containers WHERE board_id = $1", boardID)
+	rows, err := tm.db.Query("SELECT id FROM containers WHERE board_id = $1 AND archived_at IS NULL", boardID)
 	if err != nil {
 		return nil, err
 	}
@@ -400,9 +801,17 @@ func (tm *TaskManager) getContainersForBoard(boardID int) ([]int, error) {
 	return containerIDs, nil
 }
 
+// getTasksForContainer returns the IDs of the container's unarchived tasks,
+// in the container's sort mode.
 func (tm *TaskManager) getTasksForContainer(containerID int) ([]int, error) {
 
-	rows, err := tm.db.Query("SELECT id FROM tasks WHERE container_id = $1", containerID)
+	var sortMode string
+	err := tm.db.Get(&sortMode, "SELECT sort_mode FROM containers WHERE id = $1", containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tm.db.Query("SELECT id FROM tasks WHERE container_id = $1 AND archived_at IS NULL ORDER BY "+taskOrder(sortMode), containerID)
 	if err != nil {
 		return nil, err
 	}
@@ -457,9 +866,104 @@ func (tm *TaskManager) deleteTasksForContainer(containerID int) error {
 	return nil
 }
 
+// normalizeLabels trims and de-duplicates task.Labels, rejecting empty or
+// overlong labels.
+func normalizeLabels(task *Task) error {
+	labels := pq.StringArray{}
+	seen := map[string]bool{}
+	for i, label := range task.Labels {
+		label = strings.TrimSpace(label)
+		if label == "" || len([]rune(label)) > 50 {
+			return validationError([]FieldError{{
+				Field:   fmt.Sprintf("labels[%d]", i),
+				Message: "must be between 1 and 50 characters",
+			}}, "invalid task")
+		}
+		if !seen[label] {
+			seen[label] = true
+			labels = append(labels, label)
+		}
+	}
+	task.Labels = labels
+	return nil
+}
+
+// sameID reports whether two optional IDs are equal.
+func sameID(a, b *int) bool {
+	return a == nil && b == nil || a != nil && b != nil && *a == *b
+}
+
+func (tm *TaskManager) getTask(taskID string) (Task, error) {
+	var task Task
+	err := tm.db.Get(&task, "SELECT "+taskColumns+" FROM tasks WHERE id = $1", taskID)
+	if err == sql.ErrNoRows {
+		return task, notFoundError("task %s not found", taskID)
+	}
+	return task, err
+}
+
+func (tm *TaskManager) boardIDForContainer(containerID int) (int, error) {
+	var boardID int
+	err := tm.db.Get(&boardID, "SELECT board_id FROM containers WHERE id = $1", containerID)
+	if err == sql.ErrNoRows {
+		return 0, notFoundError("container %d not found", containerID)
+	}
+	return boardID, err
+}
+
+// checkTaskAccess loads a task and checks that userID owns its board.
+func (tm *TaskManager) checkTaskAccess(userID int, taskID string) (Task, error) {
+	task, err := tm.getTask(taskID)
+	if err != nil {
+		return task, err
+	}
+	boardID, err := tm.boardIDForContainer(task.ContainerID)
+	if err != nil {
+		return task, err
+	}
+	return task, tm.checkBoardOwnership(userID, strconv.Itoa(boardID))
+}
+
+// checkContainerAccess loads a container and checks that userID owns its
+// board.
+func (tm *TaskManager) checkContainerAccess(userID int, containerID string) (Container, error) {
+	var container Container
+	err := tm.db.Get(&container, "SELECT "+containerColumns+" FROM containers WHERE id = $1", containerID)
+	if err == sql.ErrNoRows {
+		return container, notFoundError("container %s not found", containerID)
+	}
+	if err != nil {
+		return container, err
+	}
+	return container, tm.checkBoardOwnership(userID, strconv.Itoa(container.BoardID))
+}
+
+// checkCompletable refuses to complete a blocked task on boards that
+// enforce blockers.
+func (tm *TaskManager) checkCompletable(task Task) error {
+	if !task.Blocked || task.Completed {
+		return nil
+	}
+	var enforce bool
+	err := tm.db.Get(&enforce, `
+		SELECT b.enforce_blockers FROM boards b JOIN containers c ON c.board_id = b.id
+		WHERE c.id = $1
+	`, task.ContainerID)
+	if err != nil {
+		return err
+	}
+	if enforce {
+		return conflictError("task %d is blocked by incomplete tasks", task.ID)
+	}
+	return nil
+}
+
 func (tm *TaskManager) checkBoardOwnership(userID int, boardID string) error {
 	var ownerID int
 	err := tm.db.QueryRow("SELECT user_id FROM boards WHERE id = $1", boardID).Scan(&ownerID)
+	if err == sql.ErrNoRows {
+		return notFoundError("board %s not found", boardID)
+	}
 	if err != nil {
 		return err
 	}
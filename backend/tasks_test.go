@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestBoardContentsAreOwnerOnly(t *testing.T) {
+	ts := newTestServer(t)
+	owner := ts.signup(t, "owner")
+	intruder := ts.signup(t, "intruder")
+
+	var board Board
+	ts.call(t, "POST", "/boards", owner.Token, Board{Title: "Private"}, &board)
+	var container Container
+	ts.call(t, "POST", "/boards/"+strconv.Itoa(board.ID)+"/containers", owner.Token, Container{Title: "Todo"}, &container)
+	var task Task
+	ts.call(t, "POST", "/containers/"+strconv.Itoa(container.ID)+"/tasks", owner.Token, Task{Title: "Secret"}, &task)
+
+	boardPath := "/boards/" + strconv.Itoa(board.ID)
+	containerPath := "/containers/" + strconv.Itoa(container.ID)
+	taskPath := "/tasks/" + strconv.Itoa(task.ID)
+	tests := []struct {
+		method, path string
+		body         interface{}
+	}{
+		{"GET", boardPath + "/containers", nil},
+		{"POST", boardPath + "/containers", Container{Title: "Mine now"}},
+		{"PUT", containerPath, Container{Title: "Mine now"}},
+		{"DELETE", containerPath, nil},
+		{"GET", containerPath + "/tasks", nil},
+		{"POST", containerPath + "/tasks", Task{Title: "Planted"}},
+		{"PUT", taskPath, Task{Title: "Mine now"}},
+		{"DELETE", taskPath, nil},
+	}
+	for _, tt := range tests {
+		resp := ts.do(t, tt.method, tt.path, intruder.Token, tt.body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("%s %s by another user: %s, want 403", tt.method, tt.path, resp.Status)
+		}
+	}
+
+	for _, path := range []string{"/containers/999999", "/tasks/999999"} {
+		resp := ts.do(t, "DELETE", path, owner.Token, nil)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("DELETE %s: %s, want 404", path, resp.Status)
+		}
+	}
+
+	// Nothing the intruder tried went through.
+	var tasks []Task
+	ts.call(t, "GET", containerPath+"/tasks", owner.Token, nil, &tasks)
+	if len(tasks) != 1 || tasks[0].Title != "Secret" {
+		t.Errorf("tasks after the intrusion = %+v, want only the original", tasks)
+	}
+	var containers []Container
+	ts.call(t, "GET", boardPath+"/containers", owner.Token, nil, &containers)
+	for _, c := range containers {
+		if c.Title == "Mine now" {
+			t.Errorf("the intruder changed container %d", c.ID)
+		}
+	}
+}
+
+func TestWIPLimitHoldsUnderConcurrentCreates(t *testing.T) {
+	ts := newTestServer(t)
+	owner := ts.signup(t, "owner")
+
+	var board Board
+	ts.call(t, "POST", "/boards", owner.Token, Board{Title: "Busy"}, &board)
+	limit := 3
+	var container Container
+	ts.call(t, "POST", "/boards/"+strconv.Itoa(board.ID)+"/containers", owner.Token, Container{Title: "Doing"}, &container)
+	container.WIPLimit, container.WIPMode = &limit, WIPEnforce
+	ts.call(t, "PUT", "/containers/"+strconv.Itoa(container.ID), owner.Token, container, nil)
+
+	const attempts = 10
+	statuses := make(chan int, attempts)
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			body := strings.NewReader(`{"title": "Task ` + strconv.Itoa(i) + `"}`)
+			req, _ := http.NewRequest("POST", ts.URL+"/containers/"+strconv.Itoa(container.ID)+"/tasks", body)
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer "+owner.Token)
+			resp, err := ts.Client().Do(req)
+			if err != nil {
+				statuses <- 0
+				return
+			}
+			resp.Body.Close()
+			statuses <- resp.StatusCode
+		}(i)
+	}
+	wg.Wait()
+	close(statuses)
+
+	created := 0
+	for status := range statuses {
+		switch status {
+		case http.StatusOK:
+			created++
+		case http.StatusConflict:
+		default:
+			t.Errorf("concurrent create: status %d, want 200 or 409", status)
+		}
+	}
+	var tasks []Task
+	ts.call(t, "GET", "/containers/"+strconv.Itoa(container.ID)+"/tasks", owner.Token, nil, &tasks)
+	if created != limit || len(tasks) != limit {
+		t.Errorf("created %d tasks (%d listed) under a limit of %d", created, len(tasks), limit)
+	}
+}
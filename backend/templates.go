@@ -0,0 +1,527 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// signupBoardTemplate is the built-in template of the board every new user
+// starts with.
+const signupBoardTemplate = "kanban"
+
+// BoardTemplate is a reusable board layout. Saved templates belong to a
+// user and are addressed by ID; built-in templates have no owner and are
+// addressed by Key.
+type BoardTemplate struct {
+	ID          int                  `json:"id,omitempty" db:"id"`
+	Key         string               `json:"key,omitempty" db:"-"`
+	UserID      int                  `json:"user_id,omitempty" db:"user_id"`
+	Name        string               `json:"name" db:"name" validate:"required,max=100"`
+	Description string               `json:"description" db:"description" validate:"max=1000"`
+	Content     BoardTemplateContent `json:"content" db:"content"`
+	CreatedAt   time.Time            `json:"created_at" db:"created_at"`
+}
+
+// BoardTemplateContent is everything a template puts on a new board.
+type BoardTemplateContent struct {
+	Background string              `json:"background"`
+	Labels     []string            `json:"labels"`
+	Swimlanes  []string            `json:"swimlanes"`
+	Containers []TemplateContainer `json:"containers"`
+	Rules      []TemplateRule      `json:"rules"`
+}
+
+func (c BoardTemplateContent) Value() (driver.Value, error) { return json.Marshal(c) }
+func (c *BoardTemplateContent) Scan(src interface{}) error  { return scanJSON(src, c) }
+
+type TemplateContainer struct {
+	Title    string         `json:"title"`
+	WIPLimit *int           `json:"wip_limit,omitempty"`
+	WIPMode  string         `json:"wip_mode,omitempty"`
+	SortMode string         `json:"sort_mode,omitempty"`
+	Tasks    []TemplateTask `json:"tasks,omitempty"`
+}
+
+type TemplateTask struct {
+	Title       string    `json:"title"`
+	Description string    `json:"description,omitempty"`
+	Priority    string    `json:"priority,omitempty"`
+	Estimate    *float64  `json:"estimate,omitempty"`
+	Labels      []string  `json:"labels,omitempty"`
+	Checklist   Checklist `json:"checklist,omitempty"`
+}
+
+// TemplateRule is an automation rule of a board template. Container IDs in
+// its trigger and actions are 1-based positions in the template's
+// Containers, and are mapped to the new containers on instantiation.
+type TemplateRule struct {
+	Name       string         `json:"name"`
+	Trigger    RuleTrigger    `json:"trigger"`
+	Conditions RuleConditions `json:"conditions"`
+	Actions    RuleActions    `json:"actions"`
+	Enabled    bool           `json:"enabled"`
+}
+
+// SaveBoardTemplateRequest snapshots a board as a template. Tasks are only
+// kept as starter tasks when IncludeTasks is set.
+type SaveBoardTemplateRequest struct {
+	Name         string `json:"name" validate:"required,max=100"`
+	Description  string `json:"description" validate:"max=1000"`
+	IncludeTasks bool   `json:"include_tasks"`
+}
+
+// TaskTemplate prefills new tasks created with ?template=.
+type TaskTemplate struct {
+	ID          int            `json:"id" db:"id"`
+	UserID      int            `json:"user_id" db:"user_id"`
+	Name        string         `json:"name" db:"name" validate:"required,max=100"`
+	Title       string         `json:"title" db:"title" validate:"required,max=200"`
+	Description string         `json:"description" db:"description" validate:"max=5000"`
+	Labels      pq.StringArray `json:"labels" db:"labels" validate:"max=20"`
+	Checklist   Checklist      `json:"checklist" db:"checklist" validate:"max=100"`
+	CreatedAt   time.Time      `json:"created_at" db:"created_at"`
+}
+
+func (t TaskTemplate) task() Task {
+	return Task{Title: t.Title, Description: t.Description, Labels: append(pq.StringArray{}, t.Labels...), Checklist: uncheck(t.Checklist)}
+}
+
+// uncheck copies a checklist with every item not done.
+func uncheck(checklist Checklist) Checklist {
+	unchecked := make(Checklist, len(checklist))
+	for i, item := range checklist {
+		unchecked[i] = ChecklistItem{Text: item.Text}
+	}
+	return unchecked
+}
+
+var builtinBoardTemplates = []BoardTemplate{
+	{
+		Key:         "kanban",
+		Name:        "Kanban",
+		Description: "To do, in progress and done columns",
+		Content: BoardTemplateContent{
+			Background: "img-3.jpg",
+			Containers: []TemplateContainer{{Title: "To do"}, {Title: "In progress"}, {Title: "Done"}},
+			Rules: []TemplateRule{{
+				Name:    "Complete tasks moved to Done",
+				Trigger: RuleTrigger{Type: TriggerTaskMoved, ContainerID: 3},
+				Actions: RuleActions{{Type: "set_completed", Completed: true}},
+				Enabled: true,
+			}},
+		},
+	},
+	{
+		Key:         "blank",
+		Name:        "Blank",
+		Description: "An empty board",
+		Content:     BoardTemplateContent{Background: "img-3.jpg"},
+	},
+}
+
+func (tm *TaskManager) GetBoardTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	saved := []BoardTemplate{}
+	err := tm.db.Select(&saved, "SELECT * FROM board_templates WHERE user_id = $1 ORDER BY name, id", userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(append(append([]BoardTemplate{}, builtinBoardTemplates...), saved...))
+}
+
+// SaveBoardTemplateHandler creates a template from an existing board.
+func (tm *TaskManager) SaveBoardTemplateHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+	boardID := mux.Vars(r)["id"]
+
+	var req SaveBoardTemplateRequest
+	err := decodeJSON(w, r, &req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tm.checkBoardOwnership(userID, boardID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	content, err := tm.snapshotBoard(boardID, req.IncludeTasks)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var template BoardTemplate
+	err = tm.db.Get(&template, `
+		INSERT INTO board_templates (user_id, name, description, content) VALUES ($1, $2, $3, $4)
+		RETURNING *
+	`, userID, req.Name, req.Description, content)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(template)
+}
+
+func (tm *TaskManager) UpdateBoardTemplateHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+	templateID := mux.Vars(r)["id"]
+
+	var req SaveBoardTemplateRequest
+	err := decodeJSON(w, r, &req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var template BoardTemplate
+	err = tm.db.Get(&template, `
+		UPDATE board_templates SET name = $1, description = $2 WHERE id = $3 AND user_id = $4
+		RETURNING *
+	`, req.Name, req.Description, templateID, userID)
+	if err == sql.ErrNoRows {
+		writeError(w, r, notFoundError("board template %s not found", templateID))
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(template)
+}
+
+func (tm *TaskManager) DeleteBoardTemplateHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+	templateID := mux.Vars(r)["id"]
+
+	res, err := tm.db.Exec("DELETE FROM board_templates WHERE id = $1 AND user_id = $2", templateID, userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(w, r, notFoundError("board template %s not found", templateID))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (tm *TaskManager) GetTaskTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	templates := []TaskTemplate{}
+	err := tm.db.Select(&templates, "SELECT * FROM task_templates WHERE user_id = $1 ORDER BY name, id", userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(templates)
+}
+
+func (tm *TaskManager) CreateTaskTemplateHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	var template TaskTemplate
+	err := decodeJSON(w, r, &template)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tm.db.Get(&template, `
+		INSERT INTO task_templates (user_id, name, title, description, labels, checklist) VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING *
+	`, userID, template.Name, template.Title, template.Description, pq.StringArray(normalizeBoardLabels(template.Labels)), template.Checklist)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(template)
+}
+
+func (tm *TaskManager) UpdateTaskTemplateHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	var data TaskTemplate
+	err := decodeJSON(w, r, &data)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	template, err := tm.getTaskTemplate(userID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tm.db.Get(&template, `
+		UPDATE task_templates SET name = $1, title = $2, description = $3, labels = $4, checklist = $5 WHERE id = $6
+		RETURNING *
+	`, data.Name, data.Title, data.Description, pq.StringArray(normalizeBoardLabels(data.Labels)), data.Checklist, template.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(template)
+}
+
+func (tm *TaskManager) DeleteTaskTemplateHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	template, err := tm.getTaskTemplate(userID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	_, err = tm.db.Exec("DELETE FROM task_templates WHERE id = $1", template.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// boardTemplate resolves ref to the content of one of userID's saved
+// templates, by ID, or of a built-in template, by key.
+func (tm *TaskManager) boardTemplate(userID int, ref string) (BoardTemplateContent, error) {
+	if _, err := strconv.Atoi(ref); err != nil {
+		for _, template := range builtinBoardTemplates {
+			if template.Key == ref {
+				return template.Content, nil
+			}
+		}
+		return BoardTemplateContent{}, notFoundError("board template %q not found", ref)
+	}
+
+	var content BoardTemplateContent
+	err := tm.db.Get(&content, "SELECT content FROM board_templates WHERE id = $1 AND user_id = $2", ref, userID)
+	if err == sql.ErrNoRows {
+		return content, notFoundError("board template %s not found", ref)
+	}
+	return content, err
+}
+
+func (tm *TaskManager) getTaskTemplate(userID int, templateID string) (TaskTemplate, error) {
+	var template TaskTemplate
+	err := tm.db.Get(&template, "SELECT * FROM task_templates WHERE id = $1 AND user_id = $2", templateID, userID)
+	if err == sql.ErrNoRows {
+		return template, notFoundError("task template %s not found", templateID)
+	}
+	return template, err
+}
+
+// snapshotBoard captures a board's layout as template content. The default
+// labels are the board's own labels plus every label used by its tasks.
+func (tm *TaskManager) snapshotBoard(boardID string, includeTasks bool) (BoardTemplateContent, error) {
+	content := BoardTemplateContent{}
+
+	var board Board
+	err := tm.db.Get(&board, "SELECT "+boardColumns+" FROM boards WHERE id = $1", boardID)
+	if err != nil {
+		return content, err
+	}
+	content.Background = board.Background
+
+	var used []string
+	err = tm.db.Select(&used, `
+		SELECT DISTINCT unnest(t.labels) FROM tasks t JOIN containers c ON c.id = t.container_id
+		WHERE c.board_id = $1
+	`, boardID)
+	if err != nil {
+		return content, err
+	}
+	content.Labels = normalizeBoardLabels(append(board.Labels, used...))
+
+	swimlanes, err := tm.getSwimlanes(boardID)
+	if err != nil {
+		return content, err
+	}
+	for _, swimlane := range swimlanes {
+		content.Swimlanes = append(content.Swimlanes, swimlane.Title)
+	}
+
+	containers := []Container{}
+	err = tm.db.Select(&containers, "SELECT "+containerColumns+" FROM containers WHERE board_id = $1 AND archived_at IS NULL ORDER BY id", boardID)
+	if err != nil {
+		return content, err
+	}
+	positions := map[int]int{}
+	for i, container := range containers {
+		positions[container.ID] = i + 1
+		tc := TemplateContainer{Title: container.Title, WIPLimit: container.WIPLimit, WIPMode: container.WIPMode, SortMode: container.SortMode}
+		if includeTasks {
+			tasks := []Task{}
+			err = tm.db.Select(&tasks, "SELECT "+taskColumns+" FROM tasks WHERE container_id = $1 AND archived_at IS NULL ORDER BY id", container.ID)
+			if err != nil {
+				return content, err
+			}
+			for _, task := range tasks {
+				tc.Tasks = append(tc.Tasks, TemplateTask{Title: task.Title, Description: task.Description, Priority: task.Priority, Estimate: task.Estimate, Labels: task.Labels, Checklist: uncheck(task.Checklist)})
+			}
+		}
+		content.Containers = append(content.Containers, tc)
+	}
+
+	rules := []AutomationRule{}
+	err = tm.db.Select(&rules, "SELECT * FROM automation_rules WHERE board_id = $1 ORDER BY id", boardID)
+	if err != nil {
+		return content, err
+	}
+	for _, rule := range rules {
+		if !rule.onlyUses(positions) {
+			continue
+		}
+		tr := TemplateRule{Name: rule.Name, Trigger: rule.Trigger, Conditions: rule.Conditions, Enabled: rule.Enabled}
+		tr.Trigger.ContainerID = positions[rule.Trigger.ContainerID]
+		for _, action := range rule.Actions {
+			action.ContainerID = positions[action.ContainerID]
+			tr.Actions = append(tr.Actions, action)
+		}
+		content.Rules = append(content.Rules, tr)
+	}
+
+	return content, nil
+}
+
+// instantiateBoard creates board for userID with everything in content, in
+// a single transaction. board carries the title, background and labels.
+func (tm *TaskManager) instantiateBoard(userID int, board Board, content BoardTemplateContent) (Board, error) {
+	tx, err := tm.db.Beginx()
+	if err != nil {
+		return board, err
+	}
+	defer tx.Rollback()
+
+	board.UserID = userID
+	board.Labels = normalizeBoardLabels(board.Labels)
+	err = tx.QueryRow("INSERT INTO boards (user_id, title, background, enforce_blockers, labels) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		userID, board.Title, board.Background, board.EnforceBlockers, board.Labels).Scan(&board.ID)
+	if err != nil {
+		return board, err
+	}
+
+	board.ContainerIDs = []int{}
+	for _, tc := range content.Containers {
+		mode := tc.WIPMode
+		if mode == "" {
+			mode = WIPWarn
+		}
+		sortMode := tc.SortMode
+		if sortMode == "" {
+			sortMode = SortManual
+		}
+		var containerID int
+		err = tx.QueryRow("INSERT INTO containers (board_id, title, wip_limit, wip_mode, sort_mode) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+			board.ID, tc.Title, tc.WIPLimit, mode, sortMode).Scan(&containerID)
+		if err != nil {
+			return board, err
+		}
+		board.ContainerIDs = append(board.ContainerIDs, containerID)
+
+		for _, task := range tc.Tasks {
+			priority := task.Priority
+			if priority == "" {
+				priority = PriorityNone
+			}
+			_, err = tx.Exec("INSERT INTO tasks (container_id, title, description, priority, estimate, labels, checklist) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+				containerID, task.Title, task.Description, priority, task.Estimate, pq.StringArray(normalizeBoardLabels(task.Labels)), task.Checklist)
+			if err != nil {
+				return board, err
+			}
+		}
+	}
+
+	board.SwimlaneIDs = []int{}
+	for i, title := range content.Swimlanes {
+		var swimlaneID int
+		err = tx.QueryRow("INSERT INTO swimlanes (board_id, title, position) VALUES ($1, $2, $3) RETURNING id", board.ID, title, i).Scan(&swimlaneID)
+		if err != nil {
+			return board, err
+		}
+		board.SwimlaneIDs = append(board.SwimlaneIDs, swimlaneID)
+	}
+
+	containerAt := func(position int) int {
+		if position < 1 || position > len(board.ContainerIDs) {
+			return 0
+		}
+		return board.ContainerIDs[position-1]
+	}
+	for _, tr := range content.Rules {
+		trigger := tr.Trigger
+		trigger.ContainerID = containerAt(trigger.ContainerID)
+		actions := RuleActions{}
+		for _, action := range tr.Actions {
+			action.ContainerID = containerAt(action.ContainerID)
+			actions = append(actions, action)
+		}
+		conditions := tr.Conditions
+		if conditions == nil {
+			conditions = RuleConditions{}
+		}
+		_, err = tx.Exec(`
+			INSERT INTO automation_rules (board_id, name, trigger, conditions, actions, enabled)
+			VALUES ($1, $2, $3, $4, $5, $6)
+		`, board.ID, tr.Name, trigger, conditions, actions, tr.Enabled)
+		if err != nil {
+			return board, err
+		}
+	}
+
+	return board, tx.Commit()
+}
+
+// normalizeBoardLabels trims, deduplicates and sorts a label set.
+func normalizeBoardLabels(labels []string) pq.StringArray {
+	seen := map[string]bool{}
+	normalized := pq.StringArray{}
+	for _, label := range labels {
+		label = strings.TrimSpace(label)
+		if label == "" || seen[label] {
+			continue
+		}
+		seen[label] = true
+		normalized = append(normalized, label)
+	}
+	sort.Strings(normalized)
+	return normalized
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// TimelineTask is a task placed on the calendar. It spans Start to End: its
+// start and due dates, or just one of them when the other is not set.
+type TimelineTask struct {
+	ID          int        `json:"id"`
+	BoardID     int        `json:"board_id"`
+	ContainerID int        `json:"container_id"`
+	Title       string     `json:"title"`
+	Completed   bool       `json:"completed"`
+	Blocked     bool       `json:"blocked"`
+	Priority    string     `json:"priority"`
+	AssigneeID  *int       `json:"assignee_id"`
+	StartsAt    *time.Time `json:"starts_at"`
+	DueAt       *time.Time `json:"due_at"`
+	Start       time.Time  `json:"start"`
+	End         time.Time  `json:"end"`
+}
+
+// TimelineGroup holds the tasks of one board or assignee. Key is the board
+// or user ID, and null for unassigned tasks.
+type TimelineGroup struct {
+	Key   *int           `json:"key"`
+	Title string         `json:"title"`
+	Tasks []TimelineTask `json:"tasks"`
+}
+
+// TimelineDay lists the tasks whose span covers a UTC day, for calendar
+// views.
+type TimelineDay struct {
+	Date    string `json:"date"`
+	TaskIDs []int  `json:"task_ids"`
+}
+
+// TimelineDependency is a blocks link between two tasks on the timeline:
+// FromTaskID has to be completed before ToTaskID.
+type TimelineDependency struct {
+	FromTaskID int `json:"from_task_id" db:"task_id"`
+	ToTaskID   int `json:"to_task_id" db:"other_task_id"`
+}
+
+type Timeline struct {
+	From         string               `json:"from"`
+	To           string               `json:"to"`
+	GroupBy      string               `json:"group_by"`
+	Groups       []TimelineGroup      `json:"groups"`
+	Days         []TimelineDay        `json:"days"`
+	Dependencies []TimelineDependency `json:"dependencies"`
+}
+
+// GetTimelineHandler lists the caller's tasks with a start or due date in a
+// date range, grouped by board or assignee, with the days each covers and
+// the dependencies between them. Archived boards, containers and tasks are
+// left out.
+func (tm *TaskManager) GetTimelineHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+	query := r.URL.Query()
+
+	var fieldErrs []FieldError
+	groupBy := query.Get("group_by")
+	if groupBy == "" {
+		groupBy = "board"
+	}
+	if groupBy != "board" && groupBy != "assignee" {
+		fieldErrs = append(fieldErrs, FieldError{Field: "group_by", Message: "must be one of board, assignee"})
+	}
+	boardID, ok := queryInt(query.Get("board_id"), 0, 1, 1<<31-1)
+	if !ok {
+		fieldErrs = append(fieldErrs, FieldError{Field: "board_id", Message: "must be a board ID"})
+	}
+	assigneeID, ok := queryInt(query.Get("assignee_id"), 0, 1, 1<<31-1)
+	if !ok {
+		fieldErrs = append(fieldErrs, FieldError{Field: "assignee_id", Message: "must be a user ID"})
+	}
+	hideCompleted, err := strconv.ParseBool(query.Get("hide_completed"))
+	if err != nil && query.Get("hide_completed") != "" {
+		fieldErrs = append(fieldErrs, FieldError{Field: "hide_completed", Message: "must be true or false"})
+	}
+	if len(fieldErrs) > 0 {
+		writeError(w, r, validationError(fieldErrs, "invalid query"))
+		return
+	}
+
+	// The range defaults to the 30 days from today, or from the given from.
+	defFrom := time.Now().UTC().Truncate(24 * time.Hour)
+	if start, err := time.Parse("2006-01-02", query.Get("from")); err == nil {
+		defFrom = start
+	}
+	from, to, err := analyticsRange(query.Get("from"), query.Get("to"), defFrom, defFrom.AddDate(0, 0, 29), 30)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	end := to.AddDate(0, 0, 1)
+
+	if boardID != 0 {
+		err = tm.checkBoardOwnership(userID, strconv.Itoa(boardID))
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+
+	var containers []struct {
+		ContainerID int    `db:"container_id"`
+		BoardID     int    `db:"board_id"`
+		BoardTitle  string `db:"board_title"`
+	}
+	err = tm.db.Select(&containers, `
+		SELECT c.id AS container_id, b.id AS board_id, b.title AS board_title
+		FROM containers c JOIN boards b ON b.id = c.board_id
+		WHERE b.user_id = $1 AND b.archived_at IS NULL AND c.archived_at IS NULL
+		AND ($2::integer = 0 OR b.id = $2::integer)
+	`, userID, boardID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	boards := map[int]int{}
+	boardTitles := map[int]string{}
+	for _, c := range containers {
+		boards[c.ContainerID] = c.BoardID
+		boardTitles[c.BoardID] = c.BoardTitle
+	}
+
+	var tasks []Task
+	err = tm.db.Select(&tasks, `
+		SELECT `+taskColumns+` FROM tasks
+		WHERE container_id IN (
+			SELECT c.id FROM containers c JOIN boards b ON b.id = c.board_id
+			WHERE b.user_id = $1 AND b.archived_at IS NULL AND c.archived_at IS NULL
+			AND ($2::integer = 0 OR b.id = $2::integer)
+		)
+		AND archived_at IS NULL
+		AND COALESCE(starts_at, due_at) < $3 AND COALESCE(due_at, starts_at) >= $4
+		AND ($5::integer = 0 OR assignee_id = $5::integer)
+		AND NOT ($6 AND completed)
+		ORDER BY COALESCE(starts_at, due_at), id
+	`, userID, boardID, end, from, assigneeID, hideCompleted)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	timeline := Timeline{
+		From:         from.Format("2006-01-02"),
+		To:           to.Format("2006-01-02"),
+		GroupBy:      groupBy,
+		Groups:       []TimelineGroup{},
+		Days:         []TimelineDay{},
+		Dependencies: []TimelineDependency{},
+	}
+
+	groups := map[int]*TimelineGroup{}
+	var unassigned *TimelineGroup
+	var ids []int64
+	for _, task := range tasks {
+		item := TimelineTask{
+			ID:          task.ID,
+			BoardID:     boards[task.ContainerID],
+			ContainerID: task.ContainerID,
+			Title:       task.Title,
+			Completed:   task.Completed,
+			Blocked:     task.Blocked,
+			Priority:    task.Priority,
+			AssigneeID:  task.AssigneeID,
+			StartsAt:    task.StartsAt,
+			DueAt:       task.DueAt,
+		}
+		if task.StartsAt != nil {
+			item.Start, item.End = *task.StartsAt, *task.StartsAt
+		}
+		if task.DueAt != nil {
+			item.End = *task.DueAt
+			if task.StartsAt == nil {
+				item.Start = *task.DueAt
+			}
+		}
+		ids = append(ids, int64(task.ID))
+
+		var group *TimelineGroup
+		switch {
+		case groupBy == "board":
+			group = groups[item.BoardID]
+			if group == nil {
+				key := item.BoardID
+				group = &TimelineGroup{Key: &key, Title: boardTitles[key]}
+				groups[key] = group
+			}
+		case task.AssigneeID == nil:
+			if unassigned == nil {
+				unassigned = &TimelineGroup{Title: "Unassigned"}
+			}
+			group = unassigned
+		default:
+			group = groups[*task.AssigneeID]
+			if group == nil {
+				key := *task.AssigneeID
+				group = &TimelineGroup{Key: &key}
+				groups[key] = group
+			}
+		}
+		group.Tasks = append(group.Tasks, item)
+	}
+
+	if groupBy == "assignee" && len(groups) > 0 {
+		userIDs := make([]int64, 0, len(groups))
+		for id := range groups {
+			userIDs = append(userIDs, int64(id))
+		}
+		var users []struct {
+			ID       int    `db:"id"`
+			Username string `db:"username"`
+		}
+		err = tm.db.Select(&users, "SELECT id, username FROM users WHERE id = ANY($1)", pq.Int64Array(userIDs))
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		for _, u := range users {
+			groups[u.ID].Title = u.Username
+		}
+	}
+
+	keys := make([]int, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+	for _, key := range keys {
+		timeline.Groups = append(timeline.Groups, *groups[key])
+	}
+	if unassigned != nil {
+		timeline.Groups = append(timeline.Groups, *unassigned)
+	}
+
+	for day := from; day.Before(end); day = day.AddDate(0, 0, 1) {
+		next := day.AddDate(0, 0, 1)
+		entry := TimelineDay{Date: day.Format("2006-01-02"), TaskIDs: []int{}}
+		for _, group := range timeline.Groups {
+			for _, task := range group.Tasks {
+				if task.Start.Before(next) && !task.End.Before(day) {
+					entry.TaskIDs = append(entry.TaskIDs, task.ID)
+				}
+			}
+		}
+		sort.Ints(entry.TaskIDs)
+		timeline.Days = append(timeline.Days, entry)
+	}
+
+	if len(ids) > 0 {
+		err = tm.db.Select(&timeline.Dependencies, `
+			SELECT task_id, other_task_id FROM task_links
+			WHERE kind = 'blocks' AND task_id = ANY($1) AND other_task_id = ANY($1)
+			ORDER BY task_id, other_task_id
+		`, pq.Int64Array(ids))
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(timeline)
+}
+
+// checkTaskDates checks that a task does not start after it is due.
+func checkTaskDates(task Task) error {
+	if task.StartsAt != nil && task.DueAt != nil && task.StartsAt.After(*task.DueAt) {
+		return validationError([]FieldError{{Field: "starts_at", Message: "must not be after due_at"}}, "invalid task")
+	}
+	return nil
+}
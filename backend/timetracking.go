@@ -0,0 +1,486 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+)
+
+// TimeEntry is time a user spent on a task, either recorded by a timer or
+// entered by hand. A running timer has no EndedAt; Seconds counts up to now.
+type TimeEntry struct {
+	ID        int        `json:"id" db:"id"`
+	TaskID    int        `json:"task_id" db:"task_id"`
+	UserID    int        `json:"user_id" db:"user_id"`
+	StartedAt time.Time  `json:"started_at" db:"started_at" validate:"required"`
+	EndedAt   *time.Time `json:"ended_at" db:"ended_at"`
+	Seconds   int64      `json:"seconds" db:"seconds"`
+	Note      string     `json:"note" db:"note" validate:"max=1000"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+}
+
+const timeEntryColumns = "id, task_id, user_id, started_at, ended_at, note, created_at, " +
+	"EXTRACT(EPOCH FROM COALESCE(ended_at, now()) - started_at)::bigint AS seconds"
+
+type TimerRequest struct {
+	Note string `json:"note" validate:"max=1000"`
+}
+
+type TimeReport struct {
+	GroupBy      string          `json:"group_by"`
+	From         time.Time       `json:"from"`
+	To           time.Time       `json:"to"`
+	Rows         []TimeReportRow `json:"rows"`
+	TotalSeconds int64           `json:"total_seconds"`
+}
+
+// TimeReportRow totals the entries of one group. When grouping by label an
+// entry counts towards every label of its task, and unlabelled tasks are
+// grouped under an empty key.
+type TimeReportRow struct {
+	Key     string `json:"key" db:"key"`
+	Label   string `json:"label" db:"label"`
+	Entries int    `json:"entries" db:"entries"`
+	Seconds int64  `json:"seconds" db:"seconds"`
+}
+
+// timeReportGroups maps group_by values to the key and label expressions of
+// the report query. day uses the time zone bound as $1.
+var timeReportGroups = map[string][2]string{
+	"board":     {"b.id::text", "b.title"},
+	"container": {"c.id::text", "c.title"},
+	"label":     {"COALESCE(lbl.name, '')", "COALESCE(lbl.name, '')"},
+	"user":      {"u.id::text", "u.username"},
+	"day":       {"to_char(e.started_at AT TIME ZONE $1, 'YYYY-MM-DD')", "to_char(e.started_at AT TIME ZONE $1, 'YYYY-MM-DD')"},
+}
+
+type TimeTracker struct {
+	db *sqlx.DB
+	tm *TaskManager
+}
+
+func NewTimeTracker(db *sqlx.DB, tm *TaskManager) *TimeTracker {
+	return &TimeTracker{db: db, tm: tm}
+}
+
+func (tt *TimeTracker) routes() []route {
+	reportQuery := []queryParam{
+		{name: "group_by", description: "board, container, label, user or day"},
+		{name: "from", description: "start of the range, RFC 3339 or YYYY-MM-DD; defaults to 30 days ago"},
+		{name: "to", description: "end of the range, RFC 3339 or YYYY-MM-DD; defaults to now"},
+		{name: "board_id", description: "only count tasks on this board", typ: "integer"},
+		{name: "user_id", description: "only count this user's entries", typ: "integer"},
+		{name: "tz", description: "IANA time zone used for dates and day grouping; defaults to UTC"},
+	}
+	return []route{
+		{name: "getTimer", method: "GET", path: "/timer", handler: tt.GetTimerHandler, auth: true, tag: "time",
+			summary: "Show the running timer", response: TimeEntry{}},
+		{name: "startTimer", method: "POST", path: "/tasks/{id}/timer", handler: tt.StartTimerHandler, auth: true, tag: "time",
+			summary: "Start a timer on a task", request: TimerRequest{}, response: TimeEntry{}, status: http.StatusCreated},
+		{name: "stopTimer", method: "POST", path: "/timer/stop", handler: tt.StopTimerHandler, auth: true, tag: "time",
+			summary: "Stop the running timer", response: TimeEntry{}},
+		{name: "listTimeEntries", method: "GET", path: "/tasks/{id}/time-entries", handler: tt.GetEntriesHandler, auth: true, tag: "time",
+			summary: "List the time recorded on a task", response: []TimeEntry{}},
+		{name: "createTimeEntry", method: "POST", path: "/tasks/{id}/time-entries", handler: tt.CreateEntryHandler, auth: true, tag: "time",
+			summary: "Record time on a task by hand", request: TimeEntry{}, response: TimeEntry{}, status: http.StatusCreated},
+		{name: "updateTimeEntry", method: "PUT", path: "/time-entries/{id}", handler: tt.UpdateEntryHandler, auth: true, tag: "time",
+			summary: "Correct a time entry", request: TimeEntry{}, response: TimeEntry{}},
+		{name: "deleteTimeEntry", method: "DELETE", path: "/time-entries/{id}", handler: tt.DeleteEntryHandler, auth: true, tag: "time",
+			summary: "Delete a time entry"},
+		{name: "timeReport", method: "GET", path: "/reports/time", handler: tt.ReportHandler, auth: true, tag: "time",
+			summary: "Total recorded time by board, container, label, user or day", response: TimeReport{}, query: reportQuery},
+		{name: "exportTimeReport", method: "GET", path: "/reports/time/export", handler: tt.ExportReportHandler, auth: true, tag: "time",
+			summary: "Download the time report as CSV", produces: "text/csv", query: reportQuery},
+	}
+}
+
+func (tt *TimeTracker) GetTimerHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	entry, err := tt.runningEntry(userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entry)
+}
+
+func (tt *TimeTracker) StartTimerHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	var req TimerRequest
+	err := decodeJSON(w, r, &req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	task, err := tt.tm.checkTaskAccess(userID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// The partial unique index on running entries is the real guard; the
+	// lookup only makes the error say where the other timer is.
+	var entry TimeEntry
+	err = tt.db.Get(&entry, `
+		INSERT INTO time_entries (task_id, user_id, started_at, note) VALUES ($1, $2, now(), $3)
+		RETURNING `+timeEntryColumns, task.ID, userID, req.Note)
+	if isUniqueViolation(err) {
+		running, lookupErr := tt.runningEntry(userID)
+		if lookupErr != nil {
+			writeError(w, r, conflictError("a timer is already running"))
+			return
+		}
+		writeError(w, r, conflictError("a timer is already running on task %d", running.TaskID))
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(entry)
+}
+
+func (tt *TimeTracker) StopTimerHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	var entry TimeEntry
+	err := tt.db.Get(&entry, `
+		UPDATE time_entries SET ended_at = now() WHERE user_id = $1 AND ended_at IS NULL
+		RETURNING `+timeEntryColumns, userID)
+	if err == sql.ErrNoRows {
+		writeError(w, r, notFoundError("no timer is running"))
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entry)
+}
+
+func (tt *TimeTracker) GetEntriesHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	task, err := tt.tm.checkTaskAccess(userID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	entries := []TimeEntry{}
+	err = tt.db.Select(&entries, "SELECT "+timeEntryColumns+" FROM time_entries WHERE task_id = $1 ORDER BY started_at", task.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+func (tt *TimeTracker) CreateEntryHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	var entry TimeEntry
+	err := decodeJSON(w, r, &entry)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	task, err := tt.tm.checkTaskAccess(userID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = validateTimeEntry(entry, false)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tt.db.Get(&entry, `
+		INSERT INTO time_entries (task_id, user_id, started_at, ended_at, note) VALUES ($1, $2, $3, $4, $5)
+		RETURNING `+timeEntryColumns, task.ID, userID, entry.StartedAt, entry.EndedAt, entry.Note)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(entry)
+}
+
+func (tt *TimeTracker) UpdateEntryHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	var entry TimeEntry
+	err := decodeJSON(w, r, &entry)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	previous, err := tt.getEntry(userID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = validateTimeEntry(entry, previous.EndedAt == nil)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = tt.db.Get(&entry, `
+		UPDATE time_entries SET started_at = $1, ended_at = $2, note = $3 WHERE id = $4
+		RETURNING `+timeEntryColumns, entry.StartedAt, entry.EndedAt, entry.Note, previous.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entry)
+}
+
+func (tt *TimeTracker) DeleteEntryHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	entry, err := tt.getEntry(userID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	_, err = tt.db.Exec("DELETE FROM time_entries WHERE id = $1", entry.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (tt *TimeTracker) ReportHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	report, err := tt.report(userID, r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+func (tt *TimeTracker) ExportReportHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	report, err := tt.report(userID, r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="time-report.csv"`)
+
+	out := csv.NewWriter(w)
+	out.Write([]string{report.GroupBy, "name", "entries", "seconds", "hours"})
+	for _, row := range report.Rows {
+		out.Write([]string{
+			row.Key,
+			row.Label,
+			strconv.Itoa(row.Entries),
+			strconv.FormatInt(row.Seconds, 10),
+			strconv.FormatFloat(float64(row.Seconds)/3600, 'f', 2, 64),
+		})
+	}
+	out.Flush()
+}
+
+// report parses the report query parameters and aggregates the entries on
+// boards owned by userID. Entries overlapping the range are clipped to it.
+func (tt *TimeTracker) report(userID int, r *http.Request) (TimeReport, error) {
+	query := r.URL.Query()
+	var fieldErrs []FieldError
+
+	report := TimeReport{GroupBy: query.Get("group_by"), Rows: []TimeReportRow{}}
+	if report.GroupBy == "" {
+		report.GroupBy = "board"
+	}
+	group, ok := timeReportGroups[report.GroupBy]
+	if !ok {
+		fieldErrs = append(fieldErrs, FieldError{Field: "group_by", Message: "must be one of board, container, label, user, day"})
+	}
+
+	tz := query.Get("tz")
+	if tz == "" {
+		tz = "UTC"
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		fieldErrs = append(fieldErrs, FieldError{Field: "tz", Message: "must be an IANA time zone name"})
+		loc = time.UTC
+	}
+
+	now := time.Now()
+	report.From, report.To = now.AddDate(0, 0, -30), now
+	if v := query.Get("from"); v != "" {
+		if report.From, err = parseTimeParam(v, loc); err != nil {
+			fieldErrs = append(fieldErrs, FieldError{Field: "from", Message: "must be RFC 3339 or YYYY-MM-DD"})
+		}
+	}
+	if v := query.Get("to"); v != "" {
+		if report.To, err = parseTimeParam(v, loc); err != nil {
+			fieldErrs = append(fieldErrs, FieldError{Field: "to", Message: "must be RFC 3339 or YYYY-MM-DD"})
+		}
+	}
+	if !report.To.After(report.From) {
+		fieldErrs = append(fieldErrs, FieldError{Field: "to", Message: "must be after from"})
+	}
+
+	args := []interface{}{tz, report.From, report.To, userID}
+	where := []string{"b.user_id = $4", "e.started_at < $3", "COALESCE(e.ended_at, now()) > $2"}
+	for _, param := range []string{"board_id", "user_id"} {
+		v := query.Get(param)
+		if v == "" {
+			continue
+		}
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			fieldErrs = append(fieldErrs, FieldError{Field: param, Message: "must be an integer"})
+			continue
+		}
+		args = append(args, id)
+		column := map[string]string{"board_id": "b.id", "user_id": "e.user_id"}[param]
+		where = append(where, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+
+	if len(fieldErrs) > 0 {
+		return report, validationError(fieldErrs, "invalid query")
+	}
+
+	labels := ""
+	if report.GroupBy == "label" {
+		labels = "LEFT JOIN LATERAL unnest(t.labels) AS lbl(name) ON true"
+	}
+
+	// $1 is referenced only by day grouping; the cast keeps its type known
+	// to Postgres in the other cases.
+	err = tt.db.Select(&report.Rows, fmt.Sprintf(`
+		SELECT key, label, COUNT(*) AS entries, SUM(seconds)::bigint AS seconds
+		FROM (
+			SELECT %s AS key, %s AS label, $1::text AS tz,
+				EXTRACT(EPOCH FROM LEAST(COALESCE(e.ended_at, now()), $3) - GREATEST(e.started_at, $2)) AS seconds
+			FROM time_entries e
+			JOIN tasks t ON t.id = e.task_id
+			JOIN containers c ON c.id = t.container_id
+			JOIN boards b ON b.id = c.board_id
+			JOIN users u ON u.id = e.user_id
+			%s
+			WHERE %s
+		) entries
+		GROUP BY key, label
+		ORDER BY seconds DESC, label
+	`, group[0], group[1], labels, strings.Join(where, " AND ")), args...)
+	if err != nil {
+		return report, err
+	}
+
+	// Grouping by label counts an entry once per label, so the total is
+	// summed over the entries themselves.
+	err = tt.db.Get(&report.TotalSeconds, fmt.Sprintf(`
+		SELECT COALESCE(SUM(seconds), 0)::bigint
+		FROM (
+			SELECT $1::text AS tz,
+				EXTRACT(EPOCH FROM LEAST(COALESCE(e.ended_at, now()), $3) - GREATEST(e.started_at, $2)) AS seconds
+			FROM time_entries e
+			JOIN tasks t ON t.id = e.task_id
+			JOIN containers c ON c.id = t.container_id
+			JOIN boards b ON b.id = c.board_id
+			WHERE %s
+		) entries
+	`, strings.Join(where, " AND ")), args...)
+	return report, err
+}
+
+func (tt *TimeTracker) runningEntry(userID int) (TimeEntry, error) {
+	var entry TimeEntry
+	err := tt.db.Get(&entry, "SELECT "+timeEntryColumns+" FROM time_entries WHERE user_id = $1 AND ended_at IS NULL", userID)
+	if err == sql.ErrNoRows {
+		return entry, notFoundError("no timer is running")
+	}
+	return entry, err
+}
+
+// getEntry loads one of userID's own entries; other users' entries are
+// reported as missing.
+func (tt *TimeTracker) getEntry(userID int, entryID string) (TimeEntry, error) {
+	var entry TimeEntry
+	err := tt.db.Get(&entry, "SELECT "+timeEntryColumns+" FROM time_entries WHERE id = $1 AND user_id = $2", entryID, userID)
+	if err == sql.ErrNoRows {
+		return entry, notFoundError("time entry %s not found", entryID)
+	}
+	return entry, err
+}
+
+// validateTimeEntry checks the range of a manual or corrected entry. Only a
+// running timer may be left without an end.
+func validateTimeEntry(entry TimeEntry, running bool) error {
+	var fieldErrs []FieldError
+	switch {
+	case entry.EndedAt == nil && !running:
+		fieldErrs = append(fieldErrs, FieldError{Field: "ended_at", Message: "is required"})
+	case entry.EndedAt != nil && !entry.EndedAt.After(entry.StartedAt):
+		fieldErrs = append(fieldErrs, FieldError{Field: "ended_at", Message: "must be after started_at"})
+	}
+	if entry.StartedAt.After(time.Now()) {
+		fieldErrs = append(fieldErrs, FieldError{Field: "started_at", Message: "must not be in the future"})
+	}
+	if len(fieldErrs) > 0 {
+		return validationError(fieldErrs, "invalid time entry")
+	}
+	return nil
+}
+
+// parseTimeParam accepts an RFC 3339 timestamp or a date, which is taken as
+// midnight in loc.
+func parseTimeParam(v string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", v, loc)
+}
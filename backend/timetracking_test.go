@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTimeReportTotalsEachEntryOnce(t *testing.T) {
+	ts := newTestServer(t)
+	login := ts.signup(t, "tracker")
+
+	var board Board
+	var container Container
+	var task Task
+	ts.call(t, "POST", "/boards", login.Token, Board{Title: "Tracked"}, &board)
+	ts.call(t, "POST", fmt.Sprintf("/boards/%d/containers", board.ID), login.Token, Container{Title: "Doing"}, &container)
+	ts.call(t, "POST", fmt.Sprintf("/containers/%d/tasks", container.ID), login.Token, Task{Title: "Pair", Labels: []string{"backend", "frontend"}}, &task)
+
+	started := time.Now().Add(-3 * time.Hour).UTC().Truncate(time.Second)
+	ended := started.Add(time.Hour)
+	ts.call(t, "POST", fmt.Sprintf("/tasks/%d/time-entries", task.ID), login.Token, TimeEntry{StartedAt: started, EndedAt: &ended}, nil)
+
+	for _, groupBy := range []string{"board", "label"} {
+		var report TimeReport
+		ts.call(t, "GET", "/reports/time?group_by="+groupBy, login.Token, nil, &report)
+
+		wantRows := map[string]int{"board": 1, "label": 2}[groupBy]
+		if len(report.Rows) != wantRows {
+			t.Errorf("group_by=%s: got %d rows, want %d", groupBy, len(report.Rows), wantRows)
+		}
+		for _, row := range report.Rows {
+			if row.Seconds != 3600 {
+				t.Errorf("group_by=%s: row %q has %d seconds, want 3600", groupBy, row.Label, row.Seconds)
+			}
+		}
+		if report.TotalSeconds != 3600 {
+			t.Errorf("group_by=%s: total is %d seconds, want 3600", groupBy, report.TotalSeconds)
+		}
+	}
+}
@@ -1,17 +1,15 @@
 package main
 
 import (
-	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -23,14 +21,37 @@ type User struct {
 }
 
 type Credentials struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username string `json:"username" validate:"required,max=32"`
+	Password string `json:"password" validate:"required,max=72"`
 }
 
 type SignupData struct {
-	Username string `json:"username"`
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Username string `json:"username" validate:"required,min=3,max=32"`
+	Email    string `json:"email" validate:"required,email,max=254"`
+	Password string `json:"password" validate:"required,min=8,max=72"`
+}
+
+// SyncData is the board snapshot posted to /update-user-data.
+type SyncData struct {
+	BoardID    int             `json:"boardId" validate:"required"`
+	Containers []SyncContainer `json:"containers" validate:"required"`
+	Tasks      []SyncTask      `json:"tasks" validate:"required"`
+}
+
+type SyncContainer struct {
+	ID    int    `json:"id" validate:"required"`
+	Title string `json:"title" validate:"max=100"`
+}
+
+// SyncTask.Priority and Estimate are left unchanged on existing tasks when
+// empty or null, for clients that predate them.
+type SyncTask struct {
+	ID          int      `json:"id" validate:"required"`
+	ContainerID int      `json:"container_id" validate:"required"`
+	Title       string   `json:"title" validate:"max=200"`
+	Description string   `json:"description" validate:"max=5000"`
+	Priority    string   `json:"priority" validate:"oneof=none|low|medium|high|urgent"`
+	Estimate    *float64 `json:"estimate" validate:"min=0,max=1000"`
 }
 
 type LoginResponse struct {
@@ -40,6 +61,16 @@ type LoginResponse struct {
 	Token    string `json:"token"`
 }
 
+// UserData is everything the Kanban UI needs to render the caller's boards.
+type UserData struct {
+	Boards       []Board       `json:"boards"`
+	Containers   []Container   `json:"containers"`
+	Swimlanes    []Swimlane    `json:"swimlanes"`
+	CustomFields []CustomField `json:"custom_fields"`
+	Tasks        []Task        `json:"tasks"`
+	Background   string        `json:"background"`
+}
+
 type Claims struct {
 	UserID int `json:"user_id"`
 	jwt.StandardClaims
@@ -58,7 +89,7 @@ func (uh *UserHandler) GetUserData(w http.ResponseWriter, r *http.Request) {
 	// Get the user ID from the request context
 	userID := r.Context().Value("userID")
 	if userID == nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		writeError(w, r, ErrUnauthorized)
 		return
 	}
 
@@ -66,35 +97,34 @@ func (uh *UserHandler) GetUserData(w http.ResponseWriter, r *http.Request) {
 	var background string
 	err := uh.db.Get(&background, "SELECT background FROM users WHERE id = $1", userID)
 	if err != nil {
-		log.Println("Could not get user background")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, fmt.Errorf("could not get user background: %w", err))
 		return
 	}
 
 	// Get the user's boards, containers, and tasks from the database
 	var boards []Board
-	rows, err := uh.db.Query("SELECT id, user_id, title, background FROM boards WHERE user_id = $1", userID)
+	rows, err := uh.db.Query("SELECT "+boardColumns+" FROM boards WHERE user_id = $1 AND archived_at IS NULL", userID)
 	if err != nil {
-		log.Printf("Could not get user board for user with id %v: %v\n", userID, err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, fmt.Errorf("could not get boards for user %v: %w", userID, err))
 		return
 	}
 	for rows.Next() {
 		board := Board{}
-		rows.Scan(&board.ID, &board.UserID, &board.Title, &board.Background)
+		rows.Scan(&board.ID, &board.UserID, &board.Title, &board.Background, &board.EnforceBlockers, &board.Labels, &board.ArchivedAt)
 		boards = append(boards, board)
 	}
 	rows.Close()
 
 	var containers []Container
+	var swimlanes []Swimlane
+	var fields []CustomField
 	var tasks []Task
 
-	for _, board := range boards {
+	for i := range boards {
 		// Get the containers for the board
-		containerIDs, err := uh.tm.getContainersForBoard(board.ID)
+		containerIDs, err := uh.tm.getContainersForBoard(boards[i].ID)
 		if err != nil {
-			log.Println("Could not get board containers")
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, r, fmt.Errorf("could not get board containers: %w", err))
 			return
 		}
 
@@ -102,18 +132,16 @@ func (uh *UserHandler) GetUserData(w http.ResponseWriter, r *http.Request) {
 			// Get the tasks for the container
 			taskIDs, err := uh.tm.getTasksForContainer(containerID)
 			if err != nil {
-				log.Println("Could not get container tasks")
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				writeError(w, r, fmt.Errorf("could not get container tasks: %w", err))
 				return
 			}
 
 			for _, taskID := range taskIDs {
 				// Get the task from the database
 				var task Task
-				err = uh.db.Get(&task, "SELECT * FROM tasks WHERE id = $1", taskID)
+				err = uh.db.Get(&task, "SELECT "+taskColumns+" FROM tasks WHERE id = $1", taskID)
 				if err != nil {
-					log.Println("Could not get task data")
-					http.Error(w, err.Error(), http.StatusInternalServerError)
+					writeError(w, r, fmt.Errorf("could not get task data: %w", err))
 					return
 				}
 
@@ -122,10 +150,9 @@ func (uh *UserHandler) GetUserData(w http.ResponseWriter, r *http.Request) {
 
 			// Get the container from the database
 			var container Container
-			err = uh.db.Get(&container, "SELECT id, board_id, title FROM containers WHERE id = $1", containerID)
+			err = uh.db.Get(&container, "SELECT "+containerColumns+" FROM containers WHERE id = $1", containerID)
 			if err != nil {
-				log.Println("Could not get container data")
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				writeError(w, r, fmt.Errorf("could not get container data: %w", err))
 				return
 			}
 
@@ -133,90 +160,178 @@ func (uh *UserHandler) GetUserData(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Add the container IDs to the board
-		board.ContainerIDs = containerIDs
+		boards[i].ContainerIDs = containerIDs
+
+		// Get the swimlanes for the board, in display order
+		boardSwimlanes, err := uh.tm.getSwimlanes(strconv.Itoa(boards[i].ID))
+		if err != nil {
+			writeError(w, r, fmt.Errorf("could not get board swimlanes: %w", err))
+			return
+		}
+		boards[i].SwimlaneIDs = []int{}
+		for _, swimlane := range boardSwimlanes {
+			boards[i].SwimlaneIDs = append(boards[i].SwimlaneIDs, swimlane.ID)
+		}
+		swimlanes = append(swimlanes, boardSwimlanes...)
+
+		// Get the custom field definitions of the board
+		boardFields, err := uh.tm.getCustomFields(strconv.Itoa(boards[i].ID))
+		if err != nil {
+			writeError(w, r, fmt.Errorf("could not get board custom fields: %w", err))
+			return
+		}
+		fields = append(fields, boardFields...)
 	}
 
 	// Construct the response object
-	response := map[string]interface{}{
-		"boards":     boards,
-		"containers": containers,
-		"tasks":      tasks,
-		"background": background,
+	response := UserData{
+		Boards:       boards,
+		Containers:   containers,
+		Swimlanes:    swimlanes,
+		CustomFields: fields,
+		Tasks:        tasks,
+		Background:   background,
 	}
 
 	// Send the response as JSON
 	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(response)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (s *UserHandler) UpdateUserData(w http.ResponseWriter, r *http.Request) {
+	// Parse the request body to get the updated data. The client posts its
+	// whole store state, so fields outside SyncData are ignored.
+	var data SyncData
+	err := decodeJSONWith(w, r, &data, decodeOptions{maxBytes: maxSyncBodyBytes, allowUnknown: true})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
-}
 
-func (s *UserHandler) UpdateUserData(w http.ResponseWriter, r *http.Request) {
-	// Parse the request body to get the updated data.
-	var data map[string]interface{}
-	err := json.NewDecoder(r.Body).Decode(&data)
+	userID := r.Context().Value("userID").(int)
+	err = s.tm.checkBoardOwnership(userID, strconv.Itoa(data.BoardID))
 	if err != nil {
-		http.Error(w, "invalid data", http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 
-	// log.Println(data)
-	// Get the board ID from the updated data.
-	boardID, ok := data["boardId"].(float64)
-	if !ok {
-		http.Error(w, "invalid board ID", http.StatusBadRequest)
+	err = s.checkSyncIDs(data)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	// Get the containers and tasks from the updated data.
-	containers, ok := data["containers"].([]interface{})
-	if !ok {
-		http.Error(w, "invalid containers", http.StatusBadRequest)
+	violations, err := s.tm.checkSyncWIP(data.BoardID, data.Tasks)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	tasks, ok := data["tasks"].([]interface{})
-	if !ok {
-		http.Error(w, "invalid tasks", http.StatusBadRequest)
+	err = s.updateContainers(data.BoardID, data.Containers)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("failed to update containers: %w", err))
 		return
 	}
 
-	err = s.updateContainers(int(boardID), containers)
+	err = s.updateTasks(data.BoardID, data.Tasks)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to update containers: %v", err), http.StatusInternalServerError)
+		writeError(w, r, fmt.Errorf("failed to update tasks: %w", err))
 		return
 	}
 
-	err = s.updateTasks(int(boardID), tasks)
+	err = s.pruneContainers(data.BoardID, data.Containers)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to update tasks: %v", err), http.StatusInternalServerError)
+		writeError(w, r, fmt.Errorf("failed to remove containers: %w", err))
 		return
 	}
 
+	s.tm.emit(r, EventBoardSynced, data.BoardID, data)
+
+	for i := range violations {
+		flagWIP(w, &violations[i])
+	}
 	w.WriteHeader(http.StatusOK)
 }
 
-func (s *UserHandler) updateContainers(boardID int, containers []interface{}) error {
-	s.db.Exec("DELETE FROM containers WHERE board_id = $1", boardID)
+// checkSyncIDs rejects snapshots that refer to containers or tasks of other
+// boards, so a sync can only change the board it names. Tasks must be in a
+// container of the snapshot or of the board.
+func (s *UserHandler) checkSyncIDs(data SyncData) error {
+	var containers []struct {
+		ID      int `db:"id"`
+		BoardID int `db:"board_id"`
+	}
+	err := s.db.Select(&containers, "SELECT id, board_id FROM containers WHERE board_id = $1 OR id = ANY($2)",
+		data.BoardID, pq.Int64Array(syncContainerIDs(data.Containers)))
+	if err != nil {
+		return err
+	}
+	onBoard := map[int]bool{}
+	foreign := map[int]bool{}
 	for _, c := range containers {
-		container, ok := c.(map[string]interface{})
-		if !ok {
-			return errors.New("invalid container")
-		}
+		onBoard[c.ID] = c.BoardID == data.BoardID
+		foreign[c.ID] = c.BoardID != data.BoardID
+	}
+	for _, c := range data.Containers {
+		onBoard[c.ID] = !foreign[c.ID]
+	}
 
-		log.Println(container)
+	taskIDs := make([]int64, 0, len(data.Tasks))
+	for _, task := range data.Tasks {
+		taskIDs = append(taskIDs, int64(task.ID))
+	}
+	var foreignTasks []int
+	err = s.db.Select(&foreignTasks, `
+		SELECT t.id FROM tasks t JOIN containers c ON c.id = t.container_id
+		WHERE t.id = ANY($1) AND c.board_id <> $2
+	`, pq.Int64Array(taskIDs), data.BoardID)
+	if err != nil {
+		return err
+	}
+	foreignTask := map[int]bool{}
+	for _, id := range foreignTasks {
+		foreignTask[id] = true
+	}
 
-		id := int(container["id"].(float64))
-		title := container["title"].(string)
+	var fieldErrs []FieldError
+	for i, c := range data.Containers {
+		if foreign[c.ID] {
+			fieldErrs = append(fieldErrs, FieldError{Field: fmt.Sprintf("containers[%d].id", i), Message: "belongs to another board"})
+		}
+	}
+	for i, task := range data.Tasks {
+		if foreignTask[task.ID] {
+			fieldErrs = append(fieldErrs, FieldError{Field: fmt.Sprintf("tasks[%d].id", i), Message: "belongs to another board"})
+		}
+		if !onBoard[task.ContainerID] {
+			fieldErrs = append(fieldErrs, FieldError{Field: fmt.Sprintf("tasks[%d].container_id", i), Message: "must be a container of the board"})
+		}
+	}
+	if len(fieldErrs) > 0 {
+		return validationError(fieldErrs, "invalid sync data")
+	}
+	return nil
+}
 
+func syncContainerIDs(containers []SyncContainer) []int64 {
+	ids := []int64{}
+	for _, container := range containers {
+		ids = append(ids, int64(container.ID))
+	}
+	return ids
+}
+
+// updateContainers upserts the synced containers. Containers of other
+// boards are never updated; checkSyncIDs rejects snapshots naming them.
+func (s *UserHandler) updateContainers(boardID int, containers []SyncContainer) error {
+	for _, container := range containers {
 		_, err := s.db.Exec(`
 			INSERT INTO containers (id, board_id, title)
 			VALUES ($1, $2, $3)
 			ON CONFLICT (id) DO
 			UPDATE SET title=EXCLUDED.title
-		`, id, boardID, title)
+			WHERE containers.board_id = EXCLUDED.board_id
+		`, container.ID, boardID, container.Title)
 		if err != nil {
 			return err
 		}
@@ -225,52 +340,70 @@ func (s *UserHandler) updateContainers(boardID int, containers []interface{}) er
 	return nil
 }
 
-func (s *UserHandler) updateTasks(boardID int, tasks []interface{}) error {
-	s.db.Exec(`
+// pruneContainers drops the board's containers, with their tasks, that are
+// no longer part of the snapshot. Archived containers are never part of it
+// and are kept.
+func (s *UserHandler) pruneContainers(boardID int, containers []SyncContainer) error {
+	ids := syncContainerIDs(containers)
+
+	_, err := s.db.Exec(`
 		DELETE FROM tasks
 		WHERE container_id IN (
 			SELECT id
 			FROM containers
-			WHERE board_id = $1
+			WHERE board_id = $1 AND archived_at IS NULL AND NOT (id = ANY($2))
 		)
-	`, boardID)
-	for _, t := range tasks {
-		task, ok := t.(map[string]interface{})
-		if !ok {
-			return errors.New("invalid task")
-		}
-
-		id := int(task["id"].(float64))
-		containerID := int(task["container_id"].(float64))
-		title, _ := task["title"].(string)
-		description, _ := task["description"].(string)
+	`, boardID, pq.Int64Array(ids))
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec("DELETE FROM containers WHERE board_id = $1 AND archived_at IS NULL AND NOT (id = ANY($2))", boardID, pq.Int64Array(ids))
+	return err
+}
 
+// updateTasks upserts the synced tasks and deletes the board's tasks missing
+// from the snapshot, except archived ones and those in archived containers.
+// Columns the client does not sync, such as labels, are left untouched on
+// existing tasks.
+func (s *UserHandler) updateTasks(boardID int, tasks []SyncTask) error {
+	ids := []int64{}
+	for _, task := range tasks {
 		_, err := s.db.Exec(`
-				INSERT INTO tasks (id, container_id, title, description)
-				VALUES ($1, $2, $3, $4)
+				INSERT INTO tasks (id, container_id, title, description, priority, estimate)
+				VALUES ($1, $2, $3, $4, COALESCE(NULLIF($5, ''), 'none'), $6)
 				ON CONFLICT (id) DO
-				UPDATE SET container_id=EXCLUDED.container_id, title=EXCLUDED.title, description=EXCLUDED.description
-			`, id, containerID, title, description)
+				UPDATE SET container_id=EXCLUDED.container_id, title=EXCLUDED.title, description=EXCLUDED.description,
+					priority=COALESCE(NULLIF($5, ''), tasks.priority), estimate=COALESCE($6, tasks.estimate)
+				WHERE tasks.container_id IN (SELECT id FROM containers WHERE board_id = $7)
+			`, task.ID, task.ContainerID, task.Title, task.Description, task.Priority, task.Estimate, boardID)
 		if err != nil {
 			return err
 		}
+		ids = append(ids, int64(task.ID))
 	}
 
-	return nil
+	_, err := s.db.Exec(`
+		DELETE FROM tasks
+		WHERE container_id IN (
+			SELECT id
+			FROM containers
+			WHERE board_id = $1 AND archived_at IS NULL
+		) AND archived_at IS NULL AND NOT (id = ANY($2))
+	`, boardID, pq.Int64Array(ids))
+	return err
 }
 
 func (s *UserHandler) signupHandler(w http.ResponseWriter, r *http.Request) {
 	var data SignupData
-	err := json.NewDecoder(r.Body).Decode(&data)
+	err := decodeJSON(w, r, &data)
 	if err != nil {
-		log.Printf("Signup Data invalid: %v\n", data)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(data.Password), bcrypt.DefaultCost)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -282,26 +415,17 @@ func (s *UserHandler) signupHandler(w http.ResponseWriter, r *http.Request) {
 
 	err = s.db.QueryRow("INSERT INTO users (username, email, password) VALUES ($1, $2, $3) RETURNING id", data.Username, data.Email, hashedPassword).Scan(&user.ID)
 	if err != nil {
-		http.Error(w, "Could not create user", http.StatusInternalServerError)
+		if isUniqueViolation(err) {
+			writeError(w, r, conflictError("username %q is already taken", data.Username))
+			return
+		}
+		writeError(w, r, fmt.Errorf("could not create user: %w", err))
 		return
 	}
 
-	ctx := r.Context()
-	ctx = context.WithValue(ctx, "userID", user.ID)
-	ctx = context.WithValue(ctx, "background", "img-3.jpg")
-	ctx = context.WithValue(ctx, "title", data.Username+"'s Board")
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{
-		UserID: user.ID,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(time.Hour * 24).Unix(), // Expires in 24 hours.
-		},
-	})
-
-	tokenString, err := token.SignedString([]byte(secretKey))
+	tokenString, err := newToken(user.ID)
 	if err != nil {
-		log.Printf("Failed to create token: %v", err)
-		http.Error(w, "Failed to create token", http.StatusInternalServerError)
+		writeError(w, r, fmt.Errorf("failed to create token: %w", err))
 		return
 	}
 
@@ -312,7 +436,15 @@ func (s *UserHandler) signupHandler(w http.ResponseWriter, r *http.Request) {
 		Token:    tokenString,
 	}
 
-	s.db.QueryRow("INSERT INTO boards (user_id, title, background) VALUES ($1, $2, $3) RETURNING id", user.ID, ctx.Value("title"), ctx.Value("background"))
+	// Every new user starts with a board made from the signup template.
+	content, err := s.tm.boardTemplate(user.ID, signupBoardTemplate)
+	if err == nil {
+		_, err = s.tm.instantiateBoard(user.ID, Board{Title: data.Username + "'s Board", Background: content.Background, Labels: content.Labels}, content)
+	}
+	if err != nil {
+		writeError(w, r, fmt.Errorf("could not create the first board: %w", err))
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
@@ -320,35 +452,57 @@ func (s *UserHandler) signupHandler(w http.ResponseWriter, r *http.Request) {
 
 func (s *UserHandler) loginHandler(w http.ResponseWriter, r *http.Request) {
 	var creds Credentials
-	err := json.NewDecoder(r.Body).Decode(&creds)
+	err := decodeJSON(w, r, &creds)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 
 	var user User
 	err = s.db.QueryRow("SELECT id, username, email, password FROM users WHERE username = $1", creds.Username).Scan(&user.ID, &user.Username, &user.Email, &user.Password)
 	if err != nil {
-		http.Error(w, "invalid username or password", http.StatusUnauthorized)
+		writeError(w, r, newDomainError(ErrUnauthorized, "invalid username or password"))
 		return
 	}
 
 	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(creds.Password))
 	if err != nil {
-		http.Error(w, "invalid username or password", http.StatusUnauthorized)
+		writeError(w, r, newDomainError(ErrUnauthorized, "invalid username or password"))
 		return
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{
-		UserID: user.ID,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(time.Hour * 24).Unix(), // Expires in 24 hours.
-		},
-	})
+	tokenString, err := newToken(user.ID)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("failed to create token: %w", err))
+		return
+	}
+
+	resp := LoginResponse{
+		ID:       user.ID,
+		Username: user.Username,
+		Email:    user.Email,
+		Token:    tokenString,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// refreshHandler issues a fresh token for an authenticated caller so clients
+// can renew sessions without resending credentials.
+func (s *UserHandler) refreshHandler(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(int)
 
-	tokenString, err := token.SignedString([]byte(secretKey))
+	var user User
+	err := s.db.QueryRow("SELECT id, username, email FROM users WHERE id = $1", userID).Scan(&user.ID, &user.Username, &user.Email)
 	if err != nil {
-		http.Error(w, "Failed to create token", http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
+	}
+
+	tokenString, err := newToken(user.ID)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("failed to create token: %w", err))
 		return
 	}
 
@@ -363,6 +517,17 @@ func (s *UserHandler) loginHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
+func newToken(userID int) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{
+		UserID: userID,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(time.Hour * 24).Unix(), // Expires in 24 hours.
+		},
+	})
+
+	return token.SignedString([]byte(secretKey))
+}
+
 func (s *UserHandler) logoutHandler(w http.ResponseWriter, r *http.Request) {
 	// TODO: Implement logout logic, e.g. delete session cookies, clear authentication tokens, etc.
 
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxBodyBytes     = 1 << 20
+	maxSyncBodyBytes = 8 << 20
+)
+
+// FieldError describes a single rule violation, keyed by the JSON path of
+// the offending field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type decodeOptions struct {
+	maxBytes     int64
+	allowUnknown bool
+}
+
+// decodeJSON strictly decodes the request body into dst and validates it
+// against the `validate` struct tags of dst.
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
+	return decodeJSONWith(w, r, dst, decodeOptions{maxBytes: maxBodyBytes})
+}
+
+func decodeJSONWith(w http.ResponseWriter, r *http.Request, dst interface{}, opts decodeOptions) error {
+	r.Body = http.MaxBytesReader(w, r.Body, opts.maxBytes)
+
+	dec := json.NewDecoder(r.Body)
+	if !opts.allowUnknown {
+		dec.DisallowUnknownFields()
+	}
+
+	err := dec.Decode(dst)
+	if err != nil {
+		return decodeError(err)
+	}
+	if dec.More() {
+		return badRequestError("request body must contain a single JSON object")
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return badRequestError("request body must contain a single JSON object")
+	}
+
+	return validate(dst)
+}
+
+func decodeError(err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &syntaxErr):
+		return badRequestError("malformed JSON at offset %d", syntaxErr.Offset)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return badRequestError("malformed JSON")
+	case errors.Is(err, io.EOF):
+		return badRequestError("request body must not be empty")
+	case errors.As(err, &typeErr):
+		return validationError([]FieldError{{
+			Field:   typeErr.Field,
+			Message: fmt.Sprintf("must be of type %s", typeErr.Type),
+		}}, "invalid request body")
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.TrimPrefix(err.Error(), "json: unknown field ")
+		return validationError([]FieldError{{
+			Field:   strings.Trim(field, `"`),
+			Message: "unknown field",
+		}}, "invalid request body")
+	case err.Error() == "http: request body too large":
+		return newDomainError(ErrPayloadTooLarge, "request body too large")
+	}
+
+	return badRequestError("invalid request body: %v", err)
+}
+
+// validate walks dst and applies the comma-separated rules found in each
+// field's `validate` tag. Supported rules are required, min=N, max=N
+// (string length or numeric value), email and oneof=a|b|c. Nested structs
+// and slices of structs are validated recursively.
+func validate(dst interface{}) error {
+	var fieldErrs []FieldError
+	validateValue(reflect.ValueOf(dst), "", &fieldErrs)
+	if len(fieldErrs) > 0 {
+		return validationError(fieldErrs, "invalid request body")
+	}
+	return nil
+}
+
+func validateValue(v reflect.Value, path string, fieldErrs *[]FieldError) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			name := jsonName(field)
+			if name == "-" {
+				continue
+			}
+			fieldPath := name
+			if path != "" {
+				fieldPath = path + "." + name
+			}
+			fv := v.Field(i)
+			if tag := field.Tag.Get("validate"); tag != "" {
+				for _, rule := range strings.Split(tag, ",") {
+					if msg := checkRule(fv, rule); msg != "" {
+						*fieldErrs = append(*fieldErrs, FieldError{Field: fieldPath, Message: msg})
+						break
+					}
+				}
+			}
+			validateValue(fv, fieldPath, fieldErrs)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			validateValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i), fieldErrs)
+		}
+	}
+}
+
+func jsonName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+func checkRule(v reflect.Value, rule string) string {
+	name, arg := rule, ""
+	if i := strings.Index(rule, "="); i >= 0 {
+		name, arg = rule[:i], rule[i+1:]
+	}
+
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			if name == "required" {
+				return "is required"
+			}
+			return ""
+		}
+		v = v.Elem()
+	}
+
+	switch name {
+	case "required":
+		if v.IsZero() || (v.Kind() == reflect.String && strings.TrimSpace(v.String()) == "") {
+			return "is required"
+		}
+	case "min", "max":
+		limit, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return ""
+		}
+		size, unit := measure(v)
+		if name == "min" && size < limit {
+			return fmt.Sprintf("must be at least %s%s", arg, unit)
+		}
+		if name == "max" && size > limit {
+			return fmt.Sprintf("must be at most %s%s", arg, unit)
+		}
+	case "email":
+		if v.Kind() == reflect.String && v.String() != "" {
+			addr, err := mail.ParseAddress(v.String())
+			if err != nil || addr.Address != v.String() {
+				return "must be a valid email address"
+			}
+		}
+	case "oneof":
+		if v.Kind() == reflect.String && v.String() != "" {
+			options := strings.Split(arg, "|")
+			for _, option := range options {
+				if v.String() == option {
+					return ""
+				}
+			}
+			return "must be one of " + strings.Join(options, ", ")
+		}
+	}
+
+	return ""
+}
+
+func measure(v reflect.Value) (float64, string) {
+	switch v.Kind() {
+	case reflect.String:
+		return float64(len([]rune(v.String()))), " characters"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(v.Len()), " items"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), ""
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), ""
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), ""
+	}
+	return 0, ""
+}
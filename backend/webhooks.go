@@ -0,0 +1,597 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+type Webhook struct {
+	ID        int            `json:"id" db:"id"`
+	BoardID   int            `json:"board_id" db:"board_id"`
+	URL       string         `json:"url" db:"url"`
+	Events    pq.StringArray `json:"events" db:"events"`
+	Secret    string         `json:"secret,omitempty" db:"secret"`
+	Active    bool           `json:"active" db:"active"`
+	CreatedAt time.Time      `json:"created_at" db:"created_at"`
+}
+
+// WebhookRequest creates or updates a subscription. Events lists event types
+// or "*" for all of them; a secret is generated when none is given.
+type WebhookRequest struct {
+	URL    string   `json:"url" validate:"required,max=2048"`
+	Events []string `json:"events" validate:"required,min=1"`
+	Secret string   `json:"secret" validate:"max=256"`
+	Active *bool    `json:"active"`
+}
+
+type WebhookDelivery struct {
+	ID             int             `json:"id" db:"id"`
+	WebhookID      int             `json:"webhook_id" db:"webhook_id"`
+	Event          string          `json:"event" db:"event"`
+	Payload        json.RawMessage `json:"payload" db:"payload"`
+	Status         string          `json:"status" db:"status"`
+	Attempts       int             `json:"attempts" db:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at" db:"next_attempt_at"`
+	LastStatusCode *int            `json:"last_status_code" db:"last_status_code"`
+	LastError      string          `json:"last_error" db:"last_error"`
+	CreatedAt      time.Time       `json:"created_at" db:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at" db:"delivered_at"`
+}
+
+// WebhookManager stores board subscriptions, queues a delivery for every
+// matching Event and sends queued deliveries from Run.
+type WebhookManager struct {
+	db     *sqlx.DB
+	tm     *TaskManager
+	client *http.Client
+
+	// allowAddress decides which addresses webhooks may be registered for
+	// and delivered to.
+	allowAddress func(net.IP) bool
+
+	maxAttempts  int
+	baseDelay    time.Duration
+	maxDelay     time.Duration
+	pollInterval time.Duration
+}
+
+func NewWebhookManager(db *sqlx.DB, tm *TaskManager) *WebhookManager {
+	wm := &WebhookManager{
+		db:           db,
+		tm:           tm,
+		allowAddress: webhookAddressAllowed,
+		maxAttempts:  8,
+		baseDelay:    30 * time.Second,
+		maxDelay:     6 * time.Hour,
+		pollInterval: 5 * time.Second,
+	}
+
+	// Addresses are checked again when connecting, as the host may resolve
+	// differently than at registration or redirect elsewhere. Proxies are
+	// not used, since they would be dialed instead of the receiver.
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !wm.allowAddress(ip) {
+				return fmt.Errorf("webhook address %s is not public", host)
+			}
+			return nil
+		},
+	}
+	wm.client = &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: 5 * time.Second, MaxIdleConns: 10, IdleConnTimeout: 90 * time.Second},
+	}
+	return wm
+}
+
+// webhookAddressAllowed is the address check of new webhook managers.
+// Tests relax it to reach receivers on the loopback interface.
+var webhookAddressAllowed = isPublicAddress
+
+// nonPublicNets are reserved ranges not covered by the net.IP predicates:
+// "this network", shared address space (Alibaba Cloud's metadata service is
+// 100.100.100.200) and IETF protocol assignments (Oracle Cloud's is
+// 192.0.0.192).
+var nonPublicNets = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),
+	mustParseCIDR("100.64.0.0/10"),
+	mustParseCIDR("192.0.0.0/24"),
+}
+
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return ipNet
+}
+
+// isPublicAddress reports whether ip may receive webhooks: loopback,
+// private (RFC 1918 and unique local), link-local, which includes the
+// 169.254.169.254 metadata service of most clouds, and other reserved
+// addresses may not.
+func isPublicAddress(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsMulticast() {
+		return false
+	}
+	for _, ipNet := range nonPublicNets {
+		if ipNet.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func (wm *WebhookManager) routes() []route {
+	return []route{
+		{name: "listWebhooks", method: "GET", path: "/boards/{id}/webhooks", handler: wm.ListWebhooksHandler, auth: true, tag: "webhooks",
+			summary: "List a board's webhook subscriptions", response: []Webhook{}},
+		{name: "createWebhook", method: "POST", path: "/boards/{id}/webhooks", handler: wm.CreateWebhookHandler, auth: true, tag: "webhooks",
+			summary: "Subscribe a URL to board events", request: WebhookRequest{}, response: Webhook{}, status: http.StatusCreated},
+		{name: "updateWebhook", method: "PUT", path: "/webhooks/{id}", handler: wm.UpdateWebhookHandler, auth: true, tag: "webhooks",
+			summary: "Change a webhook subscription", request: WebhookRequest{}, response: Webhook{}},
+		{name: "deleteWebhook", method: "DELETE", path: "/webhooks/{id}", handler: wm.DeleteWebhookHandler, auth: true, tag: "webhooks",
+			summary: "Delete a webhook subscription and its delivery log"},
+		{name: "listWebhookDeliveries", method: "GET", path: "/webhooks/{id}/deliveries", handler: wm.ListDeliveriesHandler, auth: true, tag: "webhooks",
+			summary: "Show the most recent deliveries of a webhook", response: []WebhookDelivery{},
+			query: []queryParam{{name: "status", description: "pending, delivered or dead"}}},
+		{name: "retryWebhookDelivery", method: "POST", path: "/webhooks/{id}/deliveries/{deliveryID}/retry", handler: wm.RetryDeliveryHandler, auth: true, tag: "webhooks",
+			summary: "Requeue a dead-lettered delivery", response: WebhookDelivery{}},
+	}
+}
+
+func (wm *WebhookManager) ListWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+	boardID := mux.Vars(r)["id"]
+
+	err := wm.tm.checkBoardOwnership(userID, boardID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	webhooks := []Webhook{}
+	err = wm.db.Select(&webhooks, "SELECT * FROM webhooks WHERE board_id = $1 ORDER BY id", boardID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(webhooks)
+}
+
+func (wm *WebhookManager) CreateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+	boardID := mux.Vars(r)["id"]
+
+	var req WebhookRequest
+	err := decodeJSON(w, r, &req)
+	if err == nil {
+		err = wm.validateWebhookRequest(r.Context(), req)
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = wm.tm.checkBoardOwnership(userID, boardID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if req.Secret == "" {
+		req.Secret = newRequestID() + newRequestID()
+	}
+	active := req.Active == nil || *req.Active
+
+	var webhook Webhook
+	err = wm.db.Get(&webhook, `
+		INSERT INTO webhooks (board_id, url, events, secret, active)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING *
+	`, boardID, req.URL, pq.StringArray(req.Events), req.Secret, active)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(webhook)
+}
+
+func (wm *WebhookManager) UpdateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	var req WebhookRequest
+	err := decodeJSON(w, r, &req)
+	if err == nil {
+		err = wm.validateWebhookRequest(r.Context(), req)
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	webhook, err := wm.getWebhook(userID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if req.Secret == "" {
+		req.Secret = webhook.Secret
+	}
+	active := webhook.Active
+	if req.Active != nil {
+		active = *req.Active
+	}
+
+	err = wm.db.Get(&webhook, `
+		UPDATE webhooks SET url = $1, events = $2, secret = $3, active = $4
+		WHERE id = $5
+		RETURNING *
+	`, req.URL, pq.StringArray(req.Events), req.Secret, active, webhook.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	webhook.Secret = ""
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(webhook)
+}
+
+func (wm *WebhookManager) DeleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	webhook, err := wm.getWebhook(userID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	_, err = wm.db.Exec("DELETE FROM webhooks WHERE id = $1", webhook.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (wm *WebhookManager) ListDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+
+	webhook, err := wm.getWebhook(userID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	deliveries := []WebhookDelivery{}
+	err = wm.db.Select(&deliveries, `
+		SELECT * FROM webhook_deliveries
+		WHERE webhook_id = $1 AND ($2 = '' OR status = $2)
+		ORDER BY id DESC
+		LIMIT 100
+	`, webhook.ID, status)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+func (wm *WebhookManager) RetryDeliveryHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID := r.Context().Value("userID").(int)
+	vars := mux.Vars(r)
+
+	webhook, err := wm.getWebhook(userID, vars["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var delivery WebhookDelivery
+	err = wm.db.Get(&delivery, `
+		UPDATE webhook_deliveries
+		SET status = $1, attempts = 0, next_attempt_at = now()
+		WHERE id = $2 AND webhook_id = $3 AND status = $4
+		RETURNING *
+	`, DeliveryPending, vars["deliveryID"], webhook.ID, DeliveryDead)
+	if err == sql.ErrNoRows {
+		writeError(w, r, notFoundError("no dead delivery %s for webhook %d", vars["deliveryID"], webhook.ID))
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(delivery)
+}
+
+func (wm *WebhookManager) validateWebhookRequest(ctx context.Context, req WebhookRequest) error {
+	var fieldErrs []FieldError
+
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		fieldErrs = append(fieldErrs, FieldError{Field: "url", Message: "must be an absolute http or https URL"})
+	} else if message := wm.checkWebhookHost(ctx, u.Hostname()); message != "" {
+		fieldErrs = append(fieldErrs, FieldError{Field: "url", Message: message})
+	}
+
+	for i, event := range req.Events {
+		if event != "*" && !isEventType(event) {
+			fieldErrs = append(fieldErrs, FieldError{Field: fmt.Sprintf("events[%d]", i), Message: "unknown event type"})
+		}
+	}
+
+	if len(fieldErrs) > 0 {
+		return validationError(fieldErrs, "invalid webhook")
+	}
+	return nil
+}
+
+// checkWebhookHost resolves host and describes why it may not receive
+// webhooks, or returns "" if it may.
+func (wm *WebhookManager) checkWebhookHost(ctx context.Context, host string) string {
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return "host cannot be resolved"
+		}
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+	for _, ip := range ips {
+		if !wm.allowAddress(ip) {
+			return fmt.Sprintf("must not point to a private address (%s)", ip)
+		}
+	}
+	return ""
+}
+
+func isEventType(eventType string) bool {
+	for _, t := range eventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// getWebhook loads a webhook and checks that userID owns its board.
+func (wm *WebhookManager) getWebhook(userID int, webhookID string) (Webhook, error) {
+	var webhook Webhook
+	err := wm.db.Get(&webhook, "SELECT * FROM webhooks WHERE id = $1", webhookID)
+	if err == sql.ErrNoRows {
+		return webhook, notFoundError("webhook %s not found", webhookID)
+	}
+	if err != nil {
+		return webhook, err
+	}
+
+	err = wm.tm.checkBoardOwnership(userID, strconv.Itoa(webhook.BoardID))
+	return webhook, err
+}
+
+// HandleEvent queues a delivery for every active subscription of the
+// event's board that listens for its type.
+func (wm *WebhookManager) HandleEvent(event Event) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("webhooks: encoding %s event: %v", event.Type, err)
+		return
+	}
+
+	if event.Type == EventBoardDeleted {
+		wm.sendUnqueued(event, payload)
+		return
+	}
+
+	_, err = wm.db.Exec(`
+		INSERT INTO webhook_deliveries (webhook_id, event, payload)
+		SELECT id, $1, $2 FROM webhooks
+		WHERE board_id = $3 AND active AND ($1 = ANY(events) OR '*' = ANY(events))
+	`, event.Type, payload, event.BoardID)
+	if err != nil {
+		log.Printf("webhooks: queueing %s event for board %d: %v", event.Type, event.BoardID, err)
+	}
+}
+
+// sendUnqueued sends event once to each matching subscription without
+// recording a delivery. It is used for board.deleted, whose subscriptions
+// and delivery log are deleted along with the board.
+func (wm *WebhookManager) sendUnqueued(event Event, payload []byte) {
+	webhooks := []Webhook{}
+	err := wm.db.Select(&webhooks, `
+		SELECT * FROM webhooks
+		WHERE board_id = $1 AND active AND ($2 = ANY(events) OR '*' = ANY(events))
+	`, event.BoardID, event.Type)
+	if err != nil {
+		log.Printf("webhooks: loading subscriptions of board %d: %v", event.BoardID, err)
+		return
+	}
+
+	for _, webhook := range webhooks {
+		go func(webhook Webhook) {
+			_, err := wm.send(context.Background(), webhook, WebhookDelivery{Event: event.Type, Payload: payload})
+			if err != nil {
+				log.Printf("webhooks: sending %s to webhook %d: %v", event.Type, webhook.ID, err)
+			}
+		}(webhook)
+	}
+}
+
+// Run sends due deliveries until ctx is cancelled.
+func (wm *WebhookManager) Run(ctx context.Context) {
+	ticker := time.NewTicker(wm.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := wm.deliverPending(ctx); err != nil {
+				log.Printf("webhooks: %v", err)
+			}
+		}
+	}
+}
+
+// deliverPending claims a batch of due deliveries by pushing their next
+// attempt into the future, so concurrent workers never send the same one.
+func (wm *WebhookManager) deliverPending(ctx context.Context) error {
+	deliveries := []WebhookDelivery{}
+	err := wm.db.SelectContext(ctx, &deliveries, `
+		UPDATE webhook_deliveries SET next_attempt_at = now() + interval '1 minute'
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = $1 AND next_attempt_at <= now()
+			ORDER BY next_attempt_at
+			LIMIT 20
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *
+	`, DeliveryPending)
+	if err != nil {
+		return err
+	}
+
+	for _, delivery := range deliveries {
+		var webhook Webhook
+		err := wm.db.GetContext(ctx, &webhook, "SELECT * FROM webhooks WHERE id = $1", delivery.WebhookID)
+		if err != nil {
+			return err
+		}
+		statusCode, sendErr := wm.send(ctx, webhook, delivery)
+		if err := wm.recordAttempt(delivery, statusCode, sendErr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// send posts the payload signed with HMAC-SHA256 over "<timestamp>.<body>".
+func (wm *WebhookManager) send(ctx context.Context, webhook Webhook, delivery WebhookDelivery) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(webhook.Secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(delivery.Payload)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "taskapp-webhooks/1.0")
+	req.Header.Set("X-Taskapp-Event", delivery.Event)
+	req.Header.Set("X-Taskapp-Delivery", strconv.Itoa(delivery.ID))
+	req.Header.Set("X-Taskapp-Timestamp", timestamp)
+	req.Header.Set("X-Taskapp-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := wm.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("receiver responded %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// recordAttempt marks a delivery as delivered, schedules the next attempt
+// with exponential backoff, or dead-letters it after maxAttempts.
+func (wm *WebhookManager) recordAttempt(delivery WebhookDelivery, statusCode int, sendErr error) error {
+	var code *int
+	if statusCode != 0 {
+		code = &statusCode
+	}
+	attempts := delivery.Attempts + 1
+
+	if sendErr == nil {
+		_, err := wm.db.Exec(`
+			UPDATE webhook_deliveries
+			SET status = $1, attempts = $2, last_status_code = $3, last_error = '', delivered_at = now()
+			WHERE id = $4
+		`, DeliveryDelivered, attempts, code, delivery.ID)
+		return err
+	}
+
+	status := DeliveryPending
+	if attempts >= wm.maxAttempts {
+		status = DeliveryDead
+	}
+
+	_, err := wm.db.Exec(`
+		UPDATE webhook_deliveries
+		SET status = $1, attempts = $2, last_status_code = $3, last_error = $4, next_attempt_at = $5
+		WHERE id = $6
+	`, status, attempts, code, sendErr.Error(), time.Now().Add(wm.retryDelay(attempts)), delivery.ID)
+	return err
+}
+
+// retryDelay is the wait after the given number of failed attempts: the base
+// delay doubled for each attempt after the first, at most maxDelay.
+func (wm *WebhookManager) retryDelay(attempts int) time.Duration {
+	if attempts < 1 {
+		return wm.baseDelay
+	}
+	delay := wm.baseDelay << (attempts - 1)
+	if delay > wm.maxDelay || delay <= 0 || delay>>(attempts-1) != wm.baseDelay {
+		delay = wm.maxDelay
+	}
+	return delay
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// webhookReceiver records the requests it gets and answers with status.
+type webhookReceiver struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	status   int
+	requests []receivedWebhook
+}
+
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+}
+
+func newWebhookReceiver(t *testing.T, status int) *webhookReceiver {
+	wr := &webhookReceiver{status: status}
+	wr.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		wr.mu.Lock()
+		wr.requests = append(wr.requests, receivedWebhook{header: r.Header.Clone(), body: body})
+		status := wr.status
+		wr.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(wr.Close)
+	return wr
+}
+
+func (wr *webhookReceiver) received() []receivedWebhook {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	return append([]receivedWebhook(nil), wr.requests...)
+}
+
+// allowLocalWebhooks lets webhook managers created during the test reach
+// receivers on the loopback interface.
+func allowLocalWebhooks(t *testing.T) {
+	allowed := webhookAddressAllowed
+	webhookAddressAllowed = func(ip net.IP) bool { return ip.IsLoopback() || allowed(ip) }
+	t.Cleanup(func() { webhookAddressAllowed = allowed })
+}
+
+func TestWebhookSendSignsPayload(t *testing.T) {
+	allowLocalWebhooks(t)
+	receiver := newWebhookReceiver(t, http.StatusNoContent)
+	wm := NewWebhookManager(nil, nil)
+
+	webhook := Webhook{ID: 1, URL: receiver.URL, Secret: "s3cret"}
+	delivery := WebhookDelivery{ID: 42, Event: EventTaskCreated, Payload: json.RawMessage(`{"event":"task.created","board_id":7}`)}
+	status, err := wm.send(context.Background(), webhook, delivery)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusNoContent {
+		t.Errorf("status = %d, want %d", status, http.StatusNoContent)
+	}
+
+	requests := receiver.received()
+	if len(requests) != 1 {
+		t.Fatalf("receiver got %d requests, want 1", len(requests))
+	}
+	got := requests[0]
+	if string(got.body) != string(delivery.Payload) {
+		t.Errorf("body = %s, want %s", got.body, delivery.Payload)
+	}
+	for header, want := range map[string]string{
+		"Content-Type":       "application/json",
+		"X-Taskapp-Event":    EventTaskCreated,
+		"X-Taskapp-Delivery": "42",
+	} {
+		if v := got.header.Get(header); v != want {
+			t.Errorf("%s = %q, want %q", header, v, want)
+		}
+	}
+
+	timestamp := got.header.Get("X-Taskapp-Timestamp")
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(sent, 0)) > time.Minute {
+		t.Errorf("X-Taskapp-Timestamp = %q, want the current Unix time", timestamp)
+	}
+
+	mac := hmac.New(sha256.New, []byte(webhook.Secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(got.body)
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if signature := got.header.Get("X-Taskapp-Signature"); !hmac.Equal([]byte(signature), []byte(want)) {
+		t.Errorf("X-Taskapp-Signature = %q, want %q", signature, want)
+	}
+}
+
+func TestWebhookSendFailures(t *testing.T) {
+	allowLocalWebhooks(t)
+	wm := NewWebhookManager(nil, nil)
+	delivery := WebhookDelivery{ID: 1, Event: EventTaskCreated, Payload: json.RawMessage(`{}`)}
+
+	receiver := newWebhookReceiver(t, http.StatusInternalServerError)
+	status, err := wm.send(context.Background(), Webhook{URL: receiver.URL}, delivery)
+	if err == nil || status != http.StatusInternalServerError {
+		t.Errorf("send to a failing receiver = %d, %v; want 500 and an error", status, err)
+	}
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	status, err = wm.send(context.Background(), Webhook{URL: closed.URL}, delivery)
+	if err == nil || status != 0 {
+		t.Errorf("send to an unreachable receiver = %d, %v; want 0 and an error", status, err)
+	}
+}
+
+func TestWebhookRetryDelay(t *testing.T) {
+	wm := NewWebhookManager(nil, nil)
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{8, 64 * time.Minute},
+		{10, 256 * time.Minute},
+		{11, 6 * time.Hour},
+		{40, 6 * time.Hour},
+		{100, 6 * time.Hour},
+	}
+	for _, tt := range tests {
+		if got := wm.retryDelay(tt.attempts); got != tt.want {
+			t.Errorf("retryDelay(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+// newWebhookBoard creates a board subscribed to events at url.
+func newWebhookBoard(t *testing.T, ts *testServer, url string, events ...string) (int, Webhook) {
+	t.Helper()
+
+	var userID, boardID int
+	err := ts.db.Get(&userID, "INSERT INTO users (username, email, password) VALUES ('hooks', 'hooks@example.com', '') RETURNING id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ts.db.Get(&boardID, "INSERT INTO boards (user_id, title) VALUES ($1, 'Hooks') RETURNING id", userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var webhook Webhook
+	err = ts.db.Get(&webhook, `
+		INSERT INTO webhooks (board_id, url, events, secret) VALUES ($1, $2, $3, 's3cret') RETURNING *
+	`, boardID, url, pq.StringArray(events))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return boardID, webhook
+}
+
+func TestWebhookDeliveryRetriesAndDeadLetters(t *testing.T) {
+	allowLocalWebhooks(t)
+	ts := newTestServer(t)
+	receiver := newWebhookReceiver(t, http.StatusBadGateway)
+	boardID, webhook := newWebhookBoard(t, ts, receiver.URL, EventTaskCreated)
+
+	wm := NewWebhookManager(ts.db, nil)
+	wm.maxAttempts = 3
+	wm.baseDelay = time.Millisecond
+	wm.maxDelay = 10 * time.Millisecond
+
+	wm.HandleEvent(Event{Type: EventTaskCreated, BoardID: boardID, OccurredAt: time.Now()})
+	// Not subscribed to, so never queued.
+	wm.HandleEvent(Event{Type: EventTaskDeleted, BoardID: boardID, OccurredAt: time.Now()})
+
+	for i := 0; i < 5; i++ {
+		time.Sleep(20 * time.Millisecond)
+		if err := wm.deliverPending(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var deliveries []WebhookDelivery
+	if err := ts.db.Select(&deliveries, "SELECT * FROM webhook_deliveries WHERE webhook_id = $1", webhook.ID); err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("queued %d deliveries, want 1", len(deliveries))
+	}
+	d := deliveries[0]
+	if d.Status != DeliveryDead || d.Attempts != 3 {
+		t.Errorf("delivery is %s after %d attempts, want dead after 3", d.Status, d.Attempts)
+	}
+	if d.LastStatusCode == nil || *d.LastStatusCode != http.StatusBadGateway || d.LastError == "" {
+		t.Errorf("last status = %v, error = %q; want 502 and an error", d.LastStatusCode, d.LastError)
+	}
+	if n := len(receiver.received()); n != 3 {
+		t.Errorf("receiver got %d requests, want 3", n)
+	}
+
+	// A retried delivery goes out again and succeeds once the receiver does.
+	receiver.mu.Lock()
+	receiver.status = http.StatusOK
+	receiver.mu.Unlock()
+	_, err := ts.db.Exec("UPDATE webhook_deliveries SET status = $1, attempts = 0, next_attempt_at = now() WHERE id = $2", DeliveryPending, d.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wm.deliverPending(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.db.Get(&d, "SELECT * FROM webhook_deliveries WHERE id = $1", d.ID); err != nil {
+		t.Fatal(err)
+	}
+	if d.Status != DeliveryDelivered || d.DeliveredAt == nil || d.Attempts != 1 {
+		t.Errorf("delivery is %s after %d attempts, want delivered after 1", d.Status, d.Attempts)
+	}
+}
+
+func TestWebhookBoardEvents(t *testing.T) {
+	allowLocalWebhooks(t)
+	ts := newTestServer(t)
+	login := ts.signup(t, "hooked")
+	receiver := newWebhookReceiver(t, http.StatusOK)
+
+	resp := ts.do(t, "POST", "/boards", login.Token, Board{Title: "Doomed"})
+	var board Board
+	json.NewDecoder(resp.Body).Decode(&board)
+	resp.Body.Close()
+
+	resp = ts.do(t, "POST", "/boards/"+strconv.Itoa(board.ID)+"/webhooks", login.Token,
+		WebhookRequest{URL: receiver.URL, Events: []string{EventBoardCreated, EventBoardDeleted}})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("creating webhook: %s", resp.Status)
+	}
+
+	resp = ts.do(t, "DELETE", "/boards/"+strconv.Itoa(board.ID), login.Token, nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("deleting board: %s", resp.Status)
+	}
+
+	// board.deleted is sent right away since the subscription is gone.
+	deadline := time.Now().Add(5 * time.Second)
+	for len(receiver.received()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	requests := receiver.received()
+	if len(requests) != 1 {
+		t.Fatalf("receiver got %d requests, want 1", len(requests))
+	}
+	var event Event
+	if err := json.Unmarshal(requests[0].body, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != EventBoardDeleted || event.BoardID != board.ID {
+		t.Errorf("got %s for board %d, want %s for board %d", event.Type, event.BoardID, EventBoardDeleted, board.ID)
+	}
+}
+
+func TestIsPublicAddress(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"127.1.2.3", false},
+		{"::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"10.0.0.5", false},
+		{"172.16.0.1", false},
+		{"172.31.255.255", false},
+		{"192.168.1.1", false},
+		{"fd00:ec2::254", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"100.100.100.200", false},
+		{"192.0.0.192", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"224.0.0.1", false},
+		{"172.32.0.1", true},
+	}
+	for _, tt := range tests {
+		if got := isPublicAddress(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("isPublicAddress(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestWebhookRegistrationRefusesPrivateAddresses(t *testing.T) {
+	wm := NewWebhookManager(nil, nil)
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://93.184.216.34/hooks", ""},
+		{"http://127.0.0.1:8000/hooks", "must not point to a private address (127.0.0.1)"},
+		{"http://localhost/hooks", "must not point to a private address"},
+		{"http://[::1]/hooks", "must not point to a private address (::1)"},
+		{"http://10.1.2.3/hooks", "must not point to a private address (10.1.2.3)"},
+		{"http://169.254.169.254/latest/meta-data", "must not point to a private address (169.254.169.254)"},
+		{"http://hooks.invalid/", "host cannot be resolved"},
+		{"ftp://93.184.216.34/", "must be an absolute http or https URL"},
+	}
+	for _, tt := range tests {
+		err := wm.validateWebhookRequest(context.Background(), WebhookRequest{URL: tt.url, Events: []string{"*"}})
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("%s: %v", tt.url, err)
+			}
+			continue
+		}
+		var domainErr *DomainError
+		if !errors.As(err, &domainErr) || !strings.Contains(fmt.Sprint(domainErr.Details), tt.want) {
+			t.Errorf("%s: %v, want %q", tt.url, err, tt.want)
+		}
+	}
+}
+
+func TestWebhookSendRefusesPrivateAddresses(t *testing.T) {
+	receiver := newWebhookReceiver(t, http.StatusOK)
+	wm := NewWebhookManager(nil, nil)
+
+	delivery := WebhookDelivery{ID: 1, Event: EventTaskCreated, Payload: json.RawMessage(`{}`)}
+	status, err := wm.send(context.Background(), Webhook{URL: receiver.URL}, delivery)
+	if err == nil || status != 0 || !strings.Contains(err.Error(), "is not public") {
+		t.Errorf("send to a loopback receiver = %d, %v; want it refused", status, err)
+	}
+
+	// A public host redirecting to a private one is refused at the redirect.
+	allowLocalWebhooks(t)
+	redirector := httptest.NewServer(http.RedirectHandler("http://169.254.169.254/latest/meta-data", http.StatusFound))
+	t.Cleanup(redirector.Close)
+	status, err = NewWebhookManager(nil, nil).send(context.Background(), Webhook{URL: redirector.URL}, delivery)
+	if err == nil || status != 0 || !strings.Contains(err.Error(), "169.254.169.254 is not public") {
+		t.Errorf("send redirected to the metadata service = %d, %v; want it refused", status, err)
+	}
+	if n := len(receiver.received()); n != 0 {
+		t.Errorf("receiver got %d requests, want none", n)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	WIPWarn    = "warn"
+	WIPEnforce = "enforce"
+)
+
+// WIPViolation describes a container that has, or would have, more tasks
+// than its WIP limit allows. It is the detail of wip_limit_exceeded errors
+// and the content of X-WIP-Warning response headers.
+type WIPViolation struct {
+	ContainerID int    `json:"container_id" db:"id"`
+	Limit       int    `json:"limit" db:"wip_limit"`
+	Mode        string `json:"mode" db:"wip_mode"`
+	Count       int    `json:"count" db:"count"`
+}
+
+func (v WIPViolation) String() string {
+	return fmt.Sprintf("container %d has %d tasks, over its limit of %d", v.ContainerID, v.Count, v.Limit)
+}
+
+// checkWIP checks that adding tasks to a container keeps it within its WIP
+// limit. Archived tasks do not count. Enforced limits fail with ErrWIPLimit;
+// warn-mode violations are returned for the caller to flag.
+//
+// The container row stays locked until tx ends, so the caller must add the
+// tasks in tx; concurrent checks for the container wait for it and then
+// count its tasks.
+func (tm *TaskManager) checkWIP(tx *sqlx.Tx, containerID, adding int) (*WIPViolation, error) {
+	_, err := tx.Exec("SELECT 1 FROM containers WHERE id = $1 FOR UPDATE", containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	// A separate statement, so the count sees tasks committed while the
+	// lock was awaited.
+	var v WIPViolation
+	err = tx.Get(&v, `
+		SELECT id, COALESCE(wip_limit, 0) AS wip_limit, wip_mode,
+			(SELECT COUNT(*) FROM tasks WHERE container_id = containers.id AND archived_at IS NULL) AS count
+		FROM containers WHERE id = $1
+	`, containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	v.Count += adding
+	if v.Limit == 0 || v.Count <= v.Limit {
+		return nil, nil
+	}
+	if v.Mode == WIPEnforce {
+		return nil, wipLimitError([]WIPViolation{v})
+	}
+	return &v, nil
+}
+
+// checkSyncWIP compares a board snapshot against the board's WIP limits.
+// Only containers the snapshot takes over their limit and fills further
+// than they are now count, so lowering a limit does not block every later
+// sync of the board.
+func (tm *TaskManager) checkSyncWIP(boardID int, tasks []SyncTask) ([]WIPViolation, error) {
+	limited := []WIPViolation{}
+	err := tm.db.Select(&limited, `
+		SELECT id, wip_limit, wip_mode,
+			(SELECT COUNT(*) FROM tasks WHERE container_id = containers.id AND archived_at IS NULL) AS count
+		FROM containers WHERE board_id = $1 AND wip_limit IS NOT NULL
+	`, boardID)
+	if err != nil || len(limited) == 0 {
+		return nil, err
+	}
+
+	counts := map[int]int{}
+	for _, task := range tasks {
+		counts[task.ContainerID]++
+	}
+
+	var warned, enforced []WIPViolation
+	for _, v := range limited {
+		current := v.Count
+		v.Count = counts[v.ContainerID]
+		if v.Count <= v.Limit || v.Count <= current {
+			continue
+		}
+		if v.Mode == WIPEnforce {
+			enforced = append(enforced, v)
+		} else {
+			warned = append(warned, v)
+		}
+	}
+
+	if len(enforced) > 0 {
+		return nil, wipLimitError(enforced)
+	}
+	return warned, nil
+}
+
+// flagWIP reports a warn-mode violation on a successful response. It must
+// be called before the response body is written.
+func flagWIP(w http.ResponseWriter, v *WIPViolation) {
+	if v != nil {
+		w.Header().Add("X-WIP-Warning", v.String())
+	}
+}